@@ -1,6 +1,7 @@
 /*
  * AuthPlz Command Line Application Example
  * Demonstrates authentication of command line applications using AuthPlz
+ * This uses the authorization code grant with PKCE and a loopback redirect (RFC 8252)
  *
  * AuthPlz Project (https://github.com/ryankurte/AuthPlz)
  * Copyright 2017 Ryan Kurte
@@ -10,67 +11,42 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
-
-	//	"golang.org/x/oauth2"
+	"time"
 
 	"github.com/jessevdk/go-flags"
-	//"time"
-	"encoding/base64"
+	"golang.org/x/oauth2"
 )
 
 type Config struct {
-	OAuthAddress string `short:"o" long:"oauth-address" description:"Set authorization server endpoint" default:"https://localhost:9000/api/oauth/auth"`
-	BindAddress  string `short:"a" long:"bind-address" description:"Set cli bind address" default:"localhost:9002"`
-	ClientID     string `short:"i" long:"client-id" description:"OAuth2 Client ID"`
-	ClientSecret string `short:"s" long:"client-secret" description:"OAuth2 Client Secret" default-mask:"-"`
-	TLSCert      string `short:"c" long:"tls-cert" description:"TLS Certificate file" default:"./client.crt"`
-	TLSKey       string `short:"k" long:"tls-key" description:"TLS Key file" default:"./client.key"`
+	AuthAddress  string   `short:"o" long:"oauth-address" description:"Set authorization server endpoint" default:"https://localhost:9000/api/oauth/auth"`
+	TokenAddress string   `short:"t" long:"token-address" description:"Set token server endpoint" default:"https://localhost:9000/api/oauth/token"`
+	BindAddress  string   `short:"a" long:"bind-address" description:"Set cli loopback bind address" default:"127.0.0.1:9002"`
+	ClientID     string   `short:"i" long:"client-id" description:"OAuth2 Client ID (for a public client)"`
+	Scopes       []string `short:"s" long:"scope" description:"OAuth2 scopes to request" default:"public.read" default:"private.read"`
 }
 
-func getOAuthImplicitLink(c *Config) string {
-
-	b := make([]byte, 32)
+// Generate a random URL safe string
+func generateRandom(len int) string {
+	b := make([]byte, len)
 	rand.Read(b)
-
-	v := url.Values{}
-
-	v.Set("client_id", c.ClientID)
-	v.Set("response_type", "token")
-	v.Set("grant_type", "implicit")
-	v.Set("redirect_uri", fmt.Sprintf("https://%s", c.BindAddress))
-	v.Set("scope", "public.read private.read")
-	v.Set("state", base64.StdEncoding.EncodeToString(b))
-
-	return fmt.Sprintf("%s?%s", c.OAuthAddress, v.Encode())
+	return base64.RawURLEncoding.EncodeToString(b)
 }
 
-func getOauthExplicitLink(c *Config) string {
-	b := make([]byte, 32)
-	rand.Read(b)
-
-	v := url.Values{}
-
-	v.Set("client_id", c.ClientID)
-	v.Set("response_type", "code")
-	v.Set("redirect_uri", fmt.Sprintf("https://%s", c.BindAddress))
-	v.Set("scope", "public.read private.read")
-	v.Set("state", base64.StdEncoding.EncodeToString(b))
-
-	return fmt.Sprintf("%s?%s", c.OAuthAddress, v.Encode())
+// Generate an S256 PKCE challenge for the provided verifier (RFC 7636 Section 4.2)
+func getPKCEChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
 }
 
-func newHandler(origin string, ch chan *http.Request) func(w http.ResponseWriter, r *http.Request) {
+func newHandler(state string, ch chan string) func(w http.ResponseWriter, r *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		// Set access control to allow auth site queries
-		w.Header().Set("access-control-allow-origin", origin)
-		w.Header().Set("access-control-allow-credentials", "true")
-
 		err := r.URL.Query().Get("error")
 		disc := r.URL.Query().Get("error_description")
 		if err != "" {
@@ -79,9 +55,16 @@ func newHandler(origin string, ch chan *http.Request) func(w http.ResponseWriter
 			return
 		}
 
+		if r.URL.Query().Get("state") != state {
+			fmt.Printf("OAuth error: state mismatch\n")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Authorization complete, you may now close this window"))
 
-		fmt.Printf("OAuth response: %+v\n", r.URL.Query())
+		ch <- r.URL.Query().Get("code")
 	}
 }
 
@@ -96,37 +79,48 @@ func main() {
 		os.Exit(-1)
 	}
 
-	remote, _ := url.Parse(c.OAuthAddress)
-	origin := fmt.Sprintf("%s://%s", remote.Scheme, remote.Host)
+	config := &oauth2.Config{
+		ClientID: c.ClientID,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  c.AuthAddress,
+			TokenURL: c.TokenAddress,
+		},
+		RedirectURL: fmt.Sprintf("http://%s/callback", c.BindAddress),
+		Scopes:      c.Scopes,
+	}
+
+	// Generate state and PKCE verifier for this request
+	state := generateRandom(32)
+	verifier := generateRandom(32)
 
 	// Start local HTTP server (for OAuth redirect)
-	ch := make(chan *http.Request)
-	http.HandleFunc("/", newHandler(origin, ch))
-	go http.ListenAndServeTLS(c.BindAddress, c.TLSCert, c.TLSKey, nil)
+	ch := make(chan string)
+	http.HandleFunc("/callback", newHandler(state, ch))
+	go http.ListenAndServe(c.BindAddress, nil)
 
 	// Print auth link for user to click
-	link := getOauthExplicitLink(&c)
+	link := config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", getPKCEChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
 
 	fmt.Println("Click the following link to authorize the application")
 	fmt.Println(link)
 
-	// Await token from redirect endpoint (with timeout)
-	for {
-		select {
-		case resp, ok := <-ch:
-			if !ok {
-				fmt.Printf("Channel closed error\n")
-				break
-			}
-			fmt.Printf("Received: %+v\n", resp)
-
-			/*
-				case <-time.After(time.Second * 30):
-					fmt.Printf("Timeout awaiting application authorization\n")
-			*/
-		}
+	// Await code from redirect endpoint (with timeout)
+	var code string
+	select {
+	case code = <-ch:
+	case <-time.After(time.Minute * 5):
+		fmt.Printf("Timeout awaiting application authorization\n")
+		os.Exit(-1)
 	}
 
-	// Save / use token?
+	// Exchange code (and PKCE verifier) for tokens
+	token, err := config.Exchange(oauth2.NoContext, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		fmt.Printf("Token exchange error: %s\n", err)
+		os.Exit(-1)
+	}
 
+	fmt.Printf("Received token: %+v\n", token)
 }
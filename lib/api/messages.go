@@ -24,6 +24,7 @@ const (
 	ActionMissing      = "ActionMissing"
 	IncorrectArguments = "IncorrectArguments"
 	OK                 = "OK"
+	RateLimited        = "RateLimited"
 
 	// User input messages
 	MissingEmail             = "MissingEmail"
@@ -46,7 +47,19 @@ const (
 	InvalidToken         = "InvalidToken"
 	MissingToken         = "MissingToken"
 	NoRecoveryPending    = "NoRecoveryPending"
+	NoLoginPending       = "NoLoginPending"
 	LoginRequired        = "LoginRequired"
+	SudoRequired         = "SudoRequired"
+	SudoSuccessful       = "SudoSuccessful"
+	SudoEnded            = "SudoEnded"
+	AccountDeleted       = "AccountDeleted"
+	SessionRevoked       = "SessionRevoked"
+	SessionsRevoked      = "SessionsRevoked"
+	DeviceTrusted        = "DeviceTrusted"
+	DeviceRevoked        = "DeviceRevoked"
+	DevicesRevoked       = "DevicesRevoked"
+	LoginBlocked         = "LoginBlocked"
+	LoginConfirmRequired = "LoginConfirmRequired"
 
 	// Second factor messages
 	SecondFactorRequired         = "SecondFactorRequired"
@@ -65,8 +78,22 @@ const (
 	U2FTokenRemoved          = "U2FTokenRemoved"
 	RecoveryNoRequestPending = "RecoveryNoRequestPending"
 
+	WebAuthnCredentialRemoved = "WebAuthnCredentialRemoved"
+
 	TOTPTokenRemoved = "TOTPTokenRemoved"
 
+	YubikeyTokenRemoved = "YubikeyTokenRemoved"
+
+	EmailOTPCodeSent = "EmailOTPCodeSent"
+	EmailOTPEnabled  = "EmailOTPEnabled"
+	EmailOTPDisabled = "EmailOTPDisabled"
+
+	SMSInvalidPhoneNumber = "SMSInvalidPhoneNumber"
+	SMSCodeSent           = "SMSCodeSent"
+	SMSRateLimited        = "SMSRateLimited"
+	SMSPhoneVerified      = "SMSPhoneVerified"
+	SMSPhoneRemoved       = "SMSPhoneRemoved"
+
 	BackupTokenOverwriteRequired = "CreateBackupTokenOverwriteRequired"
 	BackupTokensRemoved          = "BackupTokensRemoved"
 
@@ -77,4 +104,7 @@ const (
 	OAuthNoTokenFound       = "OAuthNoTokenFound"
 	OAuthNoGrantedScopes    = "OAuthNoGrantedScopes"
 	OAuthMissingAccessToken = "OAuthMissingAccessToken"
+	OAuthNoDevicePending    = "OAuthNoDevicePending"
+	OAuthGrantRevoked       = "OAuthGrantRevoked"
+	OAuthClientRevoked      = "OAuthClientRevoked"
 )
@@ -0,0 +1,22 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Types for rate limiting hook implementations
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitError error returned by hooks where a request has exceeded a rate limit
+// API handlers should respond with 429 Too Many Requests and the provided Retry-After time
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded (retry after %s)", e.RetryAfter)
+}
@@ -0,0 +1,35 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Types for login risk hook implementations
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package api
+
+// RiskAction Login risk action type for interface
+// Actions are ordered by severity, where multiple hooks flag a login the most severe action is applied
+type RiskAction string
+
+// Login risk actions
+const RiskActionAllow RiskAction = "allow"
+const RiskActionSecondFactor RiskAction = "second-factor"
+const RiskActionEmailConfirmation RiskAction = "email-confirmation"
+const RiskActionBlock RiskAction = "block"
+
+var riskSeverity = map[RiskAction]int{
+	RiskActionAllow:             0,
+	RiskActionSecondFactor:      1,
+	RiskActionEmailConfirmation: 2,
+	RiskActionBlock:             3,
+}
+
+// IsValid checks whether a risk action is recognised
+func (a RiskAction) IsValid() bool {
+	_, ok := riskSeverity[a]
+	return ok
+}
+
+// Exceeds checks whether a risk action is more severe than the provided action
+func (a RiskAction) Exceeds(b RiskAction) bool {
+	return riskSeverity[a] > riskSeverity[b]
+}
@@ -17,6 +17,8 @@ type TokenAction string
 const TokenActionActivate TokenAction = "activate"
 const TokenActionUnlock TokenAction = "unlock"
 const TokenActionRecovery TokenAction = "recover"
+const TokenActionLogin TokenAction = "login"
+const TokenActionLock TokenAction = "lock"
 
 // Token error actions
 const TokenActionInvalid TokenAction = "invalid"
@@ -9,6 +9,7 @@ package app
 import (
 	"net/http"
 	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
@@ -17,9 +18,11 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
 	"github.com/authplz/authplz-core/lib/controllers/datastore"
 	"github.com/authplz/authplz-core/lib/modules/2fa/backup"
 	"github.com/authplz/authplz-core/lib/modules/2fa/totp"
+	"github.com/authplz/authplz-core/lib/modules/devices"
 	"github.com/authplz/authplz-core/lib/test"
 )
 
@@ -322,9 +325,39 @@ func TestMain(t *testing.T) {
 		}
 	})
 
+	t.Run("Password updates require sudo", func(t *testing.T) {
+		v := url.Values{}
+		v.Set("old_password", fakePass)
+		v.Set("new_password", test.NewPass)
+
+		resp, err := client.PostForm("/account", http.StatusForbidden, v)
+		assert.Nil(t, err)
+
+		err = test.ParseAndCheckAPIResponse(resp, api.SudoRequired)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Logged in users can enter sudo mode", func(t *testing.T) {
+		v := url.Values{}
+		v.Set("password", fakePass)
+
+		resp, err := client.PostForm("/sudo", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		err = test.ParseAndCheckAPIResponse(resp, api.SudoSuccessful)
+		assert.Nil(t, err)
+	})
+
 	t.Run("Logged in users can update passwords", func(t *testing.T) {
+		// Login a second session, which should be revoked on password change
+		client2 := test.NewClient(apiPath)
 		v := url.Values{}
+		v.Set("email", test.FakeEmail)
+		v.Set("password", fakePass)
+		_, err := client2.PostForm("/login", http.StatusOK, v)
+		assert.Nil(t, err)
 
+		v = url.Values{}
 		v.Set("email", test.FakeEmail)
 		v.Set("old_password", fakePass)
 		v.Set("new_password", test.NewPass)
@@ -336,6 +369,11 @@ func TestMain(t *testing.T) {
 		assert.Nil(t, err)
 
 		fakePass = test.NewPass
+
+		err = client.GetAPIResponse("/status", http.StatusOK, api.LoginSuccessful)
+		assert.Nil(t, err)
+		err = client2.GetAPIResponse("/status", http.StatusUnauthorized, api.Unauthorized)
+		assert.Nil(t, err)
 	})
 
 	t.Run("Users must be logged in to update passwords", func(t *testing.T) {
@@ -435,6 +473,23 @@ func TestMain(t *testing.T) {
 		fakePass = test.NewPass
 	})
 
+	t.Run("Password resets revoke existing sessions", func(t *testing.T) {
+		err := client.GetAPIResponse("/status", http.StatusUnauthorized, api.Unauthorized)
+		assert.Nil(t, err)
+
+		// Login and reauthorize for following tests
+		v := url.Values{}
+		v.Set("email", test.FakeEmail)
+		v.Set("password", fakePass)
+		_, err = client.PostForm("/login", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		v = url.Values{}
+		v.Set("password", fakePass)
+		_, err = client.PostForm("/sudo", http.StatusOK, v)
+		assert.Nil(t, err)
+	})
+
 	t.Run("Logged in users can enrol fido tokens", func(t *testing.T) {
 		v := url.Values{}
 		v.Set("name", "fakeToken")
@@ -591,11 +646,15 @@ func TestMain(t *testing.T) {
 
 		client2 := test.NewClient(apiPath)
 
+		u, err := server.ds.GetUserByEmail(test.FakeEmail)
+		assert.Nil(t, err)
+		lastLogin := u.(*datastore.User).GetLastLogin()
+
 		// Start login
 		v := url.Values{}
 		v.Set("email", test.FakeEmail)
 		v.Set("password", fakePass)
-		_, err := client2.PostForm("/login", http.StatusAccepted, v)
+		_, err = client2.PostForm("/login", http.StatusAccepted, v)
 		assert.Nil(t, err)
 
 		// Generate challenge response
@@ -615,6 +674,11 @@ func TestMain(t *testing.T) {
 		err = client2.GetAPIResponse("/status", http.StatusOK, api.LoginSuccessful)
 		assert.Nil(t, err)
 
+		// Check post login handlers have been run
+		u, err = server.ds.GetUserByEmail(test.FakeEmail)
+		assert.Nil(t, err)
+		assert.True(t, u.(*datastore.User).GetLastLogin().After(lastLogin))
+
 	})
 
 	t.Run("Second factor allows login (backup code)", func(t *testing.T) {
@@ -643,6 +707,60 @@ func TestMain(t *testing.T) {
 
 	})
 
+	t.Run("Trusted devices skip second factor login", func(t *testing.T) {
+
+		client2 := test.NewClient(apiPath)
+
+		v := url.Values{}
+		v.Set("email", test.FakeEmail)
+		v.Set("password", fakePass)
+
+		// Devices can not be trusted without a second factor login
+		_, err := client.PostForm("/devices/trust", http.StatusForbidden, url.Values{})
+		assert.Nil(t, err)
+
+		// Login with u2f
+		_, err = client2.PostForm("/login", http.StatusAccepted, v)
+		assert.Nil(t, err)
+
+		var sr u2f.SignRequestMessage
+		err = client2.GetJSON("/u2f/authenticate", 200, &sr)
+		assert.Nil(t, err)
+		signResp, err := vt.HandleAuthenticationRequest(sr)
+		assert.Nil(t, err)
+		_, err = client2.PostJSON("/u2f/authenticate", http.StatusOK, signResp)
+		assert.Nil(t, err)
+
+		// Trust device
+		resp, err := client2.PostForm("/devices/trust", http.StatusOK, url.Values{})
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.DeviceTrusted)
+		assert.Nil(t, err)
+
+		// Subsequent logins skip the second factor
+		_, err = client2.Get("/logout", http.StatusOK)
+		assert.Nil(t, err)
+		_, err = client2.PostForm("/login", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		// Trusted devices can be listed and revoked
+		trusted := make([]devices.DeviceResp, 0)
+		err = client2.GetJSON("/devices", http.StatusOK, &trusted)
+		assert.Nil(t, err)
+		if len(trusted) != 1 || !trusted[0].Current {
+			t.Errorf("Expected current trusted device, received %+v", trusted)
+			t.FailNow()
+		}
+
+		_, err = client2.PostForm("/devices/revoke", http.StatusOK, url.Values{"id": {strconv.Itoa(int(trusted[0].ID))}})
+		assert.Nil(t, err)
+
+		_, err = client2.Get("/logout", http.StatusOK)
+		assert.Nil(t, err)
+		_, err = client2.PostForm("/login", http.StatusAccepted, v)
+		assert.Nil(t, err)
+	})
+
 	t.Run("Users can request password resets with 2fa", func(t *testing.T) {
 		client2 := test.NewClient(apiPath)
 
@@ -682,6 +800,36 @@ func TestMain(t *testing.T) {
 		fakePass = test.NewPass
 	})
 
+	t.Run("Password resets with 2fa revoke existing sessions", func(t *testing.T) {
+		err := client.GetAPIResponse("/status", http.StatusUnauthorized, api.Unauthorized)
+		assert.Nil(t, err)
+
+		// Login and reauthorize for following tests
+		v := url.Values{}
+		v.Set("email", test.FakeEmail)
+		v.Set("password", fakePass)
+		_, err = client.PostForm("/login", http.StatusAccepted, v)
+		assert.Nil(t, err)
+
+		code, err := _totp.GenerateCode(totpSecret, time.Now())
+		assert.Nil(t, err)
+
+		v = url.Values{}
+		v.Set("code", code)
+		_, err = client.PostForm("/totp/authenticate", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		v = url.Values{}
+		v.Set("password", fakePass)
+		_, err = client.PostForm("/sudo", http.StatusAccepted, v)
+		assert.Nil(t, err)
+
+		v = url.Values{}
+		v.Set("code", code)
+		_, err = client.PostForm("/totp/authenticate", http.StatusOK, v)
+		assert.Nil(t, err)
+	})
+
 	t.Run("Logged in users can list backup tokens", func(t *testing.T) {
 		resp, err := client.Get("/backupcode/codes", http.StatusOK)
 		assert.Nil(t, err)
@@ -713,6 +861,38 @@ func TestMain(t *testing.T) {
 		assert.EqualValues(t, map[string]bool{"totp": true, "u2f": true, "backup": false}, factors)
 	})
 
+	t.Run("Sudo requires a second factor when enrolled", func(t *testing.T) {
+		_, err := client.PostForm("/sudo/end", http.StatusOK, url.Values{})
+		assert.Nil(t, err)
+
+		v := url.Values{}
+		v.Set("password", fakePass)
+		resp, err := client.PostForm("/sudo", http.StatusAccepted, v)
+		assert.Nil(t, err)
+
+		factors := make(map[string]bool)
+		err = test.ParseJson(resp, &factors)
+		assert.Nil(t, err)
+		assert.True(t, factors["totp"])
+
+		// Protected actions are unavailable until the second factor is completed
+		_, err = client.Get("/backupcode/create", http.StatusForbidden)
+		assert.Nil(t, err)
+
+		code, err := _totp.GenerateCode(totpSecret, time.Now())
+		assert.Nil(t, err)
+
+		v = url.Values{}
+		v.Set("code", code)
+		_, err = client.PostForm("/totp/authenticate", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		var status appcontext.SudoStatus
+		err = client.GetJSON("/sudo", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.True(t, status.Active)
+	})
+
 	t.Run("Logged in users can logout", func(t *testing.T) {
 
 		// Perform logout
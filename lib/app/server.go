@@ -19,7 +19,6 @@ import (
 	"github.com/gocraft/web"
 	gcontext "github.com/gorilla/context"
 	"github.com/gorilla/handlers"
-	"github.com/gorilla/sessions"
 
 	"github.com/authplz/authplz-core/lib/api"
 	"github.com/authplz/authplz-core/lib/appcontext"
@@ -27,35 +26,48 @@ import (
 
 	"github.com/authplz/authplz-core/lib/controllers/datastore"
 	"github.com/authplz/authplz-core/lib/controllers/mailer"
+	"github.com/authplz/authplz-core/lib/controllers/mmdb"
+	"github.com/authplz/authplz-core/lib/controllers/outbox"
+	"github.com/authplz/authplz-core/lib/controllers/sessionstore"
+	"github.com/authplz/authplz-core/lib/controllers/sms"
 	"github.com/authplz/authplz-core/lib/controllers/token"
 
 	"github.com/authplz/authplz-core/lib/modules/2fa/backup"
+	"github.com/authplz/authplz-core/lib/modules/2fa/emailotp"
+	"github.com/authplz/authplz-core/lib/modules/2fa/smsotp"
 	"github.com/authplz/authplz-core/lib/modules/2fa/totp"
 	"github.com/authplz/authplz-core/lib/modules/2fa/u2f"
+	"github.com/authplz/authplz-core/lib/modules/2fa/webauthn"
+	"github.com/authplz/authplz-core/lib/modules/2fa/yubikey"
 
 	"github.com/authplz/authplz-core/lib/modules/audit"
 	"github.com/authplz/authplz-core/lib/modules/core"
+	"github.com/authplz/authplz-core/lib/modules/devices"
+	"github.com/authplz/authplz-core/lib/modules/geoip"
 	"github.com/authplz/authplz-core/lib/modules/oauth"
+	"github.com/authplz/authplz-core/lib/modules/risk"
 	"github.com/authplz/authplz-core/lib/modules/user"
 
-	"github.com/ryankurte/go-async"
+	"github.com/authplz/authplz-core/lib/plugins/ratelimit"
+	"github.com/authplz/authplz-core/lib/plugins/stuffing"
+	"github.com/authplz/authplz-core/lib/plugins/webhooks"
 )
 
 // AuthPlzServer Base AuthPlz server object
 type AuthPlzServer struct {
-	address        string
-	port           string
-	config         config.AuthPlzConfig
-	ds             *datastore.DataStore
-	ctx            appcontext.AuthPlzGlobalCtx
-	router         *web.Router
-	tokenControl   *token.TokenController
-	serviceManager *async.ServiceManager
-	server         *http.Server
+	address      string
+	port         string
+	config       config.AuthPlzConfig
+	ds           *datastore.DataStore
+	ctx          appcontext.AuthPlzGlobalCtx
+	router       *web.Router
+	tokenControl *token.TokenController
+	outbox       *outbox.Outbox
+	notifier     *datastore.Notifier
+	webhooks     *webhooks.Controller
+	server       *http.Server
 }
 
-const bufferSize uint = 64
-
 // NewServer Create an AuthPlz server instance
 func NewServer(config config.AuthPlzConfig) (*AuthPlzServer, error) {
 	server := AuthPlzServer{}
@@ -76,7 +88,7 @@ func NewServer(config config.AuthPlzConfig) (*AuthPlzServer, error) {
 	server.ds = dataStore
 
 	// Create session store
-	sessionStore := sessions.NewCookieStore([]byte(config.CookieSecret))
+	sessionStore := sessionstore.NewStore(dataStore, []byte(config.CookieSecret))
 	if config.DisableWebSecurity {
 		log.Println()
 		log.Println("*******************************************************************************")
@@ -97,49 +109,163 @@ func NewServer(config config.AuthPlzConfig) (*AuthPlzServer, error) {
 
 	// Create modules
 
-	// Create service manager (runs processes and distributes messages)
-	server.serviceManager = async.NewServiceManager(bufferSize)
+	// Create event outbox (persists events and distributes them to consumers)
+	server.outbox, err = outbox.NewOutbox(config.Outbox, dataStore)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading event outbox: %s", err)
+	}
+
+	// Notify instances sharing the database of events (wakes other instances without waiting to poll)
+	if config.Outbox.Notify {
+		server.notifier = dataStore.NewNotifier(config.Outbox.Channel)
+		server.outbox.BindNotifier(server.notifier)
+	}
 
 	// User management module
-	userModule := user.NewController(dataStore, server.serviceManager)
+	userModule := user.NewController(dataStore, config.Lockout, server.outbox)
 
 	// Core module
-	coreModule := core.NewController(tokenControl, userModule, server.serviceManager)
+	coreModule := core.NewController(tokenControl, userModule, server.outbox)
 
 	coreModule.BindModule("user", userModule)
 	coreModule.BindActionHandler(api.TokenActionActivate, userModule)
 	coreModule.BindActionHandler(api.TokenActionUnlock, userModule)
+	coreModule.BindActionHandler(api.TokenActionLock, userModule)
+
+	// Mailer module
+	mailController, err := mailer.NewMailController(config.Name, config.ExternalAddress, config.Mailer.Driver, config.Mailer.Options, dataStore, tokenControl, config.TemplateDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading mail controller: %s", err)
+	}
+
+	// SMS controller
+	smsController, err := sms.NewSMSController(config.Name, config.SMS.Driver, config.SMS.Options)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading sms controller: %s", err)
+	}
 
 	// 2fa modules
-	u2fModule := u2f.NewController(config.ExternalAddress, dataStore, server.serviceManager)
+	u2fModule := u2f.NewController(config.ExternalAddress, dataStore, coreModule, server.outbox)
 	coreModule.BindSecondFactor("u2f", u2fModule)
 
-	totpModule := totp.NewController(config.Name, dataStore, server.serviceManager)
+	webAuthnModule, err := webauthn.NewController(config.Name, config.ExternalAddress, dataStore, coreModule, server.outbox)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading webauthn module: %s", err)
+	}
+	coreModule.BindSecondFactor("webauthn", webAuthnModule)
+	webAuthnModule.BindLoginHandler(coreModule)
+
+	totpModule := totp.NewController(config.Name, dataStore, coreModule, server.outbox)
 	coreModule.BindSecondFactor("totp", totpModule)
 
-	backupModule := backup.NewController(config.Name, dataStore, server.serviceManager)
-	coreModule.BindSecondFactor("backup", backupModule)
+	emailOTPModule := emailotp.NewController(dataStore, mailController, coreModule, server.outbox)
+	coreModule.BindSecondFactor("emailotp", emailOTPModule)
 
-	// Audit module (async service)
-	auditModule := audit.NewController(dataStore)
-	auditSvc := async.NewAsyncService(auditModule, bufferSize)
-	server.serviceManager.BindService(&auditSvc)
+	smsOTPModule := smsotp.NewController(dataStore, smsController, config.SMS.SendLimit, coreModule, server.outbox)
+	coreModule.BindSecondFactor("sms", smsOTPModule)
 
-	// Mailer module
-	mailController, err := mailer.NewMailController(config.Name, config.ExternalAddress, config.Mailer.Driver, config.Mailer.Options, dataStore, tokenControl, config.TemplateDir)
+	yubikeyModule, err := yubikey.NewController(config.TokenSecret, dataStore, coreModule, server.outbox)
 	if err != nil {
-		return nil, fmt.Errorf("Error loading mail controller: %s", err)
+		return nil, fmt.Errorf("Error loading yubikey module: %s", err)
+	}
+	coreModule.BindSecondFactor("yubikey", yubikeyModule)
+
+	backupModule := backup.NewController(config.Name, dataStore, coreModule, server.outbox)
+	coreModule.BindSecondFactor("backup", backupModule)
+
+	// Trusted and known devices module (outbox consumer to handle device invalidation)
+	devicesModule, err := devices.NewController(config.CookieSecret, config.TrustedDeviceTimeout, dataStore, server.outbox)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading trusted devices module: %s", err)
+	}
+	coreModule.BindModule("devices", devicesModule)
+	server.outbox.BindConsumer("devices", devicesModule)
+
+	// GeoIP module (enabled if a database is provided)
+	var geoModule *geoip.Controller
+	if config.GeoIP.Database != "" {
+		geoReader, err := mmdb.NewReader(config.GeoIP.Database, config.GeoIP.ASNDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading GeoIP database: %s", err)
+		}
+		geoModule, err = geoip.NewController(geoReader, dataStore, config.GeoIP)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading GeoIP module: %s", err)
+		}
+		coreModule.BindModule("geoip", geoModule)
+	}
+
+	// Login risk policy module
+	if config.Risk.Enabled {
+		riskModule, err := risk.NewController(config.Risk, dataStore, devicesModule, server.outbox)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading login risk module: %s", err)
+		}
+		coreModule.BindModule("risk", riskModule)
+	}
+
+	// Rate limiting plugin
+	var rateLimiter *ratelimit.Controller
+	if config.RateLimit.Enabled {
+		var backend ratelimit.Backend
+		switch config.RateLimit.Backend {
+		case ratelimit.BackendMemory:
+			backend = ratelimit.NewMemoryBackend()
+		case ratelimit.BackendDatabase:
+			backend = ratelimit.NewDatabaseBackend(dataStore)
+		default:
+			return nil, fmt.Errorf("Unrecognised rate limit backend: %s", config.RateLimit.Backend)
+		}
+
+		rateLimiter, err = ratelimit.NewController(config.RateLimit, backend)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading rate limiting plugin: %s", err)
+		}
+		coreModule.BindModule("ratelimit", rateLimiter)
+	}
+
+	// Credential stuffing detection plugin (outbox broadcast consumer to share blocks between instances)
+	var stuffingDetector *stuffing.Controller
+	if config.Stuffing.Enabled {
+		stuffingDetector, err = stuffing.NewController(config.Stuffing, server.outbox)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading credential stuffing plugin: %s", err)
+		}
+		coreModule.BindModule("stuffing", stuffingDetector)
+		server.outbox.BindBroadcastConsumer("stuffing", stuffingDetector)
+	}
+
+	// Webhooks plugin (outbox consumer with delivery worker)
+	var webhooksPlugin *webhooks.Controller
+	if config.Webhooks.Enabled {
+		webhooksPlugin, err = webhooks.NewController(config.Webhooks, dataStore)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading webhooks plugin: %s", err)
+		}
+		server.outbox.BindConsumer("webhooks", webhooksPlugin)
 	}
 
-	// Create async mailer service and distribute events to it
-	mailSvc := async.NewAsyncService(mailController, bufferSize)
-	server.serviceManager.BindService(&mailSvc)
+	// Audit module (outbox consumer)
+	auditModule := audit.NewController(dataStore)
+	server.outbox.BindConsumer("audit", auditModule)
+
+	// Mailer (outbox consumer)
+	server.outbox.BindConsumer("mailer", mailController)
 
 	// OAuth management module
-	oauthModule := oauth.NewController(dataStore, config.OAuth)
+	oauthModule, err := oauth.NewController(dataStore, config.OAuth, server.outbox)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading oauth module: %s", err)
+	}
 
 	// Create a global context object
 	server.ctx = appcontext.NewGlobalCtx(sessionStore)
+	server.ctx.SecureCookies = !config.DisableWebSecurity
+	coreModule.BindFlows(&server.ctx, config.SudoTimeout)
+	oauthModule.BindFlows(&server.ctx)
+	if geoModule != nil {
+		server.ctx.BindMetaEnricher("geoip", geoModule)
+	}
 
 	// Create router
 	router := web.New(appcontext.AuthPlzCtx{}).
@@ -148,6 +274,12 @@ func NewServer(config config.AuthPlzConfig) (*AuthPlzServer, error) {
 		Middleware((*appcontext.AuthPlzCtx).GetIPMiddleware)
 
 	router = router.Middleware(web.LoggerMiddleware)
+	if rateLimiter != nil {
+		router = router.Middleware(rateLimiter.Middleware())
+	}
+	if stuffingDetector != nil {
+		router = router.Middleware(stuffingDetector.Middleware())
+	}
 
 	log.Printf("Allowed-Origins: %+v", config.AllowedOrigins)
 
@@ -165,10 +297,19 @@ func NewServer(config config.AuthPlzConfig) (*AuthPlzServer, error) {
 	coreModule.BindAPI(router)
 	userModule.BindAPI(router)
 	u2fModule.BindAPI(router)
+	webAuthnModule.BindAPI(router)
 	totpModule.BindAPI(router)
+	emailOTPModule.BindAPI(router)
+	smsOTPModule.BindAPI(router)
+	yubikeyModule.BindAPI(router)
 	backupModule.BindAPI(router)
+	devicesModule.BindAPI(router)
 	auditModule.BindAPI(router)
 	oauthModule.BindAPI(router)
+	if webhooksPlugin != nil {
+		webhooksPlugin.BindAPI(router)
+	}
+	server.webhooks = webhooksPlugin
 
 	server.router = router
 
@@ -192,8 +333,11 @@ func (server *AuthPlzServer) Start() {
 	h := http.Server{Addr: address, Handler: contextHandler}
 	server.server = &h
 
-	// Start async services
-	server.serviceManager.Run()
+	// Start event delivery
+	server.outbox.Start()
+	if server.webhooks != nil {
+		server.webhooks.Start()
+	}
 
 	// Start with/without TLS
 	var err error
@@ -211,8 +355,11 @@ func (server *AuthPlzServer) Start() {
 		h.ListenAndServeTLS(server.config.TLS.Cert, server.config.TLS.Key)
 	}
 
-	// Stop async services
-	server.serviceManager.Exit()
+	// Stop event delivery
+	server.outbox.Stop()
+	if server.webhooks != nil {
+		server.webhooks.Stop()
+	}
 
 	// Handle errors
 	if err != nil {
@@ -228,7 +375,13 @@ func (server *AuthPlzServer) Close() {
 	cancel()
 
 	// Stop workers
-	server.serviceManager.Exit()
+	server.outbox.Stop()
+	if server.webhooks != nil {
+		server.webhooks.Stop()
+	}
+	if server.notifier != nil {
+		server.notifier.Close()
+	}
 
 	// Close datastore
 	server.ds.Close()
@@ -8,61 +8,39 @@ package appcontext
 
 import (
 	"log"
-	"net/http"
 
-	"github.com/authplz/authplz-core/lib/api"
 	"github.com/gocraft/web"
 )
 
-// SecondFactorRequest is a request for 2fa
-// This is used to register a request that can be fetched by 2fa implementations
-type SecondFactorRequest struct {
-	UserID string
-	Action string
-}
-
-const (
-	secondFactorRequestSessionKey = "2fa-request-session"
-	secondFactorUserIDKey         = "2fa-user-id"
-	secondFactorActionKey         = "2fa-action"
-
-	secondFactorTimeout = 60 * 10
-)
-
 // Bind2FARequest Bind a 2fa request and action for a user
-func (c *AuthPlzCtx) Bind2FARequest(rw web.ResponseWriter, req *web.Request, userID string, action string) {
-	log.Printf("AuthPlzCtx.Bind2faRequest adding 2fa request session for user %s\n", userID)
-
-	session, err := c.GetNamedSession(rw, req, secondFactorRequestSessionKey)
-	if err != nil {
-		c.WriteInternalError(rw)
-		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
-		return
-	}
-
-	session.Values[secondFactorUserIDKey] = userID
-	session.Values[secondFactorActionKey] = action
-	session.Options.MaxAge = secondFactorTimeout
-
-	session.Save(req.Request, rw)
+// This starts a flow for the action with a pending second factor step, listing the available factors.
+// Methods are the authentication methods already used by the user (ie. password for login flows).
+func (c *AuthPlzCtx) Bind2FARequest(rw web.ResponseWriter, req *web.Request, userID string, action string, factors map[string]bool, methods ...string) error {
+	log.Printf("AuthPlzCtx.Bind2faRequest adding 2fa request for user %s (action %s)\n", userID, action)
+
+	return c.startFlow(rw, req, Flow{
+		UserID:  userID,
+		Action:  action,
+		Options: factors,
+		Methods: methods,
+		Steps:   []FlowStep{FlowStepSecondFactor},
+	})
 }
 
 // Get2FARequest Fetch a 2fa request and action for a user
+// Returns empty strings if no flow is waiting on a second factor
 func (c *AuthPlzCtx) Get2FARequest(rw web.ResponseWriter, req *web.Request) (string, string) {
-	session, err := c.GetNamedSession(rw, req, secondFactorRequestSessionKey)
-	if err != nil {
+	flow := c.ClaimFlowStep(rw, req, FlowStepSecondFactor)
+	if flow == nil {
 		return "", ""
 	}
 
-	userID, ok1 := session.Values[secondFactorUserIDKey]
-	action, ok2 := session.Values[secondFactorActionKey]
-
-	if !ok1 || !ok2 {
-		return "", ""
-	}
-
-	session.Options.MaxAge = -1
-	session.Save(req.Request, rw)
+	return flow.UserID, flow.Action
+}
 
-	return userID.(string), action.(string)
+// Complete2FARequest completes the second factor step of a pending 2fa request
+// The completed callback is run once the pending request has been verified, and the flow action is
+// executed if the callback succeeds and no further steps are required
+func (c *AuthPlzCtx) Complete2FARequest(rw web.ResponseWriter, req *web.Request, userID string, action string, completed func() error) error {
+	return c.CompleteFlowStep(rw, req, userID, action, FlowStepSecondFactor, completed)
 }
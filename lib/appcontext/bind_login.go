@@ -0,0 +1,34 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Application context handlers for email login sessions
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package appcontext
+
+import (
+	"github.com/gocraft/web"
+)
+
+const (
+	loginEmailKey = "login-email"
+)
+
+// BindLoginEmail binds a pending email login to the session
+// This is used for both email logins and confirmation of flagged logins, login links must
+// be applied on the same device that requested them
+func (c *AuthPlzCtx) BindLoginEmail(rw web.ResponseWriter, req *web.Request, email string) {
+	c.session.Values[loginEmailKey] = email
+	c.session.Save(req.Request, rw)
+}
+
+// TakeLoginEmail fetches and removes a pending email login from the session
+// This returns an empty string if no email login is pending
+func (c *AuthPlzCtx) TakeLoginEmail(rw web.ResponseWriter, req *web.Request) string {
+	e := c.session.Values[loginEmailKey]
+	delete(c.session.Values, loginEmailKey)
+	c.session.Save(req.Request, rw)
+
+	email, _ := e.(string)
+	return email
+}
@@ -16,22 +16,47 @@ import (
 
 	"github.com/gocraft/web"
 	"github.com/gorilla/sessions"
+	"github.com/satori/go.uuid"
 	//"github.com/authplz/authplz-core/lib/api"
 )
 
 func init() {
 	gob.Register(SudoSession{})
-	gob.Register(SecondFactorRequest{})
+	gob.Register(Flow{})
+	gob.Register(map[string]Flow{})
 }
 
 // AuthPlzGlobalCtx Application global / static context
 type AuthPlzGlobalCtx struct {
-	SessionStore *sessions.CookieStore
+	SessionStore sessions.Store
+	// SecureCookies sets the secure flag on cookies written outside of the session store
+	SecureCookies bool
+	flowActions   map[string]FlowAction
+	metaEnrichers map[string]MetaEnricher
+}
+
+// MetaEnricher interface for modules that add to the request metadata
+// Enrichers are provided with the request metadata (ie. remote address) and may add further fields
+// (ie. location) to be attached to emitted events
+type MetaEnricher interface {
+	EnrichMeta(meta map[string]string)
 }
 
 // NewGlobalCtx creates a new global context instance
-func NewGlobalCtx(sessionStore *sessions.CookieStore) AuthPlzGlobalCtx {
-	return AuthPlzGlobalCtx{sessionStore}
+func NewGlobalCtx(sessionStore sessions.Store) AuthPlzGlobalCtx {
+	return AuthPlzGlobalCtx{
+		SessionStore:  sessionStore,
+		flowActions:   make(map[string]FlowAction),
+		metaEnrichers: make(map[string]MetaEnricher),
+	}
+}
+
+// BindMetaEnricher binds a request metadata enricher to the global context
+func (g *AuthPlzGlobalCtx) BindMetaEnricher(name string, enricher MetaEnricher) {
+	if g.metaEnrichers == nil {
+		g.metaEnrichers = make(map[string]MetaEnricher)
+	}
+	g.metaEnrichers[name] = enricher
 }
 
 // AuthPlzCtx is the common per-request context
@@ -82,16 +107,28 @@ func (c *AuthPlzCtx) SessionMiddleware(rw web.ResponseWriter, req *web.Request,
 	// Save session for further use
 	c.session = session
 
+	// Bind a device identifier to the session for flow binding
+	if c.GetDeviceID() == "" {
+		session.Values[deviceIDKey] = uuid.NewV4().String()
+	}
+
 	// TODO: load user from session
 
 	session.Save(req.Request, rw)
 	next(rw, req)
 }
 
-// GetIPMiddleware Middleware to grab IP & forwarding headers and store in session
+// GetIPMiddleware Middleware to grab IP, forwarding and user agent headers and store in session
+// A request ID is also generated to allow events to be correlated with the originating request
 func (c *AuthPlzCtx) GetIPMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
 	c.meta["remote-address"], _, _ = net.SplitHostPort(req.RemoteAddr)
 	c.meta["forwarded-for"] = req.Header.Get("x-forwarded-for")
+	c.meta["user-agent"] = req.UserAgent()
+	c.meta["request-id"] = uuid.NewV4().String()
+
+	for _, enricher := range c.Global.metaEnrichers {
+		enricher.EnrichMeta(c.meta)
+	}
 
 	next(rw, req)
 }
@@ -105,14 +142,32 @@ func (c *AuthPlzCtx) RequireAccountMiddleware(rw web.ResponseWriter, req *web.Re
 	}
 }
 
+// Authentication methods recorded for user sessions, using RFC 8176 values where available
+const (
+	// LoginMethodPassword password login
+	LoginMethodPassword = "pwd"
+	// LoginMethodEmail login link sent by email
+	LoginMethodEmail = "email"
+	// LoginMethodHardwareKey hardware backed key login (ie. passkeys)
+	LoginMethodHardwareKey = "hwk"
+	// LoginMethodMultiFactor login with a second factor
+	LoginMethodMultiFactor = "mfa"
+
+	loginTimeKey    = "login-time"
+	loginMethodsKey = "login-methods"
+)
+
 // LoginUser Helper function to login a user
-func (c *AuthPlzCtx) LoginUser(userid string, rw web.ResponseWriter, req *web.Request) {
+// The login time and the authentication methods used are recorded with the session
+func (c *AuthPlzCtx) LoginUser(userid string, rw web.ResponseWriter, req *web.Request, methods ...string) {
 	if c.session == nil {
 		log.Printf("Error logging in user, no session found")
 		return
 	}
 
 	c.session.Values["userId"] = userid
+	c.session.Values[loginTimeKey] = time.Now().Unix()
+	c.session.Values[loginMethodsKey] = methods
 	c.session.Save(req.Request, rw)
 	c.userid = userid
 	log.Printf("Context: logged in user %s", userid)
@@ -124,8 +179,35 @@ func (c *AuthPlzCtx) LogoutUser(rw web.ResponseWriter, req *web.Request) {
 	c.session.Options.MaxAge = -1
 	c.session.Save(req.Request, rw)
 	c.userid = ""
+
+	// Sudo sessions must not outlive the user session
+	if session, err := c.GetNamedSession(rw, req, sudoSessionKey); err == nil {
+		session.Options.MaxAge = -1
+		session.Save(req.Request, rw)
+	}
+}
+
+// GetLoginTime fetches the time the current user session was logged in
+// This returns a zero time if no user is logged in
+func (c *AuthPlzCtx) GetLoginTime() time.Time {
+	t, ok := c.session.Values[loginTimeKey].(int64)
+	if !ok || c.GetUserID() == "" {
+		return time.Time{}
+	}
+	return time.Unix(t, 0)
 }
 
+// GetLoginMethods fetches the authentication methods used to log in the current user session
+func (c *AuthPlzCtx) GetLoginMethods() []string {
+	methods, ok := c.session.Values[loginMethodsKey].([]string)
+	if !ok || c.GetUserID() == "" {
+		return []string{}
+	}
+	return methods
+}
+
+// GetMeta fetches the request metadata (remote address, forwarded-for, user agent and request ID)
+// This is attached to emitted events to record the origin of user actions
 func (c *AuthPlzCtx) GetMeta() map[string]string {
 	return c.meta
 }
@@ -141,24 +223,6 @@ func (c *AuthPlzCtx) GetUserID() string {
 	}
 }
 
-// UserAction executes a user action, such as `login`
-// This is provided to allow modules to execute global actions as a given user across the API boundaries
-// For example, this allows 2fa to be used to validate a user action
-// TODO: a more elegant solution to this could be nice.
-func (c *AuthPlzCtx) UserAction(userid, action string, rw web.ResponseWriter, req *web.Request) {
-	switch action {
-	case "login":
-		c.LoginUser(userid, rw, req)
-	case "recover":
-		c.BindRecoveryRequest(userid, rw, req)
-	case "sudo":
-		// TODO: how to propagate duration through to here?
-		c.SetSudo(userid, time.Minute*5, rw, req)
-	default:
-		log.Printf("AuthPlzCtx.UserAction error: unrecognised user action (%s)", action)
-	}
-}
-
 const (
 	redirectSessionKey = "redirect-session"
 	redirectURLKey     = "redirect-url"
@@ -0,0 +1,76 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Application context trusted device helpers
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package appcontext
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+)
+
+const (
+	// TrustedDeviceCookie is the cookie used to hold trusted device tokens
+	// This is separate from the user session so device trust survives logout
+	TrustedDeviceCookie = "trusted-device"
+	// TrustedDeviceMeta is set to "true" in request metadata for logins from devices trusted by the user
+	TrustedDeviceMeta = "trusted-device"
+
+	secondFactorLoginKey = "second-factor-login"
+)
+
+// GetTrustedDeviceToken fetches the trusted device token from the request
+// This returns an empty string if no token is found
+func (c *AuthPlzCtx) GetTrustedDeviceToken(req *web.Request) string {
+	cookie, err := req.Cookie(TrustedDeviceCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// SetTrustedDeviceToken writes a trusted device token to the client
+func (c *AuthPlzCtx) SetTrustedDeviceToken(rw web.ResponseWriter, token string, expiry time.Time) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     TrustedDeviceCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiry,
+		MaxAge:   int(time.Until(expiry).Seconds()),
+		Secure:   c.Global.SecureCookies,
+		HttpOnly: true,
+	})
+}
+
+// ClearTrustedDeviceToken removes any trusted device token from the client
+func (c *AuthPlzCtx) ClearTrustedDeviceToken(rw web.ResponseWriter) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     TrustedDeviceCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(1, 0),
+		MaxAge:   -1,
+		Secure:   c.Global.SecureCookies,
+		HttpOnly: true,
+	})
+}
+
+// SetSecondFactorLogin records that the current user session was logged in using a second factor
+func (c *AuthPlzCtx) SetSecondFactorLogin(rw web.ResponseWriter, req *web.Request) {
+	c.session.Values[secondFactorLoginKey] = time.Now().Unix()
+	c.session.Save(req.Request, rw)
+}
+
+// GetSecondFactorLogin fetches the time the current user session was logged in using a second factor
+// This returns a zero time if the session was not logged in with a second factor
+func (c *AuthPlzCtx) GetSecondFactorLogin() time.Time {
+	t, ok := c.session.Values[secondFactorLoginKey].(int64)
+	if !ok || c.GetUserID() == "" {
+		return time.Time{}
+	}
+	return time.Unix(t, 0)
+}
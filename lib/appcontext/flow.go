@@ -0,0 +1,387 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Application context authentication flow engine
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package appcontext
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gocraft/web"
+	"github.com/gorilla/sessions"
+)
+
+// FlowStep is a step in an authentication flow
+type FlowStep string
+
+// Authentication flow steps
+const (
+	// FlowStepCredentials user credentials are required to start a flow
+	FlowStepCredentials FlowStep = "credentials"
+	// FlowStepSecondFactor a second factor must be validated
+	FlowStepSecondFactor FlowStep = "second-factor"
+	// FlowStepTokenAction a pending action token must be applied
+	FlowStepTokenAction FlowStep = "token-action"
+	// FlowStepConsent the user must consent to a pending authorization request
+	FlowStepConsent FlowStep = "consent"
+	// FlowStepComplete all steps have been completed
+	FlowStepComplete FlowStep = "complete"
+)
+
+// Common flow actions
+const (
+	// FlowActionLogin logs in a user on completion
+	FlowActionLogin = "login"
+	// FlowActionRecover authorises account recovery on completion
+	FlowActionRecover = "recover"
+	// FlowActionSudo grants a sudo session on completion
+	FlowActionSudo = "sudo"
+	// FlowActionToken applies an action token once the user has logged in
+	FlowActionToken = "token"
+	// FlowActionOAuthConsent holds an OAuth authorization request until the user consents
+	FlowActionOAuthConsent = "oauth-consent"
+)
+
+const (
+	flowSessionKey = "auth-flow-session"
+	flowKey        = "auth-flow"
+	deviceIDKey    = "device-id"
+
+	// Default timeout for flows with no registered timeout
+	defaultFlowTimeout = 10 * time.Minute
+	// Maximum number of attempts at a single flow step
+	flowMaxAttempts = 5
+)
+
+var (
+	// ErrNoFlowPending returned when no matching flow is bound to the session
+	ErrNoFlowPending = errors.New("appcontext: no matching flow pending")
+	// ErrUnknownFlowAction returned when a flow is started for an unregistered action
+	ErrUnknownFlowAction = errors.New("appcontext: unknown flow action")
+)
+
+// FlowAction defines an action executed at the completion of an authentication flow
+type FlowAction struct {
+	// Timeout after which incomplete flows are discarded
+	Timeout time.Duration
+	// Retain completed flows for consumption with TakeCompletedFlow
+	Retain bool
+	// Complete is called with the flow when all steps of a flow have been completed
+	Complete func(c *AuthPlzCtx, flow *Flow, rw web.ResponseWriter, req *web.Request)
+}
+
+// Flow is an authentication flow bound to a user session
+// Flows are bound to the device that started them and expire after the action timeout.
+// A session holds at most one flow per action, so flows for different actions (ie. a pending
+// OAuth consent and the login it is waiting on) may be in progress at the same time.
+type Flow struct {
+	UserID   string
+	Action   string
+	DeviceID string
+	Steps    []FlowStep
+	Options  map[string]bool
+	Methods  []string
+	// Data is action specific flow data (ie. a pending token or authorization request)
+	Data     interface{}
+	Attempts uint
+	Started  time.Time
+	Expires  time.Time
+}
+
+// Next fetches the next step required to complete the flow
+func (f *Flow) Next() FlowStep {
+	if len(f.Steps) == 0 {
+		return FlowStepComplete
+	}
+	return f.Steps[0]
+}
+
+// FlowStatus is the API safe status of a flow
+type FlowStatus struct {
+	Action  string          `json:"action,omitempty"`
+	Step    FlowStep        `json:"step"`
+	Options map[string]bool `json:"options,omitempty"`
+	Expires *time.Time      `json:"expires,omitempty"`
+}
+
+// RegisterFlowAction registers an action that may be executed by authentication flows
+// Modules register the actions they start with the global context
+func (g *AuthPlzGlobalCtx) RegisterFlowAction(name string, action FlowAction) {
+	if g.flowActions == nil {
+		g.flowActions = make(map[string]FlowAction)
+	}
+	if action.Timeout == 0 {
+		action.Timeout = defaultFlowTimeout
+	}
+	g.flowActions[name] = action
+}
+
+// GetDeviceID fetches the device identifier bound to the user session
+func (c *AuthPlzCtx) GetDeviceID() string {
+	id, ok := c.session.Values[deviceIDKey].(string)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// StartFlow starts an authentication flow for a user, replacing any existing flow for the action
+// Steps are the remaining steps required to complete the action, flows with no steps are completed immediately
+func (c *AuthPlzCtx) StartFlow(rw web.ResponseWriter, req *web.Request, userID, action string, options map[string]bool, steps ...FlowStep) error {
+	return c.startFlow(rw, req, Flow{UserID: userID, Action: action, Options: options, Steps: steps})
+}
+
+// StartFlowWithData starts an authentication flow carrying action specific data
+// Flows started without a user ID are bound to the user completing the credentials step
+func (c *AuthPlzCtx) StartFlowWithData(rw web.ResponseWriter, req *web.Request, userID, action string, data interface{}, steps ...FlowStep) error {
+	return c.startFlow(rw, req, Flow{UserID: userID, Action: action, Data: data, Steps: steps})
+}
+
+// startFlow starts the provided flow, binding it to the current device
+func (c *AuthPlzCtx) startFlow(rw web.ResponseWriter, req *web.Request, flow Flow) error {
+	flowAction, ok := c.Global.flowActions[flow.Action]
+	if !ok {
+		log.Printf("AuthPlzCtx.StartFlow no flow action registered for %s", flow.Action)
+		return ErrUnknownFlowAction
+	}
+
+	log.Printf("AuthPlzCtx.StartFlow starting %s flow for user %s (steps: %v)", flow.Action, flow.UserID, flow.Steps)
+
+	flow.DeviceID = c.GetDeviceID()
+	flow.Started = time.Now()
+	flow.Expires = time.Now().Add(flowAction.Timeout)
+
+	if len(flow.Steps) == 0 {
+		return c.completeFlow(rw, req, &flow, flowAction)
+	}
+
+	return c.saveFlow(rw, req, &flow)
+}
+
+// GetFlow fetches the most recently started flow bound to the session
+// Expired flows and flows started on another device are discarded
+func (c *AuthPlzCtx) GetFlow(rw web.ResponseWriter, req *web.Request) *Flow {
+	return c.findFlow(rw, req, func(flow *Flow) bool { return true })
+}
+
+// GetActionFlow fetches the flow bound to the session for the provided action
+func (c *AuthPlzCtx) GetActionFlow(rw web.ResponseWriter, req *web.Request, action string) *Flow {
+	return c.findFlow(rw, req, func(flow *Flow) bool { return flow.Action == action })
+}
+
+// ClaimFlowStep fetches the most recently started flow waiting on the provided step
+// Each claim is counted as an attempt, and flows are cancelled after too many attempts
+func (c *AuthPlzCtx) ClaimFlowStep(rw web.ResponseWriter, req *web.Request, step FlowStep) *Flow {
+	flow := c.findFlow(rw, req, func(flow *Flow) bool { return flow.Next() == step })
+	if flow == nil {
+		return nil
+	}
+
+	flow.Attempts++
+	if flow.Attempts > flowMaxAttempts {
+		log.Printf("AuthPlzCtx.ClaimFlowStep attempt limit exceeded for %s flow (user %s)", flow.Action, flow.UserID)
+		c.CancelFlow(rw, req, flow.Action)
+		return nil
+	}
+
+	if err := c.saveFlow(rw, req, flow); err != nil {
+		return nil
+	}
+
+	return flow
+}
+
+// CompleteFlowStep marks a step of the flow for an action as completed
+// The optional completed callback is run once the flow has been verified as waiting on the step, errors
+// returned by the callback leave the flow unchanged. Completing the credentials step of a flow with no
+// user binds the flow to the provided user. The flow action is executed when all steps have been completed
+func (c *AuthPlzCtx) CompleteFlowStep(rw web.ResponseWriter, req *web.Request, userID, action string, step FlowStep, completed func() error) error {
+	flow := c.GetActionFlow(rw, req, action)
+	if flow == nil || flow.Next() != step || !flow.boundTo(userID, step) {
+		log.Printf("AuthPlzCtx.CompleteFlowStep no %s flow waiting on step %s for user %s", action, step, userID)
+		return ErrNoFlowPending
+	}
+
+	flowAction, ok := c.Global.flowActions[action]
+	if !ok {
+		return ErrUnknownFlowAction
+	}
+
+	if completed != nil {
+		if err := completed(); err != nil {
+			return err
+		}
+	}
+
+	flow.UserID = userID
+	flow.Steps = flow.Steps[1:]
+	flow.Attempts = 0
+
+	if len(flow.Steps) == 0 {
+		return c.completeFlow(rw, req, flow, flowAction)
+	}
+
+	return c.saveFlow(rw, req, flow)
+}
+
+// TakeCompletedFlow fetches and removes the user ID of a completed flow for the provided action
+// This returns an empty string if no completed flow is found
+func (c *AuthPlzCtx) TakeCompletedFlow(rw web.ResponseWriter, req *web.Request, action string) string {
+	flow := c.GetActionFlow(rw, req, action)
+	if flow == nil || flow.Next() != FlowStepComplete {
+		return ""
+	}
+
+	c.CancelFlow(rw, req, action)
+
+	return flow.UserID
+}
+
+// CancelFlow removes the flow for an action from the session
+func (c *AuthPlzCtx) CancelFlow(rw web.ResponseWriter, req *web.Request, action string) {
+	session, flows, err := c.loadFlows(rw, req)
+	if err != nil {
+		return
+	}
+
+	delete(flows, action)
+	c.saveFlows(rw, req, session, flows)
+}
+
+// GetFlowStatus fetches the status of the current flow for reporting to the client
+func (c *AuthPlzCtx) GetFlowStatus(rw web.ResponseWriter, req *web.Request) FlowStatus {
+	flow := c.GetFlow(rw, req)
+	if flow == nil {
+		if c.GetUserID() != "" {
+			return FlowStatus{Step: FlowStepComplete}
+		}
+		return FlowStatus{Step: FlowStepCredentials}
+	}
+
+	return FlowStatus{
+		Action:  flow.Action,
+		Step:    flow.Next(),
+		Options: flow.Options,
+		Expires: &flow.Expires,
+	}
+}
+
+// completeFlow executes the action for a completed flow
+func (c *AuthPlzCtx) completeFlow(rw web.ResponseWriter, req *web.Request, flow *Flow, flowAction FlowAction) error {
+	log.Printf("AuthPlzCtx.completeFlow completed %s flow for user %s", flow.Action, flow.UserID)
+
+	if flowAction.Retain {
+		flow.Steps = nil
+		if err := c.saveFlow(rw, req, flow); err != nil {
+			return err
+		}
+	} else {
+		c.CancelFlow(rw, req, flow.Action)
+	}
+
+	if flowAction.Complete != nil {
+		flowAction.Complete(c, flow, rw, req)
+	}
+
+	return nil
+}
+
+// boundTo checks whether a flow may be completed by a user
+// Flows started without a user may only be bound to a user at the credentials step
+func (f *Flow) boundTo(userID string, step FlowStep) bool {
+	if f.UserID == "" {
+		return step == FlowStepCredentials
+	}
+	return f.UserID == userID
+}
+
+// findFlow fetches the most recently started flow matching the provided filter
+// Expired flows and flows started on another device are discarded
+func (c *AuthPlzCtx) findFlow(rw web.ResponseWriter, req *web.Request, match func(flow *Flow) bool) *Flow {
+	session, flows, err := c.loadFlows(rw, req)
+	if err != nil {
+		return nil
+	}
+
+	var found *Flow
+	discarded := false
+
+	for action, flow := range flows {
+		flow := flow
+
+		if time.Now().After(flow.Expires) {
+			log.Printf("AuthPlzCtx.GetFlow discarding expired %s flow for user %s", flow.Action, flow.UserID)
+			delete(flows, action)
+			discarded = true
+			continue
+		}
+		if flow.DeviceID != c.GetDeviceID() {
+			log.Printf("AuthPlzCtx.GetFlow discarding %s flow for user %s (device mismatch)", flow.Action, flow.UserID)
+			delete(flows, action)
+			discarded = true
+			continue
+		}
+
+		if match(&flow) && (found == nil || flow.Started.After(found.Started)) {
+			found = &flow
+		}
+	}
+
+	if discarded {
+		c.saveFlows(rw, req, session, flows)
+	}
+
+	return found
+}
+
+// loadFlows fetches the flow session and the flows bound to it by action
+func (c *AuthPlzCtx) loadFlows(rw web.ResponseWriter, req *web.Request) (*sessions.Session, map[string]Flow, error) {
+	session, err := c.GetNamedSession(rw, req, flowSessionKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	flows, ok := session.Values[flowKey].(map[string]Flow)
+	if !ok {
+		flows = make(map[string]Flow)
+	}
+
+	return session, flows, nil
+}
+
+// saveFlow writes a flow to the flow session, replacing any flow for the same action
+func (c *AuthPlzCtx) saveFlow(rw web.ResponseWriter, req *web.Request, flow *Flow) error {
+	session, flows, err := c.loadFlows(rw, req)
+	if err != nil {
+		return err
+	}
+
+	flows[flow.Action] = *flow
+
+	return c.saveFlows(rw, req, session, flows)
+}
+
+// saveFlows writes the flows bound to the session, the session expires with the last flow
+func (c *AuthPlzCtx) saveFlows(rw web.ResponseWriter, req *web.Request, session *sessions.Session, flows map[string]Flow) error {
+	if len(flows) == 0 {
+		delete(session.Values, flowKey)
+		session.Options.MaxAge = -1
+		return session.Save(req.Request, rw)
+	}
+
+	var expires time.Time
+	for _, flow := range flows {
+		if flow.Expires.After(expires) {
+			expires = flow.Expires
+		}
+	}
+
+	session.Values[flowKey] = flows
+	session.Options.MaxAge = int(time.Until(expires).Seconds())
+
+	return session.Save(req.Request, rw)
+}
@@ -10,7 +10,10 @@ import (
 	"encoding/json"
 	"github.com/authplz/authplz-core/lib/api"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // WriteJSON Helper to write objects out as JSON
@@ -65,3 +68,13 @@ func (c *AuthPlzCtx) WriteUnauthorized(w http.ResponseWriter) {
 func (c *AuthPlzCtx) WriteInternalError(w http.ResponseWriter) {
 	c.WriteAPIResultWithCode(w, http.StatusInternalServerError, api.InternalError)
 }
+
+// WriteRateLimited helper to write rate limited status with a Retry-After header
+func (c *AuthPlzCtx) WriteRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	c.WriteAPIResultWithCode(w, http.StatusTooManyRequests, api.RateLimited)
+}
@@ -1,5 +1,5 @@
 /* AuthPlz Authentication and Authorization Microservice
- * Application context "sudo" implementation (WIP)
+ * Application context "sudo" implementation
  *
  * Copyright 2018 Ryan Kurte
  */
@@ -8,31 +8,41 @@ package appcontext
 
 import (
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
 )
 
 const (
 	// SudoSessionKey is the cookie key used for sudo session storage
 	sudoSessionKey = "sudo-session"
-	// Timeout for sudo sessions
-	sudoTimeout = 60 * 10 // 10 minutes
 )
 
 // SudoSession used to store user reauthorization sessions for protected account actions
 // Such as password changes or 2fa alterations
+// Sessions are pinned to the user session, user agent and remote address that created them
 type SudoSession struct {
 	UserID       string
+	SessionID    string
+	UserAgent    string
+	RemoteAddr   string
 	SessionStart time.Time
 	SessionEnd   time.Time
 }
 
+// SudoStatus is the API safe status of a sudo session
+type SudoStatus struct {
+	Active  bool       `json:"active"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
 // SetSudo used to indicate a user has reauthorized to allow protected account actions
-// TODO: could this be pinned to more things? (user agent, IP, real invalidation so it can't be reused if cancelled?)
-// Guess re-use is a bit moot given there is no reason to cancel atm
+// The sudo session is bound to the current user session, requesting user agent and remote address
 func (c *AuthPlzCtx) SetSudo(userID string, timeout time.Duration, rw web.ResponseWriter, req *web.Request) {
-	log.Printf("AuthPlzCtx.SetSudo: creating sudo session fo user %s", c.userid)
+	log.Printf("AuthPlzCtx.SetSudo: creating sudo session for user %s", userID)
 
 	session, err := c.GetNamedSession(rw, req, sudoSessionKey)
 	if err != nil {
@@ -42,18 +52,21 @@ func (c *AuthPlzCtx) SetSudo(userID string, timeout time.Duration, rw web.Respon
 
 	sudoSession := SudoSession{
 		UserID:       userID,
+		SessionID:    c.session.ID,
+		UserAgent:    req.UserAgent(),
+		RemoteAddr:   c.meta["remote-address"],
 		SessionStart: time.Now(),
 		SessionEnd:   time.Now().Add(timeout),
 	}
 
 	session.Values[sudoSessionKey] = sudoSession
-	session.Options.MaxAge = sudoTimeout
+	session.Options.MaxAge = int(timeout.Seconds())
 	session.Save(req.Request, rw)
 }
 
 // ClearSudo removes a sudo session from a user session
 func (c *AuthPlzCtx) ClearSudo(rw web.ResponseWriter, req *web.Request) {
-	log.Printf("AuthPlzCtx.ClearSudo: ending sudo session fo user %s", c.userid)
+	log.Printf("AuthPlzCtx.ClearSudo: ending sudo session for user %s", c.GetUserID())
 
 	session, err := c.GetNamedSession(rw, req, sudoSessionKey)
 	if err != nil {
@@ -61,37 +74,71 @@ func (c *AuthPlzCtx) ClearSudo(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
+	delete(session.Values, sudoSessionKey)
 	session.Options.MaxAge = -1
 	session.Save(req.Request, rw)
 }
 
-// CanSudo checks whether a user has a current sudo session
-func (c *AuthPlzCtx) CanSudo(rw web.ResponseWriter, req *web.Request) bool {
+// getSudo fetches the current sudo session if valid for the requesting user, agent and address
+func (c *AuthPlzCtx) getSudo(rw web.ResponseWriter, req *web.Request) *SudoSession {
 	session, err := c.GetNamedSession(rw, req, sudoSessionKey)
 	if err != nil {
-		c.WriteInternalError(rw)
-		return false
+		return nil
 	}
 	s := session.Values[sudoSessionKey]
 	if s == nil {
-		return false
+		return nil
 	}
 	sudoSession, ok := s.(SudoSession)
 	if !ok {
 		c.ClearSudo(rw, req)
-		return false
+		return nil
 	}
 	if time.Now().Before(sudoSession.SessionStart) {
 		c.ClearSudo(rw, req)
-		return false
+		return nil
 	}
 	if time.Now().After(sudoSession.SessionEnd) {
 		c.ClearSudo(rw, req)
-		return false
+		return nil
 	}
-	if sudoSession.UserID != c.GetUserID() {
+	if sudoSession.UserID != c.GetUserID() || sudoSession.SessionID != c.session.ID {
 		c.ClearSudo(rw, req)
-		return false
+		return nil
+	}
+	if sudoSession.UserAgent != req.UserAgent() || sudoSession.RemoteAddr != c.meta["remote-address"] {
+		log.Printf("AuthPlzCtx.CanSudo: discarding sudo session for user %s (agent or address mismatch)", sudoSession.UserID)
+		c.ClearSudo(rw, req)
+		return nil
+	}
+	return &sudoSession
+}
+
+// CanSudo checks whether a user has a current sudo session
+func (c *AuthPlzCtx) CanSudo(rw web.ResponseWriter, req *web.Request) bool {
+	return c.getSudo(rw, req) != nil
+}
+
+// GetSudoStatus fetches the status of the current sudo session for reporting to the client
+func (c *AuthPlzCtx) GetSudoStatus(rw web.ResponseWriter, req *web.Request) SudoStatus {
+	sudoSession := c.getSudo(rw, req)
+	if sudoSession == nil {
+		return SudoStatus{Active: false}
+	}
+	return SudoStatus{Active: true, Expires: &sudoSession.SessionEnd}
+}
+
+// RequireSudoMiddleware to ensure a current sudo session for protected account actions
+// Users must be logged in, and must have reauthorized via the sudo endpoints
+func (c *AuthPlzCtx) RequireSudoMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+	if !c.CanSudo(rw, req) {
+		log.Printf("AuthPlzCtx.RequireSudoMiddleware: no sudo session for user %s", c.GetUserID())
+		c.WriteAPIResultWithCode(rw, http.StatusForbidden, api.SudoRequired)
+		return
 	}
-	return true
+	next(rw, req)
 }
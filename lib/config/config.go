@@ -15,6 +15,7 @@ import (
 	"log"
 
 	"io/ioutil"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/ryankurte/go-structparse"
@@ -47,8 +48,24 @@ type AuthPlzConfig struct {
 	TLS    TLSConfig    `yaml:"tls"`
 	OAuth  OAuthConfig  `yaml:"oauth"`
 	Mailer MailerConfig `yaml:"mailer"`
+	SMS    SMSConfig    `yaml:"sms"`
+	GeoIP  GeoIPConfig  `yaml:"geoip"`
+	Risk   RiskConfig   `yaml:"risk"`
+
+	Lockout LockoutConfig `yaml:"lockout"`
+
+	RateLimit RateLimitConfig `yaml:"rate-limit"`
+	Stuffing  StuffingConfig  `yaml:"credential-stuffing"`
+
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+	Outbox   OutboxConfig   `yaml:"outbox"`
 
 	MinimumPasswordLength int `yaml:"password-len"`
+
+	// SudoTimeout is the duration of sudo sessions for protected account actions
+	SudoTimeout time.Duration `yaml:"sudo-timeout"`
+	// TrustedDeviceTimeout is the duration devices may be trusted to skip second factor authentication
+	TrustedDeviceTimeout time.Duration `yaml:"trusted-device-timeout"`
 }
 
 // GenerateSecret Helper to generate a default secret to use
@@ -84,11 +101,24 @@ func DefaultConfig() (*AuthPlzConfig, error) {
 	c.TemplateDir = "./templates"
 
 	c.MinimumPasswordLength = 12
+	c.SudoTimeout = 5 * time.Minute
+	c.TrustedDeviceTimeout = 30 * 24 * time.Hour
 
 	c.Mailer.Driver = "logger"
 	c.Mailer.Options = make(map[string]string)
 
+	c.SMS.Driver = "logger"
+	c.SMS.Options = make(map[string]string)
+	c.SMS.SendLimit = 5
+
 	c.OAuth = DefaultOAuthConfig()
+	c.GeoIP = DefaultGeoIPConfig()
+	c.Risk = DefaultRiskConfig()
+	c.Lockout = DefaultLockoutConfig()
+	c.RateLimit = DefaultRateLimitConfig()
+	c.Stuffing = DefaultStuffingConfig()
+	c.Webhooks = DefaultWebhooksConfig()
+	c.Outbox = DefaultOutboxConfig()
 
 	c.CookieSecret, err = GenerateSecret(64)
 	if err != nil {
@@ -135,6 +165,11 @@ func LoadConfig(filename, envPrefix string) (*AuthPlzConfig, error) {
 		c.ExternalAddress = fmt.Sprintf("%s://%s:%s", prefix, c.Address, c.Port)
 	}
 
+	// Use external address as the OpenID Connect issuer if unspecified
+	if c.OAuth.Issuer == "" {
+		c.OAuth.Issuer = c.ExternalAddress
+	}
+
 	// Populate allowed origins with external address if unspecified
 	if len(c.AllowedOrigins) == 0 {
 		c.AllowedOrigins = []string{c.ExternalAddress}
@@ -11,6 +11,7 @@ import (
 	"encoding/base64"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
@@ -32,4 +33,11 @@ func TestConfig(t *testing.T) {
 	assert.EqualValues(t, testCookieSecret, c.CookieSecret)
 	assert.EqualValues(t, testTokenSecret, c.TokenSecret)
 
+	assert.EqualValues(t, 5, len(c.Risk.Rules))
+	assert.EqualValues(t, time.Hour, c.Risk.Rules[1].Window)
+	assert.EqualValues(t, 60, c.Risk.Thresholds.EmailConfirmation)
+
+	assert.EqualValues(t, 5, c.Lockout.Threshold)
+	assert.EqualValues(t, 24*time.Hour, c.Lockout.ResetAfter)
+
 }
@@ -0,0 +1,32 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * GeoIP configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+// GeoIPConfig GeoIP enrichment and login location risk configuration
+// GeoIP is disabled unless a database is provided
+type GeoIPConfig struct {
+	// Path to a MaxMind format (.mmdb) city database
+	Database string `yaml:"database"`
+	// Optional path to a MaxMind format ASN database, where ASN data is not included in the city database
+	ASNDatabase string `yaml:"asn-database"`
+	// Action required for flagged logins ("second-factor" or "email-confirmation")
+	RiskAction string `yaml:"risk-action"`
+	// Maximum plausible travel speed between consecutive logins (km/h)
+	MaxSpeed float64 `yaml:"max-speed"`
+	// Minimum distance between consecutive logins for travel to be checked (km)
+	// This avoids flagging logins due to geolocation inaccuracy
+	MinDistance float64 `yaml:"min-distance"`
+}
+
+// DefaultGeoIPConfig generates a default GeoIP configuration
+func DefaultGeoIPConfig() GeoIPConfig {
+	return GeoIPConfig{
+		RiskAction:  "second-factor",
+		MaxSpeed:    1000,
+		MinDistance: 200,
+	}
+}
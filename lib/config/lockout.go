@@ -0,0 +1,35 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Account lockout configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+import (
+	"time"
+)
+
+// LockoutConfig account lockout policy configuration
+// Accounts are locked for exponentially increasing durations on repeated failed logins,
+// and unlock automatically once the lockout duration has passed
+type LockoutConfig struct {
+	// Failed logins before an account is locked
+	Threshold uint `yaml:"threshold"`
+	// Duration of the first lockout, doubled for each subsequent lockout
+	BaseDuration time.Duration `yaml:"base-duration"`
+	// Maximum lockout duration
+	MaxDuration time.Duration `yaml:"max-duration"`
+	// Period without a lockout after which the back-off is reset
+	ResetAfter time.Duration `yaml:"reset-after"`
+}
+
+// DefaultLockoutConfig generates a default account lockout configuration
+func DefaultLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		Threshold:    5,
+		BaseDuration: 5 * time.Minute,
+		MaxDuration:  time.Hour,
+		ResetAfter:   24 * time.Hour,
+	}
+}
@@ -35,6 +35,17 @@ type OAuthConfig struct {
 	AuthorizeExpiry time.Duration
 	// RefreshExpiry is Refresh token expiry time
 	RefreshExpiry time.Duration
+	// Issuer is the OpenID Connect issuer identifier, defaults to the external address
+	Issuer string `yaml:"issuer"`
+	// SigningKey is the path to a PEM encoded RSA or ECDSA private key used to sign ID tokens
+	// An ephemeral key is generated if this is not set
+	SigningKey string `yaml:"signing-key"`
+	// DeviceExpiry is the Device Authorization Grant device code expiry time
+	DeviceExpiry time.Duration `yaml:"device-expiry"`
+	// DeviceInterval is the minimum interval between device code token requests
+	DeviceInterval time.Duration `yaml:"device-interval"`
+	// DeviceVerificationRedirect is the client app page where users enter device user codes
+	DeviceVerificationRedirect string `yaml:"device-verification-redirect"`
 }
 
 // DefaultOAuthConfig generates a default configuration for the OAuth module
@@ -44,17 +55,21 @@ func DefaultOAuthConfig() OAuthConfig {
 		AuthorizeRedirect: "/#/oauth-authorize",
 		TokenSecret:       secret,
 		AllowedScopes: configSplit{
-			Admin: []string{"public.read", "public.write", "private.read", "private.write", "introspect", "offline"},
-			User:  []string{"public.read", "public.write", "private.read", "private.write", "offline"},
+			Admin: []string{"openid", "email", "profile", "public.read", "public.write", "private.read", "private.write", "introspect", "offline"},
+			User:  []string{"openid", "email", "profile", "public.read", "public.write", "private.read", "private.write", "offline"},
 		},
 		AllowedGrants: configSplit{
-			Admin: []string{"authorization_code", "implicit", "refresh_token", "client_credentials"},
-			User:  []string{"authorization_code", "implicit", "refresh_token"},
+			Admin: []string{"authorization_code", "implicit", "refresh_token", "client_credentials", "urn:ietf:params:oauth:grant-type:device_code"},
+			User:  []string{"authorization_code", "implicit", "refresh_token", "urn:ietf:params:oauth:grant-type:device_code"},
 		},
 		AllowedResponses: []string{"code", "token", "id_token"},
 		AccessExpiry:     time.Hour * 24 * 1,
 		IDExpiry:         time.Hour * 24 * 1,
 		AuthorizeExpiry:  time.Hour * 24 * 1,
 		RefreshExpiry:    time.Hour * 24 * 180,
+
+		DeviceExpiry:               time.Minute * 10,
+		DeviceInterval:             time.Second * 5,
+		DeviceVerificationRedirect: "/#/oauth-device",
 	}
 }
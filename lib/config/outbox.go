@@ -0,0 +1,47 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Event outbox configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+import (
+	"time"
+)
+
+// OutboxConfig event outbox configuration
+// Events are persisted and delivered to each consumer at least once, failed deliveries are retried
+// with exponential back-off until MaxAttempts is reached
+type OutboxConfig struct {
+	// Interval at which pending deliveries are checked
+	PollInterval time.Duration `yaml:"poll-interval"`
+	// Delivery attempts before a delivery is marked as dead
+	MaxAttempts uint `yaml:"max-attempts"`
+	// Initial retry delay, doubled for each subsequent attempt
+	BaseDelay time.Duration `yaml:"base-delay"`
+	// Maximum retry delay
+	MaxDelay time.Duration `yaml:"max-delay"`
+	// Duration for which a delivery is claimed by an instance before it may be retried by another
+	Lease time.Duration `yaml:"lease"`
+	// Duration for which completed events are retained
+	Retention time.Duration `yaml:"retention"`
+	// Notify instances sharing the database of new events using Postgres LISTEN/NOTIFY
+	Notify bool `yaml:"notify"`
+	// Channel used for event notifications
+	Channel string `yaml:"channel"`
+}
+
+// DefaultOutboxConfig generates a default event outbox configuration
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		PollInterval: 5 * time.Second,
+		MaxAttempts:  10,
+		BaseDelay:    10 * time.Second,
+		MaxDelay:     time.Hour,
+		Lease:        5 * time.Minute,
+		Retention:    7 * 24 * time.Hour,
+		Notify:       true,
+		Channel:      "authplz_events",
+	}
+}
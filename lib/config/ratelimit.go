@@ -0,0 +1,64 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Rate limiting configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+import (
+	"time"
+)
+
+// RateLimitConfig rate limiting plugin configuration
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend for rate limit state, "memory" for a single instance or "database" to share limits across instances
+	Backend string `yaml:"backend"`
+	// Policies applied to API endpoints
+	Policies []RateLimitPolicyConfig `yaml:"policies"`
+	// Policy applied to login attempts for each account
+	Login RateLimitPolicyConfig `yaml:"login"`
+}
+
+// RateLimitPolicyConfig rate limit policy configuration
+// Requests matching a policy are limited to Limit requests per Window for each endpoint and key,
+// with bursts of up to Limit requests allowed
+type RateLimitPolicyConfig struct {
+	Name string `yaml:"name"`
+	// Endpoint paths the policy applies to
+	Paths []string `yaml:"paths"`
+	// Request methods the policy applies to (all methods if empty)
+	Methods []string `yaml:"methods"`
+	// Key to limit requests by, "ip" or "account"
+	Key    string        `yaml:"key"`
+	Limit  uint          `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+}
+
+// DefaultRateLimitConfig generates a default rate limiting configuration
+func DefaultRateLimitConfig() RateLimitConfig {
+	secondFactorPaths := []string{
+		"/api/totp/authenticate",
+		"/api/u2f/authenticate",
+		"/api/webauthn/authenticate",
+		"/api/webauthn/login",
+		"/api/emailotp/authenticate",
+		"/api/sms/authenticate",
+		"/api/yubikey/authenticate",
+		"/api/backupcode/authenticate",
+	}
+
+	return RateLimitConfig{
+		Enabled: true,
+		Backend: "memory",
+		Policies: []RateLimitPolicyConfig{
+			{Name: "login", Paths: []string{"/api/login", "/api/login/email"}, Key: "ip", Limit: 20, Window: time.Minute},
+			{Name: "create", Paths: []string{"/api/create"}, Methods: []string{"POST"}, Key: "ip", Limit: 5, Window: time.Hour},
+			{Name: "recovery", Paths: []string{"/api/recovery"}, Key: "ip", Limit: 10, Window: time.Hour},
+			{Name: "2fa-ip", Paths: secondFactorPaths, Key: "ip", Limit: 20, Window: time.Minute},
+			{Name: "2fa-account", Paths: secondFactorPaths, Key: "account", Limit: 10, Window: time.Minute},
+		},
+		Login: RateLimitPolicyConfig{Name: "login-account", Key: "account", Limit: 10, Window: 15 * time.Minute},
+	}
+}
@@ -0,0 +1,76 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Login risk policy configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+import (
+	"time"
+)
+
+// RiskConfig login risk policy configuration
+// Each matching rule adds its score to the login, and the action taken is the most severe
+// action whose threshold is met by the total score
+type RiskConfig struct {
+	Enabled    bool                    `yaml:"enabled"`
+	IPLists    map[string]IPListConfig `yaml:"ip-lists"`
+	Rules      []RiskRuleConfig        `yaml:"rules"`
+	Thresholds RiskThresholdConfig     `yaml:"thresholds"`
+}
+
+// IPListConfig IP reputation list configuration
+// Networks may be provided inline, or loaded from a file with one address or CIDR per line
+type IPListConfig struct {
+	Networks []string `yaml:"networks"`
+	File     string   `yaml:"file"`
+}
+
+// RiskRuleConfig login risk rule configuration
+type RiskRuleConfig struct {
+	// Name of the rule, recorded in risk decisions
+	Name string `yaml:"name"`
+	// Signal to be evaluated (new-device, failed-logins, ip-list, time-of-day, account-age)
+	Signal string `yaml:"signal"`
+	// Score added to the login if the signal matches
+	Score int `yaml:"score"`
+
+	// Failed login count and window for failed-logins signals
+	Count  uint          `yaml:"count"`
+	Window time.Duration `yaml:"window"`
+	// IP list name for ip-list signals
+	List string `yaml:"list"`
+	// Start and end hours (0-23) and optional timezone for time-of-day signals
+	StartHour int    `yaml:"start-hour"`
+	EndHour   int    `yaml:"end-hour"`
+	Timezone  string `yaml:"timezone"`
+	// Maximum account age for account-age signals
+	MaxAge time.Duration `yaml:"max-age"`
+}
+
+// RiskThresholdConfig scores at which each risk action is required
+// A zero threshold disables the action
+type RiskThresholdConfig struct {
+	SecondFactor      int `yaml:"second-factor"`
+	EmailConfirmation int `yaml:"email-confirmation"`
+	Block             int `yaml:"block"`
+}
+
+// DefaultRiskConfig generates a default (disabled) login risk configuration
+func DefaultRiskConfig() RiskConfig {
+	return RiskConfig{
+		Enabled: false,
+		IPLists: make(map[string]IPListConfig),
+		Rules: []RiskRuleConfig{
+			{Name: "new-device", Signal: "new-device", Score: 30},
+			{Name: "failed-logins", Signal: "failed-logins", Score: 30, Count: 3, Window: time.Hour},
+			{Name: "new-account", Signal: "account-age", Score: 10, MaxAge: 24 * time.Hour},
+		},
+		Thresholds: RiskThresholdConfig{
+			SecondFactor:      30,
+			EmailConfirmation: 60,
+			Block:             100,
+		},
+	}
+}
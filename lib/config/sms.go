@@ -0,0 +1,15 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * SMS configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+// SMSConfig SMS configuration options
+type SMSConfig struct {
+	Driver  string            `yaml:"driver"`
+	Options map[string]string `yaml:"options"`
+	// Maximum number of messages sent to a user per hour
+	SendLimit uint `yaml:"send-limit"`
+}
@@ -0,0 +1,48 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Credential stuffing detection configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+import (
+	"time"
+)
+
+// StuffingConfig credential stuffing detection configuration
+// Failed logins are tracked by source IP and subnet across all accounts within the window
+type StuffingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint paths protected by the detector
+	Paths []string `yaml:"paths"`
+	// Window over which failed logins are counted
+	Window time.Duration `yaml:"window"`
+	// Failed logins from an address before progressive delays are applied
+	DelayThreshold uint `yaml:"delay-threshold"`
+	// Initial delay, doubled for each subsequent failed login
+	BaseDelay time.Duration `yaml:"base-delay"`
+	// Maximum delay between login attempts
+	MaxDelay time.Duration `yaml:"max-delay"`
+	// Failed logins from an address before it is blocked
+	BlockThreshold uint `yaml:"block-threshold"`
+	// Failed logins from a subnet (/24 for IPv4, /48 for IPv6) before it is blocked
+	SubnetBlockThreshold uint `yaml:"subnet-block-threshold"`
+	// Duration of address and subnet blocks
+	BlockDuration time.Duration `yaml:"block-duration"`
+}
+
+// DefaultStuffingConfig generates a default credential stuffing detection configuration
+func DefaultStuffingConfig() StuffingConfig {
+	return StuffingConfig{
+		Enabled:              true,
+		Paths:                []string{"/api/login"},
+		Window:               time.Hour,
+		DelayThreshold:       10,
+		BaseDelay:            time.Second,
+		MaxDelay:             time.Minute,
+		BlockThreshold:       50,
+		SubnetBlockThreshold: 200,
+		BlockDuration:        time.Hour,
+	}
+}
@@ -0,0 +1,43 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Webhooks configuration
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package config
+
+import (
+	"time"
+)
+
+// WebhooksConfig webhook delivery configuration
+// Failed deliveries are retried with exponential back-off until MaxAttempts is reached
+type WebhooksConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout for each delivery request
+	Timeout time.Duration `yaml:"timeout"`
+	// Delivery attempts before a delivery is marked as dead
+	MaxAttempts uint `yaml:"max-attempts"`
+	// Initial retry delay, doubled for each subsequent attempt
+	BaseDelay time.Duration `yaml:"base-delay"`
+	// Maximum retry delay
+	MaxDelay time.Duration `yaml:"max-delay"`
+	// Interval at which pending retries are checked
+	RetryInterval time.Duration `yaml:"retry-interval"`
+	// Duration for which a delivery is claimed by an instance before it may be retried by another
+	// Must exceed the time to attempt a batch of deliveries (20 times the timeout)
+	Lease time.Duration `yaml:"lease"`
+}
+
+// DefaultWebhooksConfig generates a default webhook delivery configuration
+func DefaultWebhooksConfig() WebhooksConfig {
+	return WebhooksConfig{
+		Enabled:       false,
+		Timeout:       10 * time.Second,
+		MaxAttempts:   8,
+		BaseDelay:     30 * time.Second,
+		MaxDelay:      time.Hour,
+		RetryInterval: 15 * time.Second,
+		Lease:         5 * time.Minute,
+	}
+}
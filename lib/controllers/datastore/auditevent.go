@@ -15,10 +15,11 @@ import (
 // AuditEvent for a user account
 type AuditEvent struct {
 	gorm.Model
-	UserID uint
-	Type   string
-	Time   time.Time
-	Data   string
+	UserID  uint
+	EventID string `gorm:"index"`
+	Type    string
+	Time    time.Time
+	Data    string
 }
 
 // GetType fetches the type of the event
@@ -36,13 +37,40 @@ func (ae *AuditEvent) GetData() (map[string]string, error) {
 	return data, err
 }
 
+// getAuditEventByEventID fetches an audit event by originating event ID
+// This returns nil if no event is found
+func (dataStore *DataStore) getAuditEventByEventID(eventID string) (*AuditEvent, error) {
+	var auditEvent AuditEvent
+	err := dataStore.db.Where(&AuditEvent{EventID: eventID}).First(&auditEvent).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &auditEvent, nil
+}
+
 // AddAuditEvent creates an audit event in the database
-func (dataStore *DataStore) AddAuditEvent(userid, eventType string, eventTime time.Time, data map[string]string) (interface{}, error) {
+// Events with an ID are only recorded once, redelivered events return the existing record
+func (dataStore *DataStore) AddAuditEvent(userid, eventID, eventType string, eventTime time.Time, data map[string]string) (interface{}, error) {
+
+	if eventID != "" {
+		existing, err := dataStore.getAuditEventByEventID(eventID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+	}
 
 	u, err := dataStore.GetUserByExtID(userid)
 	if err != nil {
 		return nil, err
 	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
 	user := u.(*User)
 
 	encodedData, err := json.Marshal(data)
@@ -51,10 +79,11 @@ func (dataStore *DataStore) AddAuditEvent(userid, eventType string, eventTime ti
 	}
 
 	auditEvent := AuditEvent{
-		UserID: user.ID,
-		Type:   eventType,
-		Time:   eventTime,
-		Data:   string(encodedData),
+		UserID:  user.ID,
+		EventID: eventID,
+		Type:    eventType,
+		Time:    eventTime,
+		Data:    string(encodedData),
 	}
 
 	user.AuditEvents = append(user.AuditEvents, auditEvent)
@@ -62,6 +91,54 @@ func (dataStore *DataStore) AddAuditEvent(userid, eventType string, eventTime ti
 	return user.AuditEvents, err
 }
 
+// AddSystemAuditEvent creates an audit event that is not associated with a user account
+// System events (ie. detected attacks) are visible to admins
+func (dataStore *DataStore) AddSystemAuditEvent(eventID, eventType string, eventTime time.Time, data map[string]string) (interface{}, error) {
+	if eventID != "" {
+		existing, err := dataStore.getAuditEventByEventID(eventID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+	}
+
+	encodedData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	auditEvent := AuditEvent{
+		UserID:  0,
+		EventID: eventID,
+		Type:    eventType,
+		Time:    eventTime,
+		Data:    string(encodedData),
+	}
+
+	err = dataStore.db.Create(&auditEvent).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditEvent, nil
+}
+
+// GetSystemAuditEvents fetches the most recent system audit events, newest first
+func (dataStore *DataStore) GetSystemAuditEvents(limit uint) ([]interface{}, error) {
+	var auditEvents []AuditEvent
+
+	err := dataStore.db.Where("user_id = 0").Order("time desc").Limit(limit).Find(&auditEvents).Error
+
+	interfaces := make([]interface{}, len(auditEvents))
+	for i := range auditEvents {
+		interfaces[i] = &auditEvents[i]
+	}
+
+	return interfaces, err
+}
+
 // GetAuditEvents fetches a list of audit events for a given userr
 func (dataStore *DataStore) GetAuditEvents(userid string) ([]interface{}, error) {
 	var auditEvents []AuditEvent
@@ -77,9 +154,29 @@ func (dataStore *DataStore) GetAuditEvents(userid string) ([]interface{}, error)
 	err = dataStore.db.Model(user).Related(&auditEvents).Error
 
 	interfaces := make([]interface{}, len(auditEvents))
-	for i, t := range auditEvents {
-		interfaces[i] = &t
+	for i := range auditEvents {
+		interfaces[i] = &auditEvents[i]
 	}
 
 	return interfaces, err
 }
+
+// CountAuditEvents counts the audit events of a given type for a user since the provided time
+func (dataStore *DataStore) CountAuditEvents(userid, eventType string, since time.Time) (uint, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return 0, err
+	}
+	if u == nil {
+		return 0, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var count uint
+	err = dataStore.db.Model(&AuditEvent{}).Where("user_id = ? AND type = ? AND time >= ?", user.ID, eventType, since).Count(&count).Error
+
+	return count, err
+}
@@ -9,6 +9,7 @@ package datastore
 import (
 	"errors"
 	"fmt"
+	"log"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -20,7 +21,9 @@ var ErrUserNotFound = errors.New("User account not found")
 
 // DataStore instance storage
 type DataStore struct {
-	db *gorm.DB
+	db       *gorm.DB
+	dbString string
+	tx       bool
 	*oauthstore.OauthStore
 }
 
@@ -40,7 +43,7 @@ func NewDataStore(dbString string) (*DataStore, error) {
 
 	//db = db.LogMode(true)
 
-	ds := &DataStore{db: db}
+	ds := &DataStore{db: db, dbString: dbString}
 
 	ds.OauthStore = oauthstore.NewOauthStore(db, ds)
 
@@ -58,10 +61,22 @@ func (dataStore *DataStore) Drop() {
 	db := dataStore.db
 
 	db = db.Exec("DROP TABLE IF EXISTS fido_tokens CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS web_authn_credentials CASCADE;")
 	db = db.Exec("DROP TABLE IF EXISTS totp_tokens CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS yubikey_tokens CASCADE;")
 	db = db.Exec("DROP TABLE IF EXISTS backup_tokens CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS one_time_codes CASCADE;")
 	db = db.Exec("DROP TABLE IF EXISTS action_tokens CASCADE;")
 	db = db.Exec("DROP TABLE IF EXISTS audit_events CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS web_sessions CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS trusted_devices CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS known_devices CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS login_locations CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS rate_limit_buckets CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS webhook_deliveries CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS webhooks CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS outbox_deliveries CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS outbox_events CASCADE;")
 	db = db.Exec("DROP TABLE IF EXISTS users CASCADE;")
 
 	dataStore.db = db
@@ -74,14 +89,31 @@ func (dataStore *DataStore) Sync() {
 	db = db.AutoMigrate(&ActionToken{})
 
 	db = db.AutoMigrate(&FidoToken{})
+	db = db.AutoMigrate(&WebAuthnCredential{})
 	db = db.AutoMigrate(&TotpToken{})
+	db = db.AutoMigrate(&YubikeyToken{})
 	db = db.AutoMigrate(&BackupToken{})
+	db = db.AutoMigrate(&OneTimeCode{})
 
 	db = db.AutoMigrate(&AuditEvent{})
+	db = db.AutoMigrate(&WebSession{})
+	db = db.AutoMigrate(&TrustedDevice{})
+	db = db.AutoMigrate(&KnownDevice{})
+	db = db.AutoMigrate(&LoginLocation{})
+	db = db.AutoMigrate(&RateLimitBucket{})
+	db = db.AutoMigrate(&Webhook{})
+	db = db.AutoMigrate(&WebhookDelivery{})
+	db = db.AutoMigrate(&OutboxEvent{})
+	db = db.AutoMigrate(&OutboxDelivery{})
 
 	db = dataStore.OauthStore.Sync(true)
 
 	dataStore.db = db
+
+	// Migrate legacy U2F tokens to webauthn credentials
+	if err := dataStore.MigrateFidoTokens(); err != nil {
+		log.Printf("Datastore.Sync: error migrating fido tokens (%s)", err)
+	}
 }
 
 // ForceSync Drop and create existing tables to match required schema
@@ -7,8 +7,10 @@
 package datastore
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/authplz/authplz-core/lib/config"
 )
@@ -198,6 +200,146 @@ func TestDatastore(t *testing.T) {
 		}
 	})
 
+	t.Run("Migrates U2F tokens to WebAuthn credentials", func(t *testing.T) {
+		u, err := ds.GetUserByEmail(fakeEmail)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		userInst := u.(*User)
+
+		_, err = ds.AddFidoToken(userInst.GetExtID(), "legacy", "a2V5LWhhbmRsZQ", "BHB1YmxpYy1rZXk", "", 12)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Tokens are migrated on enrolment, and migration should be idempotent
+		for i := 0; i < 2; i++ {
+			err = ds.MigrateFidoTokens()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		credentials, err := ds.GetWebAuthnCredentials(userInst.GetExtID())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(credentials) != 1 {
+			t.Errorf("Expected 1 migrated credential, found %d", len(credentials))
+			return
+		}
+
+		credential := credentials[0].(*WebAuthnCredential)
+		if !credential.IsLegacyU2F() || credential.GetSignCount() != 12 {
+			t.Errorf("Migrated credential mismatch (%+v)", credential)
+		}
+		if credential.GetCredentialID() != "a2V5LWhhbmRsZQ" {
+			t.Errorf("Migrated credential ID mismatch (%s)", credential.GetCredentialID())
+		}
+	})
+
+	t.Run("Removing U2F tokens removes migrated WebAuthn credentials", func(t *testing.T) {
+		u, err := ds.GetUserByEmail(fakeEmail)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		userInst := u.(*User)
+
+		tokens, err := ds.GetFidoTokens(userInst.GetExtID())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(tokens) != 1 {
+			t.Errorf("Expected 1 fido token, found %d", len(tokens))
+			return
+		}
+
+		err = ds.RemoveFidoToken(tokens[0])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		credentials, err := ds.GetWebAuthnCredentials(userInst.GetExtID())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(credentials) != 0 {
+			t.Errorf("Expected migrated credential to be removed, found %d", len(credentials))
+		}
+	})
+
+	t.Run("Transactions commit events with changes", func(t *testing.T) {
+		err := ds.Transaction(func(store interface{}) error {
+			_, err := store.(*DataStore).AddOutboxEvent("tx-commit", "", "test", time.Now(), nil, []string{"audit"}, "pending")
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = ds.Transaction(func(store interface{}) error {
+			_, err := store.(*DataStore).AddOutboxEvent("tx-rollback", "", "test", time.Now(), nil, []string{"audit"}, "pending")
+			if err != nil {
+				return err
+			}
+			return errors.New("state change failed")
+		})
+		if err == nil {
+			t.Errorf("Expected transaction error")
+		}
+
+		var count int
+		ds.db.Model(&OutboxEvent{}).Where("event_id IN (?)", []string{"tx-commit", "tx-rollback"}).Count(&count)
+		if count != 1 {
+			t.Errorf("Expected 1 committed event, found %d", count)
+		}
+	})
+
+	t.Run("Webhook deliveries are claimed by a single instance", func(t *testing.T) {
+		now := time.Now()
+		_, err := ds.AddWebhookDelivery(1, "claim-event", "test", "{}", "claim-test", now.Add(-time.Second))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		claimed, err := ds.ClaimWebhookDeliveries("claim-test", now, time.Minute, 10)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(claimed) != 1 {
+			t.Errorf("Expected 1 claimed delivery, found %d", len(claimed))
+		}
+
+		claimed, err = ds.ClaimWebhookDeliveries("claim-test", now, time.Minute, 10)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(claimed) != 0 {
+			t.Errorf("Expected leased delivery not to be claimed again, found %d", len(claimed))
+		}
+
+		claimed, err = ds.ClaimWebhookDeliveries("claim-test", now.Add(2*time.Minute), time.Minute, 10)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(claimed) != 1 {
+			t.Errorf("Expected delivery to be claimed once the lease expired, found %d", len(claimed))
+		}
+	})
+
 	// Tear down user controller
 
 }
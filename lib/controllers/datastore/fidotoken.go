@@ -78,10 +78,29 @@ func (dataStore *DataStore) AddFidoToken(userid, name, keyHandle, publicKey, cer
 		LastUsed:    time.Now(),
 	}
 
-	// Add the token to the user and save
+	// Save the token along with a migrated webauthn credential, so keys enrolled via the u2f API
+	// are immediately available for webauthn authentication
+	tx := dataStore.begin()
+
+	err = tx.Create(&token).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = migrateFidoToken(tx.DB, &token)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
 	user.FidoTokens = append(user.FidoTokens, token)
-	_, err = dataStore.UpdateUser(user)
-	return user, err
+	return user, nil
 }
 
 // GetFidoTokens fetches the fido tokens for a provided user
@@ -100,8 +119,8 @@ func (dataStore *DataStore) GetFidoTokens(userid string) ([]interface{}, error)
 	err = dataStore.db.Model(u).Related(&fidoTokens).Error
 
 	interfaces := make([]interface{}, len(fidoTokens))
-	for i, t := range fidoTokens {
-		interfaces[i] = &t
+	for i := range fidoTokens {
+		interfaces[i] = &fidoTokens[i]
 	}
 
 	return interfaces, err
@@ -116,7 +135,24 @@ func (dataStore *DataStore) UpdateFidoToken(token interface{}) (interface{}, err
 	return token, nil
 }
 
-// RemoveFidoToken deletes a totp token
+// RemoveFidoToken deletes a fido token
+// Webauthn credentials migrated from the token are removed so the token can not be used via the webauthn API
 func (dataStore *DataStore) RemoveFidoToken(token interface{}) error {
-	return dataStore.db.Delete(token).Error
+	t := token.(*FidoToken)
+
+	tx := dataStore.begin()
+
+	err := tx.Where("fido_token_id = ?", t.ID).Delete(&WebAuthnCredential{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Delete(t).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
@@ -0,0 +1,126 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Known login devices
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// KnownDevice device previously used to log in to a user account
+// Devices are identified by a fingerprint of the user agent and network prefix
+type KnownDevice struct {
+	gorm.Model
+	UserID      uint
+	Fingerprint string `gorm:"index"`
+	UserAgent   string
+	IPPrefix    string
+	LastSeen    time.Time
+}
+
+// Getters and setters for external interface compliance
+
+// GetFingerprint fetches the device fingerprint
+func (d *KnownDevice) GetFingerprint() string { return d.Fingerprint }
+
+// GetUserAgent fetches the user agent of the device
+func (d *KnownDevice) GetUserAgent() string { return d.UserAgent }
+
+// GetIPPrefix fetches the network prefix of the device
+func (d *KnownDevice) GetIPPrefix() string { return d.IPPrefix }
+
+// GetLastSeen fetches the time the device was last used to log in
+func (d *KnownDevice) GetLastSeen() time.Time { return d.LastSeen }
+
+// SetLastSeen sets the time the device was last used to log in
+func (d *KnownDevice) SetLastSeen(t time.Time) { d.LastSeen = t }
+
+// AddKnownDevice adds a known device to a user account
+func (dataStore *DataStore) AddKnownDevice(userid, fingerprint, userAgent, ipPrefix string) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	device := KnownDevice{
+		UserID:      user.ID,
+		Fingerprint: fingerprint,
+		UserAgent:   userAgent,
+		IPPrefix:    ipPrefix,
+		LastSeen:    time.Now(),
+	}
+
+	err = dataStore.db.Create(&device).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GetKnownDevice fetches a known device for a user by fingerprint
+// This returns nil if no device is found
+func (dataStore *DataStore) GetKnownDevice(userid, fingerprint string) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var device KnownDevice
+	err = dataStore.db.Where(&KnownDevice{UserID: user.ID, Fingerprint: fingerprint}).First(&device).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &device, nil
+}
+
+// CountKnownDevices counts the known devices for a user
+func (dataStore *DataStore) CountKnownDevices(userid string) (uint, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return 0, err
+	}
+	if u == nil {
+		return 0, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var count uint
+	err = dataStore.db.Model(&KnownDevice{}).Where("user_id = ?", user.ID).Count(&count).Error
+
+	return count, err
+}
+
+// UpdateKnownDevice updates a known device instance
+func (dataStore *DataStore) UpdateKnownDevice(device interface{}) (interface{}, error) {
+	err := dataStore.db.Save(device).Error
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
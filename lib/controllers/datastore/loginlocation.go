@@ -0,0 +1,127 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Login locations
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LoginLocation geolocated address of a successful login to a user account
+type LoginLocation struct {
+	gorm.Model
+	UserID     uint
+	RemoteAddr string
+	Country    string `gorm:"index"`
+	City       string
+	Latitude   float64
+	Longitude  float64
+}
+
+// Getters and setters for external interface compliance
+
+// GetRemoteAddr fetches the remote address of the login
+func (l *LoginLocation) GetRemoteAddr() string { return l.RemoteAddr }
+
+// GetCountry fetches the ISO country code of the login
+func (l *LoginLocation) GetCountry() string { return l.Country }
+
+// GetCity fetches the city of the login
+func (l *LoginLocation) GetCity() string { return l.City }
+
+// GetLatitude fetches the approximate latitude of the login
+func (l *LoginLocation) GetLatitude() float64 { return l.Latitude }
+
+// GetLongitude fetches the approximate longitude of the login
+func (l *LoginLocation) GetLongitude() float64 { return l.Longitude }
+
+// GetTime fetches the time of the login
+func (l *LoginLocation) GetTime() time.Time { return l.CreatedAt }
+
+// AddLoginLocation adds a login location to a user account
+func (dataStore *DataStore) AddLoginLocation(userid, remoteAddr, country, city string, latitude, longitude float64) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	location := LoginLocation{
+		UserID:     user.ID,
+		RemoteAddr: remoteAddr,
+		Country:    country,
+		City:       city,
+		Latitude:   latitude,
+		Longitude:  longitude,
+	}
+
+	err = dataStore.db.Create(&location).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+// GetLastLoginLocation fetches the most recent login location for a user
+// This returns nil if no location is found
+func (dataStore *DataStore) GetLastLoginLocation(userid string) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var location LoginLocation
+	err = dataStore.db.Where(&LoginLocation{UserID: user.ID}).Order("created_at desc").First(&location).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &location, nil
+}
+
+// CountLoginLocations counts the login locations for a user in the provided country
+// An empty country counts all login locations for the user
+func (dataStore *DataStore) CountLoginLocations(userid, country string) (uint, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return 0, err
+	}
+	if u == nil {
+		return 0, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	query := dataStore.db.Model(&LoginLocation{}).Where("user_id = ?", user.ID)
+	if country != "" {
+		query = query.Where("country = ?", country)
+	}
+
+	var count uint
+	err = query.Count(&count).Error
+
+	return count, err
+}
@@ -0,0 +1,101 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Event notifications
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+const (
+	// Listener reconnection back-off limits
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	// Interval at which idle listener connections are checked
+	listenerPingInterval = 90 * time.Second
+	// Notifications buffered prior to being dropped
+	notificationBuffer = 16
+)
+
+// Notifier Postgres LISTEN/NOTIFY based notifier
+// This allows instances sharing the database to be notified of new events
+type Notifier struct {
+	db       *gorm.DB
+	dbString string
+	channel  string
+	listener *pq.Listener
+}
+
+// NewNotifier creates a notifier using the provided channel
+func (dataStore *DataStore) NewNotifier(channel string) *Notifier {
+	return &Notifier{
+		db:       dataStore.db,
+		dbString: dataStore.dbString,
+		channel:  channel,
+	}
+}
+
+// Notify sends a notification to all instances listening on the channel
+func (n *Notifier) Notify(payload string) error {
+	return n.db.Exec("SELECT pg_notify(?, ?)", n.channel, payload).Error
+}
+
+// Listen starts listening for notifications on the channel
+// Notifications are only used to wake listeners, so they are dropped if the returned channel is full.
+// An empty notification is sent following reconnection as notifications may have been missed.
+func (n *Notifier) Listen() (<-chan string, error) {
+	listener := pq.NewListener(n.dbString, minReconnectInterval, maxReconnectInterval,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("Notifier: listener error on channel %s (%s)", n.channel, err)
+			}
+		})
+
+	err := listener.Listen(n.channel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	n.listener = listener
+
+	notifications := make(chan string, notificationBuffer)
+	go func() {
+		defer close(notifications)
+
+		for {
+			select {
+			case notification, ok := <-listener.Notify:
+				if !ok {
+					return
+				}
+				payload := ""
+				if notification != nil {
+					payload = notification.Extra
+				}
+				select {
+				case notifications <- payload:
+				default:
+				}
+			case <-time.After(listenerPingInterval):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return notifications, nil
+}
+
+// Close stops listening for notifications
+func (n *Notifier) Close() error {
+	if n.listener == nil {
+		return nil
+	}
+	return n.listener.Close()
+}
@@ -114,6 +114,10 @@ func (os *OauthStore) GetAccessTokenSessionsByUserID(userID string) ([]interface
 
 	interfaces := make([]interface{}, len(oa))
 	for i := range oa {
+		err = os.db.Where(&OauthClient{ID: oa[i].ClientID}).First(&oa[i].Client).Error
+		if err != nil {
+			return nil, err
+		}
 		interfaces[i] = &oa[i]
 	}
 
@@ -139,6 +143,10 @@ func (os *OauthStore) GetClientByAccessTokenSession(signature string) (interface
 
 // RemoveAccessTokenSession Remove an access token by session key
 func (os *OauthStore) RemoveAccessTokenSession(signature string) error {
-	err := os.db.Delete(&OauthAccessToken{Signature: signature}).Error
-	return err
+	return os.db.Unscoped().Where("signature = ?", signature).Delete(&OauthAccessToken{}).Error
+}
+
+// RemoveAccessTokenSessionsByRequestID removes all access tokens issued for an originator request ID
+func (os *OauthStore) RemoveAccessTokenSessionsByRequestID(requestID string) error {
+	return os.db.Unscoped().Where("request_id = ?", requestID).Delete(&OauthAccessToken{}).Error
 }
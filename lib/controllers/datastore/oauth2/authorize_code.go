@@ -25,6 +25,10 @@ type OauthAuthorizeCode struct {
 
 func (oa *OauthAuthorizeCode) GetCode() string { return oa.Code }
 
+func (oa *OauthAuthorizeCode) GetChallenge() string { return oa.Challenge }
+
+func (oa *OauthAuthorizeCode) GetChallengeMethod() string { return oa.ChallengeMethod }
+
 func (oa *OauthAuthorizeCode) GetSession() interface{} { return &oa.OauthSession }
 
 func (oa *OauthAuthorizeCode) SetSession(session interface{}) {
@@ -32,8 +36,9 @@ func (oa *OauthAuthorizeCode) SetSession(session interface{}) {
 }
 
 // AddAuthorizeCodeSession creates an authorization code session in the database
+// The PKCE challenge and method are optional, and are verified when the code is exchanged
 func (oauthStore *OauthStore) AddAuthorizeCodeSession(userID, clientID, code, requestID string,
-	requestedAt, expiresAt time.Time, requestedScopes, grantedScopes []string) (interface{}, error) {
+	requestedAt, expiresAt time.Time, requestedScopes, grantedScopes []string, challenge, challengeMethod string) (interface{}, error) {
 
 	u, err := oauthStore.base.GetUserByExtID(userID)
 	if err != nil {
@@ -59,11 +64,13 @@ func (oauthStore *OauthStore) AddAuthorizeCodeSession(userID, clientID, code, re
 	session.AuthorizeExpiry = expiresAt
 
 	authorize := OauthAuthorizeCode{
-		ClientID:     client.ID,
-		UserID:       user.GetIntID(),
-		Code:         code,
-		OauthRequest: or,
-		OauthSession: session,
+		ClientID:        client.ID,
+		UserID:          user.GetIntID(),
+		Code:            code,
+		Challenge:       challenge,
+		ChallengeMethod: challengeMethod,
+		OauthRequest:    or,
+		OauthSession:    session,
 	}
 
 	oauthStore.db = oauthStore.db.Create(&authorize)
@@ -113,6 +120,10 @@ func (os *OauthStore) GetAuthorizeCodeSessionsByUserID(userID string) ([]interfa
 
 	interfaces := make([]interface{}, len(codes))
 	for i := range codes {
+		err = os.db.Where(&OauthClient{ID: codes[i].ClientID}).First(&codes[i].Client).Error
+		if err != nil {
+			return nil, err
+		}
 		interfaces[i] = &codes[i]
 	}
 
@@ -121,11 +132,5 @@ func (os *OauthStore) GetAuthorizeCodeSessionsByUserID(userID string) ([]interfa
 
 // RemoveAuthorizeCodeSession removes an authorization code session using the provided code
 func (oauthStore *OauthStore) RemoveAuthorizeCodeSession(code string) error {
-	authorization := OauthAuthorizeCode{
-		Code: code,
-	}
-
-	oauthStore.db = oauthStore.db.Delete(&authorization)
-
-	return oauthStore.db.Error
+	return oauthStore.db.Unscoped().Where("code = ?", code).Delete(&OauthAuthorizeCode{}).Error
 }
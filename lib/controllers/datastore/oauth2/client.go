@@ -168,3 +168,37 @@ func (oauthStore *OauthStore) RemoveClientByID(clientID string) error {
 
 	return oauthStore.db.Error
 }
+
+// RemoveClientSessionsByUserID removes all authorization codes, tokens and OpenID Connect sessions
+// issued to a client on behalf of a user
+func (oauthStore *OauthStore) RemoveClientSessionsByUserID(userID, clientID string) error {
+	u, err := oauthStore.base.GetUserByExtID(userID)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return fmt.Errorf("No user account found for userID: %s", userID)
+	}
+	user := u.(User)
+
+	c, err := oauthStore.GetClientByID(clientID)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return fmt.Errorf("No client found for clientID: %s", clientID)
+	}
+	client := c.(*OauthClient)
+
+	tx := oauthStore.db.Begin()
+
+	for _, model := range []interface{}{&OauthAuthorizeCode{}, &OauthOpenIDSession{}, &OauthAccessToken{}, &OauthRefreshToken{}, &OauthDeviceCode{}} {
+		err = tx.Unscoped().Where("user_id = ? AND client_id = ?", user.GetIntID(), client.ID).Delete(model).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
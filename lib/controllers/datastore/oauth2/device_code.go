@@ -0,0 +1,183 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * OAuth data store - device codes
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package oauthstore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OauthDeviceCode Device authorization data (RFC 8628)
+// Device codes are pending until approved or denied by a user entering the user code
+type OauthDeviceCode struct {
+	gorm.Model
+	ClientID   uint
+	UserID     uint
+	DeviceCode string `gorm:"index"` // Device code signature
+	UserCode   string `gorm:"index"` // Normalised user code
+	Approved   bool
+	Denied     bool
+	LastPolled time.Time
+	OauthRequest
+	OauthSession
+}
+
+// GetDeviceCode fetches the device code signature
+func (od *OauthDeviceCode) GetDeviceCode() string { return od.DeviceCode }
+
+// GetUserCode fetches the user code to be entered at the verification endpoint
+func (od *OauthDeviceCode) GetUserCode() string { return od.UserCode }
+
+// IsApproved checks whether the device code has been approved by a user
+func (od *OauthDeviceCode) IsApproved() bool { return od.Approved }
+
+// IsDenied checks whether the device code has been denied by a user
+func (od *OauthDeviceCode) IsDenied() bool { return od.Denied }
+
+// GetLastPolled fetches the time the device code was last polled by the client
+func (od *OauthDeviceCode) GetLastPolled() time.Time { return od.LastPolled }
+
+// GetSession fetches the user session associated with the device code
+func (od *OauthDeviceCode) GetSession() interface{} { return &od.OauthSession }
+
+// SetSession is unused for device codes
+func (od *OauthDeviceCode) SetSession(session interface{}) {}
+
+// AddDeviceCodeSession creates a pending device code session in the database
+func (oauthStore *OauthStore) AddDeviceCodeSession(clientID, deviceCode, userCode, requestID string,
+	requestedAt, expiresAt time.Time, requestedScopes []string) (interface{}, error) {
+
+	c, err := oauthStore.GetClientByID(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.New("Device code client not found")
+	}
+	client := c.(*OauthClient)
+
+	or := OauthRequest{
+		RequestID:   requestID,
+		RequestedAt: requestedAt,
+		ExpiresAt:   expiresAt,
+	}
+	or.SetRequestedScopes(requestedScopes)
+
+	device := OauthDeviceCode{
+		ClientID:     client.ID,
+		DeviceCode:   deviceCode,
+		UserCode:     userCode,
+		OauthRequest: or,
+	}
+
+	err = oauthStore.db.Create(&device).Error
+	if err != nil {
+		return nil, err
+	}
+
+	device.Client = *client
+
+	return &device, nil
+}
+
+func (oauthStore *OauthStore) fetchDeviceCodeSession(match *OauthDeviceCode) (*OauthDeviceCode, error) {
+	var device OauthDeviceCode
+	err := oauthStore.db.Where(match).First(&device).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	err = oauthStore.db.Where(&OauthClient{ID: device.ClientID}).First(&device.Client).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GetDeviceCodeSession fetches a device code session by device code signature
+// This returns nil if no session is found
+func (oauthStore *OauthStore) GetDeviceCodeSession(deviceCode string) (interface{}, error) {
+	device, err := oauthStore.fetchDeviceCodeSession(&OauthDeviceCode{DeviceCode: deviceCode})
+	if device == nil {
+		return nil, err
+	}
+	return device, err
+}
+
+// GetDeviceCodeSessionByUserCode fetches a device code session by user code
+// This returns nil if no session is found
+func (oauthStore *OauthStore) GetDeviceCodeSessionByUserCode(userCode string) (interface{}, error) {
+	device, err := oauthStore.fetchDeviceCodeSession(&OauthDeviceCode{UserCode: userCode})
+	if device == nil {
+		return nil, err
+	}
+	return device, err
+}
+
+// ApproveDeviceCodeSession binds a device code session to a user and grants the provided scopes
+func (oauthStore *OauthStore) ApproveDeviceCodeSession(userCode, userID string, grantedScopes []string) (interface{}, error) {
+	u, err := oauthStore.base.GetUserByExtID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("Device code user not found")
+	}
+	user := u.(User)
+
+	device, err := oauthStore.fetchDeviceCodeSession(&OauthDeviceCode{UserCode: userCode})
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, errors.New("Device code session not found")
+	}
+
+	device.UserID = user.GetIntID()
+	device.Approved = true
+	device.SetGrantedScopes(grantedScopes)
+	device.OauthSession = NewSession(user.GetExtID(), user.GetUsername())
+
+	err = oauthStore.db.Save(device).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// DenyDeviceCodeSession marks a device code session as denied
+func (oauthStore *OauthStore) DenyDeviceCodeSession(userCode string) error {
+	return oauthStore.db.Model(&OauthDeviceCode{}).Where(&OauthDeviceCode{UserCode: userCode}).
+		Update("denied", true).Error
+}
+
+// UpdateDeviceCodeSessionPolled sets the time a device code session was last polled
+func (oauthStore *OauthStore) UpdateDeviceCodeSessionPolled(deviceCode string, polled time.Time) error {
+	return oauthStore.db.Model(&OauthDeviceCode{}).Where(&OauthDeviceCode{DeviceCode: deviceCode}).
+		Update("last_polled", polled).Error
+}
+
+// RemoveDeviceCodeSession removes a device code session using the provided device code signature
+func (oauthStore *OauthStore) RemoveDeviceCodeSession(deviceCode string) error {
+	return oauthStore.db.Unscoped().Where(&OauthDeviceCode{DeviceCode: deviceCode}).Delete(&OauthDeviceCode{}).Error
+}
+
+// RemoveApprovedDeviceCodeSession removes an approved device code session, returning whether a session was removed
+// Concurrent exchanges of a device code race on removal, so only one is able to remove the session
+func (oauthStore *OauthStore) RemoveApprovedDeviceCodeSession(deviceCode string) (bool, error) {
+	res := oauthStore.db.Unscoped().Where(&OauthDeviceCode{DeviceCode: deviceCode, Approved: true}).Delete(&OauthDeviceCode{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -20,6 +20,8 @@ func init() {
 	gob.Register(&OauthAuthorizeCode{})
 	gob.Register(&OauthAccessToken{})
 	gob.Register(&OauthRefreshToken{})
+	gob.Register(&OauthOpenIDSession{})
+	gob.Register(&OauthDeviceCode{})
 }
 
 // User defines the user interface required by the Oauth2 storage module
@@ -56,11 +58,15 @@ func Sync(dataStore *gorm.DB) *gorm.DB {
 	db = db.Exec("DROP TABLE IF EXISTS oauth_access_tokens CASCADE;")
 	db = db.Exec("DROP TABLE IF EXISTS oauth_authorize_codes CASCADE;")
 	db = db.Exec("DROP TABLE IF EXISTS oauth_refresh_tokens CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS oauth_open_id_sessions CASCADE;")
+	db = db.Exec("DROP TABLE IF EXISTS oauth_device_codes CASCADE;")
 
 	db = db.AutoMigrate(&OauthClient{})
 	db = db.AutoMigrate(&OauthAuthorizeCode{})
 	db = db.AutoMigrate(&OauthAccessToken{})
 	db = db.AutoMigrate(&OauthRefreshToken{})
+	db = db.AutoMigrate(&OauthOpenIDSession{})
+	db = db.AutoMigrate(&OauthDeviceCode{})
 
 	return db
 }
@@ -0,0 +1,122 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * OAuth data store - OpenID Connect sessions
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package oauthstore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OauthOpenIDSession OpenID Connect session for an authorization code
+// This holds the login information required to issue an ID token when the code is exchanged
+type OauthOpenIDSession struct {
+	gorm.Model
+	ClientID    uint
+	UserID      uint
+	Code        string `gorm:"index"`
+	Nonce       string
+	AuthTime    time.Time
+	AuthMethods string
+	OauthRequest
+	OauthSession
+}
+
+// GetCode fetches the authorization code the session is bound to
+func (os *OauthOpenIDSession) GetCode() string { return os.Code }
+
+// GetNonce fetches the nonce provided by the client in the authorization request
+func (os *OauthOpenIDSession) GetNonce() string { return os.Nonce }
+
+// GetAuthTime fetches the time the user logged in
+func (os *OauthOpenIDSession) GetAuthTime() time.Time { return os.AuthTime }
+
+// GetAuthMethods fetches the authentication methods used by the user to log in
+func (os *OauthOpenIDSession) GetAuthMethods() []string { return stringToArray(os.AuthMethods) }
+
+// GetSession fetches the user session associated with the OpenID Connect session
+func (os *OauthOpenIDSession) GetSession() interface{} { return &os.OauthSession }
+
+// AddOpenIDSession creates an OpenID Connect session for an authorization code
+func (oauthStore *OauthStore) AddOpenIDSession(userID, clientID, code, requestID string, requestedAt, expiresAt, authTime time.Time,
+	nonce string, authMethods, requestedScopes, grantedScopes []string) (interface{}, error) {
+
+	u, err := oauthStore.base.GetUserByExtID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New("OpenID session user not found")
+	}
+	user := u.(User)
+
+	c, err := oauthStore.GetClientByID(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.New("OpenID session client not found")
+	}
+	client := c.(*OauthClient)
+
+	or := OauthRequest{
+		RequestID:   requestID,
+		RequestedAt: requestedAt,
+		ExpiresAt:   expiresAt,
+	}
+
+	or.SetRequestedScopes(requestedScopes)
+	or.SetGrantedScopes(grantedScopes)
+
+	session := NewSession(user.GetExtID(), user.GetUsername())
+	session.Subject = user.GetExtID()
+
+	openID := OauthOpenIDSession{
+		ClientID:     client.ID,
+		UserID:       user.GetIntID(),
+		Code:         code,
+		Nonce:        nonce,
+		AuthTime:     authTime,
+		AuthMethods:  arrayToString(authMethods),
+		OauthRequest: or,
+		OauthSession: session,
+	}
+
+	err = oauthStore.db.Create(&openID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	openID.Client = *client
+
+	return &openID, nil
+}
+
+// GetOpenIDSession fetches the OpenID Connect session for an authorization code
+// This returns nil if no session is found
+func (oauthStore *OauthStore) GetOpenIDSession(code string) (interface{}, error) {
+	var openID OauthOpenIDSession
+	err := oauthStore.db.Where(&OauthOpenIDSession{Code: code}).First(&openID).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	err = oauthStore.db.Where(&OauthClient{ID: openID.ClientID}).First(&openID.Client).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &openID, nil
+}
+
+// RemoveOpenIDSession removes the OpenID Connect session for an authorization code
+func (oauthStore *OauthStore) RemoveOpenIDSession(code string) error {
+	return oauthStore.db.Unscoped().Where(&OauthOpenIDSession{Code: code}).Delete(&OauthOpenIDSession{}).Error
+}
@@ -113,6 +113,10 @@ func (os *OauthStore) GetRefreshTokenSessionsByUserID(userID string) ([]interfac
 
 	interfaces := make([]interface{}, len(refreshes))
 	for i := range refreshes {
+		err = os.db.Where(&OauthClient{ID: refreshes[i].ClientID}).First(&refreshes[i].Client).Error
+		if err != nil {
+			return nil, err
+		}
 		interfaces[i] = &refreshes[i]
 	}
 
@@ -137,6 +141,11 @@ func (os *OauthStore) GetClientByRefreshToken(signature string) (interface{}, er
 }
 
 func (os *OauthStore) RemoveRefreshToken(signature string) error {
-	err := os.db.Delete(&OauthRefreshToken{Signature: signature}).Error
-	return err
+	return os.db.Unscoped().Where("signature = ?", signature).Delete(&OauthRefreshToken{}).Error
+}
+
+// RemoveRefreshTokenSessionsByRequestID removes all refresh tokens issued for an originator request ID
+// Refreshed tokens share the request ID of the original grant, so this revokes the refresh token chain
+func (os *OauthStore) RemoveRefreshTokenSessionsByRequestID(requestID string) error {
+	return os.db.Unscoped().Where("request_id = ?", requestID).Delete(&OauthRefreshToken{}).Error
 }
@@ -88,9 +88,10 @@ func TestOauthstore(t *testing.T) {
 
 	fakeAuthorizeCode := "oauth-fake-authorize-code"
 	fakeAuthorizeCodeRequestID := "oauth-fake-authorize-request-id"
+	fakeChallenge := "oauth-fake-code-challenge"
 
 	t.Run("Add Authorize Code session", func(t *testing.T) {
-		acs, err := ds.OauthStore.AddAuthorizeCodeSession(user.ExtID, client.ClientID, fakeAuthorizeCode, fakeAuthorizeCodeRequestID, time.Now(), time.Now().Add(time.Hour*1), scopes, scopes)
+		acs, err := ds.OauthStore.AddAuthorizeCodeSession(user.ExtID, client.ClientID, fakeAuthorizeCode, fakeAuthorizeCodeRequestID, time.Now(), time.Now().Add(time.Hour*1), scopes, scopes, fakeChallenge, "S256")
 
 		assert.Nil(t, err, "Authorize Code  creation error")
 		assert.NotNil(t, acs, "No authorize code instance returned")
@@ -108,6 +109,8 @@ func TestOauthstore(t *testing.T) {
 		assert.NotNil(t, acs, "No authorize code instance returned")
 
 		authorizeCodeSession := acs.(*oauthstore.OauthAuthorizeCode)
+		assert.EqualValues(t, fakeChallenge, authorizeCodeSession.GetChallenge())
+		assert.EqualValues(t, "S256", authorizeCodeSession.GetChallengeMethod())
 
 		c := authorizeCodeSession.GetClient()
 		assert.NotNil(t, c, "Could not fetch client for authorize code session")
@@ -215,4 +218,77 @@ func TestOauthstore(t *testing.T) {
 		client := c.(*oauthstore.OauthClient)
 		assert.EqualValues(t, clientId, client.GetID())
 	})
+
+	fakeDeviceCode := "oauth-fake-device-code"
+	fakeUserCode := "BCDFGHJK"
+	fakeDeviceCodeRequestID := "oauth-fake-device-request-id"
+
+	t.Run("Add Device Code session", func(t *testing.T) {
+		dcs, err := ds.OauthStore.AddDeviceCodeSession(client.ClientID, fakeDeviceCode, fakeUserCode, fakeDeviceCodeRequestID, time.Now(), time.Now().Add(time.Minute*10), scopes)
+		assert.Nil(t, err, "Device Code creation error")
+		assert.NotNil(t, dcs, "No device code instance returned")
+
+		deviceCodeSession := dcs.(*oauthstore.OauthDeviceCode)
+		assert.False(t, deviceCodeSession.IsApproved())
+		assert.False(t, deviceCodeSession.IsDenied())
+	})
+
+	t.Run("Approve Device Code session", func(t *testing.T) {
+		_, err := ds.OauthStore.ApproveDeviceCodeSession(fakeUserCode, user.ExtID, scopes[:1])
+		assert.Nil(t, err, "Device Code approval error")
+
+		dcs, err := ds.OauthStore.GetDeviceCodeSession(fakeDeviceCode)
+		assert.Nil(t, err, "Device Code fetch error")
+		assert.NotNil(t, dcs, "No device code instance returned")
+
+		deviceCodeSession := dcs.(*oauthstore.OauthDeviceCode)
+		assert.True(t, deviceCodeSession.IsApproved())
+		assert.EqualValues(t, user.ExtID, deviceCodeSession.GetUserID())
+		assert.EqualValues(t, scopes[:1], deviceCodeSession.GetGrantedScopes())
+
+		c := deviceCodeSession.GetClient()
+		assert.NotNil(t, c, "Could not fetch client for device code session")
+		assert.EqualValues(t, clientId, c.(*oauthstore.OauthClient).GetID())
+	})
+
+	t.Run("Approved Device Code sessions are only removed once", func(t *testing.T) {
+		removed, err := ds.OauthStore.RemoveApprovedDeviceCodeSession(fakeDeviceCode)
+		assert.Nil(t, err, "Device Code removal error")
+		assert.True(t, removed, "Approved device code not removed")
+
+		removed, err = ds.OauthStore.RemoveApprovedDeviceCodeSession(fakeDeviceCode)
+		assert.Nil(t, err, "Device Code removal error")
+		assert.False(t, removed, "Device code removed more than once")
+	})
+
+	t.Run("Remove Device Code session", func(t *testing.T) {
+		err := ds.OauthStore.RemoveDeviceCodeSession(fakeDeviceCode)
+		assert.Nil(t, err, "Device Code removal error")
+
+		dcs, err := ds.OauthStore.GetDeviceCodeSessionByUserCode(fakeUserCode)
+		assert.Nil(t, err, "Device Code fetch error")
+		assert.Nil(t, dcs, "Device code instance returned following removal")
+	})
+
+	t.Run("Remove Refresh Token sessions by request id", func(t *testing.T) {
+		err := ds.OauthStore.RemoveRefreshTokenSessionsByRequestID(fakeRefreshTokenRequestID)
+		assert.Nil(t, err, "Refresh Token removal error")
+
+		rts, err := ds.OauthStore.GetRefreshTokenSessionByRequestID(fakeRefreshTokenRequestID)
+		assert.Nil(t, err, "Refresh Token fetch error")
+		assert.Nil(t, rts, "Refresh token instance returned following removal")
+	})
+
+	t.Run("Remove client sessions for user", func(t *testing.T) {
+		ats, err := ds.OauthStore.GetAccessTokenSessionsByUserID(user.ExtID)
+		assert.Nil(t, err, "Access Token fetch error")
+		assert.NotEmpty(t, ats, "No access token instances returned")
+
+		err = ds.OauthStore.RemoveClientSessionsByUserID(user.ExtID, client.ClientID)
+		assert.Nil(t, err, "Client session removal error")
+
+		ats, err = ds.OauthStore.GetAccessTokenSessionsByUserID(user.ExtID)
+		assert.Nil(t, err, "Access Token fetch error")
+		assert.Empty(t, ats, "Access token instances returned following removal")
+	})
 }
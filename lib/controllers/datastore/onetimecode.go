@@ -0,0 +1,149 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - 2fa one time codes (email, sms)
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OneTimeCode delivered one time code object
+// Codes are stored hashed and are scoped by user and delivery method
+type OneTimeCode struct {
+	gorm.Model
+	UserID    uint
+	Method    string
+	Hash      string
+	ExpiresAt time.Time
+	Attempts  uint
+	Used      bool
+	UsedAt    time.Time
+}
+
+// Getters and setters for external interface compliance
+
+// GetMethod fetches the code delivery method
+func (code *OneTimeCode) GetMethod() string { return code.Method }
+
+// GetHashedCode fetches the hashed code
+func (code *OneTimeCode) GetHashedCode() string { return code.Hash }
+
+// GetExpiry fetches the code expiry time
+func (code *OneTimeCode) GetExpiry() time.Time { return code.ExpiresAt }
+
+// GetAttempts fetches the number of validation attempts against the code
+func (code *OneTimeCode) GetAttempts() uint { return code.Attempts }
+
+// SetAttempts sets the number of validation attempts against the code
+func (code *OneTimeCode) SetAttempts(attempts uint) { code.Attempts = attempts }
+
+// IsUsed checks if a code has been used
+func (code *OneTimeCode) IsUsed() bool { return code.Used }
+
+// SetUsed marks a code as used
+func (code *OneTimeCode) SetUsed() {
+	code.Used = true
+	code.UsedAt = time.Now()
+}
+
+// GetCreatedAt fetches the code creation time
+func (code *OneTimeCode) GetCreatedAt() time.Time { return code.CreatedAt }
+
+// AddOneTimeCode creates a one time code for a user and method
+// Only one code is valid for each user and method, existing codes are removed
+func (dataStore *DataStore) AddOneTimeCode(userid, method, hash string, expiry time.Time) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	// Remove existing codes
+	err = dataStore.db.Where(&OneTimeCode{UserID: user.ID, Method: method}).Delete(&OneTimeCode{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	code := OneTimeCode{
+		UserID:    user.ID,
+		Method:    method,
+		Hash:      hash,
+		ExpiresAt: expiry,
+	}
+
+	err = dataStore.db.Create(&code).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+// GetOneTimeCode fetches the current one time code for a user and method
+// This returns nil if no code is found
+func (dataStore *DataStore) GetOneTimeCode(userid, method string) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var code OneTimeCode
+	err = dataStore.db.Where(&OneTimeCode{UserID: user.ID, Method: method}).Last(&code).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &code, nil
+}
+
+// CountOneTimeCodes counts the codes created for a user and method since the provided time
+// This includes codes that have since been replaced, for use in rate limiting
+func (dataStore *DataStore) CountOneTimeCodes(userid, method string, since time.Time) (uint, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return 0, err
+	}
+	if u == nil {
+		return 0, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var count uint
+	err = dataStore.db.Unscoped().Model(&OneTimeCode{}).
+		Where("user_id = ? AND method = ? AND created_at > ?", user.ID, method, since).
+		Count(&count).Error
+
+	return count, err
+}
+
+// UpdateOneTimeCode updates a one time code instance
+func (dataStore *DataStore) UpdateOneTimeCode(code interface{}) (interface{}, error) {
+	err := dataStore.db.Save(code).Error
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
@@ -0,0 +1,243 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Event outbox
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OutboxEvent event persisted for delivery to consumers
+type OutboxEvent struct {
+	gorm.Model
+	EventID   string `gorm:"not null;unique_index"`
+	UserExtID string
+	Type      string
+	Time      time.Time
+	Data      string `gorm:"type:text"`
+}
+
+// Getters and setters for external interface compliance
+
+// GetID fetches the event record ID
+func (e *OutboxEvent) GetID() uint { return e.ID }
+
+// GetCreatedAt fetches the time the event was persisted
+func (e *OutboxEvent) GetCreatedAt() time.Time { return e.CreatedAt }
+
+// GetEventID fetches the unique event ID
+func (e *OutboxEvent) GetEventID() string { return e.EventID }
+
+// GetUserExtID fetches the external ID of the user associated with the event
+func (e *OutboxEvent) GetUserExtID() string { return e.UserExtID }
+
+// GetType fetches the event type
+func (e *OutboxEvent) GetType() string { return e.Type }
+
+// GetTime fetches the event originator time
+func (e *OutboxEvent) GetTime() time.Time { return e.Time }
+
+// GetData fetches a map of the associated data
+func (e *OutboxEvent) GetData() (map[string]string, error) {
+	data := make(map[string]string)
+	err := json.Unmarshal([]byte(e.Data), &data)
+	return data, err
+}
+
+// OutboxDelivery delivery of an outbox event to a single consumer
+type OutboxDelivery struct {
+	gorm.Model
+	OutboxEventID uint   `gorm:"unique_index:idx_outbox_event_consumer"`
+	Consumer      string `gorm:"not null;unique_index:idx_outbox_event_consumer"`
+	Status        string `gorm:"index"`
+	Attempts      uint
+	NextAttempt   time.Time `gorm:"index"`
+	LastError     string
+}
+
+// GetID fetches the delivery ID
+func (d *OutboxDelivery) GetID() uint { return d.ID }
+
+// GetOutboxEventID fetches the ID of the delivered outbox event
+func (d *OutboxDelivery) GetOutboxEventID() uint { return d.OutboxEventID }
+
+// GetConsumer fetches the name of the consumer the delivery is for
+func (d *OutboxDelivery) GetConsumer() string { return d.Consumer }
+
+// GetStatus fetches the delivery status
+func (d *OutboxDelivery) GetStatus() string { return d.Status }
+
+// SetStatus sets the delivery status
+func (d *OutboxDelivery) SetStatus(status string) { d.Status = status }
+
+// GetAttempts fetches the number of delivery attempts
+func (d *OutboxDelivery) GetAttempts() uint { return d.Attempts }
+
+// SetAttempts sets the number of delivery attempts
+func (d *OutboxDelivery) SetAttempts(attempts uint) { d.Attempts = attempts }
+
+// GetNextAttempt fetches the time of the next delivery attempt
+func (d *OutboxDelivery) GetNextAttempt() time.Time { return d.NextAttempt }
+
+// SetNextAttempt sets the time of the next delivery attempt
+func (d *OutboxDelivery) SetNextAttempt(t time.Time) { d.NextAttempt = t }
+
+// GetLastError fetches the error from the last delivery attempt
+func (d *OutboxDelivery) GetLastError() string { return d.LastError }
+
+// SetLastError sets the error from the last delivery attempt
+func (d *OutboxDelivery) SetLastError(err string) { d.LastError = err }
+
+// AddOutboxEvent persists an event along with a delivery for each of the provided consumers
+// Events are identified by event ID, adding an existing event returns the existing record
+func (dataStore *DataStore) AddOutboxEvent(eventID, userExtID, eventType string, eventTime time.Time, data map[string]string,
+	consumers []string, status string) (interface{}, error) {
+
+	encodedData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := dataStore.begin()
+
+	var event OutboxEvent
+	err = tx.Where(&OutboxEvent{EventID: eventID}).First(&event).Error
+	if err == nil {
+		tx.Rollback()
+		return &event, nil
+	} else if err != gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return nil, err
+	}
+
+	event = OutboxEvent{
+		EventID:   eventID,
+		UserExtID: userExtID,
+		Type:      eventType,
+		Time:      eventTime,
+		Data:      string(encodedData),
+	}
+
+	err = tx.Create(&event).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, c := range consumers {
+		delivery := OutboxDelivery{
+			OutboxEventID: event.ID,
+			Consumer:      c,
+			Status:        status,
+			NextAttempt:   now,
+		}
+		err = tx.Create(&delivery).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// GetOutboxEvent fetches an outbox event by ID
+// This returns nil if no event is found
+func (dataStore *DataStore) GetOutboxEvent(id uint) (interface{}, error) {
+	var event OutboxEvent
+	err := dataStore.db.Where("id = ?", id).First(&event).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &event, nil
+}
+
+// GetOutboxEventsSince fetches events created since the provided time
+// Events are returned in the order they were created
+func (dataStore *DataStore) GetOutboxEventsSince(since time.Time) ([]interface{}, error) {
+	var events []OutboxEvent
+	err := dataStore.db.Where("created_at > ?", since).Order("id asc").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]interface{}, len(events))
+	for i := range events {
+		interfaces[i] = &events[i]
+	}
+
+	return interfaces, nil
+}
+
+// ClaimOutboxDeliveries claims deliveries for a consumer with the provided status that are due before the provided time
+// Claimed deliveries are not due again until the lease has passed, allowing multiple instances to share the outbox.
+// Deliveries are returned in the order they were created
+func (dataStore *DataStore) ClaimOutboxDeliveries(consumer, status string, now time.Time, lease time.Duration, limit uint) ([]interface{}, error) {
+	tx := dataStore.begin()
+
+	var deliveries []OutboxDelivery
+	err := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+		Where("consumer = ? AND status = ? AND next_attempt <= ?", consumer, status, now).
+		Order("id asc").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	interfaces := make([]interface{}, len(deliveries))
+	for i := range deliveries {
+		deliveries[i].NextAttempt = now.Add(lease)
+		err = tx.Save(&deliveries[i]).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		interfaces[i] = &deliveries[i]
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	return interfaces, nil
+}
+
+// UpdateOutboxDelivery updates an outbox delivery instance
+func (dataStore *DataStore) UpdateOutboxDelivery(delivery interface{}) (interface{}, error) {
+	err := dataStore.db.Save(delivery).Error
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// RemoveOutboxEvents removes events created before the provided time without active deliveries
+// Completed deliveries (with a status other than the provided active status) are removed with the event
+func (dataStore *DataStore) RemoveOutboxEvents(before time.Time, active string) error {
+	err := dataStore.db.Unscoped().
+		Where("created_at < ? AND status != ?", before, active).
+		Delete(&OutboxDelivery{}).Error
+	if err != nil {
+		return err
+	}
+
+	return dataStore.db.Unscoped().
+		Where("created_at < ? AND NOT EXISTS (SELECT 1 FROM outbox_deliveries WHERE outbox_deliveries.outbox_event_id = outbox_events.id)", before).
+		Delete(&OutboxEvent{}).Error
+}
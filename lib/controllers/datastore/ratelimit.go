@@ -0,0 +1,55 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Rate limit buckets
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RateLimitBucket rate limit state for a single key
+// This allows rate limits to be shared between instances
+type RateLimitBucket struct {
+	gorm.Model
+	Key     string `gorm:"not null;unique_index"`
+	Tokens  float64
+	Updated time.Time
+	FullAt  time.Time `gorm:"index"`
+}
+
+// UpdateRateLimitBucket atomically updates the rate limit bucket for a key
+// The update function is called with the current bucket state (zero values for new buckets) within a
+// transaction, and returns the new state and the time at which the bucket will be full
+func (dataStore *DataStore) UpdateRateLimitBucket(key string, update func(tokens float64, updated time.Time) (float64, time.Time, time.Time)) error {
+	tx := dataStore.begin()
+
+	var bucket RateLimitBucket
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Where(&RateLimitBucket{Key: key}).First(&bucket).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		return err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		bucket = RateLimitBucket{Key: key}
+	}
+
+	bucket.Tokens, bucket.Updated, bucket.FullAt = update(bucket.Tokens, bucket.Updated)
+
+	err = tx.Save(&bucket).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// RemoveRateLimitBuckets removes rate limit buckets that are full as of the provided time
+// Full buckets are equivalent to new buckets, so do not need to be stored
+func (dataStore *DataStore) RemoveRateLimitBuckets(before time.Time) error {
+	return dataStore.db.Unscoped().Where("full_at < ?", before).Delete(&RateLimitBucket{}).Error
+}
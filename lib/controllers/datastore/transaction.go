@@ -0,0 +1,63 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - transactions
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"github.com/authplz/authplz-core/lib/controllers/datastore/oauth2"
+)
+
+// Transaction runs the provided function with a datastore scoped to a single database transaction
+// The transaction is committed if the function returns nil, and rolled back otherwise. The scoped
+// datastore is provided as an interface for use with module store interfaces.
+func (dataStore *DataStore) Transaction(fn func(store interface{}) error) error {
+	tx := dataStore.begin()
+
+	scoped := &DataStore{db: tx.DB, dbString: dataStore.dbString, tx: true}
+	scoped.OauthStore = oauthstore.NewOauthStore(tx.DB, scoped)
+
+	err := fn(scoped)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// transaction wraps a gorm transaction, allowing transaction scoped datastores to join the
+// existing transaction rather than starting a new one
+type transaction struct {
+	*gorm.DB
+	nested bool
+}
+
+// begin starts a transaction, or joins the current transaction for transaction scoped datastores
+func (dataStore *DataStore) begin() *transaction {
+	if dataStore.tx {
+		return &transaction{DB: dataStore.db, nested: true}
+	}
+	return &transaction{DB: dataStore.db.Begin()}
+}
+
+// Commit commits the transaction, nested transactions are committed with the enclosing transaction
+func (t *transaction) Commit() *gorm.DB {
+	if t.nested {
+		return t.DB
+	}
+	return t.DB.Commit()
+}
+
+// Rollback rolls back the transaction, nested transactions are rolled back when the error
+// causing the rollback is returned to the enclosing transaction
+func (t *transaction) Rollback() *gorm.DB {
+	if t.nested {
+		return t.DB
+	}
+	return t.DB.Rollback()
+}
@@ -0,0 +1,180 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Trusted devices
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TrustedDevice device trusted to skip second factor authentication
+// Devices are identified by a token held in a signed device cookie, only a hash of which is stored
+type TrustedDevice struct {
+	gorm.Model
+	UserID     uint
+	Hash       string `gorm:"not null;unique"`
+	RemoteAddr string
+	UserAgent  string
+	LastUsed   time.Time
+	ExpiresAt  time.Time
+}
+
+// Getters and setters for external interface compliance
+
+// GetID fetches the trusted device record ID
+func (d *TrustedDevice) GetID() uint { return d.ID }
+
+// GetHash fetches the hashed device token
+func (d *TrustedDevice) GetHash() string { return d.Hash }
+
+// GetRemoteAddr fetches the remote address the device was trusted from
+func (d *TrustedDevice) GetRemoteAddr() string { return d.RemoteAddr }
+
+// GetUserAgent fetches the user agent of the trusted device
+func (d *TrustedDevice) GetUserAgent() string { return d.UserAgent }
+
+// GetCreatedAt fetches the time the device was trusted
+func (d *TrustedDevice) GetCreatedAt() time.Time { return d.CreatedAt }
+
+// GetLastUsed fetches the time the device was last used to skip second factor authentication
+func (d *TrustedDevice) GetLastUsed() time.Time { return d.LastUsed }
+
+// SetLastUsed sets the time the device was last used to skip second factor authentication
+func (d *TrustedDevice) SetLastUsed(t time.Time) { d.LastUsed = t }
+
+// GetExpiry fetches the device trust expiry time
+func (d *TrustedDevice) GetExpiry() time.Time { return d.ExpiresAt }
+
+// AddTrustedDevice adds a trusted device to a user account
+func (dataStore *DataStore) AddTrustedDevice(userid, hash, remoteAddr, userAgent string, expiry time.Time) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	device := TrustedDevice{
+		UserID:     user.ID,
+		Hash:       hash,
+		RemoteAddr: remoteAddr,
+		UserAgent:  userAgent,
+		LastUsed:   time.Now(),
+		ExpiresAt:  expiry,
+	}
+
+	err = dataStore.db.Create(&device).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GetTrustedDevice fetches an unexpired trusted device for a user by hashed device token
+// This returns nil if no device is found
+func (dataStore *DataStore) GetTrustedDevice(userid, hash string) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var device TrustedDevice
+	err = dataStore.db.Where("user_id = ? AND hash = ? AND expires_at > ?", user.ID, hash, time.Now()).First(&device).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &device, nil
+}
+
+// GetTrustedDevices fetches the unexpired trusted devices for a user
+func (dataStore *DataStore) GetTrustedDevices(userid string) ([]interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	var devices []TrustedDevice
+	err = dataStore.db.Where("user_id = ? AND expires_at > ?", user.ID, time.Now()).
+		Order("last_used desc").Find(&devices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]interface{}, len(devices))
+	for i := range devices {
+		interfaces[i] = &devices[i]
+	}
+
+	return interfaces, nil
+}
+
+// UpdateTrustedDevice updates a trusted device instance
+func (dataStore *DataStore) UpdateTrustedDevice(device interface{}) (interface{}, error) {
+	err := dataStore.db.Save(device).Error
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// RemoveTrustedDevice removes a trusted device from a user account by record ID
+func (dataStore *DataStore) RemoveTrustedDevice(userid string, id uint) error {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	return dataStore.db.Unscoped().Where("user_id = ? AND id = ?", user.ID, id).Delete(&TrustedDevice{}).Error
+}
+
+// RemoveTrustedDevices removes all trusted devices from a user account
+func (dataStore *DataStore) RemoveTrustedDevices(userid string) error {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	return dataStore.db.Unscoped().Where("user_id = ?", user.ID).Delete(&TrustedDevice{}).Error
+}
@@ -36,13 +36,21 @@ type User struct {
 	Locked          bool `gorm:"not null; default:false"`
 	Admin           bool `gorm:"not null; default:false"`
 	LoginRetries    uint `gorm:"not null; default:0"`
+	LockedUntil     time.Time
+	LockoutCount    uint `gorm:"not null; default:0"`
 	LastLogin       time.Time
-
-	ActionTokens []ActionToken
-	FidoTokens   []FidoToken
-	TotpTokens   []TotpToken
-	BackupTokens []BackupToken
-	AuditEvents  []AuditEvent
+	EmailOTPEnabled bool `gorm:"not null; default:false"`
+	PhoneNumber     string
+	PhoneVerified   bool `gorm:"not null; default:false"`
+
+	ActionTokens        []ActionToken
+	FidoTokens          []FidoToken
+	WebAuthnCredentials []WebAuthnCredential
+	TotpTokens          []TotpToken
+	YubikeyTokens       []YubikeyToken
+	BackupTokens        []BackupToken
+	OneTimeCodes        []OneTimeCode
+	AuditEvents         []AuditEvent
 
 	OauthClients               []oauthstore.OauthClient
 	OauthAccessTokenSessions   []oauthstore.OauthAccessToken
@@ -103,6 +111,19 @@ func (u *User) SetLoginRetries(retries uint) { u.LoginRetries = retries }
 // ClearLoginRetries clears a users login retry count
 func (u *User) ClearLoginRetries() { u.LoginRetries = 0 }
 
+// GetLockedUntil fetches the time a users temporary account lock expires
+// A zero time indicates the lock does not expire
+func (u *User) GetLockedUntil() time.Time { return u.LockedUntil }
+
+// SetLockedUntil sets the time a users temporary account lock expires
+func (u *User) SetLockedUntil(t time.Time) { u.LockedUntil = t }
+
+// GetLockoutCount fetches the number of consecutive lockouts for a user
+func (u *User) GetLockoutCount() uint { return u.LockoutCount }
+
+// SetLockoutCount sets the number of consecutive lockouts for a user
+func (u *User) SetLockoutCount(count uint) { u.LockoutCount = count }
+
 // GetLastLogin fetches a users LastLogin time
 func (u *User) GetLastLogin() time.Time { return u.LastLogin }
 
@@ -112,9 +133,27 @@ func (u *User) GetCreatedAt() time.Time { return u.CreatedAt }
 // SetLastLogin sets a users LastLogin time
 func (u *User) SetLastLogin(t time.Time) { u.LastLogin = t }
 
+// IsEmailOTPEnabled checks if a user has enabled email one time codes as a second factor
+func (u *User) IsEmailOTPEnabled() bool { return u.EmailOTPEnabled }
+
+// SetEmailOTPEnabled sets whether email one time codes are enabled as a second factor
+func (u *User) SetEmailOTPEnabled(enabled bool) { u.EmailOTPEnabled = enabled }
+
+// GetPhoneNumber fetches a users PhoneNumber
+func (u *User) GetPhoneNumber() string { return u.PhoneNumber }
+
+// SetPhoneNumber sets a users PhoneNumber
+func (u *User) SetPhoneNumber(number string) { u.PhoneNumber = number }
+
+// IsPhoneVerified checks if a users phone number has been verified
+func (u *User) IsPhoneVerified() bool { return u.PhoneVerified }
+
+// SetPhoneVerified sets a users phone verified status
+func (u *User) SetPhoneVerified(verified bool) { u.PhoneVerified = verified }
+
 // SecondFactors Checks if a user has attached second factors
 func (u *User) SecondFactors() bool {
-	return (len(u.FidoTokens) > 0) || (len(u.TotpTokens) > 0)
+	return (len(u.FidoTokens) > 0) || (len(u.WebAuthnCredentials) > 0) || (len(u.TotpTokens) > 0) || (len(u.YubikeyTokens) > 0) || u.EmailOTPEnabled || u.PhoneVerified
 }
 
 // SetPassword sets a user password
@@ -214,6 +253,54 @@ func (dataStore *DataStore) UpdateUser(user interface{}) (interface{}, error) {
 	return user, nil
 }
 
+// RemoveUser Removes a user account along with all associated tokens, credentials and sessions
+func (dataStore *DataStore) RemoveUser(user interface{}) error {
+	u := user.(*User)
+
+	related := []interface{}{
+		&ActionToken{},
+		&FidoToken{},
+		&WebAuthnCredential{},
+		&TotpToken{},
+		&YubikeyToken{},
+		&BackupToken{},
+		&OneTimeCode{},
+		&TrustedDevice{},
+		&KnownDevice{},
+		&LoginLocation{},
+		&AuditEvent{},
+		&oauthstore.OauthClient{},
+		&oauthstore.OauthAccessToken{},
+		&oauthstore.OauthAuthorizeCode{},
+		&oauthstore.OauthRefreshToken{},
+		&oauthstore.OauthOpenIDSession{},
+	}
+
+	tx := dataStore.begin()
+
+	for _, r := range related {
+		err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(r).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err := tx.Unscoped().Where("user_ext_id = ?", u.ExtID).Delete(&WebSession{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Unscoped().Delete(u).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
 // GetTokens Fetches tokens attached to a user account
 func (dataStore *DataStore) GetTokens(user interface{}) (interface{}, error) {
 	var err error
@@ -224,10 +311,18 @@ func (dataStore *DataStore) GetTokens(user interface{}) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = dataStore.db.Model(user).Related(&u.WebAuthnCredentials).Error
+	if err != nil {
+		return nil, err
+	}
 	err = dataStore.db.Model(user).Related(&u.TotpTokens).Error
 	if err != nil {
 		return nil, err
 	}
+	err = dataStore.db.Model(user).Related(&u.YubikeyTokens).Error
+	if err != nil {
+		return nil, err
+	}
 	err = dataStore.db.Model(user).Related(&u.BackupTokens).Error
 	if err != nil {
 		return nil, err
@@ -0,0 +1,243 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - 2fa webauthn / fido2 credentials
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"encoding/base64"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+)
+
+// WebAuthnAttestationFidoU2F attestation type assigned to credentials migrated from legacy FidoTokens
+const WebAuthnAttestationFidoU2F = "fido-u2f"
+
+// WebAuthnCredential WebAuthn/FIDO2 credential object
+type WebAuthnCredential struct {
+	gorm.Model
+	ExtID           string
+	UserID          uint
+	Name            string
+	CredentialID    string `gorm:"not null;unique"`
+	PublicKey       string
+	AttestationType string
+	AAGUID          string
+	SignCount       uint
+	Flags           uint
+	CloneWarning    bool
+	Discoverable    bool
+	LegacyU2F       bool
+	FidoTokenID     uint
+	LastUsed        time.Time
+}
+
+// Getters and setters for external interface compliance
+
+// GetName fetches the credential Name
+func (c *WebAuthnCredential) GetName() string { return c.Name }
+
+// GetExtID fetches the external ID for a credential
+func (c *WebAuthnCredential) GetExtID() string { return c.ExtID }
+
+// GetCredentialID fetches the (base64 url encoded) credential ID
+func (c *WebAuthnCredential) GetCredentialID() string { return c.CredentialID }
+
+// GetPublicKey fetches the (base64 encoded) credential PublicKey
+func (c *WebAuthnCredential) GetPublicKey() string { return c.PublicKey }
+
+// GetAttestationType fetches the attestation type used at registration
+func (c *WebAuthnCredential) GetAttestationType() string { return c.AttestationType }
+
+// GetAAGUID fetches the (hex encoded) authenticator AAGUID
+func (c *WebAuthnCredential) GetAAGUID() string { return c.AAGUID }
+
+// GetSignCount fetches the authenticator signature counter
+func (c *WebAuthnCredential) GetSignCount() uint { return c.SignCount }
+
+// SetSignCount sets the authenticator signature counter
+func (c *WebAuthnCredential) SetSignCount(count uint) { c.SignCount = count }
+
+// GetFlags fetches the authenticator flags stored with the credential
+func (c *WebAuthnCredential) GetFlags() uint { return c.Flags }
+
+// SetFlags sets the authenticator flags stored with the credential
+func (c *WebAuthnCredential) SetFlags(flags uint) { c.Flags = flags }
+
+// GetCloneWarning checks whether a counter regression has been detected for the credential
+func (c *WebAuthnCredential) GetCloneWarning() bool { return c.CloneWarning }
+
+// SetCloneWarning sets the credential clone warning
+func (c *WebAuthnCredential) SetCloneWarning(warning bool) { c.CloneWarning = warning }
+
+// IsDiscoverable checks whether a credential was registered as a discoverable credential (passkey)
+func (c *WebAuthnCredential) IsDiscoverable() bool { return c.Discoverable }
+
+// IsLegacyU2F checks whether a credential was migrated from a legacy U2F token
+func (c *WebAuthnCredential) IsLegacyU2F() bool { return c.LegacyU2F }
+
+// GetLastUsed fetches the credential LastUsed time
+func (c *WebAuthnCredential) GetLastUsed() time.Time { return c.LastUsed }
+
+// SetLastUsed sets the credential LastUsed time
+func (c *WebAuthnCredential) SetLastUsed(used time.Time) { c.LastUsed = used }
+
+// AddWebAuthnCredential creates a webauthn credential instance in the database
+func (dataStore *DataStore) AddWebAuthnCredential(userid, name, credentialID, publicKey, attestationType, aaguid string, signCount, flags uint, discoverable bool) (interface{}, error) {
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+
+	// Create a credential instance
+	credential := WebAuthnCredential{
+		ExtID:           uuid.NewV4().String(),
+		UserID:          user.ID,
+		Name:            name,
+		CredentialID:    credentialID,
+		PublicKey:       publicKey,
+		AttestationType: attestationType,
+		AAGUID:          aaguid,
+		SignCount:       signCount,
+		Flags:           flags,
+		Discoverable:    discoverable,
+		LastUsed:        time.Now(),
+	}
+
+	// Add the credential to the user and save
+	user.WebAuthnCredentials = append(user.WebAuthnCredentials, credential)
+	_, err = dataStore.UpdateUser(user)
+	return user, err
+}
+
+// GetWebAuthnCredentials fetches the webauthn credentials for a provided user
+func (dataStore *DataStore) GetWebAuthnCredentials(userid string) ([]interface{}, error) {
+	var credentials []WebAuthnCredential
+
+	// Fetch user
+	u, err := dataStore.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	err = dataStore.db.Model(u).Related(&credentials).Error
+
+	interfaces := make([]interface{}, len(credentials))
+	for i := range credentials {
+		interfaces[i] = &credentials[i]
+	}
+
+	return interfaces, err
+}
+
+// UpdateWebAuthnCredential updates a webauthn credential instance
+func (dataStore *DataStore) UpdateWebAuthnCredential(credential interface{}) (interface{}, error) {
+	err := dataStore.db.Save(credential).Error
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// RemoveWebAuthnCredential deletes a webauthn credential
+// Credentials migrated from legacy U2F tokens also remove the originating token so it can not be used via the u2f API
+func (dataStore *DataStore) RemoveWebAuthnCredential(credential interface{}) error {
+	c := credential.(*WebAuthnCredential)
+
+	if c.FidoTokenID != 0 {
+		err := dataStore.db.Delete(&FidoToken{}, "id = ?", c.FidoTokenID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return dataStore.db.Delete(credential).Error
+}
+
+// MigrateFidoTokens creates webauthn credentials for any legacy U2F FidoTokens that have not yet been migrated
+// Migrated credentials are marked as legacy so authentication can request the FIDO AppID extension
+func (dataStore *DataStore) MigrateFidoTokens() error {
+	var fidoTokens []FidoToken
+
+	err := dataStore.db.Find(&fidoTokens).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range fidoTokens {
+		err = migrateFidoToken(dataStore.db, &fidoTokens[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateFidoToken creates a webauthn credential for a legacy U2F FidoToken if one does not already exist
+// Tokens with invalid key handles or public keys are skipped
+func migrateFidoToken(db *gorm.DB, t *FidoToken) error {
+	var count int
+	err := db.Model(&WebAuthnCredential{}).Where("fido_token_id = ?", t.ID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	keyHandle, err := decodeLegacyBase64(t.KeyHandle)
+	if err != nil || len(keyHandle) == 0 {
+		log.Printf("Datastore.MigrateFidoTokens: skipping token %s with invalid key handle", t.ExtID)
+		return nil
+	}
+	publicKey, err := decodeLegacyBase64(t.PublicKey)
+	if err != nil || len(publicKey) == 0 {
+		log.Printf("Datastore.MigrateFidoTokens: skipping token %s with invalid public key", t.ExtID)
+		return nil
+	}
+
+	credential := WebAuthnCredential{
+		ExtID:           uuid.NewV4().String(),
+		UserID:          t.UserID,
+		Name:            t.Name,
+		CredentialID:    base64.RawURLEncoding.EncodeToString(keyHandle),
+		PublicKey:       base64.StdEncoding.EncodeToString(publicKey),
+		AttestationType: WebAuthnAttestationFidoU2F,
+		SignCount:       t.Counter,
+		LegacyU2F:       true,
+		FidoTokenID:     t.ID,
+		LastUsed:        t.LastUsed,
+	}
+
+	return db.Create(&credential).Error
+}
+
+// decodeLegacyBase64 decodes base64 encoded U2F fields
+// go-u2f writes unpadded websafe base64, older tokens may be padded or use the standard alphabet
+func decodeLegacyBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		return data, nil
+	}
+
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,278 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Webhooks and webhook deliveries
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Webhook external endpoint subscribed to events
+type Webhook struct {
+	gorm.Model
+	URL        string `gorm:"not null"`
+	Secret     string `gorm:"not null"`
+	EventTypes string
+	Enabled    bool `gorm:"not null; default:true"`
+}
+
+// Getters and setters for external interface compliance
+
+// GetID fetches the webhook ID
+func (w *Webhook) GetID() uint { return w.ID }
+
+// GetURL fetches the webhook endpoint URL
+func (w *Webhook) GetURL() string { return w.URL }
+
+// GetSecret fetches the webhook signing secret
+func (w *Webhook) GetSecret() string { return w.Secret }
+
+// GetEventTypes fetches the event types the webhook is subscribed to
+func (w *Webhook) GetEventTypes() []string {
+	if w.EventTypes == "" {
+		return []string{}
+	}
+	return strings.Split(w.EventTypes, ",")
+}
+
+// IsEnabled checks if the webhook is enabled
+func (w *Webhook) IsEnabled() bool { return w.Enabled }
+
+// SetEnabled sets whether the webhook is enabled
+func (w *Webhook) SetEnabled(enabled bool) { w.Enabled = enabled }
+
+// GetCreatedAt fetches the webhook creation time
+func (w *Webhook) GetCreatedAt() time.Time { return w.CreatedAt }
+
+// WebhookDelivery delivery of a single event to a webhook
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID    uint   `gorm:"unique_index:idx_webhook_event"`
+	EventID      string `gorm:"not null;unique_index:idx_webhook_event"`
+	EventType    string `gorm:"not null"`
+	Payload      string `gorm:"type:text"`
+	Status       string `gorm:"index"`
+	Attempts     uint
+	NextAttempt  time.Time `gorm:"index"`
+	LastAttempt  time.Time
+	ResponseCode int
+	LastError    string
+}
+
+// GetID fetches the delivery ID
+func (d *WebhookDelivery) GetID() uint { return d.ID }
+
+// GetWebhookID fetches the ID of the webhook the delivery is for
+func (d *WebhookDelivery) GetWebhookID() uint { return d.WebhookID }
+
+// GetEventID fetches the unique ID of the delivered event
+func (d *WebhookDelivery) GetEventID() string { return d.EventID }
+
+// GetEventType fetches the type of the delivered event
+func (d *WebhookDelivery) GetEventType() string { return d.EventType }
+
+// GetPayload fetches the delivery payload
+func (d *WebhookDelivery) GetPayload() string { return d.Payload }
+
+// GetStatus fetches the delivery status
+func (d *WebhookDelivery) GetStatus() string { return d.Status }
+
+// SetStatus sets the delivery status
+func (d *WebhookDelivery) SetStatus(status string) { d.Status = status }
+
+// GetAttempts fetches the number of delivery attempts
+func (d *WebhookDelivery) GetAttempts() uint { return d.Attempts }
+
+// SetAttempts sets the number of delivery attempts
+func (d *WebhookDelivery) SetAttempts(attempts uint) { d.Attempts = attempts }
+
+// GetNextAttempt fetches the time of the next delivery attempt
+func (d *WebhookDelivery) GetNextAttempt() time.Time { return d.NextAttempt }
+
+// SetNextAttempt sets the time of the next delivery attempt
+func (d *WebhookDelivery) SetNextAttempt(t time.Time) { d.NextAttempt = t }
+
+// GetLastAttempt fetches the time of the last delivery attempt
+func (d *WebhookDelivery) GetLastAttempt() time.Time { return d.LastAttempt }
+
+// SetLastAttempt sets the time of the last delivery attempt
+func (d *WebhookDelivery) SetLastAttempt(t time.Time) { d.LastAttempt = t }
+
+// GetResponseCode fetches the HTTP response code of the last delivery attempt
+func (d *WebhookDelivery) GetResponseCode() int { return d.ResponseCode }
+
+// SetResponseCode sets the HTTP response code of the last delivery attempt
+func (d *WebhookDelivery) SetResponseCode(code int) { d.ResponseCode = code }
+
+// GetLastError fetches the error from the last delivery attempt
+func (d *WebhookDelivery) GetLastError() string { return d.LastError }
+
+// SetLastError sets the error from the last delivery attempt
+func (d *WebhookDelivery) SetLastError(err string) { d.LastError = err }
+
+// GetCreatedAt fetches the delivery creation time
+func (d *WebhookDelivery) GetCreatedAt() time.Time { return d.CreatedAt }
+
+// AddWebhook adds a webhook subscribed to the provided event types
+func (dataStore *DataStore) AddWebhook(url, secret string, eventTypes []string) (interface{}, error) {
+	webhook := Webhook{
+		URL:        url,
+		Secret:     secret,
+		EventTypes: strings.Join(eventTypes, ","),
+		Enabled:    true,
+	}
+
+	err := dataStore.db.Create(&webhook).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// GetWebhook fetches a webhook by ID
+// This returns nil if no webhook is found
+func (dataStore *DataStore) GetWebhook(id uint) (interface{}, error) {
+	var webhook Webhook
+	err := dataStore.db.Where("id = ?", id).First(&webhook).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &webhook, nil
+}
+
+// GetWebhooks fetches all webhooks
+func (dataStore *DataStore) GetWebhooks() ([]interface{}, error) {
+	var webhooks []Webhook
+	err := dataStore.db.Order("created_at asc").Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]interface{}, len(webhooks))
+	for i := range webhooks {
+		interfaces[i] = &webhooks[i]
+	}
+
+	return interfaces, nil
+}
+
+// RemoveWebhook removes a webhook and its delivery log
+func (dataStore *DataStore) RemoveWebhook(id uint) error {
+	err := dataStore.db.Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error
+	if err != nil {
+		return err
+	}
+
+	return dataStore.db.Where("id = ?", id).Delete(&Webhook{}).Error
+}
+
+// AddWebhookDelivery adds a delivery of an event to a webhook
+// Deliveries are identified by webhook and event ID, adding an existing delivery returns the existing record
+func (dataStore *DataStore) AddWebhookDelivery(webhookID uint, eventID, eventType, payload, status string, nextAttempt time.Time) (interface{}, error) {
+	var delivery WebhookDelivery
+	err := dataStore.db.Where(&WebhookDelivery{WebhookID: webhookID, EventID: eventID}).First(&delivery).Error
+	if err == nil {
+		return &delivery, nil
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	delivery = WebhookDelivery{
+		WebhookID:   webhookID,
+		EventID:     eventID,
+		EventType:   eventType,
+		Payload:     payload,
+		Status:      status,
+		NextAttempt: nextAttempt,
+	}
+
+	err = dataStore.db.Create(&delivery).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// GetWebhookDelivery fetches a webhook delivery by ID
+// This returns nil if no delivery is found
+func (dataStore *DataStore) GetWebhookDelivery(id uint) (interface{}, error) {
+	var delivery WebhookDelivery
+	err := dataStore.db.Where("id = ?", id).First(&delivery).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &delivery, nil
+}
+
+// GetWebhookDeliveries fetches the most recent deliveries for a webhook
+func (dataStore *DataStore) GetWebhookDeliveries(webhookID uint, limit uint) ([]interface{}, error) {
+	var deliveries []WebhookDelivery
+	err := dataStore.db.Where("webhook_id = ?", webhookID).Order("created_at desc").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]interface{}, len(deliveries))
+	for i := range deliveries {
+		interfaces[i] = &deliveries[i]
+	}
+
+	return interfaces, nil
+}
+
+// ClaimWebhookDeliveries claims deliveries with the provided status that are due before the provided time
+// Claimed deliveries are not due again until the lease has passed, allowing multiple instances to share deliveries.
+// Deliveries are returned in order of their next attempt
+func (dataStore *DataStore) ClaimWebhookDeliveries(status string, now time.Time, lease time.Duration, limit uint) ([]interface{}, error) {
+	tx := dataStore.begin()
+
+	var deliveries []WebhookDelivery
+	err := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+		Where("status = ? AND next_attempt <= ?", status, now).
+		Order("next_attempt asc").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	interfaces := make([]interface{}, len(deliveries))
+	for i := range deliveries {
+		deliveries[i].NextAttempt = now.Add(lease)
+		err = tx.Save(&deliveries[i]).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		interfaces[i] = &deliveries[i]
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	return interfaces, nil
+}
+
+// UpdateWebhookDelivery updates a webhook delivery instance
+func (dataStore *DataStore) UpdateWebhookDelivery(delivery interface{}) (interface{}, error) {
+	err := dataStore.db.Save(delivery).Error
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
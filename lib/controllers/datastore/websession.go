@@ -0,0 +1,162 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - Server side web sessions
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// WebSession server side web session object
+// Session values are stored encoded, with the session ID held in the session cookie
+type WebSession struct {
+	gorm.Model
+	SessionID  string `gorm:"not null;unique"`
+	Name       string
+	UserExtID  string `gorm:"index"`
+	DeviceID   string
+	RemoteAddr string
+	UserAgent  string
+	Data       string `gorm:"type:text"`
+	LastSeen   time.Time
+	ExpiresAt  time.Time
+}
+
+// Getters and setters for external interface compliance
+
+// GetID fetches the session record ID
+// This is safe to expose via the API, unlike the session ID
+func (s *WebSession) GetID() uint { return s.ID }
+
+// GetSessionID fetches the session ID
+func (s *WebSession) GetSessionID() string { return s.SessionID }
+
+// GetName fetches the session name
+func (s *WebSession) GetName() string { return s.Name }
+
+// GetUserExtID fetches the external ID of the user bound to the session
+func (s *WebSession) GetUserExtID() string { return s.UserExtID }
+
+// SetUserExtID sets the external ID of the user bound to the session
+func (s *WebSession) SetUserExtID(userid string) { s.UserExtID = userid }
+
+// GetDeviceID fetches the device ID bound to the session
+func (s *WebSession) GetDeviceID() string { return s.DeviceID }
+
+// SetDeviceID sets the device ID bound to the session
+func (s *WebSession) SetDeviceID(deviceID string) { s.DeviceID = deviceID }
+
+// GetRemoteAddr fetches the remote address the session was last seen from
+func (s *WebSession) GetRemoteAddr() string { return s.RemoteAddr }
+
+// SetRemoteAddr sets the remote address the session was last seen from
+func (s *WebSession) SetRemoteAddr(addr string) { s.RemoteAddr = addr }
+
+// GetUserAgent fetches the user agent the session was last seen from
+func (s *WebSession) GetUserAgent() string { return s.UserAgent }
+
+// SetUserAgent sets the user agent the session was last seen from
+func (s *WebSession) SetUserAgent(agent string) { s.UserAgent = agent }
+
+// GetData fetches the encoded session values
+func (s *WebSession) GetData() string { return s.Data }
+
+// SetData sets the encoded session values
+func (s *WebSession) SetData(data string) { s.Data = data }
+
+// GetCreatedAt fetches the session creation time
+func (s *WebSession) GetCreatedAt() time.Time { return s.CreatedAt }
+
+// GetLastSeen fetches the time the session was last used
+func (s *WebSession) GetLastSeen() time.Time { return s.LastSeen }
+
+// SetLastSeen sets the time the session was last used
+func (s *WebSession) SetLastSeen(t time.Time) { s.LastSeen = t }
+
+// GetExpiry fetches the session expiry time
+func (s *WebSession) GetExpiry() time.Time { return s.ExpiresAt }
+
+// SetExpiry sets the session expiry time
+func (s *WebSession) SetExpiry(t time.Time) { s.ExpiresAt = t }
+
+// AddWebSession creates a web session with the provided session ID and name
+func (dataStore *DataStore) AddWebSession(sessionID, name string) (interface{}, error) {
+	session := WebSession{
+		SessionID: sessionID,
+		Name:      name,
+		LastSeen:  time.Now(),
+	}
+
+	err := dataStore.db.Create(&session).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetWebSession fetches a web session by session ID
+// This returns nil if no session is found
+func (dataStore *DataStore) GetWebSession(sessionID string) (interface{}, error) {
+	var session WebSession
+	err := dataStore.db.Where(&WebSession{SessionID: sessionID}).First(&session).Error
+	if (err != nil) && (err != gorm.ErrRecordNotFound) {
+		return nil, err
+	} else if (err != nil) && (err == gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return &session, nil
+}
+
+// UpdateWebSession updates a web session instance
+func (dataStore *DataStore) UpdateWebSession(session interface{}) (interface{}, error) {
+	err := dataStore.db.Save(session).Error
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// RemoveWebSession removes a web session by session ID
+func (dataStore *DataStore) RemoveWebSession(sessionID string) error {
+	return dataStore.db.Unscoped().Where("session_id = ?", sessionID).Delete(&WebSession{}).Error
+}
+
+// GetWebSessionsByUserID fetches the current web sessions bound to a user
+func (dataStore *DataStore) GetWebSessionsByUserID(userid string) ([]interface{}, error) {
+	var webSessions []WebSession
+
+	err := dataStore.db.Where("user_ext_id = ? AND expires_at > ?", userid, time.Now()).
+		Order("last_seen desc").Find(&webSessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]interface{}, len(webSessions))
+	for i := range webSessions {
+		interfaces[i] = &webSessions[i]
+	}
+
+	return interfaces, nil
+}
+
+// RemoveWebSessionByID removes a web session bound to a user by record ID
+func (dataStore *DataStore) RemoveWebSessionByID(userid string, id uint) error {
+	return dataStore.db.Unscoped().Where("user_ext_id = ? AND id = ?", userid, id).Delete(&WebSession{}).Error
+}
+
+// RemoveWebSessionsByUserID removes all web sessions bound to a user except the provided session ID
+func (dataStore *DataStore) RemoveWebSessionsByUserID(userid, except string) error {
+	return dataStore.db.Unscoped().Where("user_ext_id = ? AND session_id <> ?", userid, except).Delete(&WebSession{}).Error
+}
+
+// RemoveExpiredWebSessions removes all expired web sessions
+func (dataStore *DataStore) RemoveExpiredWebSessions() error {
+	return dataStore.db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&WebSession{}).Error
+}
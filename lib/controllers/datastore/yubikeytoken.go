@@ -0,0 +1,130 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Datastore - 2fa yubikey otp tokens
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+)
+
+// YubikeyToken Yubico OTP token object
+// The Secret field contains the encrypted AES key and private ID for the token
+type YubikeyToken struct {
+	gorm.Model
+	ExtID          string
+	UserID         uint
+	Name           string
+	PublicID       string `gorm:"not null;unique"`
+	Secret         string
+	UsageCounter   uint
+	SessionCounter uint
+	LastUsed       time.Time
+}
+
+// Getters and setters for external interface compliance
+
+// GetName fetches the token Name
+func (token *YubikeyToken) GetName() string { return token.Name }
+
+// GetExtID fetches the external ID for a token
+func (token *YubikeyToken) GetExtID() string { return token.ExtID }
+
+// GetPublicID fetches the (modhex encoded) token public ID
+func (token *YubikeyToken) GetPublicID() string { return token.PublicID }
+
+// GetSecret fetches the (encrypted) token Secret
+func (token *YubikeyToken) GetSecret() string { return token.Secret }
+
+// GetUsageCounter fetches the token usage (non-volatile) counter
+func (token *YubikeyToken) GetUsageCounter() uint { return token.UsageCounter }
+
+// SetUsageCounter sets the token usage (non-volatile) counter
+func (token *YubikeyToken) SetUsageCounter(count uint) { token.UsageCounter = count }
+
+// GetSessionCounter fetches the token session (volatile) counter
+func (token *YubikeyToken) GetSessionCounter() uint { return token.SessionCounter }
+
+// SetSessionCounter sets the token session (volatile) counter
+func (token *YubikeyToken) SetSessionCounter(count uint) { token.SessionCounter = count }
+
+// GetLastUsed fetches the token LastUsed time
+func (token *YubikeyToken) GetLastUsed() time.Time { return token.LastUsed }
+
+// SetLastUsed sets the token LastUsed time
+func (token *YubikeyToken) SetLastUsed(used time.Time) { token.LastUsed = used }
+
+// AddYubikeyToken adds a yubikey otp token to the provided user
+func (ds *DataStore) AddYubikeyToken(userid, name, publicID, secret string, usageCounter, sessionCounter uint) (interface{}, error) {
+	// Fetch user
+	u, err := ds.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	user := u.(*User)
+	yubikeyToken := YubikeyToken{
+		UserID:         user.ID,
+		ExtID:          uuid.NewV4().String(),
+		Name:           name,
+		PublicID:       publicID,
+		Secret:         secret,
+		UsageCounter:   usageCounter,
+		SessionCounter: sessionCounter,
+		LastUsed:       time.Now(),
+	}
+
+	err = ds.db.Create(&yubikeyToken).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &yubikeyToken, nil
+}
+
+// GetYubikeyTokens fetches yubikey tokens attached to a given user
+func (ds *DataStore) GetYubikeyTokens(userid string) ([]interface{}, error) {
+	var yubikeyTokens []YubikeyToken
+
+	// Fetch user
+	u, err := ds.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	user := u.(*User)
+
+	// Grab tokens
+	err = ds.db.Model(user).Related(&yubikeyTokens).Error
+
+	interfaces := make([]interface{}, len(yubikeyTokens))
+	for i := range yubikeyTokens {
+		interfaces[i] = &yubikeyTokens[i]
+	}
+
+	return interfaces, err
+}
+
+// UpdateYubikeyToken updates a yubikey token instance in the database
+func (ds *DataStore) UpdateYubikeyToken(token interface{}) (interface{}, error) {
+	err := ds.db.Save(token).Error
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// RemoveYubikeyToken deletes a yubikey token
+func (ds *DataStore) RemoveYubikeyToken(token interface{}) error {
+	return ds.db.Unscoped().Delete(token).Error
+}
@@ -33,7 +33,13 @@ type MailController struct {
 }
 
 // Standard mailing templates (required for MailController creation)
-var templateNames = [...]string{"activation", "passwordreset", "passwordchanged", "loginnotice", "unlock"}
+var templateNames = [...]string{"activation", "passwordreset", "passwordchanged", "loginnotice", "unlock", "loginlink", "loginconfirm", "emailotp"}
+
+// Expiry for passwordless login links
+const loginLinkExpiry = 15 * time.Minute
+
+// Expiry for account lock links sent with login notices
+const lockLinkExpiry = 24 * time.Hour
 
 // Config Generic Mail Controller Configuration
 type Config struct {
@@ -128,6 +134,32 @@ func (mc *MailController) SendUnlock(email string, data map[string]string) error
 	return mc.SendTemplate("unlock", email, mc.appName+" Account Activation", data)
 }
 
+// SendLoginLink Send a login link email to the provided address
+func (mc *MailController) SendLoginLink(email string, data map[string]string) error {
+	return mc.SendTemplate("loginlink", email, mc.appName+" Login Link", data)
+}
+
+// SendLoginConfirmation Send a login confirmation link for a flagged login to the provided address
+func (mc *MailController) SendLoginConfirmation(email string, data map[string]string) error {
+	return mc.SendTemplate("loginconfirm", email, mc.appName+" Confirm Login", data)
+}
+
+// SendLoginNotice Send a new device login notice to the provided address
+func (mc *MailController) SendLoginNotice(email string, data map[string]string) error {
+	return mc.SendTemplate("loginnotice", email, mc.appName+" New Login", data)
+}
+
+// SendOneTimeCode Send a second factor one time code email to the provided address
+// Service information is added to the provided template data
+func (mc *MailController) SendOneTimeCode(email string, data map[string]string) error {
+	base := make(map[string]string)
+	base["Domain"] = mc.domain
+	base["ServiceName"] = mc.appName
+	base["Email"] = email
+
+	return mc.SendTemplate("emailotp", email, mc.appName+" Login Code", mergeMaps(base, data))
+}
+
 func mergeMaps(a, b map[string]string) map[string]string {
 	c := make(map[string]string)
 	for i := range a {
@@ -148,12 +180,21 @@ func (mc *MailController) HandleEvent(e interface{}) error {
 	event := e.(*events.AuthPlzEvent)
 
 	// Fetch the user object for further use
+	// System events are not associated with a user and do not result in mail
 	userID := event.GetUserExtID()
+	if userID == "" {
+		return nil
+	}
 	u, err := mc.storer.GetUserByExtID(userID)
 	if err != nil {
 		log.Printf("MailController.HandleEvent error: %s", err)
 		return err
 	}
+	if u == nil {
+		// Accounts may be removed before events are processed (ie. account deletion)
+		log.Printf("MailController.HandleEvent: user %s not found", userID)
+		return nil
+	}
 	user := u.(User)
 
 	// Fill in base data
@@ -164,11 +205,13 @@ func (mc *MailController) HandleEvent(e interface{}) error {
 	data["Username"] = user.GetUsername()
 
 	// Handle types of events
+	// Send errors are returned so that failed events are redelivered
+	var token string
 	err = nil
 	switch event.GetType() {
 	case events.AccountCreated, events.AccountNotActivated:
 		// Account creation or attemped login while not activated causes an activation email to be sent
-		token, err := mc.tokenCreator.BuildToken(userID, api.TokenActionActivate, time.Hour)
+		token, err = mc.tokenCreator.BuildToken(userID, api.TokenActionActivate, time.Hour)
 		if err != nil {
 			log.Printf("MailController.HandleEvent error creating token %s", err)
 			return err
@@ -179,7 +222,7 @@ func (mc *MailController) HandleEvent(e interface{}) error {
 
 	case events.PasswordResetReq:
 		// Password recovery request causes a password recovery email to be sent
-		token, err := mc.tokenCreator.BuildToken(userID, api.TokenActionRecovery, time.Hour)
+		token, err = mc.tokenCreator.BuildToken(userID, api.TokenActionRecovery, time.Hour)
 		if err != nil {
 			log.Printf("MailController.HandleEvent error creating token %s", err)
 			return err
@@ -190,7 +233,7 @@ func (mc *MailController) HandleEvent(e interface{}) error {
 
 	case events.AccountLocked, events.AccountNotUnlocked:
 		// Account lock and attempted login while locked causes unlock email to be sent
-		token, err := mc.tokenCreator.BuildToken(userID, api.TokenActionUnlock, time.Hour)
+		token, err = mc.tokenCreator.BuildToken(userID, api.TokenActionUnlock, time.Hour)
 		if err != nil {
 			log.Printf("MailController.HandleEvent error creating token %s", err)
 			return err
@@ -199,6 +242,39 @@ func (mc *MailController) HandleEvent(e interface{}) error {
 		data["ActionURL"] = mc.actionURL("unlock", token)
 		err = mc.SendUnlock(user.GetEmail(), mergeMaps(data, event.GetData()))
 
+	case events.LoginEmailReq:
+		// Email login request causes a short lived login link to be sent
+		token, err = mc.tokenCreator.BuildToken(userID, api.TokenActionLogin, loginLinkExpiry)
+		if err != nil {
+			log.Printf("MailController.HandleEvent error creating token %s", err)
+			return err
+		}
+		data["Token"] = token
+		data["ActionURL"] = mc.actionURL("login", token)
+		err = mc.SendLoginLink(user.GetEmail(), mergeMaps(data, event.GetData()))
+
+	case events.LoginConfirmReq:
+		// Flagged logins cause a short lived login link to be sent to confirm the login
+		token, err = mc.tokenCreator.BuildToken(userID, api.TokenActionLogin, loginLinkExpiry)
+		if err != nil {
+			log.Printf("MailController.HandleEvent error creating token %s", err)
+			return err
+		}
+		data["Token"] = token
+		data["ActionURL"] = mc.actionURL("login", token)
+		err = mc.SendLoginConfirmation(user.GetEmail(), mergeMaps(data, event.GetData()))
+
+	case events.AccountLoginNewDevice:
+		// Login from a new device causes a login notice to be sent, with a link to lock the account
+		token, err = mc.tokenCreator.BuildToken(userID, api.TokenActionLock, lockLinkExpiry)
+		if err != nil {
+			log.Printf("MailController.HandleEvent error creating token %s", err)
+			return err
+		}
+		data["Token"] = token
+		data["ActionURL"] = mc.actionURL("lock", token)
+		err = mc.SendLoginNotice(user.GetEmail(), mergeMaps(data, event.GetData()))
+
 	case events.PasswordUpdate:
 		// Password update notice email
 		err = mc.SendPasswordChanged(user.GetEmail(), mergeMaps(data, event.GetData()))
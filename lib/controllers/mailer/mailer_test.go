@@ -135,4 +135,71 @@ func TestMailController(t *testing.T) {
 		assert.EqualValues(t, driver.Subject, fmt.Sprintf("%s Password Reset", mc.appName))
 	})
 
+	t.Run("Handles LoginEmailReq event", func(t *testing.T) {
+		e := events.AuthPlzEvent{
+			UserExtID: "test-id",
+			Time:      time.Now(),
+			Type:      events.LoginEmailReq,
+			Data:      make(map[string]string),
+		}
+
+		err := mc.HandleEvent(&e)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, driver.Subject, fmt.Sprintf("%s Login Link", mc.appName))
+		assert.Contains(t, driver.Body, "test-id:login:15m0s")
+	})
+
+	t.Run("Handles LoginConfirmReq event", func(t *testing.T) {
+		data := make(map[string]string)
+		data["remote-address"] = "127.0.0.1"
+		data["country"] = "NZ"
+
+		e := events.AuthPlzEvent{
+			UserExtID: "test-id",
+			Time:      time.Now(),
+			Type:      events.LoginConfirmReq,
+			Data:      data,
+		}
+
+		err := mc.HandleEvent(&e)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, driver.Subject, fmt.Sprintf("%s Confirm Login", mc.appName))
+		assert.Contains(t, driver.Body, "test-id:login:15m0s")
+		assert.Contains(t, driver.Body, "NZ")
+	})
+
+	t.Run("Handles AccountLoginNewDevice event", func(t *testing.T) {
+		data := make(map[string]string)
+		data["RemoteAddress"] = "127.0.0.1"
+		data["UserAgent"] = "test-agent"
+
+		e := events.AuthPlzEvent{
+			UserExtID: "test-id",
+			Time:      time.Now(),
+			Type:      events.AccountLoginNewDevice,
+			Data:      data,
+		}
+
+		err := mc.HandleEvent(&e)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, driver.Subject, fmt.Sprintf("%s New Login", mc.appName))
+		assert.Contains(t, driver.Body, "test-id:lock:24h0m0s")
+		assert.Contains(t, driver.Body, "test-agent")
+	})
+
+	t.Run("Can send one time code emails", func(t *testing.T) {
+		data := make(map[string]string)
+		data["Username"] = "TestUser"
+		data["Code"] = "12345678"
+		data["Expiry"] = "10 minutes"
+
+		err := mc.SendOneTimeCode(testAddress, data)
+		assert.Nil(t, err)
+		assert.EqualValues(t, driver.Subject, fmt.Sprintf("%s Login Code", mc.appName))
+		assert.Contains(t, driver.Body, "12345678")
+	})
+
 }
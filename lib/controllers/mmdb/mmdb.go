@@ -0,0 +1,149 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * MaxMind format (.mmdb) GeoIP database reader
+ * This provides offline location and ASN lookups for IP addresses
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package mmdb
+
+import (
+	"log"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Reader GeoIP database reader instance
+type Reader struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Location result of a GeoIP lookup
+type Location struct {
+	Country         string
+	City            string
+	ASN             uint
+	ASNOrganization string
+	Latitude        float64
+	Longitude       float64
+}
+
+// Getters for external interface compliance
+
+// GetCountry fetches the ISO country code of the location
+func (l *Location) GetCountry() string { return l.Country }
+
+// GetCity fetches the (english) city name of the location
+func (l *Location) GetCity() string { return l.City }
+
+// GetASN fetches the autonomous system number of the network
+func (l *Location) GetASN() uint { return l.ASN }
+
+// GetASNOrganization fetches the organisation associated with the autonomous system
+func (l *Location) GetASNOrganization() string { return l.ASNOrganization }
+
+// GetLatitude fetches the approximate latitude of the location
+func (l *Location) GetLatitude() float64 { return l.Latitude }
+
+// GetLongitude fetches the approximate longitude of the location
+func (l *Location) GetLongitude() float64 { return l.Longitude }
+
+// record is the subset of the GeoIP2 / GeoLite2 City and ASN database formats used by the reader
+// ASN fields are read from both the top level (ASN databases) and traits (Enterprise databases)
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Traits struct {
+		ASN             uint   `maxminddb:"autonomous_system_number"`
+		ASNOrganization string `maxminddb:"autonomous_system_organization"`
+	} `maxminddb:"traits"`
+	ASN             uint   `maxminddb:"autonomous_system_number"`
+	ASNOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// NewReader opens the provided city database, and optional ASN database where ASN information
+// is not included in the city database
+func NewReader(cityPath, asnPath string) (*Reader, error) {
+	city, err := maxminddb.Open(cityPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := Reader{city: city}
+
+	if asnPath != "" {
+		r.asn, err = maxminddb.Open(asnPath)
+		if err != nil {
+			city.Close()
+			return nil, err
+		}
+	}
+
+	log.Printf("GeoIP: loaded database %s (%s)", cityPath, city.Metadata.DatabaseType)
+
+	return &r, nil
+}
+
+// Lookup fetches the location of the provided IP address
+// This returns nil if the address is invalid or not found in the database
+func (r *Reader) Lookup(address string) (interface{}, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, nil
+	}
+
+	var rec record
+	err := r.city.Lookup(ip, &rec)
+	if err != nil {
+		return nil, err
+	}
+
+	l := Location{
+		Country:         rec.Country.ISOCode,
+		City:            rec.City.Names["en"],
+		Latitude:        rec.Location.Latitude,
+		Longitude:       rec.Location.Longitude,
+		ASN:             rec.ASN,
+		ASNOrganization: rec.ASNOrganization,
+	}
+	if rec.Traits.ASN != 0 {
+		l.ASN = rec.Traits.ASN
+		l.ASNOrganization = rec.Traits.ASNOrganization
+	}
+
+	if r.asn != nil {
+		var asnRec record
+		err = r.asn.Lookup(ip, &asnRec)
+		if err != nil {
+			return nil, err
+		}
+		if asnRec.ASN != 0 {
+			l.ASN = asnRec.ASN
+			l.ASNOrganization = asnRec.ASNOrganization
+		}
+	}
+
+	if l.Country == "" && l.ASN == 0 {
+		return nil, nil
+	}
+
+	return &l, nil
+}
+
+// Close closes the underlying database files
+func (r *Reader) Close() error {
+	if r.asn != nil {
+		r.asn.Close()
+	}
+	return r.city.Close()
+}
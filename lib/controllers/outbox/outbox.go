@@ -0,0 +1,431 @@
+/*
+ * Event outbox
+ * This persists events prior to delivery, and delivers them to each bound consumer at least once.
+ * Failed deliveries are retried with exponential back-off, and marked as dead once attempts are exhausted.
+ * Instances sharing the outbox deliver each event to a consumer once across all instances, while broadcast
+ * consumers are passed events from all instances for propagating instance local state.
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package outbox
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+const (
+	// StatusPending deliveries are awaiting a (re)attempt
+	StatusPending = "pending"
+	// StatusDelivered deliveries have been handled by the consumer
+	StatusDelivered = "delivered"
+	// StatusDead deliveries have exhausted their attempts and will not be retried
+	StatusDead = "dead"
+
+	// Deliveries claimed per consumer per processing run
+	deliveryBatch = 100
+	// Interval between removals of completed events
+	pruneInterval = time.Hour
+	// Period prior to the last broadcast scan included in the next scan, allowing for events committed
+	// out of order and clock skew between instances
+	broadcastSettle = 30 * time.Second
+)
+
+// Outbox event outbox instance
+type Outbox struct {
+	config         config.OutboxConfig
+	store          Storer
+	notifier       Notifier
+	consumers      map[string]Consumer
+	names          []string
+	broadcast      map[string]Consumer
+	broadcastNames []string
+	mutex          sync.Mutex
+	started        time.Time
+	cursor         time.Time
+	seen           map[uint]time.Time
+	lastPrune      time.Time
+	notify         chan struct{}
+	done           chan struct{}
+	once           sync.Once
+	wg             sync.WaitGroup
+}
+
+// NewOutbox creates a new event outbox
+func NewOutbox(c config.OutboxConfig, store Storer) (*Outbox, error) {
+	if c.MaxAttempts == 0 {
+		return nil, fmt.Errorf("outbox: max-attempts required")
+	}
+	if c.BaseDelay == 0 || c.PollInterval == 0 || c.Lease == 0 {
+		return nil, fmt.Errorf("outbox: base-delay, poll-interval and lease required")
+	}
+
+	now := time.Now()
+
+	return &Outbox{
+		config:         c,
+		store:          store,
+		consumers:      make(map[string]Consumer),
+		names:          make([]string, 0),
+		broadcast:      make(map[string]Consumer),
+		broadcastNames: make([]string, 0),
+		started:        now,
+		cursor:         now,
+		seen:           make(map[uint]time.Time),
+		lastPrune:      now,
+		notify:         make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}, nil
+}
+
+// BindConsumer binds a named consumer to the outbox
+// Consumer names identify deliveries in the datastore, so must be stable across restarts.
+// Consumers must be bound prior to events being sent.
+func (o *Outbox) BindConsumer(name string, consumer Consumer) {
+	o.consumers[name] = consumer
+	o.names = append(o.names, name)
+}
+
+// BindBroadcastConsumer binds a named broadcast consumer to the outbox
+// Broadcast consumers are passed events sent by all instances sharing the outbox (including this one) for
+// updating instance local state, ie. caches. Events are passed on a best effort basis and are not retried,
+// and events sent prior to the outbox being created are not passed to broadcast consumers.
+func (o *Outbox) BindBroadcastConsumer(name string, consumer Consumer) {
+	o.broadcast[name] = consumer
+	o.broadcastNames = append(o.broadcastNames, name)
+}
+
+// BindNotifier binds a notifier used to wake instances sharing the outbox when events are sent
+// The notifier must be bound prior to the outbox being started.
+func (o *Outbox) BindNotifier(notifier Notifier) {
+	o.notifier = notifier
+}
+
+// SendEvent persists an event for delivery to all bound consumers
+// This implements the events.Emitter interface. Events are persisted in their own transaction, so are not
+// persisted with changes made prior to sending the event; use Transaction to commit events with the changes
+// that caused them. Events that cannot be persisted are logged and dropped.
+func (o *Outbox) SendEvent(event interface{}) {
+	eventID, err := o.addEvent(o.store, event)
+	if err != nil {
+		log.Printf("Outbox.SendEvent: error persisting event, event dropped (%s)", err)
+		return
+	}
+
+	o.sent(eventID)
+}
+
+// Transaction runs the provided function with a transaction scoped store and emitter
+// This implements the events.Transactor interface. Events sent with the emitter are persisted in the same
+// transaction as changes made using the store, and the transaction is rolled back if the function returns an
+// error or an event cannot be persisted. Instances are notified of events once the transaction is committed.
+func (o *Outbox) Transaction(fn func(store interface{}, emitter events.Emitter) error) error {
+	var emitter *txEmitter
+
+	err := o.store.Transaction(func(store interface{}) error {
+		emitter = &txEmitter{outbox: o, store: store.(Storer)}
+
+		err := fn(store, emitter)
+		if err != nil {
+			return err
+		}
+
+		return emitter.err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, eventID := range emitter.eventIDs {
+		o.sent(eventID)
+	}
+
+	return nil
+}
+
+// txEmitter emitter persisting events with a transaction scoped store
+type txEmitter struct {
+	outbox   *Outbox
+	store    Storer
+	eventIDs []string
+	err      error
+}
+
+// SendEvent persists an event within the transaction
+// Errors are returned from the enclosing Transaction call, causing the transaction to be rolled back
+func (e *txEmitter) SendEvent(event interface{}) {
+	if e.err != nil {
+		return
+	}
+
+	eventID, err := e.outbox.addEvent(e.store, event)
+	if err != nil {
+		log.Printf("Outbox.txEmitter.SendEvent: error persisting event (%s)", err)
+		e.err = err
+		return
+	}
+
+	e.eventIDs = append(e.eventIDs, eventID)
+}
+
+// addEvent persists an event with a delivery for each bound consumer using the provided store
+func (o *Outbox) addEvent(store Storer, event interface{}) (string, error) {
+	e, ok := event.(Event)
+	if !ok {
+		return "", fmt.Errorf("outbox: unsupported event type %T", event)
+	}
+
+	eventID := e.GetID()
+	if eventID == "" {
+		eventID = uuid.NewV4().String()
+	}
+
+	_, err := store.AddOutboxEvent(eventID, e.GetUserExtID(), e.GetType(), e.GetTime(), e.GetData(), o.names, StatusPending)
+	if err != nil {
+		return "", fmt.Errorf("outbox: error persisting event %s (%s)", e.GetType(), err)
+	}
+
+	return eventID, nil
+}
+
+// sent notifies instances sharing the outbox of a persisted event and wakes the delivery worker
+func (o *Outbox) sent(eventID string) {
+	if o.notifier != nil {
+		err := o.notifier.Notify(eventID)
+		if err != nil {
+			log.Printf("Outbox.SendEvent: error notifying instances of event %s (%s)", eventID, err)
+		}
+	}
+
+	o.trigger()
+}
+
+// dispatchBroadcast passes an event to all broadcast consumers
+func (o *Outbox) dispatchBroadcast(event *events.AuthPlzEvent) {
+	for _, name := range o.broadcastNames {
+		err := o.broadcast[name].HandleEvent(event)
+		if err != nil {
+			log.Printf("Outbox.dispatchBroadcast: consumer %s error handling event %s (%s)", name, event.GetType(), err)
+		}
+	}
+}
+
+// Start starts the delivery worker
+// If a notifier is bound, the worker is also woken by notifications from instances sharing the outbox
+func (o *Outbox) Start() {
+	if o.notifier != nil {
+		notifications, err := o.notifier.Listen()
+		if err != nil {
+			log.Printf("Outbox.Start: error listening for notifications, polling only (%s)", err)
+		} else {
+			o.wg.Add(1)
+			go func() {
+				defer o.wg.Done()
+
+				for {
+					select {
+					case <-o.done:
+						return
+					case _, ok := <-notifications:
+						if !ok {
+							return
+						}
+						o.trigger()
+					}
+				}
+			}()
+		}
+	}
+
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+
+		ticker := time.NewTicker(o.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-o.notify:
+			case <-ticker.C:
+			}
+			o.ProcessEvents()
+		}
+	}()
+}
+
+// Stop stops the delivery worker
+func (o *Outbox) Stop() {
+	o.once.Do(func() { close(o.done) })
+	o.wg.Wait()
+}
+
+// trigger wakes the delivery worker without blocking
+func (o *Outbox) trigger() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ProcessEvents delivers all pending events that are due to each consumer, and passes events sent since
+// the last call to broadcast consumers
+func (o *Outbox) ProcessEvents() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for _, name := range o.names {
+		deliveries, err := o.store.ClaimOutboxDeliveries(name, StatusPending, time.Now(), o.config.Lease, deliveryBatch)
+		if err != nil {
+			log.Printf("Outbox.ProcessEvents: error claiming deliveries for consumer %s (%s)", name, err)
+			return err
+		}
+
+		for _, d := range deliveries {
+			o.deliver(o.consumers[name], d.(Delivery))
+		}
+	}
+
+	if len(o.broadcastNames) > 0 {
+		err := o.broadcastEvents()
+		if err != nil {
+			log.Printf("Outbox.ProcessEvents: error fetching events for broadcast (%s)", err)
+			return err
+		}
+	}
+
+	// Remove completed events following the retention period
+	if o.config.Retention != 0 && time.Since(o.lastPrune) > pruneInterval {
+		o.lastPrune = time.Now()
+		err := o.store.RemoveOutboxEvents(time.Now().Add(-o.config.Retention), StatusPending)
+		if err != nil {
+			log.Printf("Outbox.ProcessEvents: error removing completed events (%s)", err)
+		}
+	}
+
+	return nil
+}
+
+// broadcastEvents passes events sent since the last scan to broadcast consumers
+// Scans include events from broadcastSettle prior to the last scan, with handled events tracked to avoid
+// passing events to consumers more than once.
+func (o *Outbox) broadcastEvents() error {
+	now := time.Now()
+
+	records, err := o.store.GetOutboxEventsSince(o.cursor.Add(-broadcastSettle))
+	if err != nil {
+		return err
+	}
+
+	for _, i := range records {
+		r := i.(Record)
+		if _, ok := o.seen[r.GetID()]; ok {
+			continue
+		}
+		o.seen[r.GetID()] = r.GetCreatedAt()
+
+		if r.GetCreatedAt().Before(o.started) {
+			continue
+		}
+
+		data, err := r.GetData()
+		if err != nil {
+			log.Printf("Outbox.broadcastEvents: error decoding event %s (%s)", r.GetEventID(), err)
+			continue
+		}
+
+		o.dispatchBroadcast(toEvent(r.GetEventID(), r.GetUserExtID(), r.GetType(), r.GetTime(), data))
+	}
+
+	o.cursor = now
+
+	// Forget events that can no longer be returned by a scan
+	for id, createdAt := range o.seen {
+		if createdAt.Before(o.cursor.Add(-broadcastSettle)) {
+			delete(o.seen, id)
+		}
+	}
+
+	return nil
+}
+
+// deliver passes a single delivery to a consumer, updating the delivery state with the outcome
+func (o *Outbox) deliver(consumer Consumer, delivery Delivery) {
+	r, err := o.store.GetOutboxEvent(delivery.GetOutboxEventID())
+	if err != nil {
+		log.Printf("Outbox.deliver: error fetching event %d (%s)", delivery.GetOutboxEventID(), err)
+		return
+	}
+
+	attempts := delivery.GetAttempts() + 1
+	delivery.SetAttempts(attempts)
+
+	if r == nil {
+		delivery.SetStatus(StatusDead)
+		delivery.SetLastError("event not found")
+	} else {
+		err = o.handle(consumer, r.(Record))
+		if err == nil {
+			delivery.SetStatus(StatusDelivered)
+			delivery.SetLastError("")
+		} else if attempts >= o.config.MaxAttempts {
+			log.Printf("Outbox.deliver: delivery %d to consumer %s failed after %d attempts (%s)",
+				delivery.GetID(), delivery.GetConsumer(), attempts, err)
+			delivery.SetStatus(StatusDead)
+			delivery.SetLastError(err.Error())
+		} else {
+			delivery.SetNextAttempt(time.Now().Add(o.backoff(attempts)))
+			delivery.SetLastError(err.Error())
+		}
+	}
+
+	_, err = o.store.UpdateOutboxDelivery(delivery)
+	if err != nil {
+		log.Printf("Outbox.deliver: error updating delivery %d (%s)", delivery.GetID(), err)
+	}
+}
+
+// handle passes a stored event to a consumer
+func (o *Outbox) handle(consumer Consumer, record Record) error {
+	data, err := record.GetData()
+	if err != nil {
+		return err
+	}
+
+	return consumer.HandleEvent(toEvent(record.GetEventID(), record.GetUserExtID(), record.GetType(), record.GetTime(), data))
+}
+
+// backoff calculates the delay before the next attempt following the provided number of attempts
+// Delays double with each attempt up to the configured maximum
+func (o *Outbox) backoff(attempts uint) time.Duration {
+	delay := o.config.BaseDelay
+	for i := uint(1); i < attempts && (o.config.MaxDelay == 0 || delay < o.config.MaxDelay); i++ {
+		delay *= 2
+	}
+	if o.config.MaxDelay != 0 && delay > o.config.MaxDelay {
+		delay = o.config.MaxDelay
+	}
+	return delay
+}
+
+// toEvent builds the event passed to consumers
+func toEvent(eventID, userExtID, eventType string, eventTime time.Time, data map[string]string) *events.AuthPlzEvent {
+	return &events.AuthPlzEvent{
+		ID:        eventID,
+		UserExtID: userExtID,
+		Time:      eventTime,
+		Type:      eventType,
+		Data:      data,
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Event outbox interfaces
+ * This defines the interfaces required to use the event outbox
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package outbox
+
+import (
+	"time"
+)
+
+// Event interface for events sent to the outbox
+type Event interface {
+	GetID() string
+	GetUserExtID() string
+	GetType() string
+	GetTime() time.Time
+	GetData() map[string]string
+}
+
+// Consumer interface for outbox event consumers
+// Consumers are called with *events.AuthPlzEvent instances, and may be called more than once for the same
+// event (identified by event ID). Returning an error causes the event to be redelivered.
+type Consumer interface {
+	HandleEvent(event interface{}) error
+}
+
+// Notifier interface for notifying instances sharing the outbox of new events
+// This allows instances to process events without waiting for the poll interval
+type Notifier interface {
+	// Notify all listening instances (including this one)
+	Notify(payload string) error
+	// Listen for notifications, the returned channel is closed when the notifier is closed
+	Listen() (<-chan string, error)
+}
+
+// Record stored outbox event interface
+// Storer event objects must implement this interface
+type Record interface {
+	GetID() uint
+	GetCreatedAt() time.Time
+	GetEventID() string
+	GetUserExtID() string
+	GetType() string
+	GetTime() time.Time
+	GetData() (map[string]string, error)
+}
+
+// Delivery stored outbox delivery interface
+// Storer delivery objects must implement this interface
+type Delivery interface {
+	GetID() uint
+	GetOutboxEventID() uint
+	GetConsumer() string
+	GetStatus() string
+	SetStatus(status string)
+	GetAttempts() uint
+	SetAttempts(attempts uint)
+	GetNextAttempt() time.Time
+	SetNextAttempt(t time.Time)
+	GetLastError() string
+	SetLastError(err string)
+}
+
+// Storer Outbox event store interface
+// This must be implemented by a storage module to provide persistence to the outbox
+type Storer interface {
+	// Persist an event with a delivery for each consumer
+	AddOutboxEvent(eventID, userExtID, eventType string, eventTime time.Time, data map[string]string, consumers []string, status string) (interface{}, error)
+	// Fetch an event by record ID
+	GetOutboxEvent(id uint) (interface{}, error)
+	// Fetch events created since the provided time
+	GetOutboxEventsSince(since time.Time) ([]interface{}, error)
+	// Claim due deliveries for a consumer
+	ClaimOutboxDeliveries(consumer, status string, now time.Time, lease time.Duration, limit uint) ([]interface{}, error)
+	// Update a provided delivery
+	UpdateOutboxDelivery(delivery interface{}) (interface{}, error)
+	// Remove old events without active deliveries
+	RemoveOutboxEvents(before time.Time, active string) error
+	// Run a function with a store scoped to a single transaction
+	Transaction(fn func(store interface{}) error) error
+}
@@ -0,0 +1,632 @@
+/*
+ * Event outbox tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+// mockStore in memory outbox store
+type mockStore struct {
+	mutex      sync.Mutex
+	events     map[uint]*datastore.OutboxEvent
+	deliveries map[uint]*datastore.OutboxDelivery
+	nextID     uint
+}
+
+func newMockStore() *mockStore {
+	return &mockStore{
+		events:     make(map[uint]*datastore.OutboxEvent),
+		deliveries: make(map[uint]*datastore.OutboxDelivery),
+	}
+}
+
+func (s *mockStore) AddOutboxEvent(eventID, userExtID, eventType string, eventTime time.Time, data map[string]string, consumers []string, status string) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, e := range s.events {
+		if e.EventID == eventID {
+			return e, nil
+		}
+	}
+
+	encoded, _ := json.Marshal(data)
+
+	s.nextID++
+	e := &datastore.OutboxEvent{EventID: eventID, UserExtID: userExtID, Type: eventType, Time: eventTime, Data: string(encoded)}
+	e.ID = s.nextID
+	e.CreatedAt = time.Now()
+	s.events[e.ID] = e
+
+	for _, c := range consumers {
+		s.nextID++
+		d := &datastore.OutboxDelivery{OutboxEventID: e.ID, Consumer: c, Status: status, NextAttempt: time.Now()}
+		d.ID = s.nextID
+		s.deliveries[d.ID] = d
+	}
+
+	return e, nil
+}
+
+func (s *mockStore) GetOutboxEvent(id uint) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.events[id]
+	if !ok {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (s *mockStore) GetOutboxEventsSince(since time.Time) ([]interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	created := make([]*datastore.OutboxEvent, 0)
+	for _, e := range s.events {
+		if e.CreatedAt.After(since) {
+			created = append(created, e)
+		}
+	}
+	sort.Slice(created, func(i, j int) bool { return created[i].ID < created[j].ID })
+
+	events := make([]interface{}, len(created))
+	for i := range created {
+		events[i] = created[i]
+	}
+	return events, nil
+}
+
+func (s *mockStore) ClaimOutboxDeliveries(consumer, status string, now time.Time, lease time.Duration, limit uint) ([]interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	due := make([]*datastore.OutboxDelivery, 0)
+	for _, d := range s.deliveries {
+		if d.Consumer == consumer && d.Status == status && !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+
+	deliveries := make([]interface{}, len(due))
+	for i := range due {
+		due[i].NextAttempt = now.Add(lease)
+		deliveries[i] = due[i]
+	}
+	return deliveries, nil
+}
+
+func (s *mockStore) UpdateOutboxDelivery(delivery interface{}) (interface{}, error) {
+	return delivery, nil
+}
+
+func (s *mockStore) RemoveOutboxEvents(before time.Time, active string) error {
+	return nil
+}
+
+// Transaction restores events and deliveries if the provided function fails
+func (s *mockStore) Transaction(fn func(store interface{}) error) error {
+	s.mutex.Lock()
+	events, deliveries := make(map[uint]*datastore.OutboxEvent), make(map[uint]*datastore.OutboxDelivery)
+	for k, v := range s.events {
+		events[k] = v
+	}
+	for k, v := range s.deliveries {
+		deliveries[k] = v
+	}
+	s.mutex.Unlock()
+
+	err := fn(s)
+	if err != nil {
+		s.mutex.Lock()
+		s.events, s.deliveries = events, deliveries
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+func (s *mockStore) deliveriesFor(consumer string) []*datastore.OutboxDelivery {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deliveries := make([]*datastore.OutboxDelivery, 0)
+	for _, d := range s.deliveries {
+		if d.Consumer == consumer {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries
+}
+
+// mockConsumer records handled events, failing while err is set
+type mockConsumer struct {
+	events []*events.AuthPlzEvent
+	err    error
+}
+
+func (c *mockConsumer) HandleEvent(event interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.events = append(c.events, event.(*events.AuthPlzEvent))
+	return nil
+}
+
+// mockNotifier in memory notifier shared between outbox instances
+type mockNotifier struct {
+	mutex     sync.Mutex
+	listeners []chan string
+}
+
+func (n *mockNotifier) Notify(payload string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, l := range n.listeners {
+		select {
+		case l <- payload:
+		default:
+		}
+	}
+	return nil
+}
+
+func (n *mockNotifier) Listen() (<-chan string, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	l := make(chan string, 16)
+	n.listeners = append(n.listeners, l)
+	return l, nil
+}
+
+// chanConsumer passes handled events to a channel
+type chanConsumer chan *events.AuthPlzEvent
+
+func (c chanConsumer) HandleEvent(event interface{}) error {
+	c <- event.(*events.AuthPlzEvent)
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	store := newMockStore()
+
+	c := config.DefaultOutboxConfig()
+	c.MaxAttempts = 3
+
+	o, err := NewOutbox(c, store)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	mailer, audit := &mockConsumer{}, &mockConsumer{}
+	o.BindConsumer("mailer", mailer)
+	o.BindConsumer("audit", audit)
+
+	t.Run("Events are delivered to all consumers", func(t *testing.T) {
+		e := events.NewEvent("fake-user", events.AccountCreated, map[string]string{"remote-address": "10.1.2.3"})
+		e.ID = "event-1"
+		o.SendEvent(e)
+
+		err := o.ProcessEvents()
+		if err != nil {
+			t.Error(err)
+		}
+
+		for _, consumer := range []*mockConsumer{mailer, audit} {
+			if len(consumer.events) != 1 {
+				t.Errorf("Expected 1 event, received %d", len(consumer.events))
+				t.FailNow()
+			}
+			received := consumer.events[0]
+			if received.ID != "event-1" || received.Type != events.AccountCreated || received.Data["remote-address"] != "10.1.2.3" {
+				t.Errorf("Unexpected event %+v", received)
+			}
+		}
+
+		if d := store.deliveriesFor("mailer")[0]; d.Status != StatusDelivered || d.Attempts != 1 {
+			t.Errorf("Unexpected delivery state %+v", d)
+		}
+	})
+
+	t.Run("Events are only persisted once per event ID", func(t *testing.T) {
+		e := events.NewEvent("fake-user", events.AccountCreated, events.NewData())
+		e.ID = "event-1"
+		o.SendEvent(e)
+		o.ProcessEvents()
+
+		if len(mailer.events) != 1 {
+			t.Errorf("Duplicate event delivered")
+		}
+	})
+
+	t.Run("Failed deliveries are retried without affecting other consumers", func(t *testing.T) {
+		mailer.err = errors.New("mail driver error")
+
+		e := events.NewEvent("fake-user", events.PasswordResetReq, events.NewData())
+		e.ID = "event-2"
+		o.SendEvent(e)
+		o.ProcessEvents()
+
+		if len(audit.events) != 2 {
+			t.Errorf("Event not delivered to healthy consumer")
+		}
+
+		d := store.deliveriesFor("mailer")[1]
+		if d.Status != StatusPending || d.Attempts != 1 || d.LastError != "mail driver error" {
+			t.Errorf("Unexpected failed delivery state %+v", d)
+		}
+		if delay := time.Until(d.NextAttempt); delay > c.BaseDelay || delay < c.BaseDelay-time.Second {
+			t.Errorf("Unexpected retry delay %s", delay)
+		}
+
+		// Retries are not attempted before they are due
+		o.ProcessEvents()
+		if d.Attempts != 1 {
+			t.Errorf("Retry attempted before due")
+		}
+
+		// Retries succeed once the consumer recovers
+		mailer.err = nil
+		d.NextAttempt = time.Now().Add(-time.Second)
+		o.ProcessEvents()
+
+		if d.Status != StatusDelivered || d.Attempts != 2 {
+			t.Errorf("Unexpected retried delivery state %+v", d)
+		}
+		if len(mailer.events) != 2 || mailer.events[1].ID != "event-2" {
+			t.Errorf("Retried event not delivered")
+		}
+	})
+
+	t.Run("Deliveries are marked dead once attempts are exhausted", func(t *testing.T) {
+		mailer.err = errors.New("mail driver error")
+
+		e := events.NewEvent("fake-user", events.AccountLocked, events.NewData())
+		e.ID = "event-3"
+		o.SendEvent(e)
+
+		d := store.deliveriesFor("mailer")[2]
+		for i := uint(0); i < c.MaxAttempts+1; i++ {
+			d.NextAttempt = time.Now().Add(-time.Second)
+			o.ProcessEvents()
+		}
+
+		if d.Status != StatusDead || d.Attempts != c.MaxAttempts {
+			t.Errorf("Unexpected dead delivery state %+v", d)
+		}
+		mailer.err = nil
+	})
+
+	t.Run("Events sent within transactions are committed with the transaction", func(t *testing.T) {
+		err := o.Transaction(func(store interface{}, emitter events.Emitter) error {
+			e := events.NewEvent("fake-user", events.PasswordUpdate, events.NewData())
+			e.ID = "event-tx-1"
+			emitter.SendEvent(e)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = o.Transaction(func(store interface{}, emitter events.Emitter) error {
+			e := events.NewEvent("fake-user", events.PasswordUpdate, events.NewData())
+			e.ID = "event-tx-2"
+			emitter.SendEvent(e)
+			return errors.New("state change failed")
+		})
+		if err == nil {
+			t.Errorf("Expected transaction error")
+		}
+
+		o.ProcessEvents()
+
+		received := make(map[string]bool)
+		for _, e := range audit.events {
+			received[e.ID] = true
+		}
+		if !received["event-tx-1"] {
+			t.Errorf("Committed event not delivered")
+		}
+		if received["event-tx-2"] {
+			t.Errorf("Rolled back event delivered")
+		}
+	})
+
+	t.Run("Retry delays are capped", func(t *testing.T) {
+		if o.backoff(1) != c.BaseDelay {
+			t.Errorf("Unexpected initial delay %s", o.backoff(1))
+		}
+		if o.backoff(64) != c.MaxDelay {
+			t.Errorf("Unexpected capped delay %s", o.backoff(64))
+		}
+	})
+}
+
+func TestOutboxCluster(t *testing.T) {
+	// Instances share a single store as instances share a single database
+	store := newMockStore()
+
+	c := config.DefaultOutboxConfig()
+
+	a, err := NewOutbox(c, store)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	b, err := NewOutbox(c, store)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	auditA, auditB := &mockConsumer{}, &mockConsumer{}
+	a.BindConsumer("audit", auditA)
+	b.BindConsumer("audit", auditB)
+
+	cacheA, cacheB := &mockConsumer{}, &mockConsumer{}
+	a.BindBroadcastConsumer("cache", cacheA)
+	b.BindBroadcastConsumer("cache", cacheB)
+
+	t.Run("Events are delivered once across instances", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			e := events.NewEvent("fake-user", events.AccountCreated, events.NewData())
+			e.ID = fmt.Sprintf("event-a-%d", i)
+			a.SendEvent(e)
+		}
+		e := events.NewEvent("fake-user", events.PasswordUpdate, events.NewData())
+		e.ID = "event-b-0"
+		b.SendEvent(e)
+
+		b.ProcessEvents()
+		a.ProcessEvents()
+		b.ProcessEvents()
+
+		received := make(map[string]int)
+		for _, e := range append(auditA.events, auditB.events...) {
+			received[e.ID]++
+		}
+		if len(received) != 4 {
+			t.Errorf("Expected 4 events, received %d", len(received))
+		}
+		for id, count := range received {
+			if count != 1 {
+				t.Errorf("Event %s delivered %d times", id, count)
+			}
+		}
+	})
+
+	t.Run("Events are broadcast to all instances once", func(t *testing.T) {
+		a.ProcessEvents()
+		b.ProcessEvents()
+
+		for _, consumer := range []*mockConsumer{cacheA, cacheB} {
+			if len(consumer.events) != 4 {
+				t.Errorf("Expected 4 broadcast events, received %d", len(consumer.events))
+				t.FailNow()
+			}
+			if consumer.events[0].ID != "event-a-0" || consumer.events[3].ID != "event-b-0" {
+				t.Errorf("Unexpected broadcast event order")
+			}
+		}
+	})
+
+	t.Run("Events sent prior to creation are not broadcast", func(t *testing.T) {
+		n, _ := NewOutbox(c, store)
+		cache := &mockConsumer{}
+		n.BindBroadcastConsumer("cache", cache)
+
+		n.ProcessEvents()
+		if len(cache.events) != 0 {
+			t.Errorf("Unexpected broadcast of %d events", len(cache.events))
+		}
+	})
+
+	t.Run("Notifications wake instances", func(t *testing.T) {
+		c.PollInterval = time.Hour
+		notifier := &mockNotifier{}
+
+		n1, _ := NewOutbox(c, store)
+		n2, _ := NewOutbox(c, store)
+
+		cache1, cache2 := make(chanConsumer, 1), make(chanConsumer, 1)
+		n1.BindBroadcastConsumer("cache", cache1)
+		n2.BindBroadcastConsumer("cache", cache2)
+
+		n1.BindNotifier(notifier)
+		n2.BindNotifier(notifier)
+		n1.Start()
+		n2.Start()
+		defer n1.Stop()
+		defer n2.Stop()
+
+		e := events.NewEvent("fake-user", events.AccountLocked, events.NewData())
+		e.ID = "event-notify"
+		n1.SendEvent(e)
+
+		for _, cache := range []chanConsumer{cache1, cache2} {
+			select {
+			case received := <-cache:
+				if received.ID != "event-notify" {
+					t.Errorf("Unexpected broadcast event %+v", received)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("Timeout waiting for broadcast event")
+			}
+		}
+	})
+}
+
+func TestOutboxDatastore(t *testing.T) {
+	// Instances use separate connections to the test database, as instances sharing a database do
+	c, _ := config.DefaultConfig()
+
+	dsA, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer dsA.Close()
+
+	dsA.ForceSync()
+
+	dsB, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer dsB.Close()
+
+	oc := config.DefaultOutboxConfig()
+	oc.PollInterval = time.Hour
+
+	t.Run("Deliveries locked by another instance are skipped", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			_, err := dsA.AddOutboxEvent(fmt.Sprintf("event-locked-%d", i), "fake-user", events.AccountCreated, time.Now(), nil, []string{"locked"}, StatusPending)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+		}
+
+		err := dsA.Transaction(func(store interface{}) error {
+			claimedA, err := store.(Storer).ClaimOutboxDeliveries("locked", StatusPending, time.Now(), oc.Lease, 2)
+			if err != nil {
+				return err
+			}
+
+			// Deliveries claimed by the open transaction are locked, so are skipped rather than blocking
+			claimedB, err := dsB.ClaimOutboxDeliveries("locked", StatusPending, time.Now(), oc.Lease, 4)
+			if err != nil {
+				return err
+			}
+
+			if len(claimedA) != 2 || len(claimedB) != 2 {
+				t.Errorf("Expected 2 deliveries claimed by each instance, claimed %d and %d", len(claimedA), len(claimedB))
+			}
+			for _, a := range claimedA {
+				for _, b := range claimedB {
+					if a.(Delivery).GetID() == b.(Delivery).GetID() {
+						t.Errorf("Delivery %d claimed by both instances", a.(Delivery).GetID())
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Events are delivered once across concurrent instances", func(t *testing.T) {
+		a, _ := NewOutbox(oc, dsA)
+		b, _ := NewOutbox(oc, dsB)
+
+		audit := make(chanConsumer, 64)
+		a.BindConsumer("audit", audit)
+		b.BindConsumer("audit", audit)
+
+		for i := 0; i < 20; i++ {
+			e := events.NewEvent("fake-user", events.AccountCreated, events.NewData())
+			e.ID = fmt.Sprintf("event-concurrent-%d", i)
+			a.SendEvent(e)
+		}
+
+		var wg sync.WaitGroup
+		for _, o := range []*Outbox{a, b, a, b} {
+			wg.Add(1)
+			go func(o *Outbox) {
+				defer wg.Done()
+				o.ProcessEvents()
+			}(o)
+		}
+		wg.Wait()
+		close(audit)
+
+		received := make(map[string]int)
+		for e := range audit {
+			received[e.ID]++
+		}
+		if len(received) != 20 {
+			t.Errorf("Expected 20 events, received %d", len(received))
+		}
+		for id, count := range received {
+			if count != 1 {
+				t.Errorf("Event %s delivered %d times", id, count)
+			}
+		}
+	})
+
+	t.Run("Postgres notifications wake instances", func(t *testing.T) {
+		a, _ := NewOutbox(oc, dsA)
+		b, _ := NewOutbox(oc, dsB)
+
+		audit := make(chanConsumer, 2)
+		a.BindConsumer("audit-notify", audit)
+		b.BindConsumer("audit-notify", audit)
+
+		cacheA, cacheB := make(chanConsumer, 1), make(chanConsumer, 1)
+		a.BindBroadcastConsumer("cache", cacheA)
+		b.BindBroadcastConsumer("cache", cacheB)
+
+		notifierA, notifierB := dsA.NewNotifier("authplz_test_events"), dsB.NewNotifier("authplz_test_events")
+		defer notifierA.Close()
+		defer notifierB.Close()
+
+		a.BindNotifier(notifierA)
+		b.BindNotifier(notifierB)
+		a.Start()
+		b.Start()
+		defer a.Stop()
+		defer b.Stop()
+
+		e := events.NewEvent("fake-user", events.AccountLocked, events.NewData())
+		e.ID = "event-pq-notify"
+		a.SendEvent(e)
+
+		for _, cache := range []chanConsumer{cacheA, cacheB} {
+			select {
+			case received := <-cache:
+				if received.ID != "event-pq-notify" {
+					t.Errorf("Unexpected broadcast event %+v", received)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("Timeout waiting for broadcast event")
+			}
+		}
+
+		select {
+		case received := <-audit:
+			if received.ID != "event-pq-notify" {
+				t.Errorf("Unexpected delivered event %+v", received)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Timeout waiting for delivered event")
+		}
+		select {
+		case received := <-audit:
+			t.Errorf("Event %s delivered more than once", received.ID)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
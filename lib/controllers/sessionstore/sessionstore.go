@@ -0,0 +1,266 @@
+/*
+ * Session store
+ * This implements a server side gorilla/sessions Store, allowing web sessions to be listed and revoked
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package sessionstore
+
+import (
+	"encoding/base32"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// DefaultMaxAge is the default session lifetime in seconds
+	DefaultMaxAge = 60 * 60 * 24 * 30
+	// PurgeInterval is the minimum interval between removal of expired sessions
+	PurgeInterval = time.Hour
+
+	// Maximum length of encoded session data
+	maxDataLength = 16 * 1024
+
+	// Session value keys used to attribute sessions, these match the keys used by appcontext
+	userIDKey   = "userId"
+	deviceIDKey = "device-id"
+)
+
+// ErrSessionRevoked returned when saving a session that was revoked during the request
+var ErrSessionRevoked = errors.New("sessionstore: session revoked")
+
+// Store is a server side session store
+// Session values are encoded and held in the datastore, with only the (signed) session ID sent to the client.
+// Sessions are attributed to the bound user and device, and session IDs are rotated when the bound user changes.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+
+	store Storer
+
+	purgeLock sync.Mutex
+	lastPurge time.Time
+}
+
+// NewStore creates a new server side session store
+// keyPairs are used to sign (and optionally encrypt) session IDs and data as in sessions.NewCookieStore
+func NewStore(store Storer, keyPairs ...[]byte) *Store {
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: DefaultMaxAge,
+		},
+		store: store,
+	}
+
+	s.MaxAge(s.Options.MaxAge)
+
+	for _, c := range s.Codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.MaxLength(maxDataLength)
+		}
+	}
+
+	return s
+}
+
+// Get returns a session for the given name after adding it to the registry
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry
+// Revoked or expired sessions are replaced with a new session
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		session.ID = ""
+		return session, err
+	}
+
+	ok, err := s.load(session)
+	if err != nil || !ok {
+		session.ID = ""
+		return session, err
+	}
+
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save persists a session and writes the session cookie to the response
+// Sessions with a negative MaxAge are removed
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			err := s.store.RemoveWebSession(session.ID)
+			if err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	err := s.save(r, session)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+
+	s.purge()
+
+	return nil
+}
+
+// MaxAge sets the maximum age for the store and the underlying codecs
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+
+	for _, c := range s.Codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.MaxAge(age)
+		}
+	}
+}
+
+// load fetches and decodes stored session values
+// This returns false if the session was not found or has expired
+func (s *Store) load(session *sessions.Session) (bool, error) {
+	ws, err := s.store.GetWebSession(session.ID)
+	if err != nil {
+		return false, err
+	}
+	if ws == nil {
+		return false, nil
+	}
+	webSession := ws.(WebSession)
+
+	if time.Now().After(webSession.GetExpiry()) {
+		return false, s.store.RemoveWebSession(session.ID)
+	}
+
+	err = securecookie.DecodeMulti(session.Name(), webSession.GetData(), &session.Values, s.Codecs...)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// save encodes and stores session values along with session attribution information
+func (s *Store) save(r *http.Request, session *sessions.Session) error {
+	userID, _ := session.Values[userIDKey].(string)
+	deviceID, _ := session.Values[deviceIDKey].(string)
+
+	var webSession WebSession
+
+	if session.ID != "" {
+		ws, err := s.store.GetWebSession(session.ID)
+		if err != nil {
+			return err
+		}
+		// Do not resurrect sessions revoked while the request was in progress
+		if ws == nil {
+			log.Printf("SessionStore.save: session %s revoked", session.Name())
+			return ErrSessionRevoked
+		}
+		webSession = ws.(WebSession)
+
+		// Rotate session IDs when the bound user changes to prevent session fixation
+		if webSession.GetUserExtID() != userID {
+			err = s.store.RemoveWebSession(session.ID)
+			if err != nil {
+				return err
+			}
+			session.ID = ""
+			webSession = nil
+		}
+	}
+
+	if webSession == nil {
+		session.ID = generateSessionID()
+
+		ws, err := s.store.AddWebSession(session.ID, session.Name())
+		if err != nil {
+			return err
+		}
+		webSession = ws.(WebSession)
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = s.Options.MaxAge
+	}
+
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	webSession.SetData(encoded)
+	webSession.SetUserExtID(userID)
+	webSession.SetDeviceID(deviceID)
+	webSession.SetRemoteAddr(remoteAddr)
+	webSession.SetUserAgent(r.UserAgent())
+	webSession.SetLastSeen(time.Now())
+	webSession.SetExpiry(time.Now().Add(time.Duration(maxAge) * time.Second))
+
+	_, err = s.store.UpdateWebSession(webSession)
+
+	return err
+}
+
+// purge removes expired sessions at most once per PurgeInterval
+func (s *Store) purge() {
+	s.purgeLock.Lock()
+	defer s.purgeLock.Unlock()
+
+	if time.Since(s.lastPurge) < PurgeInterval {
+		return
+	}
+	s.lastPurge = time.Now()
+
+	err := s.store.RemoveExpiredWebSessions()
+	if err != nil {
+		log.Printf("SessionStore.purge: error removing expired sessions (%s)", err)
+	}
+}
+
+// generateSessionID creates a random session ID
+func generateSessionID() string {
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+}
@@ -0,0 +1,36 @@
+/*
+ * Session store interfaces
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package sessionstore
+
+import (
+	"time"
+)
+
+// WebSession defines the interface required for stored web sessions
+type WebSession interface {
+	GetSessionID() string
+	GetUserExtID() string
+	SetUserExtID(userid string)
+	SetDeviceID(deviceID string)
+	SetRemoteAddr(addr string)
+	SetUserAgent(agent string)
+	GetData() string
+	SetData(data string)
+	SetLastSeen(t time.Time)
+	GetExpiry() time.Time
+	SetExpiry(t time.Time)
+}
+
+// Storer defines the backing storage required by the session store
+type Storer interface {
+	AddWebSession(sessionID, name string) (interface{}, error)
+	GetWebSession(sessionID string) (interface{}, error)
+	UpdateWebSession(session interface{}) (interface{}, error)
+	RemoveWebSession(sessionID string) error
+	RemoveExpiredWebSessions() error
+}
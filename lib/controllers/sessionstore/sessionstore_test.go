@@ -0,0 +1,184 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+)
+
+type FakeWebSessionStore struct {
+	sessions map[string]*datastore.WebSession
+}
+
+func NewFakeWebSessionStore() *FakeWebSessionStore {
+	return &FakeWebSessionStore{
+		sessions: make(map[string]*datastore.WebSession),
+	}
+}
+
+func (f *FakeWebSessionStore) AddWebSession(sessionID, name string) (interface{}, error) {
+	s := datastore.WebSession{
+		SessionID: sessionID,
+		Name:      name,
+	}
+	f.sessions[sessionID] = &s
+	return &s, nil
+}
+
+func (f *FakeWebSessionStore) GetWebSession(sessionID string) (interface{}, error) {
+	s, ok := f.sessions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func (f *FakeWebSessionStore) UpdateWebSession(session interface{}) (interface{}, error) {
+	s := session.(*datastore.WebSession)
+	f.sessions[s.SessionID] = s
+	return s, nil
+}
+
+func (f *FakeWebSessionStore) RemoveWebSession(sessionID string) error {
+	delete(f.sessions, sessionID)
+	return nil
+}
+
+func (f *FakeWebSessionStore) RemoveExpiredWebSessions() error {
+	for id, s := range f.sessions {
+		if time.Now().After(s.ExpiresAt) {
+			delete(f.sessions, id)
+		}
+	}
+	return nil
+}
+
+// request builds a request carrying the cookies from a previous response
+func request(prev *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:4321"
+	req.Header.Set("User-Agent", "test-agent")
+	if prev != nil {
+		for _, c := range prev.Result().Cookies() {
+			req.AddCookie(c)
+		}
+	}
+	return req
+}
+
+func TestSessionStore(t *testing.T) {
+	fakeStore := NewFakeWebSessionStore()
+	store := NewStore(fakeStore, []byte("abcDEF123"))
+
+	var rw *httptest.ResponseRecorder
+	var sessionID string
+
+	t.Run("Creates and persists sessions", func(t *testing.T) {
+		req := request(nil)
+		session, err := store.New(req, "user-session")
+		assert.Nil(t, err)
+		assert.True(t, session.IsNew)
+
+		session.Values[deviceIDKey] = "device-1"
+
+		rw = httptest.NewRecorder()
+		err = store.Save(req, rw, session)
+		assert.Nil(t, err)
+		assert.NotEqual(t, "", session.ID)
+		sessionID = session.ID
+
+		s, ok := fakeStore.sessions[session.ID]
+		assert.True(t, ok)
+		assert.EqualValues(t, "device-1", s.DeviceID)
+		assert.EqualValues(t, "10.0.0.1", s.RemoteAddr)
+		assert.EqualValues(t, "test-agent", s.UserAgent)
+		assert.True(t, s.ExpiresAt.After(time.Now()))
+
+		// Session values are not sent to the client
+		assert.Len(t, rw.Result().Cookies(), 1)
+		assert.NotContains(t, rw.Result().Cookies()[0].Value, "device-1")
+	})
+
+	t.Run("Loads existing sessions", func(t *testing.T) {
+		session, err := store.New(request(rw), "user-session")
+		assert.Nil(t, err)
+		assert.False(t, session.IsNew)
+		assert.EqualValues(t, sessionID, session.ID)
+		assert.EqualValues(t, "device-1", session.Values[deviceIDKey])
+	})
+
+	t.Run("Rotates session IDs on login", func(t *testing.T) {
+		req := request(rw)
+		session, err := store.New(req, "user-session")
+		assert.Nil(t, err)
+
+		session.Values[userIDKey] = "user-1"
+
+		rw = httptest.NewRecorder()
+		err = store.Save(req, rw, session)
+		assert.Nil(t, err)
+		assert.NotEqual(t, sessionID, session.ID)
+
+		_, ok := fakeStore.sessions[sessionID]
+		assert.False(t, ok)
+
+		sessionID = session.ID
+		assert.EqualValues(t, "user-1", fakeStore.sessions[sessionID].UserExtID)
+		assert.EqualValues(t, "device-1", fakeStore.sessions[sessionID].DeviceID)
+	})
+
+	t.Run("Revoked sessions are not restored", func(t *testing.T) {
+		req := request(rw)
+		session, err := store.New(req, "user-session")
+		assert.Nil(t, err)
+		assert.EqualValues(t, "user-1", session.Values[userIDKey])
+
+		// Revoke session while the request is in progress
+		fakeStore.RemoveWebSession(sessionID)
+
+		err = store.Save(req, httptest.NewRecorder(), session)
+		assert.EqualValues(t, ErrSessionRevoked, err)
+		assert.Len(t, fakeStore.sessions, 0)
+
+		session, err = store.New(request(rw), "user-session")
+		assert.Nil(t, err)
+		assert.True(t, session.IsNew)
+		assert.Nil(t, session.Values[userIDKey])
+	})
+
+	t.Run("Expired sessions are not restored", func(t *testing.T) {
+		req := request(nil)
+		session, _ := store.New(req, "user-session")
+		rw = httptest.NewRecorder()
+		err := store.Save(req, rw, session)
+		assert.Nil(t, err)
+
+		fakeStore.sessions[session.ID].ExpiresAt = time.Now().Add(-time.Minute)
+
+		session, err = store.New(request(rw), "user-session")
+		assert.Nil(t, err)
+		assert.True(t, session.IsNew)
+		assert.Len(t, fakeStore.sessions, 0)
+	})
+
+	t.Run("Sessions are removed with negative MaxAge", func(t *testing.T) {
+		req := request(nil)
+		session, _ := store.New(req, "user-session")
+		rw = httptest.NewRecorder()
+		err := store.Save(req, rw, session)
+		assert.Nil(t, err)
+		assert.Len(t, fakeStore.sessions, 1)
+
+		req = request(rw)
+		session, _ = store.New(req, "user-session")
+		session.Options.MaxAge = -1
+		err = store.Save(req, httptest.NewRecorder(), session)
+		assert.Nil(t, err)
+		assert.Len(t, fakeStore.sessions, 0)
+	})
+}
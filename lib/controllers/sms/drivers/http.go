@@ -0,0 +1,88 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * HTTP SMS driver
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package drivers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	HTTPDriverID = "http"
+
+	httpDriverTimeout = 10 * time.Second
+)
+
+// HTTPDriver is an SMS driver that posts messages to a generic HTTP gateway
+// Messages are posted as JSON objects containing from, to and body fields
+type HTTPDriver struct {
+	url    string
+	from   string
+	token  string
+	client *http.Client
+}
+
+// HTTPMessage is the message object posted to the gateway
+type HTTPMessage struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+// NewHTTPDriver creates a new HTTP driver instance
+func NewHTTPDriver(options map[string]string) (*HTTPDriver, error) {
+	url, ok := options["url"]
+	if !ok || url == "" {
+		return nil, fmt.Errorf("HTTPDriver options requires a 'url' argument")
+	}
+	from, ok := options["from"]
+	if !ok || from == "" {
+		return nil, fmt.Errorf("HTTPDriver options requires a 'from' argument")
+	}
+
+	return &HTTPDriver{
+		url:    url,
+		from:   from,
+		token:  options["token"],
+		client: &http.Client{Timeout: httpDriverTimeout},
+	}, nil
+}
+
+// Send posts a message to the gateway
+func (sd *HTTPDriver) Send(number, body string) error {
+	data, err := json.Marshal(HTTPMessage{From: sd.from, To: number, Body: body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", sd.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sd.token != "" {
+		req.Header.Set("Authorization", "Bearer "+sd.token)
+	}
+
+	resp, err := sd.client.Do(req)
+	if err != nil {
+		log.Printf("HTTPDriver.Send error: %s", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("HTTPDriver.Send gateway error: %s", resp.Status)
+		return fmt.Errorf("HTTPDriver.Send gateway returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * HTTP SMS driver tests
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+package drivers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPDriver(t *testing.T) {
+
+	var received HTTPMessage
+	var auth string
+	status := http.StatusOK
+
+	// Create local gateway stub
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		json.NewDecoder(req.Body).Decode(&received)
+		rw.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	options := make(map[string]string)
+	options["url"] = ts.URL
+	options["from"] = "AuthPlz"
+	options["token"] = "test-token"
+
+	d, err := NewHTTPDriver(options)
+	assert.Nil(t, err)
+
+	t.Run("Requires url and from options", func(t *testing.T) {
+		_, err := NewHTTPDriver(map[string]string{"from": "AuthPlz"})
+		assert.NotNil(t, err)
+
+		_, err = NewHTTPDriver(map[string]string{"url": ts.URL})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Can send messages", func(t *testing.T) {
+		err := d.Send("+64210000000", "test body")
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "AuthPlz", received.From)
+		assert.EqualValues(t, "+64210000000", received.To)
+		assert.EqualValues(t, "test body", received.Body)
+		assert.EqualValues(t, "Bearer test-token", auth)
+	})
+
+	t.Run("Returns gateway errors", func(t *testing.T) {
+		status = http.StatusBadGateway
+
+		err := d.Send("+64210000000", "test body")
+		assert.NotNil(t, err)
+	})
+
+}
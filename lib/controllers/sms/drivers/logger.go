@@ -0,0 +1,34 @@
+/* AuthPlz Authentication and Authorization Microservice
+ * Logging SMS driver
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package drivers
+
+import (
+	"log"
+)
+
+const (
+	LoggerDriverID = "logger"
+)
+
+// LoggerDriver is an SMS driver that writes messages to logs (for development use only)
+type LoggerDriver struct {
+	options map[string]string
+}
+
+// NewLoggerDriver creates a new logger driver instance
+func NewLoggerDriver(options map[string]string) (*LoggerDriver, error) {
+	return &LoggerDriver{options}, nil
+}
+
+// Send writes a message to the console
+func (sd *LoggerDriver) Send(number, body string) error {
+	if m, ok := sd.options["mode"]; ok && m == "silent" {
+		return nil
+	}
+	log.Printf("SMS.LoggerDriver Send To: %s\n%s", number, body)
+	return nil
+}
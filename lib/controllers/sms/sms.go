@@ -0,0 +1,111 @@
+/*
+ * SMS module controller
+ * This manages SMS message sending
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package sms
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+
+	"github.com/authplz/authplz-core/lib/controllers/sms/drivers"
+)
+
+// SMSController SMS controller instance
+type SMSController struct {
+	appName   string
+	templates map[string]*template.Template
+	driver    SMSDriver
+	options   map[string]string
+}
+
+// Standard message templates
+// SMS messages are short enough that these are built in rather than loaded from the template directory
+var messageTemplates = map[string]string{
+	"onetimecode": "{{.Code}} is your {{.ServiceName}} verification code. It expires in {{.Expiry}}.",
+}
+
+// NewSMSController Creates an SMS controller
+func NewSMSController(appName, driver string, options map[string]string) (*SMSController, error) {
+
+	// Load driver
+	var d SMSDriver
+	var err error
+	switch driver {
+	case drivers.HTTPDriverID:
+		d, err = drivers.NewHTTPDriver(options)
+	case drivers.LoggerDriverID:
+		d, err = drivers.NewLoggerDriver(options)
+	default:
+		return nil, fmt.Errorf("NewSMSController error: unrecognised driver %s", driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse message templates
+	var templates = make(map[string]*template.Template)
+	for name, text := range messageTemplates {
+		tpl, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = tpl
+	}
+
+	return &SMSController{
+		appName:   appName,
+		templates: templates,
+		driver:    d,
+		options:   options,
+	}, nil
+}
+
+// SendSMS Send a message to the provided phone number
+func (sc *SMSController) SendSMS(number, body string) error {
+	return sc.driver.Send(number, body)
+}
+
+// SendTemplate fills and sends a template based message
+func (sc *SMSController) SendTemplate(template, number string, data map[string]string) error {
+	buf := new(bytes.Buffer)
+	tmpl, ok := sc.templates[template]
+	if !ok {
+		return fmt.Errorf("template %s not found", template)
+	}
+	err := tmpl.Execute(buf, data)
+	if err != nil {
+		return err
+	}
+
+	return sc.SendSMS(number, buf.String())
+}
+
+// SendOneTimeCode Send a one time code message to the provided phone number
+func (sc *SMSController) SendOneTimeCode(number string, data map[string]string) error {
+	base := make(map[string]string)
+	base["ServiceName"] = sc.appName
+
+	err := sc.SendTemplate("onetimecode", number, mergeMaps(base, data))
+
+	log.Printf("SMSController send one time code error %s", err)
+
+	return err
+}
+
+func mergeMaps(a, b map[string]string) map[string]string {
+	c := make(map[string]string)
+	for i := range a {
+		c[i] = a[i]
+	}
+	for i := range b {
+		c[i] = b[i]
+	}
+	return c
+}
@@ -0,0 +1,13 @@
+/*
+ * SMS module controller
+ * This manages SMS message sending
+ *
+ * Copyright 2018 Ryan Kurte
+ */
+
+package sms
+
+// SMSDriver defines the interface that must be implemented by a concrete SMS driver
+type SMSDriver interface {
+	Send(number, body string) error
+}
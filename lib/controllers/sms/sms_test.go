@@ -0,0 +1,66 @@
+/*
+ * SMS module controller
+ * This manages SMS message sending
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package sms
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type FakeDriver struct {
+	To   string
+	Body string
+}
+
+func (fd *FakeDriver) Send(to, body string) error {
+	fd.To = to
+	fd.Body = body
+	return nil
+}
+
+func TestSMSController(t *testing.T) {
+
+	var sc *SMSController
+
+	testNumber := "+64210000000"
+
+	driver := FakeDriver{}
+
+	// Run tests
+	t.Run("Create sms controller", func(t *testing.T) {
+		lsc, err := NewSMSController("AuthPlz Test", "logger", make(map[string]string))
+		assert.Nil(t, err)
+
+		lsc.driver = &driver
+		sc = lsc
+	})
+
+	t.Run("Rejects unknown drivers", func(t *testing.T) {
+		_, err := NewSMSController("AuthPlz Test", "not-a-driver", make(map[string]string))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Can send messages", func(t *testing.T) {
+		err := sc.SendSMS(testNumber, "test body")
+		assert.Nil(t, err)
+		assert.EqualValues(t, testNumber, driver.To)
+	})
+
+	t.Run("Can send one time codes", func(t *testing.T) {
+		data := make(map[string]string)
+		data["Code"] = "123456"
+		data["Expiry"] = "10 minutes"
+
+		err := sc.SendOneTimeCode(testNumber, data)
+		assert.Nil(t, err)
+		assert.EqualValues(t, "123456 is your AuthPlz Test verification code. It expires in 10 minutes.", driver.Body)
+	})
+
+}
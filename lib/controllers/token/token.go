@@ -142,6 +142,18 @@ func (tc *TokenController) ValidateToken(userID, tokenString string) (*api.Token
 	return &claims.Action, nil
 }
 
+// GetTokenUser fetches the user ID a token was issued to
+// This does not check the backing store, tokens must still be validated with ValidateToken prior to use
+func (tc *TokenController) GetTokenUser(tokenString string) (string, error) {
+	claims, err := tc.parseToken(tokenString)
+	if err != nil {
+		log.Printf("TokenController.GetTokenUser: Invalid or expired token (%s)", err)
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
 // SetUsed marks a token as used in the backing datastore
 func (tc *TokenController) SetUsed(tokenString string) error {
 	// Parse and validate
@@ -10,6 +10,8 @@ package events
 
 import (
 	"time"
+
+	"github.com/satori/go.uuid"
 )
 
 // EventType wraps strings for type safety
@@ -23,12 +25,14 @@ const (
 	AccountLocked       string = "account_locked"
 	AccountUnlocked     string = "account_unlocked"
 	AccountNotUnlocked  string = "account_not_unlocked"
+	AccountLockExpired  string = "account_lock_expired"
 	AccountEnabled      string = "account_enabled"
 	AccountDisabled     string = "account_disabled"
 	AccountNotEnabled   string = "account_not_enabled"
 	AccountDeleted      string = "account_deleted"
 	PasswordUpdate      string = "password_update"
 	PasswordResetReq    string = "password_reset_request"
+	PhoneVerified       string = "phone_verified"
 )
 
 // 2FA Events
@@ -39,9 +43,21 @@ const (
 	SecondFactorU2FAdded           string = "u2f_added"
 	SecondFactorU2FUsed            string = "u2f_used"
 	SecondFactorU2FRemoved         string = "u2f_removed"
+	SecondFactorWebAuthnAdded      string = "webauthn_added"
+	SecondFactorWebAuthnUsed       string = "webauthn_used"
+	SecondFactorWebAuthnRemoved    string = "webauthn_removed"
+	SecondFactorYubikeyAdded       string = "yubikey_added"
+	SecondFactorYubikeyUsed        string = "yubikey_used"
+	SecondFactorYubikeyRemoved     string = "yubikey_removed"
+	SecondFactorEmailOTPAdded      string = "emailotp_added"
+	SecondFactorEmailOTPUsed       string = "emailotp_used"
+	SecondFactorEmailOTPRemoved    string = "emailotp_removed"
+	SecondFactorSMSUsed            string = "sms_used"
+	SecondFactorSMSRemoved         string = "sms_removed"
 	SecondFactorBackupCodesAdded   string = "backup_code_added"
 	SecondFactorBackupCodesUsed    string = "backup_code_used"
 	SecondFactorBackupCodesRemoved string = "backup_code_removed"
+	SecondFactorCompleted          string = "2fa_completed"
 )
 
 // Login Events
@@ -49,7 +65,22 @@ const (
 	LoginSuccess          string = "login_success"
 	LoginFailure          string = "login_failure"
 	AccountLoginNewDevice string = "login_new_device"
+	LoginEmailReq         string = "login_email_request"
+	LoginConfirmReq       string = "login_confirm_request"
+	LoginRiskFlagged      string = "login_risk_flagged"
+	LoginRiskDecision     string = "login_risk_decision"
 	Logout                string = "logout"
+	SessionRevoked        string = "session_revoked"
+	SessionsRevoked       string = "sessions_revoked"
+	DeviceTrusted         string = "device_trusted"
+	DeviceRevoked         string = "device_revoked"
+	DevicesRevoked        string = "devices_revoked"
+)
+
+// System Events
+// These are not associated with a user account, and are recorded in the audit log for admins
+const (
+	CredentialStuffingDetected string = "credential_stuffing_detected"
 )
 
 // OAuth Events
@@ -61,13 +92,18 @@ const (
 )
 
 // AuthPlzEvent event type for asynchronous communication
+// Event IDs are unique and allow consumers to detect redelivered events
 type AuthPlzEvent struct {
+	ID        string
 	UserExtID string
 	Time      time.Time
 	Type      string
 	Data      map[string]string
 }
 
+// GetID fetches the unique event ID
+func (e *AuthPlzEvent) GetID() string { return e.ID }
+
 // GetType fetches the event type
 func (e *AuthPlzEvent) GetType() string { return e.Type }
 
@@ -82,7 +118,13 @@ func (e *AuthPlzEvent) GetData() map[string]string { return e.Data }
 
 // NewEvent Create a new AuthPlz event
 func NewEvent(userExtID, eventType string, data map[string]string) *AuthPlzEvent {
-	return &AuthPlzEvent{userExtID, time.Now(), eventType, data}
+	return &AuthPlzEvent{
+		ID:        uuid.NewV4().String(),
+		UserExtID: userExtID,
+		Time:      time.Now(),
+		Type:      eventType,
+		Data:      data,
+	}
 }
 
 // NewData creates a new blank data object
@@ -90,7 +132,33 @@ func NewData() map[string]string {
 	return make(map[string]string)
 }
 
+// NewDataWithMeta creates a new data object containing the provided request metadata
+// (remote address, forwarded-for, user agent and request ID), for attaching the origin of an action to events
+func NewDataWithMeta(meta map[string]string) map[string]string {
+	data := make(map[string]string)
+	for k, v := range meta {
+		data[k] = v
+	}
+	return data
+}
+
 // Emitter interface for event producers
 type Emitter interface {
 	SendEvent(interface{})
 }
+
+// Transactor interface for emitters supporting transactions
+// Functions are called with a transaction scoped store and emitter, events sent with the emitter are
+// committed in the same transaction as changes made with the store
+type Transactor interface {
+	Transaction(fn func(store interface{}, emitter Emitter) error) error
+}
+
+// Transaction runs the provided function in a transaction where the emitter is a Transactor, otherwise
+// the function is called with the provided store and emitter
+func Transaction(emitter Emitter, store interface{}, fn func(store interface{}, emitter Emitter) error) error {
+	if t, ok := emitter.(Transactor); ok {
+		return t.Transaction(fn)
+	}
+	return fn(store, emitter)
+}
@@ -36,16 +36,21 @@ type Controller struct {
 	issuerName  string
 	backupStore Storer
 	emitter     events.Emitter
+
+	completedHandler CompletedHandler
 }
 
 // NewController creates a new backup code controller
 // Backup tokens are issued with an associated issuer name to assist with user identification of codes.
-// A Storer provides underlying storage to the backup code module
-func NewController(issuerName string, backupStore Storer, emitter events.Emitter) *Controller {
+// A CompletedHandler is required for completion of authorization actions, and a Storer provides
+// underlying storage to the backup code module
+func NewController(issuerName string, backupStore Storer, completedHandler CompletedHandler, emitter events.Emitter) *Controller {
 	return &Controller{
 		issuerName:  issuerName,
 		backupStore: backupStore,
 		emitter:     emitter,
+
+		completedHandler: completedHandler,
 	}
 }
 
@@ -116,7 +121,7 @@ type CreateResponse struct {
 }
 
 // CreateCodes creates a set of backup codes for a user
-func (bc *Controller) CreateCodes(userid string) (*CreateResponse, error) {
+func (bc *Controller) CreateCodes(userid string, meta map[string]string) (*CreateResponse, error) {
 	keys := make([]BackupKey, NumRecoveryKeys)
 
 	// Generate backup keys
@@ -139,7 +144,7 @@ func (bc *Controller) CreateCodes(userid string) (*CreateResponse, error) {
 
 	resp := CreateResponse{bc.issuerName, keys}
 
-	data := make(map[string]string)
+	data := events.NewDataWithMeta(meta)
 	bc.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorBackupCodesAdded, data))
 
 	return &resp, nil
@@ -192,7 +197,7 @@ func (bc *Controller) ValidateName(userid string, name string) (bool, error) {
 }
 
 // ValidateCode validates a backup code use and marks the code as used
-func (bc *Controller) ValidateCode(userid string, codeString string) (bool, error) {
+func (bc *Controller) ValidateCode(userid string, codeString string, meta map[string]string) (bool, error) {
 
 	// Split codeString into words
 	phrase := strings.Split(codeString, " ")
@@ -243,7 +248,7 @@ func (bc *Controller) ValidateCode(userid string, codeString string) (bool, erro
 		return false, err
 	}
 
-	data := make(map[string]string)
+	data := events.NewDataWithMeta(meta)
 	data["Code Name"] = code.GetName()
 	bc.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorBackupCodesUsed, data))
 
@@ -282,9 +287,9 @@ func (bc *Controller) ListCodes(userid string) ([]BackupCode, error) {
 }
 
 // ClearPendingTokens deletes pending backup tokens
-func (bc *Controller) ClearPendingTokens(userid string) error {
+func (bc *Controller) ClearPendingTokens(userid string, meta map[string]string) error {
 	err := bc.backupStore.ClearPendingBackupTokens(userid)
-	data := make(map[string]string)
+	data := events.NewDataWithMeta(meta)
 	bc.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorBackupCodesRemoved, data))
 	return err
 }
@@ -47,10 +47,14 @@ func (backupCodeModule *Controller) BindAPI(router *web.Router) {
 	backupCodeRouter.Middleware(bindBackupCodeContext(backupCodeModule))
 
 	// Bind endpoints
-	backupCodeRouter.Get("/create", (*backupCodeAPICtx).CreateTokens)
 	backupCodeRouter.Post("/authenticate", (*backupCodeAPICtx).AuthenticatePost)
 	backupCodeRouter.Get("/codes", (*backupCodeAPICtx).ListTokens)
-	backupCodeRouter.Get("/clear", (*backupCodeAPICtx).RemoveTokens)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := backupCodeRouter.Subrouter(backupCodeAPICtx{}, "")
+	sudoRouter.Middleware((*backupCodeAPICtx).RequireSudoMiddleware)
+	sudoRouter.Get("/create", (*backupCodeAPICtx).CreateTokens)
+	sudoRouter.Get("/clear", (*backupCodeAPICtx).RemoveTokens)
 }
 
 // CreateTokens creates a set of backup codes and returns them to the user
@@ -71,7 +75,7 @@ func (c *backupCodeAPICtx) CreateTokens(rw web.ResponseWriter, req *web.Request)
 		return
 	} else if supported && overwrite == "true" {
 		// Overwrite flag, clear pending tokens and continue
-		err := c.backupCodeModule.ClearPendingTokens(c.GetUserID())
+		err := c.backupCodeModule.ClearPendingTokens(c.GetUserID(), c.GetMeta())
 		if err != nil {
 			log.Printf("BackupCodeApiCtx.CreateTokens: error clearing pending backup codes (%s)", err)
 			c.WriteInternalError(rw)
@@ -80,7 +84,7 @@ func (c *backupCodeAPICtx) CreateTokens(rw web.ResponseWriter, req *web.Request)
 	}
 
 	// Create new codes
-	codes, err := c.backupCodeModule.CreateCodes(c.GetUserID())
+	codes, err := c.backupCodeModule.CreateCodes(c.GetUserID(), c.GetMeta())
 	if err != nil {
 		log.Printf("BackupCodeApiCtx.CreateTokens: error creating backup codes (%s)", err)
 		c.WriteInternalError(rw)
@@ -107,7 +111,7 @@ func (c *backupCodeAPICtx) AuthenticatePost(rw web.ResponseWriter, req *web.Requ
 	// Fetch challenge code
 	code := req.FormValue("code")
 
-	ok, err := c.backupCodeModule.ValidateCode(userid, code)
+	ok, err := c.backupCodeModule.ValidateCode(userid, code, c.GetMeta())
 	if err != nil {
 		log.Printf("backupCodeAuthenticatePost: error validating backup code (%s)", err)
 		c.WriteInternalError(rw)
@@ -120,7 +124,20 @@ func (c *backupCodeAPICtx) AuthenticatePost(rw web.ResponseWriter, req *web.Requ
 		return
 	}
 
-	c.UserAction(userid, action, rw, req)
+	// Complete the pending 2fa request, completion handlers are run once the request has been verified
+	err = c.Complete2FARequest(rw, req, userid, action, func() error {
+		return c.backupCodeModule.completedHandler.SecondFactorCompleted(userid, action, "backup", c.GetMeta())
+	})
+	if err == appcontext.ErrNoFlowPending {
+		log.Printf("BackupCodeAPICtx.AuthenticatePost: error completing 2fa request (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+	if err != nil {
+		log.Printf("BackupCodeAPICtx.AuthenticatePost: error completing action %s (%s)", action, err)
+		c.WriteInternalError(rw)
+		return
+	}
 
 	c.WriteAPIResult(rw, api.SecondFactorSuccess)
 }
@@ -146,7 +163,7 @@ func (c *backupCodeAPICtx) ListTokens(rw web.ResponseWriter, req *web.Request) {
 }
 
 func (c *backupCodeAPICtx) RemoveTokens(rw web.ResponseWriter, req *web.Request) {
-	err := c.backupCodeModule.ClearPendingTokens(c.GetUserID())
+	err := c.backupCodeModule.ClearPendingTokens(c.GetUserID(), c.GetMeta())
 	if err != nil {
 		log.Printf("BackupCodeAPICtx.RemoveTokens: error clearing pending backup codes (%s)", err)
 		c.WriteInternalError(rw)
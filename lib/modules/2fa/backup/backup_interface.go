@@ -45,3 +45,8 @@ type Storer interface {
 	// Remove valid backup codes
 	ClearPendingBackupTokens(userid string) error
 }
+
+// CompletedHandler Callback for 2fa signature completion
+type CompletedHandler interface {
+	SecondFactorCompleted(userid, action, factor string, meta map[string]string) error
+}
@@ -38,7 +38,7 @@ func TestBackupModule(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 		mockStore := NewMockStorer(ctrl)
-		bc := NewController("Test Service", mockStore, &test.MockEventEmitter{})
+		bc := NewController("Test Service", mockStore, &test.MockCompletedHandler{}, &test.MockEventEmitter{})
 
 		code, err := bc.generateCode(recoveryKeyLen)
 		assert.Nil(t, err)
@@ -50,13 +50,13 @@ func TestBackupModule(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 		mockStore := NewMockStorer(ctrl)
-		bc := NewController("Test Service", mockStore, &test.MockEventEmitter{})
+		bc := NewController("Test Service", mockStore, &test.MockCompletedHandler{}, &test.MockEventEmitter{})
 
 		mockStore.EXPECT().AddBackupToken(userID, gomock.Any(), gomock.Any()).Times(NumRecoveryKeys).Do(func(userID, name, key string) {
 			keys = append(keys, BackupKey{userID, name, key})
 		})
 
-		codes, err = bc.CreateCodes(userID)
+		codes, err = bc.CreateCodes(userID, nil)
 		assert.Nil(t, err)
 		assert.Len(t, keys, NumRecoveryKeys)
 		assert.Len(t, codes.Tokens, NumRecoveryKeys)
@@ -66,7 +66,7 @@ func TestBackupModule(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 		mockStore := NewMockStorer(ctrl)
-		bc := NewController("Test Service", mockStore, &test.MockEventEmitter{})
+		bc := NewController("Test Service", mockStore, &test.MockCompletedHandler{}, &test.MockEventEmitter{})
 
 		code := strings.Join([]string{codes.Tokens[0].Name, codes.Tokens[0].Code}, " ")
 
@@ -81,7 +81,7 @@ func TestBackupModule(t *testing.T) {
 		mockCode.EXPECT().GetName().Return(codes.Tokens[0].Name)
 		mockStore.EXPECT().UpdateBackupToken(mockCode)
 
-		ok, err := bc.ValidateCode(userID, code)
+		ok, err := bc.ValidateCode(userID, code, nil)
 		assert.Nil(t, err)
 
 		if !ok {
@@ -93,7 +93,7 @@ func TestBackupModule(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 		mockStore := NewMockStorer(ctrl)
-		bc := NewController("Test Service", mockStore, &test.MockEventEmitter{})
+		bc := NewController("Test Service", mockStore, &test.MockCompletedHandler{}, &test.MockEventEmitter{})
 
 		code := strings.Join([]string{codes.Tokens[0].Name, codes.Tokens[0].Code}, " ")
 
@@ -103,7 +103,7 @@ func TestBackupModule(t *testing.T) {
 
 		mockStore.EXPECT().GetBackupTokenByName(userID, codes.Tokens[0].Name).Return(mockCode, nil)
 
-		ok, err := bc.ValidateCode(userID, code)
+		ok, err := bc.ValidateCode(userID, code, nil)
 		assert.Nil(t, err)
 		if ok {
 			t.Errorf("Backup code validation succeeded (expected failure)")
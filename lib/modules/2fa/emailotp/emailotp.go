@@ -0,0 +1,307 @@
+/*
+ * (2fa) Email OTP Module Controller
+ * This defines the controller for the 2fa email one time code module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package emailotp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/events"
+
+	"github.com/gocraft/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// CodeLength number of digits in an emailed code
+	CodeLength = 8
+	// CodeExpiry time after which an emailed code is no longer valid
+	CodeExpiry = 10 * time.Minute
+	// MaxAttempts maximum number of validation attempts against a single code
+	MaxAttempts = 5
+	// ResendInterval minimum time between sending codes to a user
+	ResendInterval = time.Minute
+
+	codeHashRounds = 10
+
+	// Code storage methods, enrolment and authentication codes are not interchangeable
+	methodEnrol        = "email-enrol"
+	methodAuthenticate = "email"
+)
+
+// Controller Email OTP controller instance
+// The email OTP controller sends and validates one time codes to a users registered email
+// address, providing a second factor for users that are unable to use a TOTP or U2F device.
+type Controller struct {
+	store   Storer
+	mailer  Mailer
+	emitter events.Emitter
+
+	completedHandler CompletedHandler
+}
+
+// NewController creates a new email OTP controller
+// A Mailer is required to deliver codes, a CompletedHandler for completion of authorization actions,
+// and a Storer to provide underlying storage to the module
+func NewController(store Storer, mailer Mailer, completedHandler CompletedHandler, emitter events.Emitter) *Controller {
+	return &Controller{
+		store:   store,
+		mailer:  mailer,
+		emitter: emitter,
+
+		completedHandler: completedHandler,
+	}
+}
+
+// Helper middleware to bind module to API context
+func bindEmailOTPContext(emailOTPModule *Controller) func(ctx *emailOTPAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	return func(ctx *emailOTPAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		ctx.emailOTPModule = emailOTPModule
+		next(rw, req)
+	}
+}
+
+// BindAPI Binds the API for the email OTP module to the provided router
+func (emailOTPModule *Controller) BindAPI(router *web.Router) {
+	// Create router for user modules
+	emailOTPRouter := router.Subrouter(emailOTPAPICtx{}, "/api/emailotp")
+
+	// Attach module context
+	emailOTPRouter.Middleware(bindEmailOTPContext(emailOTPModule))
+
+	// Bind endpoints
+	emailOTPRouter.Get("/authenticate", (*emailOTPAPICtx).AuthenticateGet)
+	emailOTPRouter.Post("/authenticate", (*emailOTPAPICtx).AuthenticatePost)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := emailOTPRouter.Subrouter(emailOTPAPICtx{}, "")
+	sudoRouter.Middleware((*emailOTPAPICtx).RequireSudoMiddleware)
+	sudoRouter.Get("/enrol", (*emailOTPAPICtx).EnrolGet)
+	sudoRouter.Post("/enrol", (*emailOTPAPICtx).EnrolPost)
+	sudoRouter.Post("/remove", (*emailOTPAPICtx).RemovePost)
+}
+
+// IsSupported Checks whether email OTP is enabled for a given user by userid
+// This is required to implement the generic 2fa interface for binding into the core module.
+func (emailOTPModule *Controller) IsSupported(userid string) bool {
+	user, err := emailOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("EmailOTPModule.IsSupported error fetching user %s (%s)", userid, err)
+		return false
+	}
+	return user.IsEmailOTPEnabled()
+}
+
+func (emailOTPModule *Controller) loadUser(userid string) (User, error) {
+	u, err := emailOTPModule.store.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("user %s not found", userid)
+	}
+	return u.(User), nil
+}
+
+// generateCode creates a random numeric code of CodeLength digits
+func generateCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < CodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", CodeLength, n), nil
+}
+
+// sendCode generates, stores and emails a code to the user
+// Codes are not resent within the ResendInterval, returning false to indicate no code was sent
+func (emailOTPModule *Controller) sendCode(userid, method string) (bool, error) {
+	user, err := emailOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("EmailOTPModule.sendCode: error fetching user (%s)", err)
+		return false, err
+	}
+
+	// Rate limit code sending
+	c, err := emailOTPModule.store.GetOneTimeCode(userid, method)
+	if err != nil {
+		log.Printf("EmailOTPModule.sendCode: error fetching existing code (%s)", err)
+		return false, err
+	}
+	if c != nil {
+		existing := c.(CodeInterface)
+		if !existing.IsUsed() && time.Now().Before(existing.GetCreatedAt().Add(ResendInterval)) {
+			log.Printf("EmailOTPModule.sendCode: code recently sent to user %s, skipping", userid)
+			return false, nil
+		}
+	}
+
+	// Generate and hash code
+	code, err := generateCode()
+	if err != nil {
+		log.Printf("EmailOTPModule.sendCode: error generating code (%s)", err)
+		return false, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), codeHashRounds)
+	if err != nil {
+		log.Printf("EmailOTPModule.sendCode: error hashing code (%s)", err)
+		return false, err
+	}
+
+	// Store hashed code
+	_, err = emailOTPModule.store.AddOneTimeCode(userid, method, string(hash), time.Now().Add(CodeExpiry))
+	if err != nil {
+		log.Printf("EmailOTPModule.sendCode: error storing code (%s)", err)
+		return false, err
+	}
+
+	// Send code to the user
+	data := make(map[string]string)
+	data["Username"] = user.GetUsername()
+	data["Code"] = code
+	data["Expiry"] = fmt.Sprintf("%d minutes", int(CodeExpiry.Minutes()))
+
+	err = emailOTPModule.mailer.SendOneTimeCode(user.GetEmail(), data)
+	if err != nil {
+		log.Printf("EmailOTPModule.sendCode: error sending code (%s)", err)
+		return false, err
+	}
+
+	log.Printf("EmailOTPModule.sendCode: sent code to user %s", userid)
+
+	return true, nil
+}
+
+// validateCode checks a code against the stored code for a user
+// Codes are single use, expire after CodeExpiry and are invalidated after MaxAttempts
+func (emailOTPModule *Controller) validateCode(userid, method, code string) (bool, error) {
+	c, err := emailOTPModule.store.GetOneTimeCode(userid, method)
+	if err != nil {
+		log.Printf("EmailOTPModule.validateCode: error fetching code (%s)", err)
+		return false, err
+	}
+	if c == nil {
+		log.Printf("EmailOTPModule.validateCode: no code found for user %s", userid)
+		return false, nil
+	}
+	stored := c.(CodeInterface)
+
+	if stored.IsUsed() {
+		log.Printf("EmailOTPModule.validateCode: code already used for user %s", userid)
+		return false, nil
+	}
+	if time.Now().After(stored.GetExpiry()) {
+		log.Printf("EmailOTPModule.validateCode: code expired for user %s", userid)
+		return false, nil
+	}
+	if stored.GetAttempts() >= MaxAttempts {
+		log.Printf("EmailOTPModule.validateCode: attempt limit exceeded for user %s", userid)
+		return false, nil
+	}
+
+	// Record attempt prior to checking code
+	stored.SetAttempts(stored.GetAttempts() + 1)
+
+	hashErr := bcrypt.CompareHashAndPassword([]byte(stored.GetHashedCode()), []byte(code))
+	if hashErr == nil {
+		stored.SetUsed()
+	}
+
+	_, err = emailOTPModule.store.UpdateOneTimeCode(stored)
+	if err != nil {
+		log.Printf("EmailOTPModule.validateCode: error updating code (%s)", err)
+		return false, err
+	}
+
+	return hashErr == nil, nil
+}
+
+// StartEnrolment sends an enrolment code to a users email address
+func (emailOTPModule *Controller) StartEnrolment(userid string) (bool, error) {
+	return emailOTPModule.sendCode(userid, methodEnrol)
+}
+
+// ValidateEnrolment checks an enrolment code and enables email OTP for the user if valid
+func (emailOTPModule *Controller) ValidateEnrolment(userid, code string, meta map[string]string) (bool, error) {
+	ok, err := emailOTPModule.validateCode(userid, methodEnrol, code)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	user, err := emailOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("EmailOTPModule.ValidateEnrolment: error fetching user (%s)", err)
+		return false, err
+	}
+
+	user.SetEmailOTPEnabled(true)
+	_, err = emailOTPModule.store.UpdateUser(user)
+	if err != nil {
+		log.Printf("EmailOTPModule.ValidateEnrolment: error updating user (%s)", err)
+		return false, err
+	}
+
+	log.Printf("EmailOTPModule.ValidateEnrolment: enabled email OTP for user %s", userid)
+
+	emailOTPModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorEmailOTPAdded, events.NewDataWithMeta(meta)))
+
+	return true, nil
+}
+
+// SendAuthenticationCode sends an authentication code to a users email address
+func (emailOTPModule *Controller) SendAuthenticationCode(userid string) (bool, error) {
+	if !emailOTPModule.IsSupported(userid) {
+		return false, nil
+	}
+	return emailOTPModule.sendCode(userid, methodAuthenticate)
+}
+
+// ValidateAuthenticationCode validates an authentication code for a given user
+func (emailOTPModule *Controller) ValidateAuthenticationCode(userid, code string, meta map[string]string) (bool, error) {
+	if !emailOTPModule.IsSupported(userid) {
+		return false, nil
+	}
+
+	ok, err := emailOTPModule.validateCode(userid, methodAuthenticate, code)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	emailOTPModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorEmailOTPUsed, events.NewDataWithMeta(meta)))
+
+	return true, nil
+}
+
+// Disable disables email OTP for a given user
+func (emailOTPModule *Controller) Disable(userid string, meta map[string]string) error {
+	user, err := emailOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("EmailOTPModule.Disable: error fetching user (%s)", err)
+		return err
+	}
+
+	user.SetEmailOTPEnabled(false)
+	_, err = emailOTPModule.store.UpdateUser(user)
+	if err != nil {
+		log.Printf("EmailOTPModule.Disable: error updating user (%s)", err)
+		return err
+	}
+
+	emailOTPModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorEmailOTPRemoved, events.NewDataWithMeta(meta)))
+
+	return nil
+}
@@ -0,0 +1,200 @@
+/*
+ * (2fa) Email OTP Module API
+ * This defines the API methods bound to the email OTP module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package emailotp
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+)
+
+// Email OTP API context storage
+type emailOTPAPICtx struct {
+	// Base context for shared components
+	*appcontext.AuthPlzCtx
+
+	// Email OTP controller module
+	emailOTPModule *Controller
+}
+
+const (
+	emailOTPSignSessionKey string = "emailotp-sign-session"
+	emailOTPSignUserIDKey  string = "emailotp-sign-userid"
+	emailOTPSignActionKey  string = "emailotp-sign-action"
+
+	emailOTPSignMaxAge = 60 * 10
+)
+
+// EnrolGet sends an enrolment code to the logged in users email address
+func (c *emailOTPAPICtx) EnrolGet(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	_, err := c.emailOTPModule.StartEnrolment(c.GetUserID())
+	if err != nil {
+		log.Printf("EmailOTPEnrolGet: error sending enrolment code (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.EmailOTPCodeSent)
+}
+
+// EnrolPost checks an enrolment code and enables email OTP on success
+func (c *emailOTPAPICtx) EnrolPost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	code := req.FormValue("code")
+	if code == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	ok, err := c.emailOTPModule.ValidateEnrolment(c.GetUserID(), code, c.GetMeta())
+	if err != nil {
+		log.Printf("EmailOTPEnrolPost: error validating enrolment code (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("EmailOTPEnrolPost: enrolment failed for user %s", c.GetUserID())
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorFailed)
+		return
+	}
+
+	log.Printf("EmailOTPEnrolPost: enrolled user %s", c.GetUserID())
+	c.WriteAPIResult(rw, api.EmailOTPEnabled)
+}
+
+// AuthenticateGet sends an authentication code for a pending 2fa request
+// This grabs a pending 2fa userid and action from the 2fa request session, subsequent
+// calls resend the code for the same request (subject to the module resend interval)
+func (c *emailOTPAPICtx) AuthenticateGet(rw web.ResponseWriter, req *web.Request) {
+	signSession, err := c.GetNamedSession(rw, req, emailOTPSignSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch challenge user ID
+	userid, action := c.Get2FARequest(rw, req)
+	if userid == "" || action == "" {
+		// Fall back to an existing email otp request
+		userid, _ = signSession.Values[emailOTPSignUserIDKey].(string)
+		action, _ = signSession.Values[emailOTPSignActionKey].(string)
+	}
+	if userid == "" || action == "" {
+		log.Printf("emailotp.AuthenticateGet No pending 2fa requests found")
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	log.Printf("emailotp.AuthenticateGet Authentication request for user %s (action %s)", userid, action)
+
+	ok, err := c.emailOTPModule.SendAuthenticationCode(userid)
+	if err != nil {
+		log.Printf("emailotp.AuthenticateGet error sending code (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("emailotp.AuthenticateGet no code sent for user %s", userid)
+	}
+
+	// Save to session vars
+	signSession.Values[emailOTPSignUserIDKey] = userid
+	signSession.Values[emailOTPSignActionKey] = action
+	signSession.Options.MaxAge = emailOTPSignMaxAge
+	signSession.Save(req.Request, rw)
+
+	c.WriteAPIResult(rw, api.EmailOTPCodeSent)
+}
+
+// AuthenticatePost checks an authentication code to complete a pending 2fa request
+func (c *emailOTPAPICtx) AuthenticatePost(rw web.ResponseWriter, req *web.Request) {
+	signSession, err := c.GetNamedSession(rw, req, emailOTPSignSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch request from session vars
+	userid, useridOK := signSession.Values[emailOTPSignUserIDKey].(string)
+	action, actionOK := signSession.Values[emailOTPSignActionKey].(string)
+	if !useridOK || !actionOK || userid == "" || action == "" {
+		log.Printf("emailotp.AuthenticatePost No pending 2fa requests found")
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch challenge code
+	code := req.FormValue("code")
+
+	ok, err := c.emailOTPModule.ValidateAuthenticationCode(userid, code, c.GetMeta())
+	if err != nil {
+		log.Printf("emailotp.AuthenticatePost: error validating code (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("emailotp.AuthenticatePost: authentication failed for user %s\n", userid)
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.SecondFactorFailed)
+		return
+	}
+
+	// Clear pending request
+	signSession.Options.MaxAge = -1
+	signSession.Save(req.Request, rw)
+
+	log.Printf("emailotp.AuthenticatePost: Valid authentication for account %s (action %s)\n", userid, action)
+	// Complete the pending 2fa request, completion handlers are run once the request has been verified
+	err = c.Complete2FARequest(rw, req, userid, action, func() error {
+		return c.emailOTPModule.completedHandler.SecondFactorCompleted(userid, action, "emailotp", c.GetMeta())
+	})
+	if err == appcontext.ErrNoFlowPending {
+		log.Printf("emailotp.AuthenticatePost: error completing 2fa request (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+	if err != nil {
+		log.Printf("emailotp.AuthenticatePost: error completing action %s (%s)", action, err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SecondFactorSuccess)
+}
+
+// RemovePost disables email OTP for the logged in user
+func (c *emailOTPAPICtx) RemovePost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := c.emailOTPModule.Disable(c.GetUserID(), c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.EmailOTPDisabled)
+}
@@ -0,0 +1,213 @@
+/*
+ * (2fa) Email OTP Module tests
+ * This defines email OTP module tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package emailotp
+
+import (
+	"testing"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/test"
+)
+
+type mockMailer struct {
+	email string
+	code  string
+	sent  int
+}
+
+func (m *mockMailer) SendOneTimeCode(email string, data map[string]string) error {
+	m.email = email
+	m.code = data["Code"]
+	m.sent++
+	return nil
+}
+
+func TestEmailOTPModule(t *testing.T) {
+	var fakeEmail = "test@abc.com"
+	var fakePass = "abcDEF123@abcDEF123@"
+	var fakeName = "user.sdfsfdF"
+
+	c, _ := config.DefaultConfig()
+
+	// Attempt database connection
+	dataStore, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error("Error opening database")
+		t.FailNow()
+	}
+
+	// Force synchronization
+	dataStore.ForceSync()
+
+	// Create user for tests
+	u, err := dataStore.AddUser(fakeEmail, fakeName, fakePass)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user := u.(*datastore.User)
+
+	mailer := mockMailer{}
+	mockEventEmitter := test.MockEventEmitter{}
+
+	// Instantiate email otp module
+	emailOTPModule := NewController(dataStore, &mailer, &test.MockCompletedHandler{}, &mockEventEmitter)
+
+	t.Run("Generates numeric codes", func(t *testing.T) {
+		code, err := generateCode()
+		if err != nil {
+			t.Error(err)
+		}
+		if len(code) != CodeLength {
+			t.Errorf("Expected code length %d, received %d", CodeLength, len(code))
+		}
+	})
+
+	t.Run("Users are not supported prior to enrolment", func(t *testing.T) {
+		if emailOTPModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected email otp support prior to enrolment")
+		}
+
+		ok, err := emailOTPModule.SendAuthenticationCode(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+		}
+		if ok || mailer.sent != 0 {
+			t.Errorf("Authentication code sent prior to enrolment")
+		}
+	})
+
+	t.Run("Enrolment sends a code", func(t *testing.T) {
+		ok, err := emailOTPModule.StartEnrolment(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !ok || mailer.sent != 1 {
+			t.Errorf("Enrolment code not sent")
+		}
+		if mailer.email != fakeEmail {
+			t.Errorf("Enrolment code sent to incorrect address %s", mailer.email)
+		}
+	})
+
+	t.Run("Codes are not resent within the resend interval", func(t *testing.T) {
+		ok, err := emailOTPModule.StartEnrolment(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+		}
+		if ok || mailer.sent != 1 {
+			t.Errorf("Enrolment code resent within resend interval")
+		}
+	})
+
+	t.Run("Invalid enrolment codes fail", func(t *testing.T) {
+		ok, err := emailOTPModule.ValidateEnrolment(user.GetExtID(), "not-a-code", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Invalid enrolment code accepted")
+		}
+	})
+
+	t.Run("Valid enrolment codes enable email otp", func(t *testing.T) {
+		ok, err := emailOTPModule.ValidateEnrolment(user.GetExtID(), mailer.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Valid enrolment code rejected")
+		}
+		if !emailOTPModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Email otp not supported following enrolment")
+		}
+	})
+
+	t.Run("Enrolment codes can only be used once", func(t *testing.T) {
+		ok, err := emailOTPModule.ValidateEnrolment(user.GetExtID(), mailer.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Enrolment code accepted twice")
+		}
+	})
+
+	t.Run("Enrolment codes can not be used for authentication", func(t *testing.T) {
+		ok, err := emailOTPModule.ValidateAuthenticationCode(user.GetExtID(), mailer.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Enrolment code accepted for authentication")
+		}
+	})
+
+	t.Run("Authenticates with valid codes", func(t *testing.T) {
+		ok, err := emailOTPModule.SendAuthenticationCode(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !ok {
+			t.Errorf("Authentication code not sent")
+		}
+
+		ok, err = emailOTPModule.ValidateAuthenticationCode(user.GetExtID(), mailer.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Valid authentication code rejected")
+		}
+	})
+
+	t.Run("Codes are invalidated after the attempt limit", func(t *testing.T) {
+		// Previous code has been used so a new code may be sent immediately
+		ok, err := emailOTPModule.SendAuthenticationCode(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !ok {
+			t.Errorf("Authentication code not sent")
+		}
+
+		for i := 0; i < MaxAttempts; i++ {
+			ok, err := emailOTPModule.ValidateAuthenticationCode(user.GetExtID(), "00000000x", nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				t.Errorf("Invalid authentication code accepted")
+			}
+		}
+
+		ok, err = emailOTPModule.ValidateAuthenticationCode(user.GetExtID(), mailer.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Authentication code accepted after attempt limit")
+		}
+	})
+
+	t.Run("Disable email otp", func(t *testing.T) {
+		err := emailOTPModule.Disable(user.GetExtID(), nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if emailOTPModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected email otp support after removal")
+		}
+	})
+
+}
@@ -0,0 +1,374 @@
+/*
+ * (2fa) SMS OTP Module Controller
+ * This defines the controller for the 2fa SMS one time code module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package smsotp
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/events"
+
+	"github.com/gocraft/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// CodeLength number of digits in an SMS code
+	CodeLength = 6
+	// CodeExpiry time after which an SMS code is no longer valid
+	CodeExpiry = 10 * time.Minute
+	// MaxAttempts maximum number of validation attempts against a single code
+	MaxAttempts = 5
+	// ResendInterval minimum time between sending codes to a user
+	ResendInterval = time.Minute
+	// SendLimitPeriod period over which the per user send limit is applied
+	SendLimitPeriod = time.Hour
+
+	codeHashRounds = 10
+
+	// Code storage methods, enrolment and authentication codes are not interchangeable
+	methodEnrol        = "sms-enrol"
+	methodAuthenticate = "sms"
+)
+
+var (
+	// ErrRateLimited returned when a user has exceeded the SMS send limits
+	ErrRateLimited = errors.New("sms send limit exceeded")
+	// ErrInvalidPhoneNumber returned when a phone number is not in E.164 format
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+)
+
+// E.164 phone numbers, ie. +6421000000
+var phoneNumberExp = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Controller SMS OTP controller instance
+// The SMS OTP controller sends and validates one time codes to a users verified phone number
+type Controller struct {
+	store     Storer
+	sender    Sender
+	sendLimit uint
+	emitter   events.Emitter
+
+	completedHandler CompletedHandler
+}
+
+// NewController creates a new SMS OTP controller
+// A Sender is required to deliver codes, a CompletedHandler for completion of authorization actions,
+// and a Storer to provide underlying storage to the module.
+// sendLimit bounds the number of messages sent to each user per SendLimitPeriod.
+func NewController(store Storer, sender Sender, sendLimit uint, completedHandler CompletedHandler, emitter events.Emitter) *Controller {
+	return &Controller{
+		store:     store,
+		sender:    sender,
+		sendLimit: sendLimit,
+		emitter:   emitter,
+
+		completedHandler: completedHandler,
+	}
+}
+
+// Helper middleware to bind module to API context
+func bindSMSOTPContext(smsOTPModule *Controller) func(ctx *smsOTPAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	return func(ctx *smsOTPAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		ctx.smsOTPModule = smsOTPModule
+		next(rw, req)
+	}
+}
+
+// BindAPI Binds the API for the SMS OTP module to the provided router
+func (smsOTPModule *Controller) BindAPI(router *web.Router) {
+	// Create router for user modules
+	smsOTPRouter := router.Subrouter(smsOTPAPICtx{}, "/api/sms")
+
+	// Attach module context
+	smsOTPRouter.Middleware(bindSMSOTPContext(smsOTPModule))
+
+	// Bind endpoints
+	smsOTPRouter.Get("/authenticate", (*smsOTPAPICtx).AuthenticateGet)
+	smsOTPRouter.Post("/authenticate", (*smsOTPAPICtx).AuthenticatePost)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := smsOTPRouter.Subrouter(smsOTPAPICtx{}, "")
+	sudoRouter.Middleware((*smsOTPAPICtx).RequireSudoMiddleware)
+	sudoRouter.Post("/enrol", (*smsOTPAPICtx).EnrolPost)
+	sudoRouter.Post("/verify", (*smsOTPAPICtx).VerifyPost)
+	sudoRouter.Post("/remove", (*smsOTPAPICtx).RemovePost)
+}
+
+// IsSupported Checks whether SMS OTP is available for a given user by userid
+// This is required to implement the generic 2fa interface for binding into the core module.
+func (smsOTPModule *Controller) IsSupported(userid string) bool {
+	user, err := smsOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("SMSOTPModule.IsSupported error fetching user %s (%s)", userid, err)
+		return false
+	}
+	return user.IsPhoneVerified() && user.GetPhoneNumber() != ""
+}
+
+// ValidatePhoneNumber checks a phone number is in E.164 format
+func ValidatePhoneNumber(number string) bool {
+	return phoneNumberExp.MatchString(number)
+}
+
+func (smsOTPModule *Controller) loadUser(userid string) (User, error) {
+	u, err := smsOTPModule.store.GetUserByExtID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("user %s not found", userid)
+	}
+	return u.(User), nil
+}
+
+// generateCode creates a random numeric code of CodeLength digits
+func generateCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < CodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", CodeLength, n), nil
+}
+
+// bindCode binds a code to the phone number it was sent to
+func bindCode(number, code string) []byte {
+	return []byte(number + ":" + code)
+}
+
+// checkRateLimit checks whether a code may be sent to a user
+// This enforces both the ResendInterval and the per user send limit
+func (smsOTPModule *Controller) checkRateLimit(userid, method string) error {
+	c, err := smsOTPModule.store.GetOneTimeCode(userid, method)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		existing := c.(CodeInterface)
+		if !existing.IsUsed() && time.Now().Before(existing.GetCreatedAt().Add(ResendInterval)) {
+			return ErrRateLimited
+		}
+	}
+
+	since := time.Now().Add(-SendLimitPeriod)
+	var sent uint
+	for _, m := range []string{methodEnrol, methodAuthenticate} {
+		count, err := smsOTPModule.store.CountOneTimeCodes(userid, m, since)
+		if err != nil {
+			return err
+		}
+		sent += count
+	}
+	if sent >= smsOTPModule.sendLimit {
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
+// sendCode generates, stores and sends a code to the provided phone number
+func (smsOTPModule *Controller) sendCode(userid, method, number string) error {
+	// Rate limit code sending
+	err := smsOTPModule.checkRateLimit(userid, method)
+	if err == ErrRateLimited {
+		log.Printf("SMSOTPModule.sendCode: send limit reached for user %s", userid)
+		return err
+	} else if err != nil {
+		log.Printf("SMSOTPModule.sendCode: error checking send limits (%s)", err)
+		return err
+	}
+
+	// Generate and hash code
+	code, err := generateCode()
+	if err != nil {
+		log.Printf("SMSOTPModule.sendCode: error generating code (%s)", err)
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword(bindCode(number, code), codeHashRounds)
+	if err != nil {
+		log.Printf("SMSOTPModule.sendCode: error hashing code (%s)", err)
+		return err
+	}
+
+	// Store hashed code
+	_, err = smsOTPModule.store.AddOneTimeCode(userid, method, string(hash), time.Now().Add(CodeExpiry))
+	if err != nil {
+		log.Printf("SMSOTPModule.sendCode: error storing code (%s)", err)
+		return err
+	}
+
+	// Send code to the user
+	data := make(map[string]string)
+	data["Code"] = code
+	data["Expiry"] = fmt.Sprintf("%d minutes", int(CodeExpiry.Minutes()))
+
+	err = smsOTPModule.sender.SendOneTimeCode(number, data)
+	if err != nil {
+		log.Printf("SMSOTPModule.sendCode: error sending code (%s)", err)
+		return err
+	}
+
+	log.Printf("SMSOTPModule.sendCode: sent code to user %s", userid)
+
+	return nil
+}
+
+// validateCode checks a code against the stored code for a user
+// Codes are single use, expire after CodeExpiry and are invalidated after MaxAttempts
+func (smsOTPModule *Controller) validateCode(userid, method, number, code string) (bool, error) {
+	c, err := smsOTPModule.store.GetOneTimeCode(userid, method)
+	if err != nil {
+		log.Printf("SMSOTPModule.validateCode: error fetching code (%s)", err)
+		return false, err
+	}
+	if c == nil {
+		log.Printf("SMSOTPModule.validateCode: no code found for user %s", userid)
+		return false, nil
+	}
+	stored := c.(CodeInterface)
+
+	if stored.IsUsed() {
+		log.Printf("SMSOTPModule.validateCode: code already used for user %s", userid)
+		return false, nil
+	}
+	if time.Now().After(stored.GetExpiry()) {
+		log.Printf("SMSOTPModule.validateCode: code expired for user %s", userid)
+		return false, nil
+	}
+	if stored.GetAttempts() >= MaxAttempts {
+		log.Printf("SMSOTPModule.validateCode: attempt limit exceeded for user %s", userid)
+		return false, nil
+	}
+
+	// Record attempt prior to checking code
+	stored.SetAttempts(stored.GetAttempts() + 1)
+
+	hashErr := bcrypt.CompareHashAndPassword([]byte(stored.GetHashedCode()), bindCode(number, code))
+	if hashErr == nil {
+		stored.SetUsed()
+	}
+
+	_, err = smsOTPModule.store.UpdateOneTimeCode(stored)
+	if err != nil {
+		log.Printf("SMSOTPModule.validateCode: error updating code (%s)", err)
+		return false, err
+	}
+
+	return hashErr == nil, nil
+}
+
+// StartEnrolment sends a verification code to a phone number for enrolment
+func (smsOTPModule *Controller) StartEnrolment(userid, number string) error {
+	if !ValidatePhoneNumber(number) {
+		return ErrInvalidPhoneNumber
+	}
+	return smsOTPModule.sendCode(userid, methodEnrol, number)
+}
+
+// ValidateEnrolment checks a verification code and sets the users verified phone number if valid
+func (smsOTPModule *Controller) ValidateEnrolment(userid, number, code string, meta map[string]string) (bool, error) {
+	ok, err := smsOTPModule.validateCode(userid, methodEnrol, number, code)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	user, err := smsOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("SMSOTPModule.ValidateEnrolment: error fetching user (%s)", err)
+		return false, err
+	}
+
+	user.SetPhoneNumber(number)
+	user.SetPhoneVerified(true)
+	_, err = smsOTPModule.store.UpdateUser(user)
+	if err != nil {
+		log.Printf("SMSOTPModule.ValidateEnrolment: error updating user (%s)", err)
+		return false, err
+	}
+
+	log.Printf("SMSOTPModule.ValidateEnrolment: verified phone number for user %s", userid)
+
+	data := events.NewDataWithMeta(meta)
+	data["Phone Number"] = number
+	smsOTPModule.emitter.SendEvent(events.NewEvent(userid, events.PhoneVerified, data))
+
+	return true, nil
+}
+
+// SendAuthenticationCode sends an authentication code to a users verified phone number
+func (smsOTPModule *Controller) SendAuthenticationCode(userid string) (bool, error) {
+	user, err := smsOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("SMSOTPModule.SendAuthenticationCode: error fetching user (%s)", err)
+		return false, err
+	}
+	if !user.IsPhoneVerified() || user.GetPhoneNumber() == "" {
+		return false, nil
+	}
+
+	err = smsOTPModule.sendCode(userid, methodAuthenticate, user.GetPhoneNumber())
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ValidateAuthenticationCode validates an authentication code for a given user
+func (smsOTPModule *Controller) ValidateAuthenticationCode(userid, code string, meta map[string]string) (bool, error) {
+	user, err := smsOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("SMSOTPModule.ValidateAuthenticationCode: error fetching user (%s)", err)
+		return false, err
+	}
+	if !user.IsPhoneVerified() || user.GetPhoneNumber() == "" {
+		return false, nil
+	}
+
+	ok, err := smsOTPModule.validateCode(userid, methodAuthenticate, user.GetPhoneNumber(), code)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	smsOTPModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorSMSUsed, events.NewDataWithMeta(meta)))
+
+	return true, nil
+}
+
+// RemovePhone removes a users verified phone number, disabling SMS OTP
+func (smsOTPModule *Controller) RemovePhone(userid string, meta map[string]string) error {
+	user, err := smsOTPModule.loadUser(userid)
+	if err != nil {
+		log.Printf("SMSOTPModule.RemovePhone: error fetching user (%s)", err)
+		return err
+	}
+
+	user.SetPhoneNumber("")
+	user.SetPhoneVerified(false)
+	_, err = smsOTPModule.store.UpdateUser(user)
+	if err != nil {
+		log.Printf("SMSOTPModule.RemovePhone: error updating user (%s)", err)
+		return err
+	}
+
+	smsOTPModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorSMSRemoved, events.NewDataWithMeta(meta)))
+
+	return nil
+}
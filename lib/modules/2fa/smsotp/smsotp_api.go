@@ -0,0 +1,249 @@
+/*
+ * (2fa) SMS OTP Module API
+ * This defines the API methods bound to the SMS OTP module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package smsotp
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+)
+
+// SMS OTP API context storage
+type smsOTPAPICtx struct {
+	// Base context for shared components
+	*appcontext.AuthPlzCtx
+
+	// SMS OTP controller module
+	smsOTPModule *Controller
+}
+
+const (
+	smsOTPEnrolSessionKey string = "smsotp-enrol-session"
+	smsOTPEnrolNumberKey  string = "smsotp-enrol-number"
+	smsOTPSignSessionKey  string = "smsotp-sign-session"
+	smsOTPSignUserIDKey   string = "smsotp-sign-userid"
+	smsOTPSignActionKey   string = "smsotp-sign-action"
+
+	smsOTPEnrolMaxAge = 60 * 10
+	smsOTPSignMaxAge  = 60 * 10
+)
+
+// writeSendError writes the appropriate response for a code sending error
+func (c *smsOTPAPICtx) writeSendError(rw web.ResponseWriter, err error) {
+	switch err {
+	case ErrRateLimited:
+		c.WriteAPIResultWithCode(rw, http.StatusTooManyRequests, api.SMSRateLimited)
+	case ErrInvalidPhoneNumber:
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SMSInvalidPhoneNumber)
+	default:
+		c.WriteInternalError(rw)
+	}
+}
+
+// EnrolPost sends a verification code to the provided phone number
+func (c *smsOTPAPICtx) EnrolPost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	number := req.FormValue("phone")
+	if !ValidatePhoneNumber(number) {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SMSInvalidPhoneNumber)
+		return
+	}
+
+	session, err := c.GetNamedSession(rw, req, smsOTPEnrolSessionKey)
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	err = c.smsOTPModule.StartEnrolment(c.GetUserID(), number)
+	if err != nil {
+		log.Printf("SMSOTPEnrolPost: error sending verification code (%s)", err)
+		c.writeSendError(rw, err)
+		return
+	}
+
+	// Save pending number to session
+	session.Values[smsOTPEnrolNumberKey] = number
+	session.Options.MaxAge = smsOTPEnrolMaxAge
+	session.Save(req.Request, rw)
+
+	c.WriteAPIResult(rw, api.SMSCodeSent)
+}
+
+// VerifyPost checks a verification code and enrols the pending phone number on success
+func (c *smsOTPAPICtx) VerifyPost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	session, err := c.GetNamedSession(rw, req, smsOTPEnrolSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	number, ok := session.Values[smsOTPEnrolNumberKey].(string)
+	if !ok || number == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	code := req.FormValue("code")
+	if code == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	ok, err = c.smsOTPModule.ValidateEnrolment(c.GetUserID(), number, code, c.GetMeta())
+	if err != nil {
+		log.Printf("SMSOTPVerifyPost: error validating verification code (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("SMSOTPVerifyPost: verification failed for user %s", c.GetUserID())
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorFailed)
+		return
+	}
+
+	// Clear pending enrolment
+	session.Options.MaxAge = -1
+	session.Save(req.Request, rw)
+
+	log.Printf("SMSOTPVerifyPost: verified phone number for user %s", c.GetUserID())
+	c.WriteAPIResult(rw, api.SMSPhoneVerified)
+}
+
+// AuthenticateGet sends an authentication code for a pending 2fa request
+// This grabs a pending 2fa userid and action from the 2fa request session, subsequent
+// calls resend the code for the same request (subject to the module send limits)
+func (c *smsOTPAPICtx) AuthenticateGet(rw web.ResponseWriter, req *web.Request) {
+	signSession, err := c.GetNamedSession(rw, req, smsOTPSignSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch challenge user ID
+	userid, action := c.Get2FARequest(rw, req)
+	if userid == "" || action == "" {
+		// Fall back to an existing sms otp request
+		userid, _ = signSession.Values[smsOTPSignUserIDKey].(string)
+		action, _ = signSession.Values[smsOTPSignActionKey].(string)
+	}
+	if userid == "" || action == "" {
+		log.Printf("smsotp.AuthenticateGet No pending 2fa requests found")
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	log.Printf("smsotp.AuthenticateGet Authentication request for user %s (action %s)", userid, action)
+
+	// Save to session vars prior to sending so rate limited requests may be retried
+	signSession.Values[smsOTPSignUserIDKey] = userid
+	signSession.Values[smsOTPSignActionKey] = action
+	signSession.Options.MaxAge = smsOTPSignMaxAge
+	signSession.Save(req.Request, rw)
+
+	ok, err := c.smsOTPModule.SendAuthenticationCode(userid)
+	if err != nil {
+		log.Printf("smsotp.AuthenticateGet error sending code (%s)", err)
+		c.writeSendError(rw, err)
+		return
+	}
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNotFound)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SMSCodeSent)
+}
+
+// AuthenticatePost checks an authentication code to complete a pending 2fa request
+func (c *smsOTPAPICtx) AuthenticatePost(rw web.ResponseWriter, req *web.Request) {
+	signSession, err := c.GetNamedSession(rw, req, smsOTPSignSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch request from session vars
+	userid, useridOK := signSession.Values[smsOTPSignUserIDKey].(string)
+	action, actionOK := signSession.Values[smsOTPSignActionKey].(string)
+	if !useridOK || !actionOK || userid == "" || action == "" {
+		log.Printf("smsotp.AuthenticatePost No pending 2fa requests found")
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch challenge code
+	code := req.FormValue("code")
+
+	ok, err := c.smsOTPModule.ValidateAuthenticationCode(userid, code, c.GetMeta())
+	if err != nil {
+		log.Printf("smsotp.AuthenticatePost: error validating code (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("smsotp.AuthenticatePost: authentication failed for user %s\n", userid)
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.SecondFactorFailed)
+		return
+	}
+
+	// Clear pending request
+	signSession.Options.MaxAge = -1
+	signSession.Save(req.Request, rw)
+
+	log.Printf("smsotp.AuthenticatePost: Valid authentication for account %s (action %s)\n", userid, action)
+	// Complete the pending 2fa request, completion handlers are run once the request has been verified
+	err = c.Complete2FARequest(rw, req, userid, action, func() error {
+		return c.smsOTPModule.completedHandler.SecondFactorCompleted(userid, action, "sms", c.GetMeta())
+	})
+	if err == appcontext.ErrNoFlowPending {
+		log.Printf("smsotp.AuthenticatePost: error completing 2fa request (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+	if err != nil {
+		log.Printf("smsotp.AuthenticatePost: error completing action %s (%s)", action, err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SecondFactorSuccess)
+}
+
+// RemovePost removes the verified phone number for the logged in user
+func (c *smsOTPAPICtx) RemovePost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := c.smsOTPModule.RemovePhone(c.GetUserID(), c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SMSPhoneRemoved)
+}
@@ -0,0 +1,63 @@
+/*
+ * SMS OTP Module interfaces
+ * This defines the interfaces required to use the SMS OTP module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package smsotp
+
+import (
+	"time"
+)
+
+// CodeInterface One time code instance interface
+// Storer code objects must implement this interface
+type CodeInterface interface {
+	GetHashedCode() string
+	GetExpiry() time.Time
+	GetAttempts() uint
+	SetAttempts(uint)
+	IsUsed() bool
+	SetUsed()
+	GetCreatedAt() time.Time
+}
+
+// User interface type
+// Storer user objects must implement this interface
+type User interface {
+	GetExtID() string
+	GetPhoneNumber() string
+	SetPhoneNumber(string)
+	IsPhoneVerified() bool
+	SetPhoneVerified(bool)
+}
+
+// Storer Code store interface
+// This must be implemented by a storage module to provide persistence to the module
+type Storer interface {
+	// Fetch a user instance by user id
+	GetUserByExtID(userid string) (interface{}, error)
+	// Update a user instance
+	UpdateUser(user interface{}) (interface{}, error)
+	// Add a one time code for a given user and method, replacing any existing codes
+	AddOneTimeCode(userid, method, hash string, expiry time.Time) (interface{}, error)
+	// Fetch the current one time code for a given user and method
+	GetOneTimeCode(userid, method string) (interface{}, error)
+	// Count codes created for a given user and method since the provided time
+	CountOneTimeCodes(userid, method string, since time.Time) (uint, error)
+	// Update a provided one time code
+	UpdateOneTimeCode(code interface{}) (interface{}, error)
+}
+
+// Sender interface for delivery of one time codes
+// This is implemented by the SMS controller
+type Sender interface {
+	SendOneTimeCode(number string, data map[string]string) error
+}
+
+// CompletedHandler Callback for 2fa signature completion
+type CompletedHandler interface {
+	SecondFactorCompleted(userid, action, factor string, meta map[string]string) error
+}
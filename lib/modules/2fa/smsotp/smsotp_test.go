@@ -0,0 +1,188 @@
+/*
+ * (2fa) SMS OTP Module tests
+ * This defines SMS OTP module tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package smsotp
+
+import (
+	"testing"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/test"
+)
+
+type mockSender struct {
+	number string
+	code   string
+	sent   int
+}
+
+func (m *mockSender) SendOneTimeCode(number string, data map[string]string) error {
+	m.number = number
+	m.code = data["Code"]
+	m.sent++
+	return nil
+}
+
+func TestSMSOTPModule(t *testing.T) {
+	var fakeEmail = "test@abc.com"
+	var fakePass = "abcDEF123@abcDEF123@"
+	var fakeName = "user.sdfsfdF"
+	var fakeNumber = "+64210000000"
+
+	c, _ := config.DefaultConfig()
+
+	// Attempt database connection
+	dataStore, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error("Error opening database")
+		t.FailNow()
+	}
+
+	// Force synchronization
+	dataStore.ForceSync()
+
+	// Create user for tests
+	u, err := dataStore.AddUser(fakeEmail, fakeName, fakePass)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user := u.(*datastore.User)
+
+	sender := mockSender{}
+	mockEventEmitter := test.MockEventEmitter{}
+
+	// Instantiate sms otp module
+	smsOTPModule := NewController(dataStore, &sender, 3, &test.MockCompletedHandler{}, &mockEventEmitter)
+
+	t.Run("Validates phone numbers", func(t *testing.T) {
+		if !ValidatePhoneNumber(fakeNumber) {
+			t.Errorf("Valid phone number rejected")
+		}
+		if ValidatePhoneNumber("0210000000") {
+			t.Errorf("Phone number without country code accepted")
+		}
+		if ValidatePhoneNumber("+64 21 000 000") {
+			t.Errorf("Phone number with spaces accepted")
+		}
+	})
+
+	t.Run("Users are not supported prior to enrolment", func(t *testing.T) {
+		if smsOTPModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected sms otp support prior to enrolment")
+		}
+	})
+
+	t.Run("Enrolment rejects invalid phone numbers", func(t *testing.T) {
+		err := smsOTPModule.StartEnrolment(user.GetExtID(), "not-a-number")
+		if err != ErrInvalidPhoneNumber {
+			t.Errorf("Expected ErrInvalidPhoneNumber, received %s", err)
+		}
+	})
+
+	t.Run("Enrolment sends a code", func(t *testing.T) {
+		err := smsOTPModule.StartEnrolment(user.GetExtID(), fakeNumber)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if sender.sent != 1 || sender.number != fakeNumber {
+			t.Errorf("Enrolment code not sent")
+		}
+	})
+
+	t.Run("Codes are not resent within the resend interval", func(t *testing.T) {
+		err := smsOTPModule.StartEnrolment(user.GetExtID(), fakeNumber)
+		if err != ErrRateLimited {
+			t.Errorf("Expected ErrRateLimited, received %s", err)
+		}
+		if sender.sent != 1 {
+			t.Errorf("Enrolment code resent within resend interval")
+		}
+	})
+
+	t.Run("Enrolment codes are bound to the phone number", func(t *testing.T) {
+		ok, err := smsOTPModule.ValidateEnrolment(user.GetExtID(), "+64219999999", sender.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Enrolment code accepted for a different phone number")
+		}
+	})
+
+	t.Run("Valid enrolment codes verify the phone number", func(t *testing.T) {
+		ok, err := smsOTPModule.ValidateEnrolment(user.GetExtID(), fakeNumber, sender.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Valid enrolment code rejected")
+		}
+		if !smsOTPModule.IsSupported(user.GetExtID()) {
+			t.Errorf("SMS otp not supported following enrolment")
+		}
+	})
+
+	t.Run("Authenticates with valid codes", func(t *testing.T) {
+		ok, err := smsOTPModule.SendAuthenticationCode(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !ok {
+			t.Errorf("Authentication code not sent")
+		}
+
+		ok, err = smsOTPModule.ValidateAuthenticationCode(user.GetExtID(), "000000x", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Invalid authentication code accepted")
+		}
+
+		ok, err = smsOTPModule.ValidateAuthenticationCode(user.GetExtID(), sender.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Valid authentication code rejected")
+		}
+	})
+
+	t.Run("Enforces per user send limits", func(t *testing.T) {
+		// Two codes have been sent, the third send reaches the limit
+		ok, err := smsOTPModule.SendAuthenticationCode(user.GetExtID())
+		if err != nil || !ok {
+			t.Errorf("Authentication code not sent (%s)", err)
+		}
+
+		_, err = smsOTPModule.ValidateAuthenticationCode(user.GetExtID(), sender.code, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = smsOTPModule.SendAuthenticationCode(user.GetExtID())
+		if err != ErrRateLimited {
+			t.Errorf("Expected ErrRateLimited, received %s", err)
+		}
+	})
+
+	t.Run("Remove phone number", func(t *testing.T) {
+		err := smsOTPModule.RemovePhone(user.GetExtID(), nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if smsOTPModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected sms otp support after removal")
+		}
+	})
+
+}
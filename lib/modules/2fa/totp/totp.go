@@ -24,17 +24,21 @@ type Controller struct {
 	issuerName string
 	totpStore  Storer
 	emitter    events.Emitter
+
+	completedHandler CompletedHandler
 }
 
 // NewController creates a new TOTP controller
 // TOTP tokens are issued against the provided issuer name and user email account.
 // A CompletedHandler is required for completion of authorization actions, as welll as a Storer to
 // provide underlying storage to the TOTP module
-func NewController(issuerName string, totpStore Storer, emitter events.Emitter) *Controller {
+func NewController(issuerName string, totpStore Storer, completedHandler CompletedHandler, emitter events.Emitter) *Controller {
 	return &Controller{
 		issuerName: issuerName,
 		totpStore:  totpStore,
 		emitter:    emitter,
+
+		completedHandler: completedHandler,
 	}
 }
 
@@ -56,10 +60,14 @@ func (totpModule *Controller) BindAPI(router *web.Router) {
 	totpRouter.Middleware(totpSessionMiddleware)
 
 	// Bind endpoints
-	totpRouter.Get("/enrol", (*totpAPICtx).TOTPEnrolGet)
-	totpRouter.Post("/enrol", (*totpAPICtx).TOTPEnrolPost)
 	totpRouter.Post("/authenticate", (*totpAPICtx).TOTPAuthenticatePost)
 	totpRouter.Get("/tokens", (*totpAPICtx).TOTPListTokens)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := totpRouter.Subrouter(totpAPICtx{}, "")
+	sudoRouter.Middleware((*totpAPICtx).RequireSudoMiddleware)
+	sudoRouter.Get("/enrol", (*totpAPICtx).TOTPEnrolGet)
+	sudoRouter.Post("/enrol", (*totpAPICtx).TOTPEnrolPost)
 }
 
 // IsSupported Checks whether totp is supported for a given user by userid
@@ -100,7 +108,7 @@ func (totpModule *Controller) CreateToken(userid string) (*otp.Key, error) {
 }
 
 // ValidateRegistration validates a totp token registration for a given user and enrols the token if valid
-func (totpModule *Controller) ValidateRegistration(userid, tokenName, secret, token string) (bool, error) {
+func (totpModule *Controller) ValidateRegistration(userid, tokenName, secret, token string, meta map[string]string) (bool, error) {
 
 	// Check token matches key
 	valid := totp.Validate(token, secret)
@@ -117,7 +125,7 @@ func (totpModule *Controller) ValidateRegistration(userid, tokenName, secret, to
 
 	log.Printf("TOTPModule.ValidateRegistration: registered token for user %s", userid)
 
-	data := make(map[string]string)
+	data := events.NewDataWithMeta(meta)
 	data["Token Name"] = t.(TokenInterface).GetName()
 	totpModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorTotpAdded, data))
 
@@ -126,7 +134,7 @@ func (totpModule *Controller) ValidateRegistration(userid, tokenName, secret, to
 
 // ValidateToken validates a totp token for a given user
 // This is used to check a user provided token against the set of registered totp keys
-func (totpModule *Controller) ValidateToken(userid string, token string) (bool, error) {
+func (totpModule *Controller) ValidateToken(userid string, token string, meta map[string]string) (bool, error) {
 	// Fetch tokens
 	tokens, err := totpModule.totpStore.GetTotpTokens(userid)
 	if err != nil {
@@ -161,6 +169,10 @@ func (totpModule *Controller) ValidateToken(userid string, token string) (bool,
 		return false, err
 	}
 
+	data := events.NewDataWithMeta(meta)
+	data["Token Name"] = validToken.GetName()
+	totpModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorTotpUsed, data))
+
 	return true, nil
 }
 
@@ -200,7 +212,7 @@ func (totpModule *Controller) ListTokens(userid string) ([]TokenResp, error) {
 }
 
 // RemoveToken removes a token by matching user and token external IDs
-func (totpModule *Controller) RemoveToken(userid, tokenID string) (bool, error) {
+func (totpModule *Controller) RemoveToken(userid, tokenID string, meta map[string]string) (bool, error) {
 	tokens, err := totpModule.totpStore.GetTotpTokens(userid)
 	if err != nil {
 		log.Printf("TOTPModule.ListTokens: error fetching TOTP tokens (%s)", err)
@@ -214,9 +226,13 @@ func (totpModule *Controller) RemoveToken(userid, tokenID string) (bool, error)
 			if err != nil {
 				log.Printf("TOTPModule.ListTokens: error deleting TOTP tokens (%s)", err)
 				return false, err
-			} else {
-				return true, nil
 			}
+
+			data := events.NewDataWithMeta(meta)
+			data["Token Name"] = token.GetName()
+			totpModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorTotpRemoved, data))
+
+			return true, nil
 		}
 	}
 
@@ -158,7 +158,7 @@ func (c *totpAPICtx) TOTPEnrolPost(rw web.ResponseWriter, req *web.Request) {
 	req.ParseForm()
 	code := req.FormValue("code")
 
-	valid, err := c.totpModule.ValidateRegistration(c.GetUserID(), keyName, token.Secret(), code)
+	valid, err := c.totpModule.ValidateRegistration(c.GetUserID(), keyName, token.Secret(), code, c.GetMeta())
 	if err != nil {
 		log.Printf("TOTPEnrolPost: error validating token registration (%s)", err)
 		c.WriteInternalError(rw)
@@ -191,7 +191,7 @@ func (c *totpAPICtx) TOTPAuthenticatePost(rw web.ResponseWriter, req *web.Reques
 	// Fetch challenge code
 	code := req.FormValue("code")
 
-	ok, err := c.totpModule.ValidateToken(userid, code)
+	ok, err := c.totpModule.ValidateToken(userid, code, c.GetMeta())
 	if err != nil {
 		log.Printf("TOTPAuthenticatePost: error validating totp code (%s)", err)
 		c.WriteInternalError(rw)
@@ -205,7 +205,20 @@ func (c *totpAPICtx) TOTPAuthenticatePost(rw web.ResponseWriter, req *web.Reques
 	}
 
 	log.Printf("TOTPAuthenticatePost: Valid authentication for account %s (action %s)\n", userid, action)
-	c.UserAction(userid, action, rw, req)
+	// Complete the pending 2fa request, completion handlers are run once the request has been verified
+	err = c.Complete2FARequest(rw, req, userid, action, func() error {
+		return c.totpModule.completedHandler.SecondFactorCompleted(userid, action, "totp", c.GetMeta())
+	})
+	if err == appcontext.ErrNoFlowPending {
+		log.Printf("TOTPAuthenticatePost: error completing 2fa request (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+	if err != nil {
+		log.Printf("TOTPAuthenticatePost: error completing action %s (%s)", action, err)
+		c.WriteInternalError(rw)
+		return
+	}
 
 	c.WriteAPIResult(rw, api.SecondFactorSuccess)
 }
@@ -246,7 +259,7 @@ func (c *totpAPICtx) TOTPRemoveToken(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Attempt removal
-	ok, err := c.totpModule.RemoveToken(c.GetUserID(), tokenID)
+	ok, err := c.totpModule.RemoveToken(c.GetUserID(), tokenID, c.GetMeta())
 	if err != nil {
 		c.WriteInternalError(rw)
 		return
@@ -47,5 +47,5 @@ type Storer interface {
 
 // CompletedHandler Callback for 2fa signature completion
 type CompletedHandler interface {
-	SecondFactorCompleted(userid, action string)
+	SecondFactorCompleted(userid, action, factor string, meta map[string]string) error
 }
@@ -49,7 +49,7 @@ func TestTOTPModule(t *testing.T) {
 	mockEventEmitter := test.MockEventEmitter{}
 
 	// Instantiate u2f module
-	totpModule := NewController("localhost", dataStore, &mockEventEmitter)
+	totpModule := NewController("localhost", dataStore, &test.MockCompletedHandler{}, &mockEventEmitter)
 
 	t.Run("Create token", func(t *testing.T) {
 		to, err := totpModule.CreateToken(user.GetExtID())
@@ -71,7 +71,7 @@ func TestTOTPModule(t *testing.T) {
 			t.FailNow()
 		}
 
-		ok, err := totpModule.ValidateRegistration(user.GetExtID(), "test token", token.Secret(), code)
+		ok, err := totpModule.ValidateRegistration(user.GetExtID(), "test token", token.Secret(), code, nil)
 		if err != nil {
 			t.Error(err)
 			t.FailNow()
@@ -100,7 +100,7 @@ func TestTOTPModule(t *testing.T) {
 			t.FailNow()
 		}
 
-		ok, err := totpModule.ValidateToken(user.GetExtID(), code)
+		ok, err := totpModule.ValidateToken(user.GetExtID(), code, nil)
 		if err != nil {
 			t.Error(err)
 			t.FailNow()
@@ -23,17 +23,21 @@ type Controller struct {
 	url      string
 	u2fStore Storer
 	emitter  events.Emitter
+
+	completedHandler CompletedHandler
 }
 
 // NewController creates a new U2F controller
 // U2F tokens are issued against the provided url, the browser will reject any u2f requests not from this domain.
 // A CompletedHandler is required for completion of authorization actions, as well as a Storer to
 // provide underlying storage to the U2F module
-func NewController(url string, u2fStore Storer, emitter events.Emitter) *Controller {
+func NewController(url string, u2fStore Storer, completedHandler CompletedHandler, emitter events.Emitter) *Controller {
 	return &Controller{
 		url:      url,
 		u2fStore: u2fStore,
 		emitter:  emitter,
+
+		completedHandler: completedHandler,
 	}
 }
 
@@ -83,7 +87,7 @@ func (u2fModule *Controller) GetChallenge(userid string) (*u2f.Challenge, error)
 
 // ValidateRegistration Validates and saves a u2f registration
 // Returns ok, err indicating registration validity and forwarding errors
-func (u2fModule *Controller) ValidateRegistration(userid, tokenName string, challenge *u2f.Challenge, resp *u2f.RegisterResponse) (bool, error) {
+func (u2fModule *Controller) ValidateRegistration(userid, tokenName string, challenge *u2f.Challenge, resp *u2f.RegisterResponse, meta map[string]string) (bool, error) {
 
 	// Check registration validity
 	// TODO: attestation should be disabled only in test mode, need a better certificate list
@@ -100,7 +104,8 @@ func (u2fModule *Controller) ValidateRegistration(userid, tokenName string, chal
 		return false, err
 	}
 
-	data := make(map[string]string)
+	data := events.NewDataWithMeta(meta)
+	data["Token Name"] = tokenName
 	u2fModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorU2FAdded, data))
 
 	// Indicate successful registration
@@ -108,7 +113,7 @@ func (u2fModule *Controller) ValidateRegistration(userid, tokenName string, chal
 }
 
 // ValidateSignature validates a u2f signature response
-func (u2fModule *Controller) ValidateSignature(userid string, challenge *u2f.Challenge, resp *u2f.SignResponse) (bool, error) {
+func (u2fModule *Controller) ValidateSignature(userid string, challenge *u2f.Challenge, resp *u2f.SignResponse, meta map[string]string) (bool, error) {
 
 	// Check signature validity
 	reg, err := challenge.Authenticate(*resp)
@@ -148,7 +153,7 @@ func (u2fModule *Controller) ValidateSignature(userid string, challenge *u2f.Cha
 		return false, err
 	}
 
-	data := make(map[string]string)
+	data := events.NewDataWithMeta(meta)
 	data["Token Name"] = token.GetName()
 	u2fModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorU2FUsed, data))
 
@@ -180,7 +185,7 @@ func (u2fModule *Controller) ListTokens(userid string) ([]interface{}, error) {
 }
 
 // RemoveToken removes a token by matching user and token external IDs
-func (u2fModule *Controller) RemoveToken(userid, tokenID string) (bool, error) {
+func (u2fModule *Controller) RemoveToken(userid, tokenID string, meta map[string]string) (bool, error) {
 	tokens, err := u2fModule.u2fStore.GetFidoTokens(userid)
 	if err != nil {
 		log.Printf("U2FModule.RemoveToken: error fetching FIDO tokens (%s)", err)
@@ -194,9 +199,13 @@ func (u2fModule *Controller) RemoveToken(userid, tokenID string) (bool, error) {
 			if err != nil {
 				log.Printf("U2FModule.RemoveToken: error deleting FIDO tokens (%s)", err)
 				return false, err
-			} else {
-				return true, nil
 			}
+
+			data := events.NewDataWithMeta(meta)
+			data["Token Name"] = token.GetName()
+			u2fModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorU2FRemoved, data))
+
+			return true, nil
 		}
 	}
 
@@ -65,11 +65,15 @@ func (u2fModule *Controller) BindAPI(router *web.Router) {
 	u2frouter.Middleware(BindU2FContext(u2fModule))
 
 	// Bind endpoints
-	u2frouter.Get("/enrol", (*u2fApiCtx).EnrolGet)
-	u2frouter.Post("/enrol", (*u2fApiCtx).EnrolPost)
 	u2frouter.Get("/authenticate", (*u2fApiCtx).AuthenticateGet)
 	u2frouter.Post("/authenticate", (*u2fApiCtx).AuthenticatePost)
 	u2frouter.Get("/tokens", (*u2fApiCtx).TokensGet)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := u2frouter.Subrouter(u2fApiCtx{}, "")
+	sudoRouter.Middleware((*u2fApiCtx).RequireSudoMiddleware)
+	sudoRouter.Get("/enrol", (*u2fApiCtx).EnrolGet)
+	sudoRouter.Post("/enrol", (*u2fApiCtx).EnrolPost)
 }
 
 // EnrolGet First stage token enrolment (get) handler
@@ -148,7 +152,7 @@ func (c *u2fApiCtx) EnrolPost(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Validate registration
-	ok, err := c.um.ValidateRegistration(c.GetUserID(), keyName, challenge, &registerResp)
+	ok, err := c.um.ValidateRegistration(c.GetUserID(), keyName, challenge, &registerResp, c.GetMeta())
 	if err != nil {
 		c.WriteInternalError(rw)
 		return
@@ -247,7 +251,7 @@ func (c *u2fApiCtx) AuthenticatePost(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Validate signature
-	ok, err := c.um.ValidateSignature(userid, challenge, &u2fSignResp)
+	ok, err := c.um.ValidateSignature(userid, challenge, &u2fSignResp, c.GetMeta())
 	if err != nil {
 		c.WriteInternalError(rw)
 		return
@@ -259,7 +263,20 @@ func (c *u2fApiCtx) AuthenticatePost(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	log.Printf("AuthenticatePost: Valid authentication for account %s (action %s)\n", userid, action)
-	c.UserAction(userid, action, rw, req)
+	// Complete the pending 2fa request, completion handlers are run once the request has been verified
+	err = c.Complete2FARequest(rw, req, userid, action, func() error {
+		return c.um.completedHandler.SecondFactorCompleted(userid, action, "u2f", c.GetMeta())
+	})
+	if err == appcontext.ErrNoFlowPending {
+		log.Printf("AuthenticatePost: error completing 2fa request (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+	if err != nil {
+		log.Printf("AuthenticatePost: error completing action %s (%s)", action, err)
+		c.WriteInternalError(rw)
+		return
+	}
 	c.WriteAPIResult(rw, api.SecondFactorSuccess)
 }
 
@@ -300,7 +317,7 @@ func (c *u2fApiCtx) RemoveToken(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Attempt removal
-	ok, err := c.um.RemoveToken(c.GetUserID(), tokenID)
+	ok, err := c.um.RemoveToken(c.GetUserID(), tokenID, c.GetMeta())
 	if err != nil {
 		c.WriteInternalError(rw)
 		return
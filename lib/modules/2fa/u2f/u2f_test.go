@@ -47,7 +47,7 @@ func TestU2FModule(t *testing.T) {
 	mockEventEmitter := test.MockEventEmitter{}
 
 	// Instantiate u2f module
-	u2fModule := NewController("localhost", dataStore, &mockEventEmitter)
+	u2fModule := NewController("localhost", dataStore, &test.MockCompletedHandler{}, &mockEventEmitter)
 
 	t.Run("Create challenges", func(t *testing.T) {
 		c, err := u2fModule.GetChallenge(user.GetExtID())
@@ -74,7 +74,7 @@ func TestU2FModule(t *testing.T) {
 			t.FailNow()
 		}
 
-		ok, err := u2fModule.ValidateRegistration(user.GetExtID(), "test token", challenge, resp)
+		ok, err := u2fModule.ValidateRegistration(user.GetExtID(), "test token", challenge, resp, nil)
 		if err != nil {
 			t.Error(err)
 			t.FailNow()
@@ -111,7 +111,7 @@ func TestU2FModule(t *testing.T) {
 			t.FailNow()
 		}
 
-		ok, err := u2fModule.ValidateSignature(user.GetExtID(), challenge, resp)
+		ok, err := u2fModule.ValidateSignature(user.GetExtID(), challenge, resp, nil)
 		if err != nil {
 			t.Error(err)
 			t.FailNow()
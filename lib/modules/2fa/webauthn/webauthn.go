@@ -0,0 +1,484 @@
+/*
+ * WebAuthn / FIDO2 Module Controller implementation
+ * This provides a 2fa interface for binding into the core module as well as helpers to
+ * create and bind a router to the server instance.
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package webauthn
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	webauthn "github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+// Controller WebAuthn controller instance storage
+type Controller struct {
+	webAuthn     *webauthn.WebAuthn
+	appID        string
+	store        Storer
+	emitter      events.Emitter
+	loginHandler LoginHandler
+
+	completedHandler CompletedHandler
+}
+
+// NewController creates a new WebAuthn controller
+// Credentials are issued against the relying party derived from the provided external address. Credentials
+// migrated from the u2f module are authenticated using the same address as their FIDO AppID.
+// A CompletedHandler is required for completion of authorization actions.
+func NewController(name, address string, store Storer, completedHandler CompletedHandler, emitter events.Emitter) (*Controller, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: name,
+		RPID:          u.Hostname(),
+		RPOrigins:     []string{fmt.Sprintf("%s://%s", u.Scheme, u.Host)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Controller{
+		webAuthn: w,
+		appID:    address,
+		store:    store,
+		emitter:  emitter,
+
+		completedHandler: completedHandler,
+	}, nil
+}
+
+// BindLoginHandler binds the handler used to run login hooks for passkey logins
+// Passkey login is disabled until a LoginHandler is bound
+func (wc *Controller) BindLoginHandler(h LoginHandler) {
+	wc.loginHandler = h
+}
+
+// webAuthnUser adapts a user and their credentials to the webauthn.User interface
+type webAuthnUser struct {
+	user        User
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte                         { return []byte(u.user.GetExtID()) }
+func (u *webAuthnUser) WebAuthnName() string                       { return u.user.GetEmail() }
+func (u *webAuthnUser) WebAuthnDisplayName() string                { return u.user.GetUsername() }
+func (u *webAuthnUser) WebAuthnIcon() string                       { return "" }
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// toCredential converts a stored credential into a webauthn credential
+func toCredential(c CredentialInterface) (*webauthn.Credential, error) {
+	id, err := base64.RawURLEncoding.DecodeString(c.GetCredentialID())
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(c.GetPublicKey())
+	if err != nil {
+		return nil, err
+	}
+	aaguid, err := hex.DecodeString(c.GetAAGUID())
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.Credential{
+		ID:              id,
+		PublicKey:       publicKey,
+		AttestationType: c.GetAttestationType(),
+		Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(c.GetFlags())),
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       aaguid,
+			SignCount:    uint32(c.GetSignCount()),
+			CloneWarning: c.GetCloneWarning(),
+		},
+	}, nil
+}
+
+// loadUser fetches a user and their stored credentials
+func (wc *Controller) loadUser(userid string) (*webAuthnUser, []CredentialInterface, error) {
+	u, err := wc.store.GetUserByExtID(userid)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, ok := u.(User)
+	if !ok {
+		return nil, nil, fmt.Errorf("WebAuthnModule.loadUser: invalid user object for user %s", userid)
+	}
+
+	tokens, err := wc.ListTokens(userid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored := make([]CredentialInterface, 0, len(tokens))
+	credentials := make([]webauthn.Credential, 0, len(tokens))
+	for _, t := range tokens {
+		c := t.(CredentialInterface)
+
+		credential, err := toCredential(c)
+		if err != nil {
+			log.Printf("WebAuthnModule.loadUser: error decoding credential %s (%s)", c.GetExtID(), err)
+			continue
+		}
+
+		stored = append(stored, c)
+		credentials = append(credentials, *credential)
+	}
+
+	return &webAuthnUser{user: user, credentials: credentials}, stored, nil
+}
+
+// IsSupported Checks whether webauthn is supported for a given user by userid
+// This is required to implement the generic 2fa interface for binding into the core module.
+func (wc *Controller) IsSupported(userid string) bool {
+	tokens, err := wc.store.GetWebAuthnCredentials(userid)
+	if err != nil {
+		log.Printf("WebAuthnModule.IsSupported error fetching credentials for user %s (%s)", userid, err)
+		return false
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	return true
+}
+
+// GetRegistrationChallenge Builds a credential creation challenge for a given user
+// Existing credentials are excluded to avoid registering the same authenticator twice, passkey
+// registrations require a discoverable (resident) credential with user verification
+func (wc *Controller) GetRegistrationChallenge(userid string, passkey bool) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	user, _, err := wc.loadUser(userid)
+	if err != nil {
+		log.Printf("WebAuthnModule.GetRegistrationChallenge: error loading user %s (%s)", userid, err)
+		return nil, nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, c := range user.credentials {
+		exclusions[i] = c.Descriptor()
+	}
+
+	opts := []webauthn.RegistrationOption{webauthn.WithExclusions(exclusions)}
+	if passkey {
+		opts = append(opts, webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		}))
+	}
+
+	return wc.webAuthn.BeginRegistration(user, opts...)
+}
+
+// ValidateRegistration Validates and saves a webauthn registration
+// Returns ok, err indicating registration validity and forwarding errors
+func (wc *Controller) ValidateRegistration(userid, name string, passkey bool, session *webauthn.SessionData, resp *protocol.ParsedCredentialCreationData, meta map[string]string) (bool, error) {
+	user, _, err := wc.loadUser(userid)
+	if err != nil {
+		log.Printf("WebAuthnModule.ValidateRegistration: error loading user %s (%s)", userid, err)
+		return false, err
+	}
+
+	// Check registration validity
+	credential, err := wc.webAuthn.CreateCredential(user, *session, resp)
+	if err != nil {
+		log.Printf("WebAuthnModule.ValidateRegistration: registration validation failed (%s)", err)
+		return false, nil
+	}
+
+	// Create and save credential
+	_, err = wc.store.AddWebAuthnCredential(userid, name,
+		base64.RawURLEncoding.EncodeToString(credential.ID),
+		base64.StdEncoding.EncodeToString(credential.PublicKey),
+		credential.AttestationType,
+		hex.EncodeToString(credential.Authenticator.AAGUID),
+		uint(credential.Authenticator.SignCount),
+		uint(credential.Flags.ProtocolValue()),
+		passkey)
+	if err != nil {
+		log.Printf("WebAuthnModule.ValidateRegistration: error storing registration (%s)", err)
+		return false, err
+	}
+
+	data := events.NewDataWithMeta(meta)
+	data["Token Name"] = name
+	wc.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorWebAuthnAdded, data))
+
+	// Indicate successful registration
+	return true, nil
+}
+
+// GetChallenge Builds a webauthn authentication challenge for a given user
+// The FIDO AppID extension is requested where the user has credentials migrated from the u2f module
+func (wc *Controller) GetChallenge(userid string) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	user, stored, err := wc.loadUser(userid)
+	if err != nil {
+		log.Printf("WebAuthnModule.GetChallenge: error loading user %s (%s)", userid, err)
+		return nil, nil, err
+	}
+
+	opts := make([]webauthn.LoginOption, 0)
+	for _, c := range stored {
+		if c.IsLegacyU2F() {
+			opts = append(opts, webauthn.WithAssertionExtensions(protocol.AuthenticationExtensions{"appid": wc.appID}))
+			break
+		}
+	}
+
+	return wc.webAuthn.BeginLogin(user, opts...)
+}
+
+// ValidateSignature validates a webauthn assertion response
+func (wc *Controller) ValidateSignature(userid string, session *webauthn.SessionData, resp *protocol.ParsedCredentialAssertionData, meta map[string]string) (bool, error) {
+	user, stored, err := wc.loadUser(userid)
+	if err != nil {
+		log.Printf("WebAuthnModule.ValidateSignature: error loading user %s (%s)", userid, err)
+		return false, err
+	}
+
+	// Check assertion validity
+	credential, err := wc.webAuthn.ValidateLogin(user, *session, resp)
+	if err != nil {
+		log.Printf("WebAuthnModule.ValidateSignature: assertion validation failed (%s)", err)
+		return false, nil
+	}
+
+	// Locate matching credential
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+	var token CredentialInterface
+	for _, c := range stored {
+		if c.GetCredentialID() == credentialID {
+			token = c
+		}
+	}
+	if token == nil {
+		log.Printf("WebAuthnModule.ValidateSignature: matching credential not found for user %s", userid)
+		return false, nil
+	}
+
+	return wc.updateCredential(userid, token, credential, meta)
+}
+
+// GetLoginChallenge Builds a challenge for username-less login with a discoverable credential (passkey)
+func (wc *Controller) GetLoginChallenge() (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return wc.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+}
+
+// ValidateLogin validates a discoverable credential assertion
+// The user is identified by the user handle returned by the authenticator, returns the user id and
+// ok flag indicating assertion validity
+func (wc *Controller) ValidateLogin(session *webauthn.SessionData, resp *protocol.ParsedCredentialAssertionData, meta map[string]string) (string, bool, error) {
+	var stored []CredentialInterface
+
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, credentials, err := wc.loadUser(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		stored = credentials
+		return user, nil
+	}
+
+	userid := string(resp.Response.UserHandle)
+
+	// Check assertion validity
+	credential, err := wc.webAuthn.ValidateDiscoverableLogin(handler, *session, resp)
+	if err != nil {
+		log.Printf("WebAuthnModule.ValidateLogin: assertion validation failed (%s)", err)
+		return "", false, wc.loginFailure(userid, meta)
+	}
+
+	// Locate matching credential
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+	var token CredentialInterface
+	for _, c := range stored {
+		if c.GetCredentialID() == credentialID {
+			token = c
+		}
+	}
+	if token == nil {
+		log.Printf("WebAuthnModule.ValidateLogin: matching credential not found for user %s", userid)
+		return "", false, wc.loginFailure(userid, meta)
+	}
+
+	ok, err := wc.updateCredential(userid, token, credential, meta)
+	if err != nil {
+		return "", false, err
+	}
+	if !ok {
+		return "", false, wc.loginFailure(userid, meta)
+	}
+
+	return userid, true, nil
+}
+
+// loginFailure runs the bound login failure hooks for a failed passkey assertion
+// The user is nil where the assertion does not identify a known account
+func (wc *Controller) loginFailure(userid string, meta map[string]string) error {
+	if wc.loginHandler == nil {
+		return nil
+	}
+
+	var u interface{}
+	if userid != "" {
+		user, err := wc.store.GetUserByExtID(userid)
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			u = user
+		}
+	}
+
+	return wc.loginHandler.PostLoginFailure(u, meta)
+}
+
+// CompleteLogin runs the bound login hooks for a passkey authenticated user with the provided request metadata
+// Logins are checked with the same PreLogin and login risk hooks as password logins. Passkeys require user
+// verification so satisfy a second factor requirement, logins that are blocked or require email confirmation
+// return the required action without running PostLoginSuccess hooks.
+// Logins from devices trusted by the user (identified by the provided device token) are marked in the metadata,
+// exempting them from lockouts caused by failures from other sources as for password logins.
+// Returns the risk action (RiskActionAllow where the login is rejected prior to risk checks) and ok, err
+// indicating whether the login is permitted
+func (wc *Controller) CompleteLogin(userid, deviceToken string, meta map[string]string) (api.RiskAction, bool, error) {
+	if wc.loginHandler == nil {
+		log.Printf("WebAuthnModule.CompleteLogin: no login handler bound, passkey login disabled")
+		return api.RiskActionAllow, false, nil
+	}
+
+	u, err := wc.store.GetUserByExtID(userid)
+	if err != nil {
+		return api.RiskActionAllow, false, err
+	}
+
+	if meta != nil && wc.loginHandler.IsTrustedDevice(userid, deviceToken) {
+		meta[appcontext.TrustedDeviceMeta] = "true"
+	}
+
+	ok, err := wc.loginHandler.PreLogin(u, meta)
+	if err != nil || !ok {
+		return api.RiskActionAllow, false, err
+	}
+
+	action, err := wc.loginHandler.CheckLoginRisk(u, meta)
+	if err != nil {
+		return api.RiskActionBlock, false, err
+	}
+	if action == api.RiskActionBlock || action == api.RiskActionEmailConfirmation {
+		log.Printf("WebAuthnModule.CompleteLogin: passkey login for user %s flagged (%s)", userid, action)
+		return action, false, nil
+	}
+
+	err = wc.loginHandler.PostLoginSuccess(u, meta)
+	if err != nil {
+		return action, false, err
+	}
+
+	return action, true, nil
+}
+
+// StartLoginConfirmation starts email confirmation of a flagged passkey login
+// Returns the email address for the user, the confirmation link is completed via the core email login endpoint
+func (wc *Controller) StartLoginConfirmation(userid string, meta map[string]string) (string, error) {
+	u, err := wc.store.GetUserByExtID(userid)
+	if err != nil {
+		return "", err
+	}
+	if u == nil {
+		return "", fmt.Errorf("WebAuthnModule.StartLoginConfirmation: user %s not found", userid)
+	}
+	user := u.(User)
+
+	wc.loginHandler.LoginConfirmStart(userid, meta)
+
+	return user.GetEmail(), nil
+}
+
+// updateCredential updates a stored credential following a successful assertion
+// Credentials with counter regressions are flagged and rejected as they may have been cloned
+func (wc *Controller) updateCredential(userid string, token CredentialInterface, credential *webauthn.Credential, meta map[string]string) (bool, error) {
+	if credential.Authenticator.CloneWarning {
+		log.Printf("WebAuthnModule.updateCredential: sign counter regression for credential %s (user %s)", token.GetExtID(), userid)
+		token.SetCloneWarning(true)
+		_, err := wc.store.UpdateWebAuthnCredential(token)
+		return false, err
+	}
+
+	// Update credential instance
+	token.SetSignCount(uint(credential.Authenticator.SignCount))
+	token.SetFlags(uint(credential.Flags.ProtocolValue()))
+	token.SetLastUsed(time.Now())
+
+	// Save updated credential
+	_, err := wc.store.UpdateWebAuthnCredential(token)
+	if err != nil {
+		log.Printf("WebAuthnModule.updateCredential: error updating credential object (%s)", err)
+		return false, err
+	}
+
+	data := events.NewDataWithMeta(meta)
+	data["Token Name"] = token.GetName()
+	wc.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorWebAuthnUsed, data))
+
+	// Indicate successful authentication
+	return true, nil
+}
+
+// ListTokens lists webauthn credentials for a given user
+func (wc *Controller) ListTokens(userid string) ([]interface{}, error) {
+	// Fetch credentials from database
+	tokens, err := wc.store.GetWebAuthnCredentials(userid)
+	if err != nil {
+		log.Printf("WebAuthnModule.ListTokens: error fetching credentials (%s)", err)
+		return make([]interface{}, 0), err
+	}
+
+	return tokens, nil
+}
+
+// RemoveToken removes a credential by matching user and credential external IDs
+func (wc *Controller) RemoveToken(userid, tokenID string, meta map[string]string) (bool, error) {
+	tokens, err := wc.store.GetWebAuthnCredentials(userid)
+	if err != nil {
+		log.Printf("WebAuthnModule.RemoveToken: error fetching credentials (%s)", err)
+		return false, err
+	}
+
+	for _, t := range tokens {
+		token := t.(CredentialInterface)
+		if token.GetExtID() != tokenID {
+			continue
+		}
+
+		err := wc.store.RemoveWebAuthnCredential(token)
+		if err != nil {
+			log.Printf("WebAuthnModule.RemoveToken: error deleting credential (%s)", err)
+			return false, err
+		}
+
+		data := events.NewDataWithMeta(meta)
+		data["Token Name"] = token.GetName()
+		wc.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorWebAuthnRemoved, data))
+
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,459 @@
+/*
+ * WebAuthn / FIDO2 Module API implementation
+ * This provides WebAuthn endpoints for credential registration, authentication and management
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package webauthn
+
+import (
+	"encoding/gob"
+	"log"
+	"net/http"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	webauthn "github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+)
+
+const (
+	webAuthnRegisterSessionKey string = "webauthn-register-session"
+	webAuthnSignSessionKey     string = "webauthn-sign-session"
+	webAuthnLoginSessionKey    string = "webauthn-login-session"
+	webAuthnRegisterDataKey    string = "webauthn-register-data"
+	webAuthnRegisterNameKey    string = "webauthn-register-name"
+	webAuthnRegisterPasskeyKey string = "webauthn-register-passkey"
+	webAuthnLoginDataKey       string = "webauthn-login-data"
+	webAuthnSignDataKey        string = "webauthn-sign-data"
+	webAuthnSignUserIDKey      string = "webauthn-sign-userid"
+	webAuthnSignActionKey      string = "webauthn-sign-action"
+
+	webAuthnRegisterMaxAge = 60 * 10
+	webAuthnSignMaxAge     = 60 * 10
+	webAuthnLoginMaxAge    = 60 * 5
+)
+
+// webAuthnAPICtx context storage for router instance
+type webAuthnAPICtx struct {
+	// Base context for shared components
+	*appcontext.AuthPlzCtx
+
+	// WebAuthn controller module
+	wm *Controller
+}
+
+// Initialise serialisation of webauthn session objects
+func init() {
+	gob.Register(&webauthn.SessionData{})
+}
+
+// BindWebAuthnContext Helper middleware to bind module to API context
+func BindWebAuthnContext(webAuthnModule *Controller) func(ctx *webAuthnAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	return func(ctx *webAuthnAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		ctx.wm = webAuthnModule
+		next(rw, req)
+	}
+}
+
+// BindAPI Binds the API for the webauthn module to the provided router
+func (wc *Controller) BindAPI(router *web.Router) {
+	// Create router for webauthn module
+	webAuthnRouter := router.Subrouter(webAuthnAPICtx{}, "/api/webauthn")
+
+	// Attach module context
+	webAuthnRouter.Middleware(BindWebAuthnContext(wc))
+
+	// Bind endpoints
+	webAuthnRouter.Get("/authenticate", (*webAuthnAPICtx).AuthenticateGet)
+	webAuthnRouter.Post("/authenticate", (*webAuthnAPICtx).AuthenticatePost)
+	webAuthnRouter.Get("/login", (*webAuthnAPICtx).LoginGet)
+	webAuthnRouter.Post("/login", (*webAuthnAPICtx).LoginPost)
+	webAuthnRouter.Get("/tokens", (*webAuthnAPICtx).TokensGet)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := webAuthnRouter.Subrouter(webAuthnAPICtx{}, "")
+	sudoRouter.Middleware((*webAuthnAPICtx).RequireSudoMiddleware)
+	sudoRouter.Get("/enrol", (*webAuthnAPICtx).EnrolGet)
+	sudoRouter.Post("/enrol", (*webAuthnAPICtx).EnrolPost)
+	sudoRouter.Post("/remove", (*webAuthnAPICtx).RemoveToken)
+}
+
+// EnrolGet First stage credential enrolment (get) handler
+// This creates and caches a challenge for a credential to be registered, passkey=true requests a
+// discoverable credential that can be used for username-less login
+func (c *webAuthnAPICtx) EnrolGet(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	tokenName := req.URL.Query().Get("name")
+	if tokenName == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.TokenNameRequired)
+		return
+	}
+
+	passkey := req.URL.Query().Get("passkey") == "true"
+
+	// Build registration challenge
+	options, sessionData, err := c.wm.GetRegistrationChallenge(c.GetUserID(), passkey)
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	// Save to session
+	session, err := c.GetNamedSession(rw, req, webAuthnRegisterSessionKey)
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+	session.Values[webAuthnRegisterDataKey] = sessionData
+	session.Values[webAuthnRegisterNameKey] = tokenName
+	session.Values[webAuthnRegisterPasskeyKey] = passkey
+	session.Options.MaxAge = webAuthnRegisterMaxAge
+	session.Save(req.Request, rw)
+
+	log.Println("webauthn.EnrolGet: Fetched enrolment challenge")
+
+	// Return challenge to user
+	c.WriteJSON(rw, *options)
+}
+
+// EnrolPost Second stage credential enrolment (post) handler
+// This checks the cached challenge and completes credential enrolment
+func (c *webAuthnAPICtx) EnrolPost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	// Fetch request from session vars
+	session, err := c.GetNamedSession(rw, req, webAuthnRegisterSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	session.Options.MaxAge = -1
+	session.Save(req.Request, rw)
+
+	sessionData, sessionDataOK := session.Values[webAuthnRegisterDataKey].(*webauthn.SessionData)
+	keyName, keyNameOK := session.Values[webAuthnRegisterNameKey].(string)
+	passkey, _ := session.Values[webAuthnRegisterPasskeyKey].(bool)
+	if !sessionDataOK || !keyNameOK {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Parse JSON response body
+	registerResp, err := protocol.ParseCredentialCreationResponseBody(req.Body)
+	if err != nil {
+		log.Printf("webauthn.EnrolPost: error decoding registration response (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorBadResponse)
+		return
+	}
+
+	// Validate registration
+	ok, err := c.wm.ValidateRegistration(c.GetUserID(), keyName, passkey, sessionData, registerResp, c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("WebAuthn enrolment failed for user %s\n", c.GetUserID())
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorFailed)
+		return
+	}
+
+	log.Printf("Enrolled WebAuthn credential for account %s\n", c.GetUserID())
+	c.WriteAPIResult(rw, api.SecondFactorSuccess)
+}
+
+// AuthenticateGet Fetches an authentication challenge
+// This grabs a pending 2fa userid and action from the 2fa request session
+func (c *webAuthnAPICtx) AuthenticateGet(rw web.ResponseWriter, req *web.Request) {
+	signSession, err := c.GetNamedSession(rw, req, webAuthnSignSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch challenge user ID
+	userid, action := c.Get2FARequest(rw, req)
+	if userid == "" || action == "" {
+		log.Printf("webauthn.AuthenticateGet No pending 2fa requests found")
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	log.Printf("webauthn.AuthenticateGet Authentication request for user %s (action %s)", userid, action)
+
+	// Generate challenge
+	options, sessionData, err := c.wm.GetChallenge(userid)
+	if err != nil {
+		log.Printf("webauthn.AuthenticateGet error building webauthn challenge %s", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	// Save to session vars
+	signSession.Values[webAuthnSignDataKey] = sessionData
+	signSession.Values[webAuthnSignUserIDKey] = userid
+	signSession.Values[webAuthnSignActionKey] = action
+	signSession.Options.MaxAge = webAuthnSignMaxAge
+	signSession.Save(req.Request, rw)
+
+	// Write challenge to user
+	c.WriteJSON(rw, *options)
+}
+
+// AuthenticatePost Post authentication response to complete authentication
+func (c *webAuthnAPICtx) AuthenticatePost(rw web.ResponseWriter, req *web.Request) {
+	signSession, err := c.GetNamedSession(rw, req, webAuthnSignSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch request from session vars
+	sessionData, sessionDataOK := signSession.Values[webAuthnSignDataKey].(*webauthn.SessionData)
+	userid, useridOK := signSession.Values[webAuthnSignUserIDKey].(string)
+	action, actionOK := signSession.Values[webAuthnSignActionKey].(string)
+
+	// Clear session vars
+	signSession.Options.MaxAge = -1
+	signSession.Save(req.Request, rw)
+
+	if !sessionDataOK || !useridOK || !actionOK || userid == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	log.Printf("webauthn.AuthenticatePost for user %s (action %s)", userid, action)
+
+	// Parse JSON response body
+	signResp, err := protocol.ParseCredentialRequestResponseBody(req.Body)
+	if err != nil {
+		log.Printf("webauthn.AuthenticatePost: error decoding assertion response (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorBadResponse)
+		return
+	}
+
+	// Validate signature
+	ok, err := c.wm.ValidateSignature(userid, sessionData, signResp, c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("webauthn.AuthenticatePost: authentication failed for user %s\n", userid)
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.SecondFactorFailed)
+		return
+	}
+
+	log.Printf("webauthn.AuthenticatePost: Valid authentication for account %s (action %s)\n", userid, action)
+	// Complete the pending 2fa request, completion handlers are run once the request has been verified
+	err = c.Complete2FARequest(rw, req, userid, action, func() error {
+		return c.wm.completedHandler.SecondFactorCompleted(userid, action, "webauthn", c.GetMeta())
+	})
+	if err == appcontext.ErrNoFlowPending {
+		log.Printf("webauthn.AuthenticatePost: error completing 2fa request (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+	if err != nil {
+		log.Printf("webauthn.AuthenticatePost: error completing action %s (%s)", action, err)
+		c.WriteInternalError(rw)
+		return
+	}
+	c.WriteAPIResult(rw, api.SecondFactorSuccess)
+}
+
+// LoginGet Fetches a challenge for username-less login using a passkey
+func (c *webAuthnAPICtx) LoginGet(rw web.ResponseWriter, req *web.Request) {
+	// Check user is not already logged in
+	if c.GetUserID() != "" {
+		c.WriteAPIResult(rw, api.AlreadyAuthenticated)
+		return
+	}
+
+	// Generate challenge
+	options, sessionData, err := c.wm.GetLoginChallenge()
+	if err != nil {
+		log.Printf("webauthn.LoginGet error building webauthn challenge %s", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	// Save to session vars
+	loginSession, err := c.GetNamedSession(rw, req, webAuthnLoginSessionKey)
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+	loginSession.Values[webAuthnLoginDataKey] = sessionData
+	loginSession.Options.MaxAge = webAuthnLoginMaxAge
+	loginSession.Save(req.Request, rw)
+
+	// Write challenge to user
+	c.WriteJSON(rw, *options)
+}
+
+// LoginPost Completes a username-less login using a passkey
+// This runs the same login and risk hooks as password login, passkeys require user verification so
+// further second factors are not requested. Flagged logins may be blocked or require email confirmation.
+func (c *webAuthnAPICtx) LoginPost(rw web.ResponseWriter, req *web.Request) {
+	// Check user is not already logged in
+	if c.GetUserID() != "" {
+		c.WriteAPIResult(rw, api.AlreadyAuthenticated)
+		return
+	}
+
+	loginSession, err := c.GetNamedSession(rw, req, webAuthnLoginSessionKey)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Fetch and clear challenge from session vars
+	sessionData, sessionDataOK := loginSession.Values[webAuthnLoginDataKey].(*webauthn.SessionData)
+	loginSession.Options.MaxAge = -1
+	loginSession.Save(req.Request, rw)
+
+	if !sessionDataOK {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	// Parse JSON response body
+	loginResp, err := protocol.ParseCredentialRequestResponseBody(req.Body)
+	if err != nil {
+		log.Printf("webauthn.LoginPost: error decoding assertion response (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorBadResponse)
+		return
+	}
+
+	// Validate assertion and identify user
+	userid, ok, err := c.wm.ValidateLogin(sessionData, loginResp, c.GetMeta())
+	if err != nil {
+		log.Printf("webauthn.LoginPost: error validating assertion (%s)", err)
+		c.writeLoginError(rw, err)
+		return
+	}
+	if !ok {
+		log.Printf("webauthn.LoginPost: passkey authentication failed")
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	// Run login hooks
+	action, ok, err := c.wm.CompleteLogin(userid, c.GetTrustedDeviceToken(req), c.GetMeta())
+	if err != nil {
+		log.Printf("webauthn.LoginPost: login hook error for user %s (%s)", userid, err)
+		c.writeLoginError(rw, err)
+		return
+	}
+	if !ok {
+		switch action {
+		case api.RiskActionEmailConfirmation:
+			c.startLoginConfirmation(rw, req, userid)
+		case api.RiskActionBlock:
+			log.Printf("webauthn.LoginPost: login risk handler blocked login for user %s", userid)
+			c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.LoginBlocked)
+		default:
+			log.Printf("webauthn.LoginPost: login blocked for user %s", userid)
+			c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.AccountLocked)
+		}
+		return
+	}
+
+	log.Printf("webauthn.LoginPost: Passkey login OK for user %s", userid)
+
+	// Create session
+	c.LoginUser(userid, rw, req, appcontext.LoginMethodHardwareKey)
+	c.WriteAPIResult(rw, api.LoginSuccessful)
+}
+
+// startLoginConfirmation requests email confirmation of a flagged passkey login
+// The confirmation link is bound to the current session and completed via the core email login endpoint
+func (c *webAuthnAPICtx) startLoginConfirmation(rw web.ResponseWriter, req *web.Request, userid string) {
+	email, err := c.wm.StartLoginConfirmation(userid, c.GetMeta())
+	if err != nil {
+		log.Printf("webauthn.LoginPost: error starting login confirmation for user %s (%s)", userid, err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	log.Printf("webauthn.LoginPost: Partial login (email confirmation required)")
+
+	c.BindLoginEmail(rw, req, email)
+	c.WriteAPIResultWithCode(rw, http.StatusAccepted, api.LoginConfirmRequired)
+}
+
+// writeLoginError writes the response for login hook errors
+// Rate limited logins receive a 429 response, other errors are internal errors
+func (c *webAuthnAPICtx) writeLoginError(rw web.ResponseWriter, err error) {
+	if rle, ok := err.(*api.RateLimitError); ok {
+		c.WriteRateLimited(rw, rle.RetryAfter)
+		return
+	}
+	c.WriteInternalError(rw)
+}
+
+// TokensGet Lists webauthn credentials for the logged in user
+func (c *webAuthnAPICtx) TokensGet(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	// Fetch credentials
+	tokens, err := c.wm.ListTokens(c.GetUserID())
+	if err != nil {
+		log.Printf("webauthn.TokensGet error fetching credentials %s", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	// Write credentials out
+	c.WriteJSON(rw, tokens)
+}
+
+// RemoveToken removes a provided credential
+func (c *webAuthnAPICtx) RemoveToken(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	// Fetch credential ID
+	tokenID := req.FormValue("id")
+	if tokenID == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	// Attempt removal
+	ok, err := c.wm.RemoveToken(c.GetUserID(), tokenID, c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	// Write response
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusNotFound, api.SecondFactorNotFound)
+		return
+	}
+	c.WriteAPIResult(rw, api.WebAuthnCredentialRemoved)
+}
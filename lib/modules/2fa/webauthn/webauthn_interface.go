@@ -0,0 +1,74 @@
+/*
+ * WebAuthn / FIDO2 Module API interfaces
+ * This defines the interfaces required to use the webauthn module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package webauthn
+
+import (
+	"time"
+
+	"github.com/authplz/authplz-core/lib/api"
+)
+
+// CredentialInterface Credential instance interface
+// This must be implemented by the credential storage implementation
+type CredentialInterface interface {
+	GetExtID() string
+	GetName() string
+	GetCredentialID() string
+	GetPublicKey() string
+	GetAttestationType() string
+	GetAAGUID() string
+	GetSignCount() uint
+	SetSignCount(uint)
+	GetFlags() uint
+	SetFlags(uint)
+	GetCloneWarning() bool
+	SetCloneWarning(bool)
+	IsDiscoverable() bool
+	IsLegacyU2F() bool
+	GetLastUsed() time.Time
+	SetLastUsed(time.Time)
+}
+
+// User interface required by the webauthn module
+type User interface {
+	GetExtID() string
+	GetEmail() string
+	GetUsername() string
+}
+
+// Storer WebAuthn credential store interface
+// This must be implemented by a storage module to provide persistence to the module
+type Storer interface {
+	// Fetch a user instance by user id
+	GetUserByExtID(userid string) (interface{}, error)
+	// Add a webauthn credential to a given user
+	AddWebAuthnCredential(userid, name, credentialID, publicKey, attestationType, aaguid string, signCount, flags uint, discoverable bool) (interface{}, error)
+	// Fetch webauthn credentials for a given user
+	GetWebAuthnCredentials(userid string) ([]interface{}, error)
+	// Update a provided webauthn credential
+	UpdateWebAuthnCredential(credential interface{}) (interface{}, error)
+	// Remove the provided webauthn credential
+	RemoveWebAuthnCredential(credential interface{}) error
+}
+
+// LoginHandler Hooks for passwordless (passkey) login
+// This is implemented by the core module to apply the same checks used for password logins
+type LoginHandler interface {
+	PreLogin(u interface{}, meta map[string]string) (bool, error)
+	CheckLoginRisk(u interface{}, meta map[string]string) (api.RiskAction, error)
+	PostLoginSuccess(u interface{}, meta map[string]string) error
+	PostLoginFailure(u interface{}, meta map[string]string) error
+	LoginConfirmStart(userid string, meta map[string]string)
+	IsTrustedDevice(userid, deviceToken string) bool
+}
+
+// CompletedHandler Callback for 2fa signature completion
+type CompletedHandler interface {
+	SecondFactorCompleted(userid, action, factor string, meta map[string]string) error
+}
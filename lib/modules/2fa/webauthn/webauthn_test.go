@@ -0,0 +1,308 @@
+/*
+ * WebAuthn Module tests
+ * This defines webauthn module tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package webauthn
+
+import (
+	"testing"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/test"
+
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+type mockLoginHandler struct {
+	allow     bool
+	risk      api.RiskAction
+	preLogin  bool
+	postLogin bool
+	failures  int
+	confirm   bool
+	trusted   bool
+	meta      map[string]string
+}
+
+func (h *mockLoginHandler) PreLogin(u interface{}, meta map[string]string) (bool, error) {
+	h.preLogin = true
+	h.meta = meta
+	return h.allow, nil
+}
+
+func (h *mockLoginHandler) CheckLoginRisk(u interface{}, meta map[string]string) (api.RiskAction, error) {
+	if h.risk == "" {
+		return api.RiskActionAllow, nil
+	}
+	return h.risk, nil
+}
+
+func (h *mockLoginHandler) PostLoginSuccess(u interface{}, meta map[string]string) error {
+	h.postLogin = true
+	return nil
+}
+
+func (h *mockLoginHandler) PostLoginFailure(u interface{}, meta map[string]string) error {
+	h.failures++
+	return nil
+}
+
+func (h *mockLoginHandler) LoginConfirmStart(userid string, meta map[string]string) {
+	h.confirm = true
+}
+
+func (h *mockLoginHandler) IsTrustedDevice(userid, deviceToken string) bool {
+	return h.trusted && deviceToken != ""
+}
+
+func TestWebAuthnModule(t *testing.T) {
+	var fakeEmail = "test@abc.com"
+	var fakePass = "abcDEF123@abcDEF123@"
+	var fakeName = "user.sdfsfdF"
+
+	c, _ := config.DefaultConfig()
+
+	// Attempt database connection
+	dataStore, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error("Error opening database")
+		t.FailNow()
+	}
+
+	// Force synchronization
+	dataStore.ForceSync()
+
+	// Create user for tests
+	u, err := dataStore.AddUser(fakeEmail, fakeName, fakePass)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user := u.(*datastore.User)
+
+	mockEventEmitter := test.MockEventEmitter{}
+
+	// Instantiate webauthn module
+	webAuthnModule, err := NewController("AuthPlz", "https://localhost:9000", dataStore, &test.MockCompletedHandler{}, &mockEventEmitter)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	t.Run("Users without credentials are not supported", func(t *testing.T) {
+		if webAuthnModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected webauthn support for user without credentials")
+		}
+	})
+
+	t.Run("Create registration challenge", func(t *testing.T) {
+		options, session, err := webAuthnModule.GetRegistrationChallenge(user.GetExtID(), false)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if options == nil || session == nil {
+			t.Errorf("Registration challenge is nil")
+			t.FailNow()
+		}
+		if options.Response.RelyingParty.ID != "localhost" {
+			t.Errorf("Unexpected relying party ID %s", options.Response.RelyingParty.ID)
+		}
+		if string(session.UserID) != user.GetExtID() {
+			t.Errorf("Registration session user mismatch")
+		}
+	})
+
+	t.Run("Create passkey registration challenge", func(t *testing.T) {
+		options, _, err := webAuthnModule.GetRegistrationChallenge(user.GetExtID(), true)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if options.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+			t.Errorf("Passkey registration does not require a resident key")
+		}
+	})
+
+	t.Run("Create passkey login challenge", func(t *testing.T) {
+		options, session, err := webAuthnModule.GetLoginChallenge()
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(options.Response.AllowedCredentials) != 0 {
+			t.Errorf("Passkey login challenge should not list credentials")
+		}
+		if session.UserVerification != protocol.VerificationRequired {
+			t.Errorf("Passkey login does not require user verification")
+		}
+	})
+
+	t.Run("Passkey logins require a login handler", func(t *testing.T) {
+		_, ok, err := webAuthnModule.CompleteLogin(user.GetExtID(), "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Passkey login completed without login handler")
+		}
+	})
+
+	handler := mockLoginHandler{allow: false}
+	webAuthnModule.BindLoginHandler(&handler)
+
+	t.Run("Passkey logins run login handlers", func(t *testing.T) {
+		_, ok, err := webAuthnModule.CompleteLogin(user.GetExtID(), "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok || !handler.preLogin || handler.postLogin {
+			t.Errorf("Passkey login not blocked by PreLogin handler")
+		}
+
+		handler.allow = true
+		_, ok, err = webAuthnModule.CompleteLogin(user.GetExtID(), "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok || !handler.postLogin {
+			t.Errorf("Passkey login did not run PostLoginSuccess handler")
+		}
+	})
+
+	t.Run("Passkey logins run login risk handlers", func(t *testing.T) {
+		for _, risk := range []api.RiskAction{api.RiskActionBlock, api.RiskActionEmailConfirmation} {
+			handler.risk = risk
+			handler.postLogin = false
+
+			action, ok, err := webAuthnModule.CompleteLogin(user.GetExtID(), "", nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok || action != risk || handler.postLogin {
+				t.Errorf("Passkey login not stopped for risk action %s", risk)
+			}
+		}
+
+		email, err := webAuthnModule.StartLoginConfirmation(user.GetExtID(), nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if email != fakeEmail || !handler.confirm {
+			t.Errorf("Passkey login confirmation not started")
+		}
+
+		handler.risk = api.RiskActionSecondFactor
+		_, ok, err := webAuthnModule.CompleteLogin(user.GetExtID(), "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Passkey login did not satisfy second factor requirement")
+		}
+		handler.risk = api.RiskActionAllow
+	})
+
+	t.Run("Passkey logins from trusted devices are marked for login handlers", func(t *testing.T) {
+		_, _, err := webAuthnModule.CompleteLogin(user.GetExtID(), "", map[string]string{})
+		if err != nil {
+			t.Error(err)
+		}
+		if handler.meta[appcontext.TrustedDeviceMeta] != "" {
+			t.Errorf("Passkey login without device token marked as trusted")
+		}
+
+		handler.trusted = true
+		_, ok, err := webAuthnModule.CompleteLogin(user.GetExtID(), "fake-device-token", map[string]string{})
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok || handler.meta[appcontext.TrustedDeviceMeta] != "true" {
+			t.Errorf("Passkey login from trusted device not marked as trusted")
+		}
+		handler.trusted = false
+	})
+
+	t.Run("Failed passkey logins run login failure handlers", func(t *testing.T) {
+		err := webAuthnModule.loginFailure(user.GetExtID(), nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if handler.failures != 1 {
+			t.Errorf("Passkey login failure did not run PostLoginFailure handler")
+		}
+	})
+
+	t.Run("Legacy U2F tokens are migrated", func(t *testing.T) {
+		_, err := dataStore.AddFidoToken(user.GetExtID(), "legacy", "a2V5LWhhbmRsZQ", "BHB1YmxpYy1rZXk", "", 0)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		err = dataStore.MigrateFidoTokens()
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !webAuthnModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Expected webauthn support following migration")
+		}
+	})
+
+	t.Run("Authentication challenges request the AppID extension for legacy credentials", func(t *testing.T) {
+		options, session, err := webAuthnModule.GetChallenge(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(options.Response.AllowedCredentials) != 1 {
+			t.Errorf("Expected 1 allowed credential, received %d", len(options.Response.AllowedCredentials))
+		}
+		if session.Extensions["appid"] != "https://localhost:9000" {
+			t.Errorf("AppID extension not found in session (%+v)", session.Extensions)
+		}
+	})
+
+	t.Run("Remove credentials", func(t *testing.T) {
+		tokens, err := webAuthnModule.ListTokens(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(tokens) != 1 {
+			t.Errorf("Expected 1 token, receved %d tokens", len(tokens))
+			t.FailNow()
+		}
+
+		ok, err := webAuthnModule.RemoveToken(user.GetExtID(), tokens[0].(CredentialInterface).GetExtID(), nil)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !ok {
+			t.Errorf("Credential removal failed")
+		}
+
+		fidoTokens, err := dataStore.GetFidoTokens(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(fidoTokens) != 0 {
+			t.Errorf("Legacy token not removed with migrated credential")
+		}
+		if webAuthnModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected webauthn support after credential removal")
+		}
+	})
+
+}
@@ -0,0 +1,387 @@
+/*
+ * Yubikey OTP Module Controller
+ * This defines the Yubikey OTP module controller
+ * OTPs are validated locally against per-token AES keys, no validation server is used.
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package yubikey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/events"
+
+	"github.com/gocraft/web"
+)
+
+// Context used to derive the secret encryption key from the application secret
+const secretKeyContext = "authplz-yubikey-secret"
+
+var (
+	// ErrInvalidSecret returned when a token AES key or private ID is malformed
+	ErrInvalidSecret = errors.New("yubikey: invalid token secret")
+	// ErrUnauthorized returned when a non-admin user attempts an admin enrolment
+	ErrUnauthorized = errors.New("yubikey: admin privileges required")
+)
+
+// Controller Yubikey OTP controller instance
+type Controller struct {
+	store   Storer
+	emitter events.Emitter
+	aead    cipher.AEAD
+
+	completedHandler CompletedHandler
+}
+
+// NewController creates a new Yubikey OTP controller
+// The provided secret is used to derive a key to encrypt token secrets at rest. A CompletedHandler
+// is required for completion of authorization actions, and a Storer provides underlying storage to the module
+func NewController(secret string, store Storer, completedHandler CompletedHandler, emitter events.Emitter) (*Controller, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("yubikey: secret required")
+	}
+
+	// Derive secret encryption key
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(secretKeyContext))
+	key := mac.Sum(nil)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Controller{
+		store:   store,
+		emitter: emitter,
+		aead:    aead,
+
+		completedHandler: completedHandler,
+	}, nil
+}
+
+// Helper middleware to bind module to API context
+func bindYubikeyContext(yubikeyModule *Controller) func(ctx *yubikeyAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	return func(ctx *yubikeyAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		ctx.yubikeyModule = yubikeyModule
+		next(rw, req)
+	}
+}
+
+// BindAPI Binds the API for the yubikey module to the provided router
+func (yubikeyModule *Controller) BindAPI(router *web.Router) {
+	// Create router for user modules
+	yubikeyRouter := router.Subrouter(yubikeyAPICtx{}, "/api/yubikey")
+
+	// Attach module context
+	yubikeyRouter.Middleware(bindYubikeyContext(yubikeyModule))
+
+	// Bind endpoints
+	yubikeyRouter.Post("/authenticate", (*yubikeyAPICtx).AuthenticatePost)
+	yubikeyRouter.Get("/tokens", (*yubikeyAPICtx).ListTokens)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := yubikeyRouter.Subrouter(yubikeyAPICtx{}, "")
+	sudoRouter.Middleware((*yubikeyAPICtx).RequireSudoMiddleware)
+	sudoRouter.Post("/enrol", (*yubikeyAPICtx).EnrolPost)
+	sudoRouter.Post("/admin/enrol", (*yubikeyAPICtx).AdminEnrolPost)
+	sudoRouter.Post("/remove", (*yubikeyAPICtx).RemoveToken)
+}
+
+// IsSupported Checks whether yubikey otp is supported for a given user by userid
+// This is required to implement the generic 2fa interface for binding into the core module.
+func (yubikeyModule *Controller) IsSupported(userid string) bool {
+	tokens, err := yubikeyModule.store.GetYubikeyTokens(userid)
+	if err != nil {
+		log.Printf("YubikeyModule.IsSupported error fetching yubikey tokens for user %s (%s)", userid, err)
+		return false
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	return true
+}
+
+// encryptSecret encrypts a token AES key and private ID for storage
+func (yubikeyModule *Controller) encryptSecret(key, privateID []byte) (string, error) {
+	nonce := make([]byte, yubikeyModule.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	plaintext := append(append([]byte{}, key...), privateID...)
+	sealed := yubikeyModule.aead.Seal(nonce, nonce, plaintext, nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts a stored token secret into the AES key and private ID
+func (yubikeyModule *Controller) decryptSecret(secret string) ([]byte, []byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonceSize := yubikeyModule.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, nil, ErrInvalidSecret
+	}
+
+	plaintext, err := yubikeyModule.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(plaintext) != KeyLength+PrivateIDLength {
+		return nil, nil, ErrInvalidSecret
+	}
+
+	return plaintext[:KeyLength], plaintext[KeyLength:], nil
+}
+
+// decodeSecret decodes hex encoded AES key and private ID values
+func decodeSecret(keyHex, privateIDHex string) ([]byte, []byte, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != KeyLength {
+		return nil, nil, ErrInvalidSecret
+	}
+	privateID, err := hex.DecodeString(privateIDHex)
+	if err != nil || len(privateID) != PrivateIDLength {
+		return nil, nil, ErrInvalidSecret
+	}
+	return key, privateID, nil
+}
+
+// addToken encrypts a token secret and adds the token to the provided user
+func (yubikeyModule *Controller) addToken(userid, name, publicID string, key, privateID []byte, usageCounter, sessionCounter uint, meta map[string]string) error {
+	secret, err := yubikeyModule.encryptSecret(key, privateID)
+	if err != nil {
+		log.Printf("YubikeyModule.addToken: error encrypting token secret (%s)", err)
+		return err
+	}
+
+	t, err := yubikeyModule.store.AddYubikeyToken(userid, name, publicID, secret, usageCounter, sessionCounter)
+	if err != nil {
+		log.Printf("YubikeyModule.addToken: error creating token object (%s)", err)
+		return err
+	}
+
+	log.Printf("YubikeyModule.addToken: registered token for user %s", userid)
+
+	data := events.NewDataWithMeta(meta)
+	data["Token Name"] = t.(TokenInterface).GetName()
+	yubikeyModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorYubikeyAdded, data))
+
+	return nil
+}
+
+// ValidateRegistration validates a yubikey registration for a given user and enrols the token if valid
+// Users provide the hex encoded AES key and private ID along with an OTP from the token to confirm these
+func (yubikeyModule *Controller) ValidateRegistration(userid, name, keyHex, privateIDHex, otp string, meta map[string]string) (bool, error) {
+	key, privateID, err := decodeSecret(keyHex, privateIDHex)
+	if err != nil {
+		return false, nil
+	}
+
+	decrypted, err := DecryptOTP(otp, key)
+	if err != nil {
+		log.Printf("YubikeyModule.ValidateRegistration: invalid otp (%s)", err)
+		return false, nil
+	}
+	if subtle.ConstantTimeCompare(decrypted.PrivateID, privateID) != 1 {
+		log.Printf("YubikeyModule.ValidateRegistration: private ID mismatch")
+		return false, nil
+	}
+
+	err = yubikeyModule.addToken(userid, name, decrypted.PublicID, key, privateID, decrypted.UsageCounter, decrypted.SessionCounter, meta)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AdminRegistration enrols a token for the user with the provided email on behalf of an admin user
+// This allows tokens to be provisioned without an OTP from the token
+func (yubikeyModule *Controller) AdminRegistration(adminID, email, name, publicID, keyHex, privateIDHex string, meta map[string]string) (bool, error) {
+	a, err := yubikeyModule.store.GetUserByExtID(adminID)
+	if err != nil {
+		return false, err
+	}
+	if a == nil || !a.(User).IsAdmin() {
+		return false, ErrUnauthorized
+	}
+
+	u, err := yubikeyModule.store.GetUserByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	if u == nil {
+		log.Printf("YubikeyModule.AdminRegistration: user not found %s", email)
+		return false, nil
+	}
+	user := u.(User)
+
+	if len(publicID) != PublicIDLength {
+		return false, nil
+	}
+	if _, err := decodeModhex(publicID); err != nil {
+		return false, nil
+	}
+	key, privateID, err := decodeSecret(keyHex, privateIDHex)
+	if err != nil {
+		return false, nil
+	}
+
+	log.Printf("YubikeyModule.AdminRegistration: admin %s enrolling token for user %s", adminID, user.GetExtID())
+
+	err = yubikeyModule.addToken(user.GetExtID(), name, publicID, key, privateID, 0, 0, meta)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ValidateToken validates a yubikey otp for a given user
+// Counters must increase monotonically to prevent OTP replay
+func (yubikeyModule *Controller) ValidateToken(userid, otp string, meta map[string]string) (bool, error) {
+	publicID, err := ParsePublicID(otp)
+	if err != nil {
+		return false, nil
+	}
+
+	// Fetch tokens
+	tokens, err := yubikeyModule.store.GetYubikeyTokens(userid)
+	if err != nil {
+		log.Printf("YubikeyModule.ValidateToken: error loading tokens for user (%s)", err)
+		return false, err
+	}
+
+	// Locate matching token
+	var token TokenInterface
+	for _, t := range tokens {
+		if t.(TokenInterface).GetPublicID() == publicID {
+			token = t.(TokenInterface)
+		}
+	}
+	if token == nil {
+		log.Printf("YubikeyModule.ValidateToken: no matching token for user %s", userid)
+		return false, nil
+	}
+
+	key, privateID, err := yubikeyModule.decryptSecret(token.GetSecret())
+	if err != nil {
+		log.Printf("YubikeyModule.ValidateToken: error decrypting token secret (%s)", err)
+		return false, err
+	}
+
+	decrypted, err := DecryptOTP(otp, key)
+	if err != nil {
+		log.Printf("YubikeyModule.ValidateToken: invalid otp for user %s", userid)
+		return false, nil
+	}
+	if subtle.ConstantTimeCompare(decrypted.PrivateID, privateID) != 1 {
+		log.Printf("YubikeyModule.ValidateToken: private ID mismatch for user %s", userid)
+		return false, nil
+	}
+
+	// Check counters to prevent replay
+	if decrypted.UsageCounter < token.GetUsageCounter() ||
+		(decrypted.UsageCounter == token.GetUsageCounter() && decrypted.SessionCounter <= token.GetSessionCounter()) {
+		log.Printf("YubikeyModule.ValidateToken: replayed otp for user %s", userid)
+		return false, nil
+	}
+
+	// Update token counters and last used time
+	token.SetUsageCounter(decrypted.UsageCounter)
+	token.SetSessionCounter(decrypted.SessionCounter)
+	token.SetLastUsed(time.Now())
+
+	_, err = yubikeyModule.store.UpdateYubikeyToken(token)
+	if err != nil {
+		log.Printf("YubikeyModule.ValidateToken: error updating token object (%s)", err)
+		return false, err
+	}
+
+	data := events.NewDataWithMeta(meta)
+	data["Token Name"] = token.GetName()
+	yubikeyModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorYubikeyUsed, data))
+
+	return true, nil
+}
+
+// TokenResp is a sanatised token instance to return from the controller
+type TokenResp struct {
+	ExtID    string
+	Name     string
+	PublicID string
+	LastUsed time.Time
+}
+
+// ListTokens lists tokens for a given user
+func (yubikeyModule *Controller) ListTokens(userid string) ([]TokenResp, error) {
+	tokens, err := yubikeyModule.store.GetYubikeyTokens(userid)
+	if err != nil {
+		log.Printf("YubikeyModule.ListTokens: error fetching yubikey tokens (%s)", err)
+		return nil, err
+	}
+
+	cleanTokens := make([]TokenResp, len(tokens))
+	for i, t := range tokens {
+		ti := t.(TokenInterface)
+		cleanTokens[i] = TokenResp{
+			ExtID:    ti.GetExtID(),
+			Name:     ti.GetName(),
+			PublicID: ti.GetPublicID(),
+			LastUsed: ti.GetLastUsed(),
+		}
+	}
+
+	return cleanTokens, nil
+}
+
+// RemoveToken removes a token by matching user and token external IDs
+func (yubikeyModule *Controller) RemoveToken(userid, tokenID string, meta map[string]string) (bool, error) {
+	tokens, err := yubikeyModule.store.GetYubikeyTokens(userid)
+	if err != nil {
+		log.Printf("YubikeyModule.RemoveToken: error fetching yubikey tokens (%s)", err)
+		return false, err
+	}
+
+	for _, t := range tokens {
+		token := t.(TokenInterface)
+		if token.GetExtID() == tokenID {
+			err := yubikeyModule.store.RemoveYubikeyToken(token)
+			if err != nil {
+				log.Printf("YubikeyModule.RemoveToken: error deleting yubikey token (%s)", err)
+				return false, err
+			}
+
+			data := events.NewDataWithMeta(meta)
+			data["Token Name"] = token.GetName()
+			yubikeyModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorYubikeyRemoved, data))
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,182 @@
+/*
+ * Yubikey OTP Module API
+ * This defines the API methods bound to the yubikey module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package yubikey
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+)
+
+// Yubikey API context storage
+type yubikeyAPICtx struct {
+	// Base context for shared components
+	*appcontext.AuthPlzCtx
+
+	// Yubikey controller module
+	yubikeyModule *Controller
+}
+
+// EnrolPost enrols a yubikey for the logged in user
+// This requires the token name, hex encoded AES secret and private ID, and an OTP from the token
+func (c *yubikeyAPICtx) EnrolPost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	name := req.FormValue("name")
+	if name == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.TokenNameRequired)
+		return
+	}
+
+	ok, err := c.yubikeyModule.ValidateRegistration(c.GetUserID(), name, req.FormValue("secret"), req.FormValue("private_id"), req.FormValue("otp"), c.GetMeta())
+	if err != nil {
+		log.Printf("YubikeyEnrolPost: error validating token registration (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		log.Printf("YubikeyEnrolPost: registration failed for user %s", c.GetUserID())
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorFailed)
+		return
+	}
+
+	log.Printf("YubikeyEnrolPost: enrolled token for user %s", c.GetUserID())
+	c.WriteAPIResult(rw, api.SecondFactorSuccess)
+}
+
+// AdminEnrolPost enrols a yubikey for the user with the provided email (admin only)
+// This requires the token name, modhex encoded public ID, and hex encoded AES secret and private ID
+func (c *yubikeyAPICtx) AdminEnrolPost(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	email := req.FormValue("email")
+	name := req.FormValue("name")
+	if email == "" || name == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	ok, err := c.yubikeyModule.AdminRegistration(c.GetUserID(), email, name, req.FormValue("public_id"), req.FormValue("secret"), req.FormValue("private_id"), c.GetMeta())
+	if err == ErrUnauthorized {
+		c.WriteUnauthorized(rw)
+		return
+	} else if err != nil {
+		log.Printf("YubikeyAdminEnrolPost: error enrolling token (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SecondFactorSuccess)
+}
+
+// AuthenticatePost completes a yubikey authentication
+func (c *yubikeyAPICtx) AuthenticatePost(rw web.ResponseWriter, req *web.Request) {
+
+	// Fetch challenge user ID
+	userid, action := c.Get2FARequest(rw, req)
+	if userid == "" || action == "" {
+		log.Printf("yubikey.AuthenticatePost No pending 2fa requests found")
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+
+	log.Printf("yubikey.AuthenticatePost Authentication request for user %s", userid)
+
+	ok, err := c.yubikeyModule.ValidateToken(userid, req.FormValue("otp"), c.GetMeta())
+	if err != nil {
+		log.Printf("YubikeyAuthenticatePost: error validating otp (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	if !ok {
+		log.Printf("YubikeyAuthenticatePost: authentication failed for user %s\n", userid)
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.SecondFactorFailed)
+		return
+	}
+
+	log.Printf("YubikeyAuthenticatePost: Valid authentication for account %s (action %s)\n", userid, action)
+	// Complete the pending 2fa request, completion handlers are run once the request has been verified
+	err = c.Complete2FARequest(rw, req, userid, action, func() error {
+		return c.yubikeyModule.completedHandler.SecondFactorCompleted(userid, action, "yubikey", c.GetMeta())
+	})
+	if err == appcontext.ErrNoFlowPending {
+		log.Printf("YubikeyAuthenticatePost: error completing 2fa request (%s)", err)
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.SecondFactorNoRequestSession)
+		return
+	}
+	if err != nil {
+		log.Printf("YubikeyAuthenticatePost: error completing action %s (%s)", action, err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SecondFactorSuccess)
+}
+
+// ListTokens lists sanatised yubikey tokens for a given account
+func (c *yubikeyAPICtx) ListTokens(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	tokens, err := c.yubikeyModule.ListTokens(c.GetUserID())
+	if err != nil {
+		log.Printf("Error fetching yubikey tokens %s", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteJSON(rw, tokens)
+}
+
+// RemoveToken removes a provided token
+func (c *yubikeyAPICtx) RemoveToken(rw web.ResponseWriter, req *web.Request) {
+	// Check if user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	tokenID := req.FormValue("id")
+	if tokenID == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	ok, err := c.yubikeyModule.RemoveToken(c.GetUserID(), tokenID, c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusNotFound, api.IncorrectArguments)
+		return
+	}
+	c.WriteAPIResult(rw, api.YubikeyTokenRemoved)
+}
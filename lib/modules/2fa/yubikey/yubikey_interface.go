@@ -0,0 +1,57 @@
+/*
+ * Yubikey OTP Module interfaces
+ * This defines the interfaces required to use the yubikey module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package yubikey
+
+import (
+	"time"
+)
+
+// TokenInterface Token instance interface
+// Storer token objects must implement this interface
+type TokenInterface interface {
+	GetExtID() string
+	GetName() string
+	GetPublicID() string
+	GetSecret() string
+	GetUsageCounter() uint
+	SetUsageCounter(uint)
+	GetSessionCounter() uint
+	SetSessionCounter(uint)
+	GetLastUsed() time.Time
+	SetLastUsed(time.Time)
+}
+
+// User interface type
+// Storer user objects must implement this interface
+type User interface {
+	GetExtID() string
+	IsAdmin() bool
+}
+
+// Storer Token store interface
+// This must be implemented by a storage module to provide persistence to the module
+type Storer interface {
+	// Fetch a user instance by user id
+	GetUserByExtID(userid string) (interface{}, error)
+	// Fetch a user instance by email (for admin enrolment)
+	GetUserByEmail(email string) (interface{}, error)
+	// Add a yubikey token to a given user
+	AddYubikeyToken(userid, name, publicID, secret string, usageCounter, sessionCounter uint) (interface{}, error)
+	// Fetch yubikey tokens for a given user
+	GetYubikeyTokens(userid string) ([]interface{}, error)
+	// Update a provided yubikey token
+	UpdateYubikeyToken(token interface{}) (interface{}, error)
+	// Remove a yubikey token
+	RemoveYubikeyToken(token interface{}) error
+}
+
+// CompletedHandler Callback for 2fa signature completion
+type CompletedHandler interface {
+	SecondFactorCompleted(userid, action, factor string, meta map[string]string) error
+}
@@ -0,0 +1,136 @@
+/*
+ * Yubikey OTP Module OTP parsing
+ * This implements local decoding and validation of Yubico OTPs
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package yubikey
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	// OTPLength length of a modhex encoded OTP
+	OTPLength = 44
+	// PublicIDLength length of a modhex encoded public ID
+	PublicIDLength = 12
+	// KeyLength length of a token AES key
+	KeyLength = 16
+	// PrivateIDLength length of a token private ID
+	PrivateIDLength = 6
+
+	// modhex alphabet, indexed by hex value
+	modhexAlphabet = "cbdefghijklnrtuv"
+	// CRC residual for a valid decrypted OTP
+	crcOKResidual = 0xf0b8
+)
+
+var (
+	// ErrInvalidOTP returned when an OTP is malformed or fails decryption checks
+	ErrInvalidOTP = errors.New("yubikey: invalid otp")
+)
+
+// OTP decrypted OTP fields
+type OTP struct {
+	PublicID       string
+	PrivateID      []byte
+	UsageCounter   uint
+	Timestamp      uint
+	SessionCounter uint
+	Random         uint
+}
+
+// decodeModhex decodes a modhex encoded string
+func decodeModhex(s string) ([]byte, error) {
+	if len(s)%2 != 0 {
+		return nil, ErrInvalidOTP
+	}
+
+	data := make([]byte, len(s)/2)
+	for i := 0; i < len(s); i += 2 {
+		hi := strings.IndexByte(modhexAlphabet, s[i])
+		lo := strings.IndexByte(modhexAlphabet, s[i+1])
+		if hi < 0 || lo < 0 {
+			return nil, ErrInvalidOTP
+		}
+		data[i/2] = byte(hi<<4 | lo)
+	}
+
+	return data, nil
+}
+
+// crc16 computes the ISO13239 CRC used by yubikey OTPs
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			j := crc & 1
+			crc >>= 1
+			if j != 0 {
+				crc ^= 0x8408
+			}
+		}
+	}
+	return crc
+}
+
+// ParsePublicID extracts the public ID from a modhex encoded OTP
+func ParsePublicID(otp string) (string, error) {
+	otp = strings.ToLower(strings.TrimSpace(otp))
+	if len(otp) != OTPLength {
+		return "", ErrInvalidOTP
+	}
+	publicID := otp[:PublicIDLength]
+	if _, err := decodeModhex(publicID); err != nil {
+		return "", err
+	}
+	return publicID, nil
+}
+
+// DecryptOTP decrypts and validates an OTP using the provided AES key
+// This checks the OTP format and CRC, the caller must check the private ID and counters
+func DecryptOTP(otp string, key []byte) (*OTP, error) {
+	otp = strings.ToLower(strings.TrimSpace(otp))
+
+	publicID, err := ParsePublicID(otp)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeyLength {
+		return nil, ErrInvalidOTP
+	}
+
+	ciphertext, err := decodeModhex(otp[PublicIDLength:])
+	if err != nil {
+		return nil, err
+	}
+
+	// OTPs are a single AES-128 block
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, aes.BlockSize)
+	block.Decrypt(plaintext, ciphertext)
+
+	// Check CRC
+	if crc16(plaintext) != crcOKResidual {
+		return nil, ErrInvalidOTP
+	}
+
+	return &OTP{
+		PublicID:       publicID,
+		PrivateID:      plaintext[0:6],
+		UsageCounter:   uint(binary.LittleEndian.Uint16(plaintext[6:8])),
+		Timestamp:      uint(plaintext[8]) | uint(plaintext[9])<<8 | uint(plaintext[10])<<16,
+		SessionCounter: uint(plaintext[11]),
+		Random:         uint(binary.LittleEndian.Uint16(plaintext[12:14])),
+	}, nil
+}
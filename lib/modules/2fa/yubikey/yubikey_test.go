@@ -0,0 +1,348 @@
+/*
+ * Yubikey OTP Module tests
+ * This defines Yubikey OTP module tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package yubikey
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/test"
+)
+
+// encodeModhex encodes data using the modhex alphabet
+func encodeModhex(data []byte) string {
+	out := make([]byte, len(data)*2)
+	for i, b := range data {
+		out[i*2] = modhexAlphabet[b>>4]
+		out[i*2+1] = modhexAlphabet[b&0x0f]
+	}
+	return string(out)
+}
+
+// generateOTP builds an OTP as emitted by a token with the provided parameters
+func generateOTP(publicID string, key, privateID []byte, usageCounter, sessionCounter uint16, validCRC bool) string {
+	plaintext := make([]byte, aes.BlockSize)
+	copy(plaintext[0:6], privateID)
+	binary.LittleEndian.PutUint16(plaintext[6:8], usageCounter)
+	plaintext[8], plaintext[9], plaintext[10] = 0x01, 0x02, 0x03
+	plaintext[11] = byte(sessionCounter)
+	binary.LittleEndian.PutUint16(plaintext[12:14], 0xbeef)
+
+	crc := ^crc16(plaintext[:14])
+	if !validCRC {
+		crc ^= 0x01
+	}
+	binary.LittleEndian.PutUint16(plaintext[14:16], crc)
+
+	block, _ := aes.NewCipher(key)
+	ciphertext := make([]byte, aes.BlockSize)
+	block.Encrypt(ciphertext, plaintext)
+
+	return publicID + encodeModhex(ciphertext)
+}
+
+var (
+	fakePublicID  = "vvccccfhcbdn"
+	fakeKeyHex    = "0102030405060708090a0b0c0d0e0f10"
+	fakePrivateID = "a1a2a3a4a5a6"
+)
+
+func TestYubikeyOTP(t *testing.T) {
+	key, _ := hex.DecodeString(fakeKeyHex)
+	privateID, _ := hex.DecodeString(fakePrivateID)
+
+	t.Run("Decrypts valid OTPs", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 3, 7, true)
+		if len(otp) != OTPLength {
+			t.Errorf("Unexpected OTP length %d", len(otp))
+		}
+
+		decrypted, err := DecryptOTP(otp, key)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if decrypted.PublicID != fakePublicID {
+			t.Errorf("Public ID mismatch (expected %s received %s)", fakePublicID, decrypted.PublicID)
+		}
+		if hex.EncodeToString(decrypted.PrivateID) != fakePrivateID {
+			t.Errorf("Private ID mismatch")
+		}
+		if decrypted.UsageCounter != 3 || decrypted.SessionCounter != 7 {
+			t.Errorf("Counter mismatch (usage %d session %d)", decrypted.UsageCounter, decrypted.SessionCounter)
+		}
+	})
+
+	t.Run("Rejects OTPs with invalid CRCs", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 3, 7, false)
+		_, err := DecryptOTP(otp, key)
+		if err != ErrInvalidOTP {
+			t.Errorf("Expected ErrInvalidOTP, received %v", err)
+		}
+	})
+
+	t.Run("Rejects OTPs with the wrong key", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 3, 7, true)
+		wrongKey := make([]byte, KeyLength)
+		_, err := DecryptOTP(otp, wrongKey)
+		if err != ErrInvalidOTP {
+			t.Errorf("Expected ErrInvalidOTP, received %v", err)
+		}
+	})
+
+	t.Run("Rejects malformed OTPs", func(t *testing.T) {
+		_, err := DecryptOTP("abcdefg", key)
+		if err != ErrInvalidOTP {
+			t.Errorf("Expected ErrInvalidOTP, received %v", err)
+		}
+
+		otp := generateOTP(fakePublicID, key, privateID, 3, 7, true)
+		_, err = DecryptOTP("a"+otp[1:], key)
+		if err != ErrInvalidOTP {
+			t.Errorf("Expected ErrInvalidOTP for non-modhex input, received %v", err)
+		}
+	})
+}
+
+func TestYubikeyModule(t *testing.T) {
+	var fakeEmail = "test@abc.com"
+	var fakePass = "abcDEF123@abcDEF123@"
+	var fakeName = "user.sdfsfdF"
+
+	var adminEmail = "admin@abc.com"
+	var adminName = "admin.sdfsfdF"
+
+	c, _ := config.DefaultConfig()
+
+	// Attempt database connection
+	dataStore, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error("Error opening database")
+		t.FailNow()
+	}
+
+	// Force synchronization
+	dataStore.ForceSync()
+
+	// Create user for tests
+	u, err := dataStore.AddUser(fakeEmail, fakeName, fakePass)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user := u.(*datastore.User)
+
+	mockEventEmitter := test.MockEventEmitter{}
+
+	// Instantiate yubikey module
+	yubikeyModule, err := NewController(c.TokenSecret, dataStore, &test.MockCompletedHandler{}, &mockEventEmitter)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	key, _ := hex.DecodeString(fakeKeyHex)
+	privateID, _ := hex.DecodeString(fakePrivateID)
+
+	t.Run("Secrets can be encrypted and decrypted", func(t *testing.T) {
+		secret, err := yubikeyModule.encryptSecret(key, privateID)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if secret == fakeKeyHex || len(secret) == 0 {
+			t.Errorf("Secret not encrypted")
+		}
+
+		k, p, err := yubikeyModule.decryptSecret(secret)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if hex.EncodeToString(k) != fakeKeyHex || hex.EncodeToString(p) != fakePrivateID {
+			t.Errorf("Decrypted secret mismatch")
+		}
+	})
+
+	t.Run("Users are not supported prior to enrolment", func(t *testing.T) {
+		if yubikeyModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected yubikey support prior to enrolment")
+		}
+	})
+
+	t.Run("Registration rejects mismatched private IDs", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 1, 0, true)
+		ok, err := yubikeyModule.ValidateRegistration(user.GetExtID(), "fakeToken", fakeKeyHex, "000000000000", otp, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Registration accepted with incorrect private ID")
+		}
+	})
+
+	t.Run("Registration accepts valid tokens", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 1, 0, true)
+		ok, err := yubikeyModule.ValidateRegistration(user.GetExtID(), "fakeToken", fakeKeyHex, fakePrivateID, otp, nil)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !ok {
+			t.Errorf("Token registration failed")
+		}
+		if !yubikeyModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Yubikey not supported following registration")
+		}
+	})
+
+	t.Run("Token secrets are encrypted at rest", func(t *testing.T) {
+		tokens, err := dataStore.GetYubikeyTokens(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(tokens) != 1 {
+			t.Errorf("Expected 1 token, found %d", len(tokens))
+			t.FailNow()
+		}
+		token := tokens[0].(TokenInterface)
+		if token.GetSecret() == fakeKeyHex {
+			t.Errorf("Token secret stored in plain text")
+		}
+	})
+
+	t.Run("Registration OTPs can not be replayed", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 1, 0, true)
+		ok, err := yubikeyModule.ValidateToken(user.GetExtID(), otp, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("Registration OTP accepted for authentication")
+		}
+	})
+
+	t.Run("Authenticates with increasing session counters", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 1, 1, true)
+		ok, err := yubikeyModule.ValidateToken(user.GetExtID(), otp, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Valid OTP rejected")
+		}
+	})
+
+	t.Run("Authenticates with increasing usage counters", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 2, 0, true)
+		ok, err := yubikeyModule.ValidateToken(user.GetExtID(), otp, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Valid OTP rejected")
+		}
+	})
+
+	t.Run("Rejects OTPs with decreasing counters", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 1, 5, true)
+		ok, err := yubikeyModule.ValidateToken(user.GetExtID(), otp, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("OTP with decreased usage counter accepted")
+		}
+	})
+
+	t.Run("Rejects OTPs with invalid CRCs", func(t *testing.T) {
+		otp := generateOTP(fakePublicID, key, privateID, 3, 0, false)
+		ok, err := yubikeyModule.ValidateToken(user.GetExtID(), otp, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("OTP with invalid CRC accepted")
+		}
+	})
+
+	t.Run("Admin enrolment requires admin privileges", func(t *testing.T) {
+		ok, err := yubikeyModule.AdminRegistration(user.GetExtID(), fakeEmail, "adminToken", "vvccccfhcbdr", fakeKeyHex, fakePrivateID, nil)
+		if err != ErrUnauthorized {
+			t.Errorf("Expected ErrUnauthorized, received %v", err)
+		}
+		if ok {
+			t.Errorf("Admin enrolment succeeded for non-admin user")
+		}
+	})
+
+	t.Run("Admins can enrol tokens for users", func(t *testing.T) {
+		a, err := dataStore.AddUser(adminEmail, adminName, fakePass)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		admin := a.(*datastore.User)
+		admin.SetAdmin(true)
+		_, err = dataStore.UpdateUser(admin)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		ok, err := yubikeyModule.AdminRegistration(admin.GetExtID(), fakeEmail, "adminToken", "vvccccfhcbdr", fakeKeyHex, fakePrivateID, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("Admin enrolment failed")
+		}
+
+		otp := generateOTP("vvccccfhcbdr", key, privateID, 1, 0, true)
+		ok, err = yubikeyModule.ValidateToken(user.GetExtID(), otp, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("OTP rejected for admin enrolled token")
+		}
+	})
+
+	t.Run("Remove tokens", func(t *testing.T) {
+		tokens, err := yubikeyModule.ListTokens(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(tokens) != 2 {
+			t.Errorf("Expected 2 tokens, found %d", len(tokens))
+			t.FailNow()
+		}
+
+		for _, token := range tokens {
+			ok, err := yubikeyModule.RemoveToken(user.GetExtID(), token.ExtID, nil)
+			if err != nil {
+				t.Error(err)
+			}
+			if !ok {
+				t.Errorf("Token removal failed")
+			}
+		}
+
+		if yubikeyModule.IsSupported(user.GetExtID()) {
+			t.Errorf("Unexpected yubikey support after removal")
+		}
+	})
+
+}
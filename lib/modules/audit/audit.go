@@ -9,10 +9,17 @@
 package audit
 
 import (
+	"errors"
 	"log"
 	"time"
 )
 
+// Maximum number of system events returned to admins
+const systemEventLimit = 100
+
+// ErrUnauthorized returned when a non-admin user attempts to list system events
+var ErrUnauthorized = errors.New("audit: admin privileges required")
+
 // Controller instance
 type Controller struct {
 	store Storer
@@ -24,8 +31,31 @@ func NewController(store Storer) *Controller {
 }
 
 // AddEvent adds an event to the audit log
+// Events without a user ID are recorded as system events
 func (ac *Controller) AddEvent(userExtID, eventType string, eventTime time.Time, data map[string]string) error {
-	_, err := ac.store.AddAuditEvent(userExtID, eventType, eventTime, data)
+	return ac.addEvent("", userExtID, eventType, eventTime, data)
+}
+
+// addEvent adds an event to the audit log, events with an ID are only recorded once
+func (ac *Controller) addEvent(eventID, userExtID, eventType string, eventTime time.Time, data map[string]string) error {
+	var err error
+	if userExtID == "" {
+		_, err = ac.store.AddSystemAuditEvent(eventID, eventType, eventTime, data)
+	} else {
+		// Events for deleted accounts (ie. account deletion) cannot be recorded
+		var u interface{}
+		u, err = ac.store.GetUserByExtID(userExtID)
+		if err != nil {
+			log.Printf("AuditController.AddEvent: error fetching user %s (%s)", userExtID, err)
+			return err
+		}
+		if u == nil {
+			log.Printf("AuditController.AddEvent: dropping event %s for unknown user %s", eventType, userExtID)
+			return nil
+		}
+
+		_, err = ac.store.AddAuditEvent(userExtID, eventID, eventType, eventTime, data)
+	}
 	if err != nil {
 		log.Printf("AuditController.AddEvent: error adding audit event (%s)", err)
 		return err
@@ -36,21 +66,78 @@ func (ac *Controller) AddEvent(userExtID, eventType string, eventTime time.Time,
 	return nil
 }
 
-// HandleEvent handles async events for go-async
+// HandleEvent handles events from the event outbox
+// Errors are returned so that failed events are redelivered
 func (ac *Controller) HandleEvent(event interface{}) error {
 	auditEvent := event.(Event)
-	ac.AddEvent(auditEvent.GetUserExtID(), auditEvent.GetType(), auditEvent.GetTime(), auditEvent.GetData())
-	return nil
+	return ac.addEvent(auditEvent.GetID(), auditEvent.GetUserExtID(), auditEvent.GetType(), auditEvent.GetTime(), auditEvent.GetData())
+}
+
+// EventResp sanitised audit event object
+// Request metadata attached to the event is included to show where the action originated
+type EventResp struct {
+	Type         string            `json:"type"`
+	Time         time.Time         `json:"time"`
+	RemoteAddr   string            `json:"remote_address"`
+	ForwardedFor string            `json:"forwarded_for"`
+	UserAgent    string            `json:"user_agent"`
+	RequestID    string            `json:"request_id"`
+	Data         map[string]string `json:"data"`
 }
 
 // ListEvents fetches events for the provided userID
-func (ac *Controller) ListEvents(userid string) ([]interface{}, error) {
+func (ac *Controller) ListEvents(userid string) ([]EventResp, error) {
 
 	events, err := ac.store.GetAuditEvents(userid)
 	if err != nil {
-		log.Printf("AuditController.AddEvent: error adding audit event (%s)", err)
-		return events, err
+		log.Printf("AuditController.ListEvents: error fetching audit events (%s)", err)
+		return nil, err
+	}
+
+	return buildEventResps(events)
+}
+
+// ListSystemEvents fetches recent system events for the provided admin userID
+func (ac *Controller) ListSystemEvents(adminID string) ([]EventResp, error) {
+	a, err := ac.store.GetUserByExtID(adminID)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || !a.(User).IsAdmin() {
+		return nil, ErrUnauthorized
+	}
+
+	events, err := ac.store.GetSystemAuditEvents(systemEventLimit)
+	if err != nil {
+		log.Printf("AuditController.ListSystemEvents: error fetching audit events (%s)", err)
+		return nil, err
+	}
+
+	return buildEventResps(events)
+}
+
+// buildEventResps converts stored audit events to sanitised API responses
+func buildEventResps(events []interface{}) ([]EventResp, error) {
+	resp := make([]EventResp, len(events))
+	for i, e := range events {
+		event := e.(AuditEvent)
+
+		data, err := event.GetData()
+		if err != nil {
+			log.Printf("AuditController.buildEventResps: error decoding audit event data (%s)", err)
+			return nil, err
+		}
+
+		resp[i] = EventResp{
+			Type:         event.GetType(),
+			Time:         event.GetTime(),
+			RemoteAddr:   data["remote-address"],
+			ForwardedFor: data["forwarded-for"],
+			UserAgent:    data["user-agent"],
+			RequestID:    data["request-id"],
+			Data:         data,
+		}
 	}
 
-	return events, err
+	return resp, nil
 }
@@ -43,6 +43,7 @@ func (ac *Controller) BindAPI(router *web.Router) {
 
 	// Bind endpoints
 	auditRouter.Get("/", (*APICtx).GetEvents)
+	auditRouter.Get("/system", (*APICtx).GetSystemEvents)
 }
 
 // GetEvents endpoint fetches a list of audit events for a given user
@@ -62,3 +63,25 @@ func (c *APICtx) GetEvents(rw web.ResponseWriter, req *web.Request) {
 
 	c.WriteJSON(rw, events)
 }
+
+// GetSystemEvents endpoint fetches a list of recent system audit events (admin only)
+// System events are not associated with a user account, ie. detected attacks
+func (c *APICtx) GetSystemEvents(rw web.ResponseWriter, req *web.Request) {
+	// Check user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	events, err := c.ac.ListSystemEvents(c.GetUserID())
+	if err == ErrUnauthorized {
+		c.WriteUnauthorized(rw)
+		return
+	} else if err != nil {
+		log.Printf("AuditApiCtx.GetSystemEvents: error listing events (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteJSON(rw, events)
+}
@@ -14,19 +14,34 @@ import (
 
 // Event Audit event type interface
 type Event interface {
+	GetID() string
 	GetUserExtID() string
 	GetType() string
 	GetTime() time.Time
 	GetData() map[string]string
 }
 
+// AuditEvent Stored audit event interface
+// Storer audit event objects must implement this interface
+type AuditEvent interface {
+	GetType() string
+	GetTime() time.Time
+	GetData() (map[string]string, error)
+}
+
 // User Audit user type interface
 type User interface {
 	GetExtID() string
+	IsAdmin() bool
 }
 
 // Storer Interface that datastore must implement to provide audit controller
 type Storer interface {
-	AddAuditEvent(userid, eventType string, eventTime time.Time, data map[string]string) (interface{}, error)
+	AddAuditEvent(userid, eventID, eventType string, eventTime time.Time, data map[string]string) (interface{}, error)
 	GetAuditEvents(userid string) ([]interface{}, error)
+
+	AddSystemAuditEvent(eventID, eventType string, eventTime time.Time, data map[string]string) (interface{}, error)
+	GetSystemAuditEvents(limit uint) ([]interface{}, error)
+
+	GetUserByExtID(userid string) (interface{}, error)
 }
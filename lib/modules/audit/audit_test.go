@@ -71,8 +71,9 @@ func TestAuditController(t *testing.T) {
 
 	t.Run("Post audit event", func(t *testing.T) {
 		d := make(map[string]string)
-		d["ip"] = "127.0.0.1"
-		e := events.AuthPlzEvent{user.GetExtID(), time.Now(), events.AccountActivated, d}
+		d["remote-address"] = "127.0.0.1"
+		d["request-id"] = "abc123"
+		e := events.AuthPlzEvent{UserExtID: user.GetExtID(), Time: time.Now(), Type: events.AccountActivated, Data: d}
 
 		serviceManager.SendEvent(&e)
 
@@ -89,6 +90,56 @@ func TestAuditController(t *testing.T) {
 		}
 	})
 
+	t.Run("Listed events include request metadata", func(t *testing.T) {
+		list, err := ac.ListEvents(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		for _, e := range list {
+			if e.Type != events.AccountActivated {
+				continue
+			}
+			if e.RemoteAddr != "127.0.0.1" || e.RequestID != "abc123" {
+				t.Errorf("Audit event missing request metadata (%+v)", e)
+			}
+			return
+		}
+		t.Errorf("Async audit event not found")
+	})
+
+	t.Run("System events are only listed for admins", func(t *testing.T) {
+		err := ac.AddEvent("", events.CredentialStuffingDetected, time.Now(), map[string]string{"Source": "192.0.2.1"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = ac.ListSystemEvents(user.GetExtID())
+		if err != ErrUnauthorized {
+			t.Errorf("Expected unauthorized error for non-admin user, received %v", err)
+		}
+
+		user.Admin = true
+		ds.UpdateUser(user)
+
+		list, err := ac.ListSystemEvents(user.GetExtID())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(list) != 1 || list[0].Data["Source"] != "192.0.2.1" {
+			t.Errorf("Unexpected system events %+v", list)
+		}
+
+		// System events are not included in user events
+		userEvents, _ := ac.ListEvents(user.GetExtID())
+		for _, e := range userEvents {
+			if e.Type == events.CredentialStuffingDetected {
+				t.Errorf("System event listed for user")
+			}
+		}
+	})
+
 	t.Run("Stop async server", func(t *testing.T) {
 		serviceManager.Exit()
 	})
@@ -32,11 +32,15 @@ type Controller struct {
 	// 2nd Factor Authentication implementations
 	secondFactorHandlers map[string]SecondFactorProvider
 
+	// Trusted device implementations
+	trustedDevices map[string]TrustedDeviceProvider
+
 	// Event handler implementations
 	eventHandlers map[string]EventHandler
 
 	// Login handler implementations
 	preLogin         map[string]PreLoginHook
+	loginRisk        map[string]LoginRiskHook
 	postLoginSuccess map[string]PostLoginSuccessHook
 	postLoginFailure map[string]PostLoginFailureHook
 
@@ -51,8 +55,10 @@ func NewController(tokenValidator TokenValidator, loginProvider LoginProvider, e
 		userControl:          loginProvider,
 		tokenHandlers:        make(map[api.TokenAction]TokenHandler),
 		secondFactorHandlers: make(map[string]SecondFactorProvider),
+		trustedDevices:       make(map[string]TrustedDeviceProvider),
 
 		preLogin:         make(map[string]PreLoginHook),
+		loginRisk:        make(map[string]LoginRiskHook),
 		postLoginSuccess: make(map[string]PostLoginSuccessHook),
 		postLoginFailure: make(map[string]PostLoginFailureHook),
 		eventHandlers:    make(map[string]EventHandler),
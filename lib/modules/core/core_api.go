@@ -46,18 +46,26 @@ func (coreModule *Controller) BindAPI(router *web.Router) {
 
 	// Bind endpoints
 	coreRouter.Post("/login", (*coreCtx).Login)
+	coreRouter.Get("/login/email", (*coreCtx).LoginEmailGet)
+	coreRouter.Post("/login/email", (*coreCtx).LoginEmailPost)
 	coreRouter.Get("/logout", (*coreCtx).Logout)
 	coreRouter.Post("/logout", (*coreCtx).Logout)
 	coreRouter.Get("/action", (*coreCtx).Action)
 	coreRouter.Post("/action", (*coreCtx).Action)
+	coreRouter.Get("/lock", (*coreCtx).Lock)
+	coreRouter.Post("/lock", (*coreCtx).Lock)
 	coreRouter.Get("/recovery", (*coreCtx).RecoverGet)
 	coreRouter.Post("/recovery", (*coreCtx).RecoverPost)
 	coreRouter.Get("/2fa-status", (*coreCtx).SecondFactorStatus)
+	coreRouter.Get("/flow", (*coreCtx).FlowGet)
+	coreRouter.Get("/sudo", (*coreCtx).SudoGet)
+	coreRouter.Post("/sudo", (*coreCtx).SudoPost)
+	coreRouter.Post("/sudo/end", (*coreCtx).SudoEnd)
 	coreRouter.Get("/test", (*coreCtx).TestGet)
 }
 
 // Handle an action token (both get and post calls)
-// This adds the action token to a session flash for use post-login attempt
+// This binds the action token to a token flow, which is completed on the next successful login
 func (c *coreCtx) Action(rw web.ResponseWriter, req *web.Request) {
 	// Grab token string from get or post request
 	var tokenString string
@@ -74,16 +82,16 @@ func (c *coreCtx) Action(rw web.ResponseWriter, req *web.Request) {
 
 	// If the user isn't logged in
 	if c.GetUserID() == "" {
-		session := c.GetSession()
-
-		// Clear existing flashes (by reading)
-		_ = session.Flashes()
-
-		// Add token to flash and redirect
-		session.AddFlash(tokenString)
-		session.Save(req.Request, rw)
+		// Start token flow, replacing any pending token
+		err := c.StartFlowWithData(rw, req, "", appcontext.FlowActionToken, tokenString,
+			appcontext.FlowStepCredentials, appcontext.FlowStepTokenAction)
+		if err != nil {
+			log.Printf("CoreAPI.Action error starting token flow (%s)", err)
+			c.WriteInternalError(rw)
+			return
+		}
 
-		log.Printf("CoreAPI.Action saved token to session store")
+		log.Printf("CoreAPI.Action bound token to flow")
 
 		c.WriteAPIResult(rw, api.OK)
 
@@ -93,6 +101,36 @@ func (c *coreCtx) Action(rw web.ResponseWriter, req *web.Request) {
 	}
 }
 
+// Lock handles an account lock token (both get and post calls)
+// This is used by the "this wasn't me" link in login notices, and does not require login
+func (c *coreCtx) Lock(rw web.ResponseWriter, req *web.Request) {
+	tokenString := req.FormValue("token")
+	if tokenString == "" {
+		tokenString = req.URL.Query().Get("token")
+	}
+	if tokenString == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.MissingToken)
+		return
+	}
+
+	ok, err := c.cm.HandleLockToken(tokenString, c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.InvalidToken)
+		return
+	}
+
+	// End any session on the current device
+	if c.GetUserID() != "" {
+		c.LogoutUser(rw, req)
+	}
+
+	c.WriteAPIResult(rw, api.AccountLocked)
+}
+
 // Login to a user account
 // This is probably the most interesting endpoint IMO
 func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
@@ -119,10 +157,10 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Attempt login via UserControl interface
-	loginOk, u, e := c.cm.userControl.Login(email, password)
+	loginOk, u, e := c.cm.userControl.Login(email, password, c.GetMeta())
 	if e != nil {
 		// Run post login failure handlers
-		err := c.cm.PostLoginFailure(u)
+		err := c.cm.PostLoginFailure(u, c.GetMeta())
 		if err != nil {
 			log.Printf("Core.Login: PostLoginFailure error (%s)\n", err)
 			c.WriteInternalError(rw)
@@ -137,6 +175,14 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 	// Reject invalid credentials
 	if !loginOk {
 		log.Printf("Core.Login: invalid credentials\n")
+
+		// Run post login failure handlers, the user is nil for unknown accounts
+		failedUser, _ := c.cm.userControl.GetLoginUser(email)
+		err := c.cm.PostLoginFailure(failedUser, c.GetMeta())
+		if err != nil {
+			log.Printf("Core.Login: PostLoginFailure error (%s)\n", err)
+		}
+
 		c.WriteUnauthorized(rw)
 		return
 	}
@@ -149,16 +195,12 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
-	// Load flashes and apply actions if they exist
-	flashes := c.GetSession().Flashes()
-	if len(flashes) > 0 {
-		log.Printf("Core.Login: found session flash")
-
-		// Fetch token from session flash
-		tokenString := flashes[0].(string)
+	// Apply pending action tokens if they exist
+	if flow := c.GetActionFlow(rw, req, appcontext.FlowActionToken); flow != nil {
+		log.Printf("Core.Login: found token flow")
 
 		// Handle token and call require action
-		tokenOk, err := c.cm.HandleToken(user.GetExtID(), user, tokenString)
+		tokenOk, err := c.completeTokenFlow(rw, req, flow, user)
 		if err != nil {
 			c.WriteInternalError(rw)
 			return
@@ -169,7 +211,7 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 		}
 
 		// Reload login state
-		loginOk, u, e = c.cm.userControl.Login(email, password)
+		loginOk, u, e = c.cm.userControl.Login(email, password, c.GetMeta())
 		if e != nil {
 			c.WriteInternalError(rw)
 			log.Printf("Core.Login: user controller error %s\n", e)
@@ -179,10 +221,11 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Call PreLogin handlers
-	preLoginOk, err := c.cm.PreLogin(u)
+	c.markTrustedDevice(req, user.GetExtID())
+	preLoginOk, err := c.cm.PreLogin(u, c.GetMeta())
 	if err != nil {
 		log.Printf("Core.Login: PreLogin handler error (%s)\n", err)
-		c.WriteInternalError(rw)
+		c.writePreLoginError(rw, err)
 		return
 	}
 	if !preLoginOk {
@@ -191,13 +234,42 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
-	// Check for available second factors
-	secondFactorRequired, factorsAvailable := c.cm.CheckSecondFactors(user.GetExtID())
+	// Check login risk, flagged logins may require a second factor or email confirmation
+	riskAction, err := c.cm.CheckLoginRisk(u, c.GetMeta())
+	if err != nil {
+		log.Printf("Core.Login: login risk handler error (%s)\n", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if riskAction == api.RiskActionBlock {
+		log.Printf("Core.Login: login risk handler blocked login\n")
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.LoginBlocked)
+		return
+	}
+
+	// Check for available second factors, unless the device is trusted and the login has not been flagged
+	deviceToken := c.GetTrustedDeviceToken(req)
+	if riskAction != api.RiskActionAllow {
+		deviceToken = ""
+	}
+	secondFactorRequired, factorsAvailable := c.cm.CheckSecondFactors(user.GetExtID(), deviceToken)
+
+	// Require email confirmation where requested, or where a second factor is required but not available
+	if riskAction == api.RiskActionEmailConfirmation || (riskAction == api.RiskActionSecondFactor && !secondFactorRequired) {
+		log.Println("Core.Login: Partial login (email confirmation required)")
+		c.startLoginConfirmation(rw, req, user)
+		return
+	}
 
 	// Respond with list of available 2fa components if required
 	if loginOk && preLoginOk && secondFactorRequired {
 		log.Println("Core.Login: Partial login (2fa required)")
-		c.Bind2FARequest(rw, req, user.GetExtID(), "login")
+		err = c.Bind2FARequest(rw, req, user.GetExtID(), appcontext.FlowActionLogin, factorsAvailable, appcontext.LoginMethodPassword)
+		if err != nil {
+			log.Printf("Core.Login: error binding 2fa request (%s)\n", err)
+			c.WriteInternalError(rw)
+			return
+		}
 		c.WriteJSONWithStatus(rw, http.StatusAccepted, factorsAvailable)
 		return
 	}
@@ -205,7 +277,7 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 	// Handle login success
 	if loginOk && preLoginOk {
 		// Run post login success handlers
-		err := c.cm.PostLoginSuccess(u)
+		err := c.cm.PostLoginSuccess(u, c.GetMeta())
 		if err != nil {
 			log.Printf("Core.Login: PostLoginSuccess error (%s)\n", err)
 			c.WriteInternalError(rw)
@@ -215,7 +287,7 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 		log.Printf("Core.Login: Login OK for user: %s", user.GetExtID())
 
 		// Create session
-		c.LoginUser(user.GetExtID(), rw, req)
+		c.LoginUser(user.GetExtID(), rw, req, appcontext.LoginMethodPassword)
 
 		c.WriteAPIResult(rw, api.LoginSuccessful)
 		return
@@ -226,6 +298,180 @@ func (c *coreCtx) Login(rw web.ResponseWriter, req *web.Request) {
 	c.WriteInternalError(rw)
 }
 
+// Email login endpoints provide passwordless login via single use links
+
+// LoginEmailPost takes an email input to send a login link
+// This always responds OK to avoid leaking account information
+func (c *coreCtx) LoginEmailPost(rw web.ResponseWriter, req *web.Request) {
+	email := req.FormValue("email")
+	if !govalidator.IsEmail(email) {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.InvalidEmail)
+		return
+	}
+
+	// Check user is not already logged in
+	if c.GetUserID() != "" {
+		log.Printf("Core.LoginEmailPost: user already authenticated (%s)\n", c.GetUserID())
+		c.WriteAPIResult(rw, api.AlreadyAuthenticated)
+		return
+	}
+
+	// Save login email to session
+	c.BindLoginEmail(rw, req, email)
+
+	// Start email login (creates event and prompts email sending)
+	err := c.cm.LoginEmailStart(email, c.GetMeta())
+	if err != nil {
+		log.Printf("Core.LoginEmailPost error starting email login for user %s (%s)", email, err)
+	}
+
+	log.Printf("Core.LoginEmailPost started email login for user %s", email)
+
+	c.WriteAPIResult(rw, api.OK)
+}
+
+// startLoginConfirmation requests email confirmation of a flagged login
+// The confirmation link is bound to the current session and completed via the email login endpoint
+func (c *coreCtx) startLoginConfirmation(rw web.ResponseWriter, req *web.Request, user UserInterface) {
+	c.BindLoginEmail(rw, req, user.GetEmail())
+
+	c.cm.LoginConfirmStart(user.GetExtID(), c.GetMeta())
+
+	c.WriteAPIResultWithCode(rw, http.StatusAccepted, api.LoginConfirmRequired)
+}
+
+// completeTokenFlow applies the pending action token for a user
+// This binds the token flow to the user at the credentials step and handles the token at the token action step.
+// Tokens are single use, so the flow is removed whether or not the token is accepted
+func (c *coreCtx) completeTokenFlow(rw web.ResponseWriter, req *web.Request, flow *appcontext.Flow, user UserInterface) (bool, error) {
+	tokenString, _ := flow.Data.(string)
+
+	err := c.CompleteFlowStep(rw, req, user.GetExtID(), appcontext.FlowActionToken, appcontext.FlowStepCredentials, nil)
+	if err != nil {
+		log.Printf("Core.completeTokenFlow: error completing credentials step (%s)", err)
+		c.CancelFlow(rw, req, appcontext.FlowActionToken)
+		return false, nil
+	}
+
+	tokenOk := false
+	err = c.CompleteFlowStep(rw, req, user.GetExtID(), appcontext.FlowActionToken, appcontext.FlowStepTokenAction, func() error {
+		var err error
+		tokenOk, err = c.cm.HandleToken(user.GetExtID(), user, tokenString, c.GetMeta())
+		return err
+	})
+	if err != nil {
+		c.CancelFlow(rw, req, appcontext.FlowActionToken)
+		return false, err
+	}
+
+	return tokenOk, nil
+}
+
+// markTrustedDevice marks logins from devices trusted by the user in the request metadata
+// This allows PreLogin handlers to exempt trusted devices from lockouts caused by failures from other sources
+func (c *coreCtx) markTrustedDevice(req *web.Request, userid string) {
+	if c.cm.IsTrustedDevice(userid, c.GetTrustedDeviceToken(req)) {
+		c.GetMeta()[appcontext.TrustedDeviceMeta] = "true"
+	}
+}
+
+// LoginEmailGet handles a login link token
+func (c *coreCtx) LoginEmailGet(rw web.ResponseWriter, req *web.Request) {
+	tokenString := req.URL.Query().Get("token")
+	if tokenString == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.MissingToken)
+		return
+	}
+
+	// Check user is not already logged in
+	if c.GetUserID() != "" {
+		log.Printf("Core.LoginEmailGet: user already authenticated (%s)\n", c.GetUserID())
+		c.WriteAPIResult(rw, api.AlreadyAuthenticated)
+		return
+	}
+
+	// Fetch login session key
+	// This requires login links to be requested and applied on the same device
+	email := c.TakeLoginEmail(rw, req)
+	if email == "" {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.NoLoginPending)
+		return
+	}
+
+	// Validate login token
+	ok, u, err := c.cm.HandleLoginToken(email, tokenString)
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.InvalidToken)
+		return
+	}
+
+	user := u.(UserInterface)
+
+	// Call PreLogin handlers
+	c.markTrustedDevice(req, user.GetExtID())
+	preLoginOk, err := c.cm.PreLogin(u, c.GetMeta())
+	if err != nil {
+		log.Printf("Core.LoginEmailGet: PreLogin handler error (%s)\n", err)
+		c.writePreLoginError(rw, err)
+		return
+	}
+	if !preLoginOk {
+		log.Printf("Core.LoginEmailGet: PreLogin handler blocked login\n")
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.AccountLocked)
+		return
+	}
+
+	// Check login risk, use of the login link satisfies email confirmation
+	riskAction, err := c.cm.CheckLoginRisk(u, c.GetMeta())
+	if err != nil {
+		log.Printf("Core.LoginEmailGet: login risk handler error (%s)\n", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if riskAction == api.RiskActionBlock {
+		log.Printf("Core.LoginEmailGet: login risk handler blocked login\n")
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.LoginBlocked)
+		return
+	}
+
+	// Check for available second factors, unless the device is trusted and a second factor has not been required
+	deviceToken := c.GetTrustedDeviceToken(req)
+	if riskAction == api.RiskActionSecondFactor {
+		deviceToken = ""
+	}
+	secondFactorRequired, factorsAvailable := c.cm.CheckSecondFactors(user.GetExtID(), deviceToken)
+	if secondFactorRequired {
+		log.Println("Core.LoginEmailGet: Partial login (2fa required)")
+		err = c.Bind2FARequest(rw, req, user.GetExtID(), appcontext.FlowActionLogin, factorsAvailable, appcontext.LoginMethodEmail)
+		if err != nil {
+			log.Printf("Core.LoginEmailGet: error binding 2fa request (%s)\n", err)
+			c.WriteInternalError(rw)
+			return
+		}
+		c.WriteJSONWithStatus(rw, http.StatusAccepted, factorsAvailable)
+		return
+	}
+
+	// Run post login success handlers
+	err = c.cm.PostLoginSuccess(u, c.GetMeta())
+	if err != nil {
+		log.Printf("Core.LoginEmailGet: PostLoginSuccess error (%s)\n", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	log.Printf("Core.LoginEmailGet: Login OK for user: %s", user.GetExtID())
+
+	// Create session
+	c.LoginUser(user.GetExtID(), rw, req, appcontext.LoginMethodEmail)
+
+	c.WriteAPIResult(rw, api.LoginSuccessful)
+}
+
 func (c *coreCtx) SecondFactorStatus(rw web.ResponseWriter, req *web.Request) {
 	if c.GetUserID() == "" {
 		c.WriteUnauthorized(rw)
@@ -233,11 +479,16 @@ func (c *coreCtx) SecondFactorStatus(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Check for available second factors
-	_, factorsAvailable := c.cm.CheckSecondFactors(c.GetUserID())
+	_, factorsAvailable := c.cm.CheckSecondFactors(c.GetUserID(), "")
 
 	c.WriteJSON(rw, factorsAvailable)
 }
 
+// FlowGet reports the next step required to complete the current authentication flow
+func (c *coreCtx) FlowGet(rw web.ResponseWriter, req *web.Request) {
+	c.WriteJSON(rw, c.GetFlowStatus(rw, req))
+}
+
 // Logout Endpoint ends a user session
 func (c *coreCtx) Logout(rw web.ResponseWriter, req *web.Request) {
 	c.LogoutUser(rw, req)
@@ -250,11 +501,118 @@ func (c *coreCtx) Logout(rw web.ResponseWriter, req *web.Request) {
 	c.WriteAPIResult(rw, api.LogoutSuccessful)
 }
 
+// Sudo endpoints allow logged in users to reauthorize for protected account actions
+
+// SudoGet reports the status of the current sudo session
+func (c *coreCtx) SudoGet(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	c.WriteJSON(rw, c.GetSudoStatus(rw, req))
+}
+
+// SudoPost rechecks a logged in users password to start a sudo session
+// Users with second factors enabled must then complete a second factor to finish the sudo flow
+func (c *coreCtx) SudoPost(rw web.ResponseWriter, req *web.Request) {
+	userID := c.GetUserID()
+	if userID == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	password := req.FormValue("password")
+	if password == "" {
+		log.Printf("Core.SudoPost invalid request (missing password)")
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.MissingPassword)
+		return
+	}
+
+	// Fetch the logged in user to recheck credentials
+	u, err := c.cm.userControl.GetLoginUserByExtID(userID)
+	if err != nil {
+		log.Printf("Core.SudoPost: error fetching user %s (%s)", userID, err)
+		c.WriteInternalError(rw)
+		return
+	}
+	user, ok := u.(UserInterface)
+	if !ok {
+		log.Printf("Core.SudoPost: user %s not found", userID)
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	// Check the password without login side effects, so failures are only counted by login failure hooks
+	passwordOk, err := c.cm.userControl.CheckPassword(userID, password)
+	if err != nil {
+		log.Printf("Core.SudoPost: user controller error (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+	if !passwordOk {
+		log.Printf("Core.SudoPost: invalid credentials for user %s", userID)
+		err = c.cm.PostLoginFailure(user, c.GetMeta())
+		if err != nil {
+			log.Printf("Core.SudoPost: PostLoginFailure error (%s)", err)
+		}
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	// Locked or disabled accounts can not reauthorize
+	preLoginOk, err := c.cm.PreLogin(u, c.GetMeta())
+	if err != nil {
+		log.Printf("Core.SudoPost: PreLogin handler error (%s)", err)
+		c.writePreLoginError(rw, err)
+		return
+	}
+	if !preLoginOk {
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.AccountLocked)
+		return
+	}
+
+	// Require a second factor if available, regardless of device trust
+	secondFactorRequired, factorsAvailable := c.cm.CheckSecondFactors(userID, "")
+	if secondFactorRequired {
+		log.Printf("Core.SudoPost: second factor required for user %s", userID)
+		err = c.Bind2FARequest(rw, req, userID, appcontext.FlowActionSudo, factorsAvailable)
+		if err != nil {
+			log.Printf("Core.SudoPost: error binding 2fa request (%s)", err)
+			c.WriteInternalError(rw)
+			return
+		}
+		c.WriteJSONWithStatus(rw, http.StatusAccepted, factorsAvailable)
+		return
+	}
+
+	// Otherwise the sudo flow completes immediately
+	err = c.StartFlow(rw, req, userID, appcontext.FlowActionSudo, nil)
+	if err != nil {
+		log.Printf("Core.SudoPost: error starting sudo flow (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SudoSuccessful)
+}
+
+// SudoEnd ends the current sudo session
+func (c *coreCtx) SudoEnd(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	c.ClearSudo(rw, req)
+
+	c.WriteAPIResult(rw, api.SudoEnded)
+}
+
 // Recover endpoints provide mechanisms for user account recovery
 
 const (
-	recoverySessionKey = "recovery-session"
-	recoveryEmailKey   = "recovery-email"
+	recoveryEmailKey = "recovery-email"
 )
 
 // RecoverPost takes an email input to start the recovery process
@@ -293,7 +651,7 @@ func (c *coreCtx) RecoverGet(rw web.ResponseWriter, req *web.Request) {
 	// This requires recovery tokens to be requested and applied on the same device
 	session := c.GetSession()
 	e := session.Values[recoveryEmailKey]
-	session.Options.MaxAge = -1
+	delete(session.Values, recoveryEmailKey)
 	session.Save(req.Request, rw)
 
 	if e == nil {
@@ -317,22 +675,32 @@ func (c *coreCtx) RecoverGet(rw web.ResponseWriter, req *web.Request) {
 
 	log.Printf("Core.RecoverGet continuing recovery for user %s", user.GetExtID())
 
-	// Check if 2fa is required
-	secondFactorRequired, factorsAvailable := c.cm.CheckSecondFactors(user.GetExtID())
+	// Check if 2fa is required, regardless of device trust
+	secondFactorRequired, factorsAvailable := c.cm.CheckSecondFactors(user.GetExtID(), "")
 	if secondFactorRequired {
 		log.Printf("Core.RecoverGet recovery requires 2fa for user %s", user.GetExtID())
 
 		// Bind 2fa request with recovery action
-		// c.UserAction will be called at completion with the recover action
-		c.Bind2FARequest(rw, req, user.GetExtID(), "recover")
+		// The recovery flow will be completed by the 2fa module
+		err = c.Bind2FARequest(rw, req, user.GetExtID(), appcontext.FlowActionRecover, factorsAvailable)
+		if err != nil {
+			log.Printf("Core.RecoverGet error binding 2fa request (%s)", err)
+			c.WriteInternalError(rw)
+			return
+		}
 
 		// Write available factors to client
 		c.WriteJSONWithStatus(rw, http.StatusAccepted, factorsAvailable)
 		return
 	}
 
-	// Bind recovery request flag to session
-	c.BindRecoveryRequest(user.GetExtID(), rw, req)
+	// Bind completed recovery flow to session
+	err = c.StartFlow(rw, req, user.GetExtID(), appcontext.FlowActionRecover, nil)
+	if err != nil {
+		log.Printf("Core.RecoverGet error binding recovery request (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
 
 	log.Printf("Core.RecoverGet bound recovery session for user %s", user.GetExtID())
 
@@ -344,3 +712,13 @@ func (c *coreCtx) RecoverGet(rw web.ResponseWriter, req *web.Request) {
 func (c *coreCtx) TestGet(rw web.ResponseWriter, req *web.Request) {
 	c.WriteAPIResult(rw, api.OK)
 }
+
+// writePreLoginError writes the response for PreLogin handler errors
+// Rate limited requests are reported to the client, other errors are internal
+func (c *coreCtx) writePreLoginError(rw web.ResponseWriter, err error) {
+	if rle, ok := err.(*api.RateLimitError); ok {
+		c.WriteRateLimited(rw, rle.RetryAfter)
+		return
+	}
+	c.WriteInternalError(rw)
+}
@@ -10,12 +10,14 @@ package core
 import (
 	"net/http"
 	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
 	"github.com/authplz/authplz-core/lib/controllers/datastore"
 	"github.com/authplz/authplz-core/lib/events"
 	"github.com/authplz/authplz-core/lib/modules/user"
@@ -27,10 +29,13 @@ func TestCoreAPI(t *testing.T) {
 	ts, err := test.NewTestServer()
 	assert.Nil(t, err)
 
-	userModule := user.NewController(ts.DataStore, ts.EventEmitter)
+	userModule := user.NewController(ts.DataStore, ts.Config.Lockout, ts.EventEmitter)
 
 	coreModule := NewController(ts.TokenControl, userModule, ts.EventEmitter)
 	coreModule.BindModule("user", userModule)
+	coreModule.BindActionHandler(api.TokenActionLock, userModule)
+	coreModule.BindActionHandler(api.TokenActionActivate, userModule)
+	coreModule.BindFlows(ts.Ctx, time.Minute)
 	coreModule.BindAPI(ts.Router)
 	userModule.BindAPI(ts.Router)
 
@@ -70,6 +75,262 @@ func TestCoreAPI(t *testing.T) {
 		// Check user status
 		_, err = client.Get("/status", http.StatusOK)
 		assert.Nil(t, err)
+
+		// Check flow status
+		var status appcontext.FlowStatus
+		err = client.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, appcontext.FlowStepComplete, status.Step)
+	})
+
+	t.Run("Sudo endpoints work", func(t *testing.T) {
+		client := test.NewClient("http://" + ts.Address() + "/api")
+
+		v := url.Values{}
+		v.Set("password", test.FakePass)
+
+		// Users must be logged in to reauthorize
+		_, err := client.PostForm("/sudo", http.StatusUnauthorized, v)
+		assert.Nil(t, err)
+
+		lv := url.Values{}
+		lv.Set("email", test.FakeEmail)
+		lv.Set("password", test.FakePass)
+		_, err = client.PostForm("/login", http.StatusOK, lv)
+		assert.Nil(t, err)
+
+		var status appcontext.SudoStatus
+		err = client.GetJSON("/sudo", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.False(t, status.Active)
+
+		// Invalid passwords are rejected, and counted once by login failure hooks rather than by login retries
+		_, err = client.PostForm("/sudo", http.StatusUnauthorized, url.Values{"password": {"Wrong password"}})
+		assert.Nil(t, err)
+		assert.EqualValues(t, events.LoginFailure, ts.EventEmitter.Event.Type)
+
+		u, _ := ts.DataStore.GetUserByEmail(test.FakeEmail)
+		assert.EqualValues(t, 0, u.(*datastore.User).GetLoginRetries())
+
+		// Valid passwords start a sudo session
+		resp, err := client.PostForm("/sudo", http.StatusOK, v)
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.SudoSuccessful)
+		assert.Nil(t, err)
+
+		err = client.GetJSON("/sudo", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.True(t, status.Active)
+
+		// Sudo sessions can be ended
+		_, err = client.PostForm("/sudo/end", http.StatusOK, url.Values{})
+		assert.Nil(t, err)
+
+		err = client.GetJSON("/sudo", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.False(t, status.Active)
+	})
+
+	t.Run("Users can list and revoke sessions", func(t *testing.T) {
+		lv := url.Values{}
+		lv.Set("email", test.FakeEmail)
+		lv.Set("password", test.FakePass)
+
+		client1 := test.NewClient("http://" + ts.Address() + "/api")
+		_, err := client1.PostForm("/login", http.StatusOK, lv)
+		assert.Nil(t, err)
+
+		client2 := test.NewClient("http://" + ts.Address() + "/api")
+		_, err = client2.PostForm("/login", http.StatusOK, lv)
+		assert.Nil(t, err)
+
+		// Find the session for the second client
+		sessions := make([]struct {
+			ID      uint `json:"id"`
+			Current bool `json:"current"`
+		}, 0)
+		err = client2.GetJSON("/sessions", http.StatusOK, &sessions)
+		assert.Nil(t, err)
+
+		var id uint
+		for _, s := range sessions {
+			if s.Current {
+				id = s.ID
+			}
+		}
+		if id == 0 {
+			t.Errorf("Current session not found in session list")
+			t.FailNow()
+		}
+
+		// Revoke the second session from the first client
+		resp, err := client1.PostForm("/sessions/revoke", http.StatusOK, url.Values{"id": {strconv.Itoa(int(id))}})
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.SessionRevoked)
+		assert.Nil(t, err)
+
+		_, err = client2.Get("/status", http.StatusUnauthorized)
+		assert.Nil(t, err)
+		_, err = client1.Get("/status", http.StatusOK)
+		assert.Nil(t, err)
+
+		// Log out everywhere
+		resp, err = client1.PostForm("/sessions/revoke-all", http.StatusOK, url.Values{})
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.SessionsRevoked)
+		assert.Nil(t, err)
+
+		_, err = client1.Get("/status", http.StatusUnauthorized)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Users can delete accounts", func(t *testing.T) {
+		client := test.NewClient("http://" + ts.Address() + "/api")
+
+		email := "delete@abc.com"
+
+		v := url.Values{}
+		v.Set("email", email)
+		v.Set("password", test.FakePass)
+		v.Set("username", "delete.user")
+		_, err := client.PostForm("/create", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		u, _ := ts.DataStore.GetUserByEmail(email)
+		u.(*datastore.User).SetActivated(true)
+		ts.DataStore.UpdateUser(u)
+
+		v = url.Values{}
+		v.Set("email", email)
+		v.Set("password", test.FakePass)
+		_, err = client.PostForm("/login", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		// Account deletion requires reauthorization
+		resp, err := client.PostForm("/account/delete", http.StatusForbidden, url.Values{})
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.SudoRequired)
+		assert.Nil(t, err)
+
+		_, err = client.PostForm("/sudo", http.StatusOK, url.Values{"password": {test.FakePass}})
+		assert.Nil(t, err)
+
+		resp, err = client.PostForm("/account/delete", http.StatusOK, url.Values{})
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.AccountDeleted)
+		assert.Nil(t, err)
+		assert.EqualValues(t, events.AccountDeleted, ts.EventEmitter.Event.GetType())
+
+		// Deleted accounts are logged out and removed
+		_, err = client.Get("/status", http.StatusUnauthorized)
+		assert.Nil(t, err)
+
+		_, err = client.PostForm("/login", http.StatusUnauthorized, v)
+		assert.Nil(t, err)
+
+		u, err = ts.DataStore.GetUserByEmail(email)
+		assert.Nil(t, err)
+		assert.Nil(t, u)
+	})
+
+	t.Run("Lock links lock accounts", func(t *testing.T) {
+		client := test.NewClient("http://" + ts.Address() + "/api")
+
+		email := "lock@abc.com"
+
+		v := url.Values{}
+		v.Set("email", email)
+		v.Set("password", test.FakePass)
+		v.Set("username", "lock.user")
+		_, err := client.PostForm("/create", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		u, _ := ts.DataStore.GetUserByEmail(email)
+		u.(*datastore.User).SetActivated(true)
+		ts.DataStore.UpdateUser(u)
+
+		v = url.Values{}
+		v.Set("email", email)
+		v.Set("password", test.FakePass)
+		_, err = client.PostForm("/login", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		// Lock tokens are only accepted for the lock action
+		token, err := ts.TokenControl.BuildToken(u.(*datastore.User).GetExtID(), api.TokenActionUnlock, time.Hour)
+		assert.Nil(t, err)
+		_, err = client.GetWithParams("/lock", http.StatusBadRequest, url.Values{"token": {token}})
+		assert.Nil(t, err)
+
+		// Lock links work without login, and revoke existing sessions
+		lockClient := test.NewClient("http://" + ts.Address() + "/api")
+		token, err = ts.TokenControl.BuildToken(u.(*datastore.User).GetExtID(), api.TokenActionLock, time.Hour)
+		assert.Nil(t, err)
+		resp, err := lockClient.GetWithParams("/lock", http.StatusOK, url.Values{"token": {token}})
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.AccountLocked)
+		assert.Nil(t, err)
+
+		_, err = client.Get("/status", http.StatusUnauthorized)
+		assert.Nil(t, err)
+		_, err = client.PostForm("/login", http.StatusUnauthorized, v)
+		assert.Nil(t, err)
+
+		// Lock tokens are single use
+		_, err = lockClient.GetWithParams("/lock", http.StatusBadRequest, url.Values{"token": {token}})
+		assert.Nil(t, err)
+	})
+
+	t.Run("Action tokens are applied at login", func(t *testing.T) {
+		client := test.NewClient("http://" + ts.Address() + "/api")
+
+		email := "activate@abc.com"
+
+		v := url.Values{}
+		v.Set("email", email)
+		v.Set("password", test.FakePass)
+		v.Set("username", "activate.user")
+		_, err := client.PostForm("/create", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		u, _ := ts.DataStore.GetUserByEmail(email)
+
+		// Tokens are bound to a token flow until the user logs in
+		token, err := ts.TokenControl.BuildToken(user.GetExtID(), api.TokenActionActivate, time.Hour)
+		assert.Nil(t, err)
+		_, err = client.PostForm("/action", http.StatusOK, url.Values{"token": {token}})
+		assert.Nil(t, err)
+
+		var status appcontext.FlowStatus
+		err = client.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, appcontext.FlowActionToken, status.Action)
+		assert.EqualValues(t, appcontext.FlowStepCredentials, status.Step)
+
+		// Tokens issued to other users are rejected and removed
+		v = url.Values{}
+		v.Set("email", email)
+		v.Set("password", test.FakePass)
+		resp, err := client.PostForm("/login", http.StatusBadRequest, v)
+		assert.Nil(t, err)
+		err = test.ParseAndCheckAPIResponse(resp, api.InvalidToken)
+		assert.Nil(t, err)
+
+		err = client.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, "", status.Action)
+
+		// Tokens for the user are applied before login
+		token, err = ts.TokenControl.BuildToken(u.(*datastore.User).GetExtID(), api.TokenActionActivate, time.Hour)
+		assert.Nil(t, err)
+		_, err = client.PostForm("/action", http.StatusOK, url.Values{"token": {token}})
+		assert.Nil(t, err)
+
+		_, err = client.PostForm("/login", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		err = client.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, appcontext.FlowStepComplete, status.Step)
 	})
 
 	t.Run("Invalid account fails", func(t *testing.T) {
@@ -96,6 +357,11 @@ func TestCoreAPI(t *testing.T) {
 			t.Error(err)
 			t.FailNow()
 		}
+
+		// Failed logins are passed to login failure hooks with the user
+		assert.NotNil(t, ts.EventEmitter.Event)
+		assert.EqualValues(t, events.LoginFailure, ts.EventEmitter.Event.Type)
+		assert.EqualValues(t, user.GetExtID(), ts.EventEmitter.Event.GetUserExtID())
 	})
 
 	t.Run("Account recovery endpoints work", func(t *testing.T) {
@@ -120,12 +386,85 @@ func TestCoreAPI(t *testing.T) {
 		_, err = client.GetWithParams("/recovery", http.StatusOK, v)
 		assert.Nil(t, err)
 
+		// Check recovery flow has been completed
+		var status appcontext.FlowStatus
+		err = client.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, appcontext.FlowActionRecover, status.Action)
+		assert.EqualValues(t, appcontext.FlowStepComplete, status.Step)
+
 		// Post new password to user reset endpoint
 		newPass := "Reset Password 78@"
 		v = url.Values{}
 		v.Set("password", newPass)
 		_, err = client.PostForm("/reset", http.StatusOK, v)
 		assert.Nil(t, err)
+
+		// Recovery requests can only be used once
+		_, err = client.PostForm("/reset", http.StatusBadRequest, v)
+		assert.Nil(t, err)
+
+		err = client.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, appcontext.FlowStepCredentials, status.Step)
+	})
+
+	t.Run("Email login endpoints work", func(t *testing.T) {
+		client := test.NewClient("http://" + ts.Address() + "/api")
+
+		// Tokens are rejected without a pending email login
+		d, _ := time.ParseDuration("10m")
+		token, _ := ts.TokenControl.BuildToken(user.GetExtID(), api.TokenActionLogin, d)
+
+		v := url.Values{}
+		v.Set("token", token)
+		_, err := client.GetWithParams("/login/email", http.StatusBadRequest, v)
+		assert.Nil(t, err)
+
+		// Post login request to /api/login/email
+		v = url.Values{}
+		v.Set("email", test.FakeEmail)
+		_, err = client.PostForm("/login/email", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		// Check for email login event
+		assert.EqualValues(t, events.LoginEmailReq, ts.EventEmitter.Event.GetType())
+
+		// Tokens for other actions are rejected
+		recoveryToken, _ := ts.TokenControl.BuildToken(user.GetExtID(), api.TokenActionRecovery, d)
+		v = url.Values{}
+		v.Set("token", recoveryToken)
+		_, err = client.GetWithParams("/login/email", http.StatusBadRequest, v)
+		assert.Nil(t, err)
+
+		// Login with a valid token
+		_, err = client.PostForm("/login/email", http.StatusOK, url.Values{"email": {test.FakeEmail}})
+		assert.Nil(t, err)
+
+		v = url.Values{}
+		v.Set("token", token)
+		_, err = client.GetWithParams("/login/email", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		_, err = client.Get("/status", http.StatusOK)
+		assert.Nil(t, err)
+
+		// Tokens can only be used once
+		client = test.NewClient("http://" + ts.Address() + "/api")
+		_, err = client.PostForm("/login/email", http.StatusOK, url.Values{"email": {test.FakeEmail}})
+		assert.Nil(t, err)
+
+		_, err = client.GetWithParams("/login/email", http.StatusBadRequest, v)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Email login does not leak account information", func(t *testing.T) {
+		client := test.NewClient("http://" + ts.Address() + "/api")
+
+		v := url.Values{}
+		v.Set("email", "wrong@email.com")
+		_, err := client.PostForm("/login/email", http.StatusOK, v)
+		assert.Nil(t, err)
 	})
 
 }
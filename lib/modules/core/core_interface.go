@@ -15,13 +15,21 @@ import (
 // LoginProvider Interface for a user control module
 type LoginProvider interface {
 	// Login method, returns boolean result, user interface for further use, error in case of failure
-	Login(email string, password string) (bool, interface{}, error)
+	Login(email string, password string, meta map[string]string) (bool, interface{}, error)
 	GetUserByEmail(email string) (interface{}, error)
+	// Fetch the user interface for passwordless logins, as consumed by login hooks
+	GetLoginUser(email string) (interface{}, error)
+	// Fetch the user interface by external ID for completion of second factor logins
+	GetLoginUserByExtID(userid string) (interface{}, error)
+	// Check the password for a user by external ID without the side effects of a login
+	CheckPassword(userid, password string) (bool, error)
 }
 
 // TokenValidator Interface for token validation
 type TokenValidator interface {
 	ValidateToken(userid string, tokenString string) (*api.TokenAction, error)
+	GetTokenUser(tokenString string) (string, error)
+	SetUsed(tokenString string) error
 }
 
 // SecondFactorProvider for 2 factor authentication modules
@@ -33,28 +41,43 @@ type SecondFactorProvider interface {
 	IsSupported(userid string) bool
 }
 
+// TrustedDeviceProvider for trusted device modules
+// These modules allow second factor authentication to be skipped on devices previously trusted by a user
+type TrustedDeviceProvider interface {
+	// Check whether the provided device token identifies a device trusted by the user
+	IsTrustedDevice(userid, deviceToken string) bool
+}
+
 // TokenHandler for token handler modules
 // These modules accept a token action and user id to execute a task
 // For example, the user module accepts 'activate' and 'unlock' actions
 type TokenHandler interface {
-	HandleToken(userid string, tokenAction api.TokenAction) error
+	HandleToken(userid string, tokenAction api.TokenAction, meta map[string]string) error
 }
 
 // Core Event Hook Interfaces
+// Hooks are provided with the request metadata (remote address, user agent etc.) for the login
 
 // PreLoginHook PreLogin hooks may allow or deny login
 type PreLoginHook interface {
-	PreLogin(u interface{}) (bool, error)
+	PreLogin(u interface{}, meta map[string]string) (bool, error)
+}
+
+// LoginRiskHook Login risk hooks assess logins following PreLogin checks
+// Hooks return the api.RiskAction required to continue the login, and the reason where a login is flagged
+type LoginRiskHook interface {
+	CheckLoginRisk(u interface{}, meta map[string]string) (api.RiskAction, string, error)
 }
 
 // PostLoginSuccessHook Post login success hooks called on login success
 type PostLoginSuccessHook interface {
-	PostLoginSuccess(u interface{}) error
+	PostLoginSuccess(u interface{}, meta map[string]string) error
 }
 
 // PostLoginFailureHook Post login failure hooks called on login failure
+// The user is nil where a login is attempted for an unknown account
 type PostLoginFailureHook interface {
-	PostLoginFailure(u interface{}) error
+	PostLoginFailure(u interface{}, meta map[string]string) error
 }
 
 // EventHandler Interface for event handler modules
@@ -15,6 +15,7 @@ import (
 	"github.com/authplz/authplz-core/lib/api"
 	"github.com/authplz/authplz-core/lib/controllers/datastore"
 	"github.com/authplz/authplz-core/lib/controllers/token"
+	"github.com/authplz/authplz-core/lib/events"
 
 	"github.com/authplz/authplz-core/lib/test"
 )
@@ -30,10 +31,11 @@ type MockHandler struct {
 	TokenAction          api.TokenAction
 	LoginAllowed         bool
 	u                    interface{}
+	PostLoginCalled      bool
 }
 
 // user controller interface
-func (mh *MockHandler) Login(email string, password string) (bool, interface{}, error) {
+func (mh *MockHandler) Login(email string, password string, meta map[string]string) (bool, interface{}, error) {
 	var u interface{}
 	return mh.LoginCallResp, u, nil
 }
@@ -42,21 +44,56 @@ func (mh *MockHandler) GetUserByEmail(email string) (interface{}, error) {
 	return mh.u, nil
 }
 
+func (mh *MockHandler) GetLoginUser(email string) (interface{}, error) {
+	return mh.u, nil
+}
+
+func (mh *MockHandler) GetLoginUserByExtID(userid string) (interface{}, error) {
+	return mh.u, nil
+}
+
+func (mh *MockHandler) CheckPassword(userid, password string) (bool, error) {
+	return mh.LoginCallResp, nil
+}
+
 // 2fa handler interface
 func (mh *MockHandler) IsSupported(userid string) bool {
 	return mh.SecondFactorRequired
 }
 
 // token handler interface
-func (mh *MockHandler) HandleToken(userid string, tokenAction api.TokenAction) error {
+func (mh *MockHandler) HandleToken(userid string, tokenAction api.TokenAction, meta map[string]string) error {
 	mh.TokenAction = tokenAction
 	return nil
 }
 
-func (mh *MockHandler) PreLogin(u interface{}) (bool, error) {
+func (mh *MockHandler) PreLogin(u interface{}, meta map[string]string) (bool, error) {
 	return mh.LoginAllowed, nil
 }
 
+func (mh *MockHandler) PostLoginSuccess(u interface{}, meta map[string]string) error {
+	mh.PostLoginCalled = true
+	return nil
+}
+
+// trusted device handler interface
+type MockTrustedDevice struct {
+	Token string
+}
+
+func (mtd *MockTrustedDevice) IsTrustedDevice(userid, deviceToken string) bool {
+	return deviceToken == mtd.Token
+}
+
+// login risk handler interface
+type MockLoginRisk struct {
+	Action api.RiskAction
+}
+
+func (mlr *MockLoginRisk) CheckLoginRisk(u interface{}, meta map[string]string) (api.RiskAction, string, error) {
+	return mlr.Action, "mock reason", nil
+}
+
 type FakeActionTokenStore struct {
 	tokens map[string]datastore.ActionToken
 }
@@ -101,9 +138,10 @@ func TestCoreModule(t *testing.T) {
 
 	tokenControl := token.NewTokenController("localhost", "ABCD", NewFakeActionTokenStore())
 
-	mockHandler := MockHandler{false, false, api.TokenActionInvalid, false, nil}
+	mockHandler := MockHandler{false, false, api.TokenActionInvalid, false, nil, false}
+	mockEventEmitter := test.MockEventEmitter{}
 
-	coreControl := NewController(tokenControl, &mockHandler, &test.MockEventEmitter{})
+	coreControl := NewController(tokenControl, &mockHandler, &mockEventEmitter)
 
 	t.Run("Bind and call token action handlers", func(t *testing.T) {
 		var u interface{}
@@ -115,7 +153,7 @@ func TestCoreModule(t *testing.T) {
 		token, _ := tokenControl.BuildToken("fakeid", mockAction, d)
 
 		mockHandler.TokenAction = api.TokenActionInvalid
-		ok, err := coreControl.HandleToken("fakeid", u, token)
+		ok, err := coreControl.HandleToken("fakeid", u, token, nil)
 		if err != nil {
 			t.Error(err)
 		}
@@ -131,7 +169,7 @@ func TestCoreModule(t *testing.T) {
 		coreControl.BindSecondFactor("mock-2fa", &mockHandler)
 
 		mockHandler.SecondFactorRequired = false
-		required, available := coreControl.CheckSecondFactors("fake")
+		required, available := coreControl.CheckSecondFactors("fake", "")
 		if required {
 			t.Errorf("CheckSecondFactors expected required=false, received required=true")
 		}
@@ -140,7 +178,7 @@ func TestCoreModule(t *testing.T) {
 		}
 
 		mockHandler.SecondFactorRequired = true
-		required, available = coreControl.CheckSecondFactors("fake")
+		required, available = coreControl.CheckSecondFactors("fake", "")
 		if !required {
 			t.Errorf("CheckSecondFactors expected required=true, received required=false")
 		}
@@ -150,13 +188,65 @@ func TestCoreModule(t *testing.T) {
 		}
 	})
 
+	t.Run("Bind and check trusted device handlers", func(t *testing.T) {
+		coreControl.BindTrustedDevice("mock-device", &MockTrustedDevice{Token: "trusted-token"})
+
+		mockHandler.SecondFactorRequired = true
+		required, _ := coreControl.CheckSecondFactors("fake", "untrusted-token")
+		if !required {
+			t.Errorf("CheckSecondFactors expected required=true for untrusted device")
+		}
+
+		required, _ = coreControl.CheckSecondFactors("fake", "trusted-token")
+		if required {
+			t.Errorf("CheckSecondFactors expected required=false for trusted device")
+		}
+
+		required, _ = coreControl.CheckSecondFactors("fake", "")
+		if !required {
+			t.Errorf("CheckSecondFactors expected required=true with no device token")
+		}
+	})
+
+	t.Run("Bind and check login risk handlers", func(t *testing.T) {
+		u := &datastore.User{ExtID: "fake", Email: fakeEmail}
+
+		mockEventEmitter.Event = nil
+		action, err := coreControl.CheckLoginRisk(u, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionAllow || mockEventEmitter.Event != nil {
+			t.Errorf("Expected login allowed with no risk handlers")
+		}
+
+		coreControl.BindLoginRisk("mock-risk-2fa", &MockLoginRisk{Action: api.RiskActionSecondFactor})
+		coreControl.BindLoginRisk("mock-risk-block", &MockLoginRisk{Action: api.RiskActionBlock})
+		coreControl.BindLoginRisk("mock-risk-allow", &MockLoginRisk{Action: api.RiskActionAllow})
+
+		action, err = coreControl.CheckLoginRisk(u, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionBlock {
+			t.Errorf("Expected most severe risk action (block), received %s", action)
+		}
+		if mockEventEmitter.Event == nil || mockEventEmitter.Event.GetType() != events.LoginRiskFlagged {
+			t.Errorf("Expected login risk flagged event")
+		}
+
+		delete(coreControl.loginRisk, "mock-risk-2fa")
+		delete(coreControl.loginRisk, "mock-risk-block")
+		delete(coreControl.loginRisk, "mock-risk-allow")
+	})
+
 	t.Run("Bind PreLogin handlers", func(t *testing.T) {
 		var u interface{}
 
 		coreControl.BindPreLogin("mock-login-handler", &mockHandler)
 
 		mockHandler.LoginAllowed = false
-		ok, err := coreControl.PreLogin(u)
+		ok, err := coreControl.PreLogin(u, nil)
 		if err != nil {
 			t.Error(err)
 		}
@@ -165,7 +255,7 @@ func TestCoreModule(t *testing.T) {
 		}
 
 		mockHandler.LoginAllowed = true
-		ok, err = coreControl.PreLogin(u)
+		ok, err = coreControl.PreLogin(u, nil)
 		if err != nil {
 			t.Error(err)
 		}
@@ -175,6 +265,40 @@ func TestCoreModule(t *testing.T) {
 
 	})
 
+	t.Run("Second factor login completion runs PostLoginSuccess handlers", func(t *testing.T) {
+		coreControl.BindPostLoginSuccess("mock-login-handler", &mockHandler)
+
+		mockHandler.PostLoginCalled = false
+		err := coreControl.SecondFactorCompleted("fakeid", "login", "mock-2fa", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if !mockHandler.PostLoginCalled {
+			t.Errorf("PostLoginSuccess handler not called")
+		}
+		if mockEventEmitter.Event == nil || mockEventEmitter.Event.GetData()["Factor"] != "mock-2fa" {
+			t.Errorf("Second factor completion event not emitted")
+		}
+	})
+
+	t.Run("Second factor recovery completion does not run login handlers", func(t *testing.T) {
+		mockHandler.PostLoginCalled = false
+		err := coreControl.SecondFactorCompleted("fakeid", "recover", "mock-2fa", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if mockHandler.PostLoginCalled {
+			t.Errorf("PostLoginSuccess handler called for recovery action")
+		}
+	})
+
+	t.Run("Second factor completion rejects unknown actions", func(t *testing.T) {
+		err := coreControl.SecondFactorCompleted("fakeid", "mock-action", "mock-2fa", nil)
+		if err == nil {
+			t.Errorf("Expected error for unknown action")
+		}
+	})
+
 	t.Run("Bind event handlers", func(t *testing.T) {
 
 	})
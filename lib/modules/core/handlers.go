@@ -9,20 +9,53 @@ package core
 
 import (
 	"fmt"
-	"github.com/authplz/authplz-core/lib/events"
 	"log"
+	"strings"
+
+	"github.com/authplz/authplz-core/lib/events"
 
 	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
 )
 
 // SecondFactorCompleted handles completion of a 2fa provider
-func (coreModule *Controller) SecondFactorCompleted(userid, action string) {
-	log.Printf("CoreModule.SecondFactorCompleted for user %s action %s", userid, action)
+// This is called by 2fa modules with the action pending on the 2fa request, the factor used and the request metadata,
+// and runs the hooks required for that action prior to the module updating the user session
+func (coreModule *Controller) SecondFactorCompleted(userid, action, factor string, meta map[string]string) error {
+	log.Printf("CoreModule.SecondFactorCompleted for user %s action %s (factor %s)", userid, action, factor)
+
+	switch action {
+	case appcontext.FlowActionLogin:
+		// Load user and run post login success handlers
+		u, err := coreModule.userControl.GetLoginUserByExtID(userid)
+		if err != nil {
+			log.Printf("CoreModule.SecondFactorCompleted: fetching user failed (%s)", err)
+			return err
+		}
+
+		err = coreModule.PostLoginSuccess(u, meta)
+		if err != nil {
+			return err
+		}
+	case appcontext.FlowActionRecover, appcontext.FlowActionSudo:
+		// Recovery and sudo sessions are bound by the caller, no hooks are required
+	default:
+		return fmt.Errorf("CoreModule.SecondFactorCompleted: unrecognised action (%s)", action)
+	}
+
+	data := events.NewDataWithMeta(meta)
+	data["Action"] = action
+	data["Factor"] = factor
+	coreModule.emitter.SendEvent(events.NewEvent(userid, events.SecondFactorCompleted, data))
+
+	return nil
 }
 
 // CheckSecondFactors Determine whether a second factor is required for a user
-// This returns a bool indicating whether 2fa is required, and a map of the available 2fa mechanisms
-func (coreModule *Controller) CheckSecondFactors(userid string) (bool, map[string]bool) {
+// This returns a bool indicating whether 2fa is required, and a map of the available 2fa mechanisms.
+// A device token may be provided to skip 2fa on devices trusted by the user, callers requiring
+// 2fa regardless of device trust (ie. sudo or recovery) must provide an empty device token.
+func (coreModule *Controller) CheckSecondFactors(userid, deviceToken string) (bool, map[string]bool) {
 	availableHandlers := make(map[string]bool)
 	secondFactorRequired := false
 
@@ -34,12 +67,32 @@ func (coreModule *Controller) CheckSecondFactors(userid string) (bool, map[strin
 		availableHandlers[key] = supported
 	}
 
+	if secondFactorRequired && coreModule.IsTrustedDevice(userid, deviceToken) {
+		log.Printf("CoreModule.CheckSecondFactors: skipping 2fa for user %s (trusted device)", userid)
+		return false, availableHandlers
+	}
+
 	return secondFactorRequired, availableHandlers
 }
 
+// IsTrustedDevice checks whether any bound trusted device handler accepts the device token for a user
+func (coreModule *Controller) IsTrustedDevice(userid, deviceToken string) bool {
+	if deviceToken == "" {
+		return false
+	}
+
+	for _, handler := range coreModule.trustedDevices {
+		if handler.IsTrustedDevice(userid, deviceToken) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // HandleToken Handles a token string for a given user
 // Returns accepted bool and error in case of failure
-func (coreModule *Controller) HandleToken(userid string, user interface{}, tokenString string) (bool, error) {
+func (coreModule *Controller) HandleToken(userid string, user interface{}, tokenString string, meta map[string]string) (bool, error) {
 	action, err := coreModule.tokenControl.ValidateToken(userid, tokenString)
 	if err != nil {
 		log.Printf("CoreModule.Login: token validation failed %s\n", err)
@@ -54,7 +107,7 @@ func (coreModule *Controller) HandleToken(userid string, user interface{}, token
 	}
 
 	// Execute token action
-	err = tokenHandler.HandleToken(userid, *action)
+	err = tokenHandler.HandleToken(userid, *action, meta)
 	if err != nil {
 		log.Printf("CoreModule.HandleToken: token action %s handler error %s\n", *action, err)
 		return false, err
@@ -91,9 +144,9 @@ func (coreModule *Controller) HandleRecoveryToken(email string, tokenString stri
 }
 
 // PreLogin Runs bound login handlers to accept user logins
-func (coreModule *Controller) PreLogin(u interface{}) (bool, error) {
+func (coreModule *Controller) PreLogin(u interface{}, meta map[string]string) (bool, error) {
 	for key, handler := range coreModule.preLogin {
-		ok, err := handler.PreLogin(u)
+		ok, err := handler.PreLogin(u, meta)
 		if err != nil {
 			log.Printf("CoreModule.LoginHandlers: error in handler %s (%s)", key, err)
 			return false, err
@@ -107,10 +160,45 @@ func (coreModule *Controller) PreLogin(u interface{}) (bool, error) {
 	return true, nil
 }
 
+// CheckLoginRisk Runs bound login risk handlers to determine the verification required for a login
+// This returns the most severe action required by any handler, flagged logins emit a LoginRiskFlagged
+// event with the request metadata and the reasons provided by each handler
+func (coreModule *Controller) CheckLoginRisk(u interface{}, meta map[string]string) (api.RiskAction, error) {
+	action := api.RiskActionAllow
+	reasons := make([]string, 0)
+
+	for key, handler := range coreModule.loginRisk {
+		a, reason, err := handler.CheckLoginRisk(u, meta)
+		if err != nil {
+			log.Printf("CoreModule.CheckLoginRisk: error in handler %s (%s)", key, err)
+			return api.RiskActionBlock, err
+		}
+		if a == api.RiskActionAllow {
+			continue
+		}
+
+		log.Printf("CoreModule.CheckLoginRisk: login flagged by handler %s (%s: %s)", key, a, reason)
+		reasons = append(reasons, fmt.Sprintf("%s: %s", key, reason))
+		if a.Exceeds(action) {
+			action = a
+		}
+	}
+
+	if action != api.RiskActionAllow {
+		user := u.(UserInterface)
+		data := events.NewDataWithMeta(meta)
+		data["Action"] = string(action)
+		data["Reasons"] = strings.Join(reasons, "; ")
+		coreModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.LoginRiskFlagged, data))
+	}
+
+	return action, nil
+}
+
 // PostLoginSuccess Runs bound post login success handlers
-func (coreModule *Controller) PostLoginSuccess(u interface{}) error {
+func (coreModule *Controller) PostLoginSuccess(u interface{}, meta map[string]string) error {
 	for key, handler := range coreModule.postLoginSuccess {
-		err := handler.PostLoginSuccess(u)
+		err := handler.PostLoginSuccess(u, meta)
 		if err != nil {
 			log.Printf("CoreModule.PostLoginSuccess: error in handler %s (%s)", key, err)
 			return err
@@ -120,9 +208,9 @@ func (coreModule *Controller) PostLoginSuccess(u interface{}) error {
 }
 
 // PostLoginFailure Runs bound post login failure handlers
-func (coreModule *Controller) PostLoginFailure(u interface{}) error {
+func (coreModule *Controller) PostLoginFailure(u interface{}, meta map[string]string) error {
 	for key, handler := range coreModule.postLoginFailure {
-		err := handler.PostLoginFailure(u)
+		err := handler.PostLoginFailure(u, meta)
 		if err != nil {
 			log.Printf("CoreModule.PostLoginFailure: error in handler %s (%s)", key, err)
 			return err
@@ -146,3 +234,108 @@ func (coreModule *Controller) PasswordResetStart(email string, meta map[string]s
 
 	return nil
 }
+
+// LoginEmailStart Starts a passwordless (email link) login
+func (coreModule *Controller) LoginEmailStart(email string, meta map[string]string) error {
+	u, err := coreModule.userControl.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		log.Printf("CoreModule.LoginEmailStart: no matching user found ('%s')", email)
+		return fmt.Errorf("No matching user found")
+	}
+	user := u.(UserInterface)
+	coreModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.LoginEmailReq, meta))
+
+	return nil
+}
+
+// LoginConfirmStart Starts confirmation of a flagged login
+// This sends a single use login link to the user, which must be used to complete the login
+func (coreModule *Controller) LoginConfirmStart(userid string, meta map[string]string) {
+	coreModule.emitter.SendEvent(events.NewEvent(userid, events.LoginConfirmReq, meta))
+}
+
+// HandleLockToken handles an account lock token
+// Lock tokens are sent with new device login notices, and are executed without login to allow
+// users to lock accounts they believe to be compromised
+func (coreModule *Controller) HandleLockToken(tokenString string, meta map[string]string) (bool, error) {
+
+	// Fetch user from token
+	userid, err := coreModule.tokenControl.GetTokenUser(tokenString)
+	if err != nil {
+		return false, nil
+	}
+
+	// Validate token
+	action, err := coreModule.tokenControl.ValidateToken(userid, tokenString)
+	if err != nil {
+		log.Printf("CoreModule.HandleLockToken: token validation failed %s\n", err)
+		return false, nil
+	}
+
+	// Check for correct action
+	if *action != api.TokenActionLock {
+		return false, nil
+	}
+
+	// Locate token handler
+	tokenHandler, ok := coreModule.tokenHandlers[api.TokenActionLock]
+	if !ok {
+		log.Printf("CoreModule.HandleLockToken: no token handler found for action %s\n", *action)
+		return false, nil
+	}
+
+	// Mark token as used
+	err = coreModule.tokenControl.SetUsed(tokenString)
+	if err != nil {
+		log.Printf("CoreModule.HandleLockToken: error marking token used %s\n", err)
+		return false, err
+	}
+
+	// Execute token action
+	err = tokenHandler.HandleToken(userid, *action, meta)
+	if err != nil {
+		log.Printf("CoreModule.HandleLockToken: token action %s handler error %s\n", *action, err)
+		return false, err
+	}
+
+	log.Printf("CoreModule.HandleLockToken: account locked for user %s\n", userid)
+
+	return true, nil
+}
+
+// HandleLoginToken handles an email login token
+// Login tokens are single use, the returned user is suitable for passing to login hooks
+func (coreModule *Controller) HandleLoginToken(email string, tokenString string) (bool, interface{}, error) {
+
+	// Load user
+	u, err := coreModule.userControl.GetLoginUser(email)
+	if err != nil {
+		log.Printf("CoreModule.HandleLoginToken: fetching user failed %s\n", err)
+		return false, nil, nil
+	}
+	user := u.(UserInterface)
+
+	// Validate token
+	action, err := coreModule.tokenControl.ValidateToken(user.GetExtID(), tokenString)
+	if err != nil {
+		log.Printf("CoreModule.HandleLoginToken: token validation failed %s\n", err)
+		return false, nil, nil
+	}
+
+	// Check for correct action
+	if *action != api.TokenActionLogin {
+		return false, nil, nil
+	}
+
+	// Mark token as used
+	err = coreModule.tokenControl.SetUsed(tokenString)
+	if err != nil {
+		log.Printf("CoreModule.HandleLoginToken: error marking token used %s\n", err)
+		return false, nil, err
+	}
+
+	return true, u, nil
+}
@@ -8,7 +8,12 @@
 package core
 
 import (
+	"time"
+
+	"github.com/gocraft/web"
+
 	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
 )
 
 // BindActionHandler Binds a token action handler instance to the core module
@@ -26,6 +31,13 @@ func (coreModule *Controller) BindSecondFactor(name string, sfi SecondFactorProv
 	coreModule.secondFactorHandlers[name] = sfi
 }
 
+// BindTrustedDevice Binds a trusted device handler instance into the core module
+// If any trusted device handler accepts the device token presented at login, second factor
+// authentication will be skipped for that login
+func (coreModule *Controller) BindTrustedDevice(name string, tdi TrustedDeviceProvider) {
+	coreModule.trustedDevices[name] = tdi
+}
+
 // BindEventHandler Binds an event handler interface into the core module
 // Event handlers are called during a variety of evens
 func (coreModule *Controller) BindEventHandler(name string, ehi EventHandler) {
@@ -38,6 +50,13 @@ func (coreModule *Controller) BindPreLogin(name string, lhi PreLoginHook) {
 	coreModule.preLogin[name] = lhi
 }
 
+// BindLoginRisk Binds a login risk handler interface to the core module
+// Login risk handlers are called following PreLogin handlers and may require additional verification
+// (a second factor or email confirmation) or block the login
+func (coreModule *Controller) BindLoginRisk(name string, lri LoginRiskHook) {
+	coreModule.loginRisk[name] = lri
+}
+
 // BindPostLoginSuccess binds a PostLoginSuccess handler interface to the core module
 // This handler will be called on successful logins
 func (coreModule *Controller) BindPostLoginSuccess(name string, plsi PostLoginSuccessHook) {
@@ -56,12 +75,18 @@ func (coreModule *Controller) BindModule(name string, mod interface{}) {
 	if i, ok := mod.(SecondFactorProvider); ok {
 		coreModule.BindSecondFactor(name, i)
 	}
+	if i, ok := mod.(TrustedDeviceProvider); ok {
+		coreModule.BindTrustedDevice(name, i)
+	}
 	if i, ok := mod.(EventHandler); ok {
 		coreModule.BindEventHandler(name, i)
 	}
 	if i, ok := mod.(PreLoginHook); ok {
 		coreModule.BindPreLogin(name, i)
 	}
+	if i, ok := mod.(LoginRiskHook); ok {
+		coreModule.BindLoginRisk(name, i)
+	}
 	if i, ok := mod.(PostLoginSuccessHook); ok {
 		coreModule.BindPostLoginSuccess(name, i)
 	}
@@ -69,3 +94,24 @@ func (coreModule *Controller) BindModule(name string, mod interface{}) {
 		coreModule.BindPostLoginFailure(name, i)
 	}
 }
+
+// BindFlows registers the flow actions started by the core module with the global context
+// Login flows log in the user (recording the authentication methods and second factor login) on completion, recovery flows are retained for the password reset endpoint,
+// sudo flows grant a sudo session for the provided sudoTimeout, and token flows hold action tokens until they are applied at login
+func (coreModule *Controller) BindFlows(ctx *appcontext.AuthPlzGlobalCtx, sudoTimeout time.Duration) {
+	ctx.RegisterFlowAction(appcontext.FlowActionLogin, appcontext.FlowAction{
+		Complete: func(c *appcontext.AuthPlzCtx, flow *appcontext.Flow, rw web.ResponseWriter, req *web.Request) {
+			c.LoginUser(flow.UserID, rw, req, append(flow.Methods, appcontext.LoginMethodMultiFactor)...)
+			c.SetSecondFactorLogin(rw, req)
+		},
+	})
+	ctx.RegisterFlowAction(appcontext.FlowActionRecover, appcontext.FlowAction{
+		Retain: true,
+	})
+	ctx.RegisterFlowAction(appcontext.FlowActionSudo, appcontext.FlowAction{
+		Complete: func(c *appcontext.AuthPlzCtx, flow *appcontext.Flow, rw web.ResponseWriter, req *web.Request) {
+			c.SetSudo(flow.UserID, sudoTimeout, rw, req)
+		},
+	})
+	ctx.RegisterFlowAction(appcontext.FlowActionToken, appcontext.FlowAction{})
+}
@@ -0,0 +1,377 @@
+/*
+ * Trusted Devices Module Controller
+ * This defines the trusted devices module controller
+ * Trusted devices allow users to skip second factor authentication when logging in from a known browser,
+ * and known devices are tracked to notify users of logins from new devices.
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package devices
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/gocraft/web"
+	"github.com/gorilla/securecookie"
+
+	"github.com/authplz/authplz-core/lib/appcontext"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+const (
+	// Length of generated device tokens in bytes
+	deviceTokenLength = 32
+
+	// Network prefix lengths used to fingerprint login devices
+	ipv4PrefixBits = 24
+	ipv6PrefixBits = 48
+)
+
+// Events that invalidate all trusted devices for a user
+var revokeEvents = map[string]bool{
+	events.PasswordUpdate:                 true,
+	events.SecondFactorTotpRemoved:        true,
+	events.SecondFactorU2FRemoved:         true,
+	events.SecondFactorWebAuthnRemoved:    true,
+	events.SecondFactorYubikeyRemoved:     true,
+	events.SecondFactorEmailOTPRemoved:    true,
+	events.SecondFactorSMSRemoved:         true,
+	events.SecondFactorBackupCodesRemoved: true,
+}
+
+// Controller Trusted devices controller instance
+type Controller struct {
+	store   Storer
+	codec   *securecookie.SecureCookie
+	timeout time.Duration
+	emitter events.Emitter
+}
+
+// NewController creates a new trusted devices controller
+// The provided secret is used to sign device cookies, and devices are trusted for the provided timeout
+func NewController(secret string, timeout time.Duration, store Storer, emitter events.Emitter) (*Controller, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("devices: secret required")
+	}
+
+	codec := securecookie.New([]byte(secret), nil)
+	codec.MaxAge(int(timeout.Seconds()))
+
+	return &Controller{
+		store:   store,
+		codec:   codec,
+		timeout: timeout,
+		emitter: emitter,
+	}, nil
+}
+
+// Helper middleware to bind module to API context
+func bindDevicesContext(devicesModule *Controller) func(ctx *devicesAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	return func(ctx *devicesAPICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		ctx.devicesModule = devicesModule
+		next(rw, req)
+	}
+}
+
+// BindAPI Binds the API for the trusted devices module to the provided router
+func (devicesModule *Controller) BindAPI(router *web.Router) {
+	// Create router for user modules
+	devicesRouter := router.Subrouter(devicesAPICtx{}, "/api/devices")
+
+	// Attach module context
+	devicesRouter.Middleware(bindDevicesContext(devicesModule))
+
+	// Bind endpoints
+	devicesRouter.Get("/", (*devicesAPICtx).DevicesGet)
+	devicesRouter.Post("/trust", (*devicesAPICtx).TrustPost)
+	devicesRouter.Post("/revoke", (*devicesAPICtx).RevokePost)
+	devicesRouter.Post("/revoke-all", (*devicesAPICtx).RevokeAllPost)
+}
+
+// DeviceResp sanitised trusted device object
+type DeviceResp struct {
+	ID         uint      `json:"id"`
+	RemoteAddr string    `json:"remote_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsed   time.Time `json:"last_used"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// TrustDevice creates a trusted device for a user, recording the remote address and user agent from the request metadata
+// This returns the signed device token to be stored in the device cookie, and the expiry of the device trust
+func (devicesModule *Controller) TrustDevice(userid string, meta map[string]string) (string, time.Time, error) {
+	// Generate device token
+	b := make([]byte, deviceTokenLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.URLEncoding.EncodeToString(b)
+
+	expiry := time.Now().Add(devicesModule.timeout)
+
+	// Store hashed token
+	_, err = devicesModule.store.AddTrustedDevice(userid, hashToken(token), meta["remote-address"], meta["user-agent"], expiry)
+	if err != nil {
+		log.Printf("DevicesModule.TrustDevice error adding trusted device for user %s (%s)", userid, err)
+		return "", time.Time{}, err
+	}
+
+	// Sign token for the device cookie
+	signed, err := devicesModule.codec.Encode(appcontext.TrustedDeviceCookie, token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	data := events.NewDataWithMeta(meta)
+	devicesModule.emitter.SendEvent(events.NewEvent(userid, events.DeviceTrusted, data))
+
+	log.Printf("DevicesModule.TrustDevice trusted device for user %s", userid)
+
+	return signed, expiry, nil
+}
+
+// IsTrustedDevice checks whether the provided signed device token identifies a device trusted by the user
+// This is required to implement the trusted device interface for binding into the core module.
+func (devicesModule *Controller) IsTrustedDevice(userid, deviceToken string) bool {
+	device, err := devicesModule.getDevice(userid, deviceToken)
+	if err != nil || device == nil {
+		return false
+	}
+
+	device.SetLastUsed(time.Now())
+	_, err = devicesModule.store.UpdateTrustedDevice(device)
+	if err != nil {
+		log.Printf("DevicesModule.IsTrustedDevice error updating trusted device for user %s (%s)", userid, err)
+		return false
+	}
+
+	return true
+}
+
+// GetDevices fetches the trusted devices for a user
+// current is the signed device token of the requesting device, which is flagged in the response
+func (devicesModule *Controller) GetDevices(userid, current string) ([]DeviceResp, error) {
+	devices, err := devicesModule.store.GetTrustedDevices(userid)
+	if err != nil {
+		log.Printf("DevicesModule.GetDevices error fetching trusted devices for user %s (%s)", userid, err)
+		return nil, err
+	}
+
+	currentHash := ""
+	if token, err := devicesModule.decodeToken(current); err == nil {
+		currentHash = hashToken(token)
+	}
+
+	resp := make([]DeviceResp, len(devices))
+	for i, d := range devices {
+		device := d.(TrustedDevice)
+		resp[i] = DeviceResp{
+			ID:         device.GetID(),
+			RemoteAddr: device.GetRemoteAddr(),
+			UserAgent:  device.GetUserAgent(),
+			CreatedAt:  device.GetCreatedAt(),
+			LastUsed:   device.GetLastUsed(),
+			ExpiresAt:  device.GetExpiry(),
+			Current:    currentHash != "" && device.GetHash() == currentHash,
+		}
+	}
+
+	return resp, nil
+}
+
+// RevokeDevice revokes a single trusted device for a user
+func (devicesModule *Controller) RevokeDevice(userid string, id uint, meta map[string]string) error {
+	err := devicesModule.store.RemoveTrustedDevice(userid, id)
+	if err != nil {
+		log.Printf("DevicesModule.RevokeDevice error revoking device for user %s (%s)", userid, err)
+		return err
+	}
+
+	data := events.NewDataWithMeta(meta)
+	data["Device"] = fmt.Sprintf("%d", id)
+	devicesModule.emitter.SendEvent(events.NewEvent(userid, events.DeviceRevoked, data))
+
+	return nil
+}
+
+// RevokeDevices revokes all trusted devices for a user
+func (devicesModule *Controller) RevokeDevices(userid string, meta map[string]string) error {
+	err := devicesModule.store.RemoveTrustedDevices(userid)
+	if err != nil {
+		log.Printf("DevicesModule.RevokeDevices error revoking devices for user %s (%s)", userid, err)
+		return err
+	}
+
+	devicesModule.emitter.SendEvent(events.NewEvent(userid, events.DevicesRevoked, events.NewDataWithMeta(meta)))
+
+	return nil
+}
+
+// HandleEvent handles async events for go-async
+// Password changes and second factor removals invalidate all trusted devices for the user
+func (devicesModule *Controller) HandleEvent(event interface{}) error {
+	e, ok := event.(Event)
+	if !ok || !revokeEvents[e.GetType()] {
+		return nil
+	}
+
+	log.Printf("DevicesModule.HandleEvent revoking trusted devices for user %s (%s)", e.GetUserExtID(), e.GetType())
+
+	// Events are not emitted from the async handler, the triggering event is recorded instead
+	err := devicesModule.store.RemoveTrustedDevices(e.GetUserExtID())
+	if err != nil {
+		log.Printf("DevicesModule.HandleEvent error revoking devices for user %s (%s)", e.GetUserExtID(), err)
+	}
+
+	return err
+}
+
+// PostLoginSuccess checks logins against the known devices for the user
+// Logins from unknown devices emit an AccountLoginNewDevice event with the request metadata. The first
+// login for a user is recorded without notification.
+func (devicesModule *Controller) PostLoginSuccess(u interface{}, meta map[string]string) error {
+	user, ok := u.(User)
+	if !ok {
+		return nil
+	}
+	userid := user.GetExtID()
+
+	userAgent := meta["user-agent"]
+	prefix := ipPrefix(meta["remote-address"])
+	fingerprint := deviceFingerprint(userAgent, prefix)
+
+	d, err := devicesModule.store.GetKnownDevice(userid, fingerprint)
+	if err != nil {
+		log.Printf("DevicesModule.PostLoginSuccess error fetching known device for user %s (%s)", userid, err)
+		return err
+	}
+
+	// Update known devices
+	if d != nil {
+		device := d.(KnownDevice)
+		device.SetLastSeen(time.Now())
+		_, err = devicesModule.store.UpdateKnownDevice(device)
+		return err
+	}
+
+	count, err := devicesModule.store.CountKnownDevices(userid)
+	if err != nil {
+		log.Printf("DevicesModule.PostLoginSuccess error counting known devices for user %s (%s)", userid, err)
+		return err
+	}
+
+	// Add the device, committing the new device notification with the device
+	err = events.Transaction(devicesModule.emitter, devicesModule.store, func(s interface{}, emitter events.Emitter) error {
+		_, err := s.(Storer).AddKnownDevice(userid, fingerprint, userAgent, prefix)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		log.Printf("DevicesModule.PostLoginSuccess login from new device for user %s", userid)
+
+		data := events.NewDataWithMeta(meta)
+		data["RemoteAddress"] = meta["remote-address"]
+		data["ForwardedFor"] = meta["forwarded-for"]
+		data["UserAgent"] = userAgent
+		emitter.SendEvent(events.NewEvent(userid, events.AccountLoginNewDevice, data))
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("DevicesModule.PostLoginSuccess error adding known device for user %s (%s)", userid, err)
+		return err
+	}
+
+	return nil
+}
+
+// IsNewDevice checks whether a login with the provided request metadata is from a device
+// the user has not previously logged in from
+// This returns false for the first login for a user, as there are no devices to compare against
+func (devicesModule *Controller) IsNewDevice(userid string, meta map[string]string) (bool, error) {
+	fingerprint := deviceFingerprint(meta["user-agent"], ipPrefix(meta["remote-address"]))
+
+	d, err := devicesModule.store.GetKnownDevice(userid, fingerprint)
+	if err != nil {
+		return false, err
+	}
+	if d != nil {
+		return false, nil
+	}
+
+	count, err := devicesModule.store.CountKnownDevices(userid)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// getDevice fetches the trusted device matching a signed device token
+func (devicesModule *Controller) getDevice(userid, deviceToken string) (TrustedDevice, error) {
+	token, err := devicesModule.decodeToken(deviceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := devicesModule.store.GetTrustedDevice(userid, hashToken(token))
+	if err != nil {
+		log.Printf("DevicesModule.getDevice error fetching trusted device for user %s (%s)", userid, err)
+		return nil, err
+	}
+	if d == nil {
+		return nil, nil
+	}
+
+	return d.(TrustedDevice), nil
+}
+
+// decodeToken validates and decodes a signed device token
+func (devicesModule *Controller) decodeToken(deviceToken string) (string, error) {
+	var token string
+	err := devicesModule.codec.Decode(appcontext.TrustedDeviceCookie, deviceToken, &token)
+	return token, err
+}
+
+// ipPrefix fetches the network prefix for an address to allow for address changes within a network
+func ipPrefix(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(ipv4PrefixBits, 32)
+		return fmt.Sprintf("%s/%d", ip4.Mask(mask), ipv4PrefixBits)
+	}
+
+	mask := net.CIDRMask(ipv6PrefixBits, 128)
+	return fmt.Sprintf("%s/%d", ip.Mask(mask), ipv6PrefixBits)
+}
+
+// deviceFingerprint generates a login device fingerprint from a user agent and network prefix
+func deviceFingerprint(userAgent, prefix string) string {
+	return hashToken(userAgent + "|" + prefix)
+}
+
+// hashToken hashes a device token for storage
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,117 @@
+/*
+ * Trusted Devices Module API
+ * This defines the API methods bound to the trusted devices module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package devices
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+)
+
+// Trusted devices API context storage
+type devicesAPICtx struct {
+	// Base context for shared components
+	*appcontext.AuthPlzCtx
+
+	// Trusted devices controller module
+	devicesModule *Controller
+}
+
+const (
+	// Window following a second factor login in which the device may be trusted
+	trustWindow = 10 * time.Minute
+)
+
+// DevicesGet lists the trusted devices for the logged in user
+func (c *devicesAPICtx) DevicesGet(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	devices, err := c.devicesModule.GetDevices(c.GetUserID(), c.GetTrustedDeviceToken(req))
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteJSON(rw, devices)
+}
+
+// TrustPost trusts the current device to skip second factor authentication on future logins
+// This is only available shortly after logging in with a second factor
+func (c *devicesAPICtx) TrustPost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	loginTime := c.GetSecondFactorLogin()
+	if loginTime.IsZero() || time.Since(loginTime) > trustWindow {
+		log.Printf("DevicesAPI.TrustPost no recent second factor login for user %s", c.GetUserID())
+		c.WriteAPIResultWithCode(rw, http.StatusForbidden, api.SecondFactorRequired)
+		return
+	}
+
+	token, expiry, err := c.devicesModule.TrustDevice(c.GetUserID(), c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.SetTrustedDeviceToken(rw, token, expiry)
+
+	c.WriteAPIResult(rw, api.DeviceTrusted)
+}
+
+// RevokePost revokes a single trusted device for the logged in user
+func (c *devicesAPICtx) RevokePost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	id, err := strconv.ParseUint(req.FormValue("id"), 10, 64)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	err = c.devicesModule.RevokeDevice(c.GetUserID(), uint(id), c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.DeviceRevoked)
+}
+
+// RevokeAllPost revokes all trusted devices for the logged in user
+func (c *devicesAPICtx) RevokeAllPost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := c.devicesModule.RevokeDevices(c.GetUserID(), c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.ClearTrustedDeviceToken(rw)
+
+	c.WriteAPIResult(rw, api.DevicesRevoked)
+}
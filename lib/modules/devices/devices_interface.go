@@ -0,0 +1,72 @@
+/*
+ * Trusted Devices Module interfaces
+ * This defines the interfaces required to use the trusted devices module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package devices
+
+import (
+	"time"
+)
+
+// TrustedDevice trusted device instance interface
+// Storer device objects must implement this interface
+type TrustedDevice interface {
+	GetID() uint
+	GetHash() string
+	GetRemoteAddr() string
+	GetUserAgent() string
+	GetCreatedAt() time.Time
+	GetLastUsed() time.Time
+	SetLastUsed(time.Time)
+	GetExpiry() time.Time
+}
+
+// KnownDevice known login device instance interface
+// Storer device objects must implement this interface
+type KnownDevice interface {
+	GetFingerprint() string
+	GetLastSeen() time.Time
+	SetLastSeen(time.Time)
+}
+
+// User interface type
+// Login hook user objects must implement this interface
+type User interface {
+	GetExtID() string
+}
+
+// Event interface for events consumed by the module
+type Event interface {
+	GetUserExtID() string
+	GetType() string
+}
+
+// Storer Trusted and known device store interface
+// This must be implemented by a storage module to provide persistence to the module
+type Storer interface {
+	// Add a trusted device for a given user
+	AddTrustedDevice(userid, hash, remoteAddr, userAgent string, expiry time.Time) (interface{}, error)
+	// Fetch an unexpired trusted device for a given user by hashed device token
+	GetTrustedDevice(userid, hash string) (interface{}, error)
+	// Fetch the unexpired trusted devices for a given user
+	GetTrustedDevices(userid string) ([]interface{}, error)
+	// Update a provided trusted device
+	UpdateTrustedDevice(device interface{}) (interface{}, error)
+	// Remove a trusted device for a given user by record ID
+	RemoveTrustedDevice(userid string, id uint) error
+	// Remove all trusted devices for a given user
+	RemoveTrustedDevices(userid string) error
+
+	// Add a known login device for a given user
+	AddKnownDevice(userid, fingerprint, userAgent, ipPrefix string) (interface{}, error)
+	// Fetch a known login device for a given user by fingerprint
+	GetKnownDevice(userid, fingerprint string) (interface{}, error)
+	// Count the known login devices for a given user
+	CountKnownDevices(userid string) (uint, error)
+	// Update a provided known login device
+	UpdateKnownDevice(device interface{}) (interface{}, error)
+}
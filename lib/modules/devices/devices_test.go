@@ -0,0 +1,219 @@
+/*
+ * Trusted Devices Module tests
+ * This defines trusted devices module tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package devices
+
+import (
+	"testing"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/events"
+	"github.com/authplz/authplz-core/lib/test"
+)
+
+func TestDevicesModule(t *testing.T) {
+	var fakeEmail = "test@abc.com"
+	var fakePass = "abcDEF123@abcDEF123@"
+	var fakeName = "user.sdfsfdF"
+
+	c, _ := config.DefaultConfig()
+
+	// Attempt database connection
+	dataStore, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error("Error opening database")
+		t.FailNow()
+	}
+
+	// Force synchronization
+	dataStore.ForceSync()
+
+	// Create user for tests
+	u, err := dataStore.AddUser(fakeEmail, fakeName, fakePass)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user := u.(*datastore.User)
+
+	mockEventEmitter := test.MockEventEmitter{}
+
+	// Instantiate trusted devices module
+	devicesModule, err := NewController("abcDEF123", time.Hour, dataStore, &mockEventEmitter)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	var token string
+	deviceMeta := map[string]string{"remote-address": "127.0.0.1", "user-agent": "test-agent"}
+
+	t.Run("Devices can be trusted", func(t *testing.T) {
+		token, _, err = devicesModule.TrustDevice(user.GetExtID(), deviceMeta)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !devicesModule.IsTrustedDevice(user.GetExtID(), token) {
+			t.Errorf("Trusted device not recognised")
+		}
+	})
+
+	t.Run("Tokens are bound to users", func(t *testing.T) {
+		if devicesModule.IsTrustedDevice("not-a-user", token) {
+			t.Errorf("Trusted device accepted for incorrect user")
+		}
+	})
+
+	t.Run("Unsigned tokens are rejected", func(t *testing.T) {
+		if devicesModule.IsTrustedDevice(user.GetExtID(), token+"x") {
+			t.Errorf("Modified device token accepted")
+		}
+		if devicesModule.IsTrustedDevice(user.GetExtID(), "") {
+			t.Errorf("Empty device token accepted")
+		}
+	})
+
+	t.Run("Devices can be listed", func(t *testing.T) {
+		devices, err := devicesModule.GetDevices(user.GetExtID(), token)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(devices) != 1 {
+			t.Errorf("Expected 1 trusted device, received %d", len(devices))
+			t.FailNow()
+		}
+		if !devices[0].Current {
+			t.Errorf("Current device not flagged")
+		}
+	})
+
+	t.Run("Devices can be revoked", func(t *testing.T) {
+		devices, err := devicesModule.GetDevices(user.GetExtID(), token)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		err = devicesModule.RevokeDevice(user.GetExtID(), devices[0].ID, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if devicesModule.IsTrustedDevice(user.GetExtID(), token) {
+			t.Errorf("Revoked device accepted")
+		}
+	})
+
+	t.Run("Password changes revoke trusted devices", func(t *testing.T) {
+		token, _, err = devicesModule.TrustDevice(user.GetExtID(), deviceMeta)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		err = devicesModule.HandleEvent(events.NewEvent(user.GetExtID(), events.PasswordUpdate, events.NewData()))
+		if err != nil {
+			t.Error(err)
+		}
+		if devicesModule.IsTrustedDevice(user.GetExtID(), token) {
+			t.Errorf("Device accepted following password change")
+		}
+	})
+
+	t.Run("Second factor removal revokes trusted devices", func(t *testing.T) {
+		token, _, err = devicesModule.TrustDevice(user.GetExtID(), deviceMeta)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		// Unrelated events do not revoke devices
+		err = devicesModule.HandleEvent(events.NewEvent(user.GetExtID(), events.LoginSuccess, events.NewData()))
+		if err != nil {
+			t.Error(err)
+		}
+		if !devicesModule.IsTrustedDevice(user.GetExtID(), token) {
+			t.Errorf("Device revoked by unrelated event")
+		}
+
+		err = devicesModule.HandleEvent(events.NewEvent(user.GetExtID(), events.SecondFactorTotpRemoved, events.NewData()))
+		if err != nil {
+			t.Error(err)
+		}
+		if devicesModule.IsTrustedDevice(user.GetExtID(), token) {
+			t.Errorf("Device accepted following second factor removal")
+		}
+	})
+
+	t.Run("First logins do not notify", func(t *testing.T) {
+		mockEventEmitter.Event = nil
+
+		meta := map[string]string{"remote-address": "10.1.2.3", "user-agent": "test-agent"}
+		err := devicesModule.PostLoginSuccess(user, meta)
+		if err != nil {
+			t.Error(err)
+		}
+		if mockEventEmitter.Event != nil {
+			t.Errorf("Unexpected event %s on first login", mockEventEmitter.Event.Type)
+		}
+	})
+
+	t.Run("Known devices do not notify", func(t *testing.T) {
+		mockEventEmitter.Event = nil
+
+		// Addresses within the same network prefix match the known device
+		meta := map[string]string{"remote-address": "10.1.2.45", "user-agent": "test-agent"}
+		err := devicesModule.PostLoginSuccess(user, meta)
+		if err != nil {
+			t.Error(err)
+		}
+		if mockEventEmitter.Event != nil {
+			t.Errorf("Unexpected event %s on known device login", mockEventEmitter.Event.Type)
+		}
+	})
+
+	t.Run("New devices emit login notices", func(t *testing.T) {
+		mockEventEmitter.Event = nil
+
+		meta := map[string]string{"remote-address": "10.1.2.3", "user-agent": "other-agent"}
+		err := devicesModule.PostLoginSuccess(user, meta)
+		if err != nil {
+			t.Error(err)
+		}
+		if mockEventEmitter.Event == nil || mockEventEmitter.Event.Type != events.AccountLoginNewDevice {
+			t.Errorf("Expected new device login event")
+			t.FailNow()
+		}
+		if mockEventEmitter.Event.Data["UserAgent"] != "other-agent" {
+			t.Errorf("New device login event missing request metadata")
+		}
+	})
+
+	t.Run("New devices can be checked prior to login", func(t *testing.T) {
+		isNew, err := devicesModule.IsNewDevice(user.GetExtID(), map[string]string{"remote-address": "10.1.2.3", "user-agent": "other-agent"})
+		if err != nil {
+			t.Error(err)
+		}
+		if isNew {
+			t.Errorf("Known device reported as new")
+		}
+
+		isNew, err = devicesModule.IsNewDevice(user.GetExtID(), map[string]string{"remote-address": "10.9.9.9", "user-agent": "test-agent"})
+		if err != nil {
+			t.Error(err)
+		}
+		if !isNew {
+			t.Errorf("New device not reported")
+		}
+	})
+
+}
@@ -0,0 +1,186 @@
+/*
+ * GeoIP Module Controller
+ * This defines the GeoIP module controller
+ * The GeoIP module annotates request metadata with the location of the remote address, and flags
+ * logins from unusual countries or with impossible travel since the previous login.
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/config"
+)
+
+const (
+	// Mean radius of the earth in km
+	earthRadius = 6371.0
+
+	// Minimum interval used for travel speed calculations
+	// This avoids division by zero for near simultaneous logins
+	minTravelInterval = time.Minute
+)
+
+// Controller GeoIP controller instance
+type Controller struct {
+	locator     Locator
+	store       Storer
+	action      api.RiskAction
+	maxSpeed    float64
+	minDistance float64
+}
+
+// NewController creates a new GeoIP controller
+func NewController(locator Locator, store Storer, c config.GeoIPConfig) (*Controller, error) {
+	action := api.RiskAction(c.RiskAction)
+	if !action.IsValid() || action == api.RiskActionAllow {
+		return nil, fmt.Errorf("geoip: invalid risk action '%s'", c.RiskAction)
+	}
+
+	return &Controller{
+		locator:     locator,
+		store:       store,
+		action:      action,
+		maxSpeed:    c.MaxSpeed,
+		minDistance: c.MinDistance,
+	}, nil
+}
+
+// lookup fetches the location for an address, returning nil if not found
+func (geoModule *Controller) lookup(address string) Location {
+	if address == "" {
+		return nil
+	}
+
+	l, err := geoModule.locator.Lookup(address)
+	if err != nil {
+		log.Printf("GeoIPModule.lookup error looking up address %s (%s)", address, err)
+		return nil
+	}
+	if l == nil {
+		return nil
+	}
+
+	return l.(Location)
+}
+
+// EnrichMeta annotates request metadata with the location of the remote address
+// This is called for each request, and the resulting metadata is attached to emitted events
+func (geoModule *Controller) EnrichMeta(meta map[string]string) {
+	location := geoModule.lookup(meta["remote-address"])
+	if location == nil {
+		return
+	}
+
+	meta["country"] = location.GetCountry()
+	meta["city"] = location.GetCity()
+	if location.GetASN() != 0 {
+		meta["asn"] = strconv.FormatUint(uint64(location.GetASN()), 10)
+		meta["asn-organization"] = location.GetASNOrganization()
+	}
+}
+
+// CheckLoginRisk checks a login against the previous login locations for the user
+// Logins requiring travel faster than the configured maximum speed since the previous login, or from
+// a country the user has not previously logged in from, are flagged with the configured action.
+func (geoModule *Controller) CheckLoginRisk(u interface{}, meta map[string]string) (api.RiskAction, string, error) {
+	user, ok := u.(User)
+	if !ok {
+		return api.RiskActionAllow, "", nil
+	}
+	userid := user.GetExtID()
+
+	location := geoModule.lookup(meta["remote-address"])
+	if location == nil {
+		return api.RiskActionAllow, "", nil
+	}
+
+	l, err := geoModule.store.GetLastLoginLocation(userid)
+	if err != nil {
+		log.Printf("GeoIPModule.CheckLoginRisk error fetching last login location for user %s (%s)", userid, err)
+		return api.RiskActionAllow, "", err
+	}
+
+	// First logins have nothing to compare against
+	if l == nil {
+		return api.RiskActionAllow, "", nil
+	}
+	last := l.(LoginLocation)
+
+	// Check travel speed since the previous login
+	distance := haversine(last.GetLatitude(), last.GetLongitude(), location.GetLatitude(), location.GetLongitude())
+	if distance > geoModule.minDistance {
+		elapsed := time.Since(last.GetTime())
+		if elapsed < minTravelInterval {
+			elapsed = minTravelInterval
+		}
+
+		speed := distance / elapsed.Hours()
+		if speed > geoModule.maxSpeed {
+			reason := fmt.Sprintf("impossible travel from %s (%.0f km in %s)", last.GetCountry(), distance, elapsed.Round(time.Minute))
+			log.Printf("GeoIPModule.CheckLoginRisk user %s: %s", userid, reason)
+			return geoModule.action, reason, nil
+		}
+	}
+
+	// Check for logins from new countries
+	if location.GetCountry() != "" && location.GetCountry() != last.GetCountry() {
+		count, err := geoModule.store.CountLoginLocations(userid, location.GetCountry())
+		if err != nil {
+			log.Printf("GeoIPModule.CheckLoginRisk error counting login locations for user %s (%s)", userid, err)
+			return api.RiskActionAllow, "", err
+		}
+
+		if count == 0 {
+			reason := fmt.Sprintf("login from new country %s", location.GetCountry())
+			log.Printf("GeoIPModule.CheckLoginRisk user %s: %s", userid, reason)
+			return geoModule.action, reason, nil
+		}
+	}
+
+	return api.RiskActionAllow, "", nil
+}
+
+// PostLoginSuccess records the location of successful logins
+func (geoModule *Controller) PostLoginSuccess(u interface{}, meta map[string]string) error {
+	user, ok := u.(User)
+	if !ok {
+		return nil
+	}
+
+	address := meta["remote-address"]
+	location := geoModule.lookup(address)
+	if location == nil {
+		return nil
+	}
+
+	_, err := geoModule.store.AddLoginLocation(user.GetExtID(), address, location.GetCountry(), location.GetCity(),
+		location.GetLatitude(), location.GetLongitude())
+	if err != nil {
+		log.Printf("GeoIPModule.PostLoginSuccess error adding login location for user %s (%s)", user.GetExtID(), err)
+	}
+
+	return err
+}
+
+// haversine calculates the great circle distance in km between two points
+func haversine(lat1, long1, lat2, long2 float64) float64 {
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLong := toRad(long2 - long1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLong/2)*math.Sin(dLong/2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
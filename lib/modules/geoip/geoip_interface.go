@@ -0,0 +1,57 @@
+/*
+ * GeoIP Module interfaces
+ * This defines the interfaces required to use the GeoIP module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package geoip
+
+import (
+	"time"
+)
+
+// Location GeoIP lookup result interface
+// Locator result objects must implement this interface
+type Location interface {
+	GetCountry() string
+	GetCity() string
+	GetASN() uint
+	GetASNOrganization() string
+	GetLatitude() float64
+	GetLongitude() float64
+}
+
+// Locator GeoIP database interface
+// Lookup should return nil where an address is not found
+type Locator interface {
+	Lookup(address string) (interface{}, error)
+}
+
+// LoginLocation login location instance interface
+// Storer location objects must implement this interface
+type LoginLocation interface {
+	GetCountry() string
+	GetCity() string
+	GetLatitude() float64
+	GetLongitude() float64
+	GetTime() time.Time
+}
+
+// User interface type
+// Login hook user objects must implement this interface
+type User interface {
+	GetExtID() string
+}
+
+// Storer Login location store interface
+// This must be implemented by a storage module to provide persistence to the module
+type Storer interface {
+	// Add a login location for a given user
+	AddLoginLocation(userid, remoteAddr, country, city string, latitude, longitude float64) (interface{}, error)
+	// Fetch the most recent login location for a given user
+	GetLastLoginLocation(userid string) (interface{}, error)
+	// Count the login locations for a given user in a country, or all locations if country is empty
+	CountLoginLocations(userid, country string) (uint, error)
+}
@@ -0,0 +1,177 @@
+/*
+ * GeoIP Module tests
+ * This defines GeoIP module tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package geoip
+
+import (
+	"math"
+	"testing"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/controllers/mmdb"
+)
+
+// MockLocator mock GeoIP database for testing
+type MockLocator struct {
+	Locations map[string]*mmdb.Location
+}
+
+func (ml *MockLocator) Lookup(address string) (interface{}, error) {
+	l, ok := ml.Locations[address]
+	if !ok {
+		return nil, nil
+	}
+	return l, nil
+}
+
+func TestGeoIPModule(t *testing.T) {
+	var fakeEmail = "test@abc.com"
+	var fakePass = "abcDEF123@abcDEF123@"
+	var fakeName = "user.sdfsfdF"
+
+	c, _ := config.DefaultConfig()
+
+	// Attempt database connection
+	dataStore, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error("Error opening database")
+		t.FailNow()
+	}
+
+	// Force synchronization
+	dataStore.ForceSync()
+
+	// Create user for tests
+	u, err := dataStore.AddUser(fakeEmail, fakeName, fakePass)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user := u.(*datastore.User)
+
+	locator := MockLocator{Locations: map[string]*mmdb.Location{
+		"10.0.0.1": {Country: "NZ", City: "Auckland", ASN: 9500, ASNOrganization: "Test ISP", Latitude: -36.85, Longitude: 174.76},
+		"10.0.0.2": {Country: "NZ", City: "Auckland", Latitude: -36.86, Longitude: 174.77},
+		"10.0.0.3": {Country: "GB", City: "London", Latitude: 51.51, Longitude: -0.13},
+		"10.0.0.4": {Country: "AU", City: "Sydney", Latitude: -33.87, Longitude: 151.21},
+	}}
+
+	// Instantiate GeoIP module
+	geoModule, err := NewController(&locator, dataStore, c.GeoIP)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	login := func(t *testing.T, address string) {
+		err := geoModule.PostLoginSuccess(user, map[string]string{"remote-address": address})
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+
+	t.Run("Invalid risk actions are rejected", func(t *testing.T) {
+		gc := config.DefaultGeoIPConfig()
+		gc.RiskAction = "not-an-action"
+		_, err := NewController(&locator, dataStore, gc)
+		if err == nil {
+			t.Errorf("Expected error for invalid risk action")
+		}
+	})
+
+	t.Run("Request metadata is enriched with location", func(t *testing.T) {
+		meta := map[string]string{"remote-address": "10.0.0.1"}
+		geoModule.EnrichMeta(meta)
+		if meta["country"] != "NZ" || meta["city"] != "Auckland" || meta["asn"] != "9500" {
+			t.Errorf("Unexpected enriched metadata: %+v", meta)
+		}
+
+		meta = map[string]string{"remote-address": "192.168.1.1"}
+		geoModule.EnrichMeta(meta)
+		if _, ok := meta["country"]; ok {
+			t.Errorf("Unexpected location for unknown address")
+		}
+	})
+
+	t.Run("First logins are allowed", func(t *testing.T) {
+		action, _, err := geoModule.CheckLoginRisk(user, map[string]string{"remote-address": "10.0.0.1"})
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionAllow {
+			t.Errorf("Expected first login to be allowed, received %s", action)
+		}
+		login(t, "10.0.0.1")
+	})
+
+	t.Run("Logins from the same location are allowed", func(t *testing.T) {
+		action, _, err := geoModule.CheckLoginRisk(user, map[string]string{"remote-address": "10.0.0.2"})
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionAllow {
+			t.Errorf("Expected login to be allowed, received %s", action)
+		}
+	})
+
+	t.Run("Impossible travel is flagged", func(t *testing.T) {
+		action, reason, err := geoModule.CheckLoginRisk(user, map[string]string{"remote-address": "10.0.0.3"})
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionSecondFactor {
+			t.Errorf("Expected impossible travel to require a second factor, received %s", action)
+		}
+		if reason == "" {
+			t.Errorf("Expected reason for flagged login")
+		}
+	})
+
+	t.Run("Logins from new countries are flagged", func(t *testing.T) {
+		// Disable travel checks to isolate country checks
+		gc := config.DefaultGeoIPConfig()
+		gc.RiskAction = string(api.RiskActionEmailConfirmation)
+		gc.MaxSpeed = math.MaxFloat64
+		countryModule, err := NewController(&locator, dataStore, gc)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		action, _, err := countryModule.CheckLoginRisk(user, map[string]string{"remote-address": "10.0.0.4"})
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionEmailConfirmation {
+			t.Errorf("Expected new country to require email confirmation, received %s", action)
+		}
+
+		// Countries are known following a successful login
+		login(t, "10.0.0.4")
+		login(t, "10.0.0.1")
+
+		action, _, err = countryModule.CheckLoginRisk(user, map[string]string{"remote-address": "10.0.0.4"})
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionAllow {
+			t.Errorf("Expected known country to be allowed, received %s", action)
+		}
+	})
+
+	t.Run("Haversine distances are correct", func(t *testing.T) {
+		// Auckland to London is approximately 18,350 km
+		d := haversine(-36.85, 174.76, 51.51, -0.13)
+		if d < 18000 || d > 18700 {
+			t.Errorf("Unexpected distance %f", d)
+		}
+	})
+}
@@ -0,0 +1,369 @@
+/*
+ * OAuth Module Device Authorization Grant
+ * Implements the device authorization grant (RFC 8628) for headless devices and CLIs
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DeviceCodeGrantType is the grant type used to poll for device authorizations
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	// User codes use a reduced alphabet (no vowels or ambiguous characters) as recommended in RFC 8628 Section 6.1
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+)
+
+// Device authorization token endpoint errors (RFC 8628 Section 3.5)
+var (
+	ErrAuthorizationPending = &fosite.RFC6749Error{
+		Name:        "authorization_pending",
+		Description: "The authorization request is still pending as the user has not yet completed the user interaction steps",
+		Code:        http.StatusBadRequest,
+	}
+	ErrSlowDown = &fosite.RFC6749Error{
+		Name:        "slow_down",
+		Description: "The authorization request is still pending and polling should continue with a longer interval",
+		Code:        http.StatusBadRequest,
+	}
+	ErrExpiredToken = &fosite.RFC6749Error{
+		Name:        "expired_token",
+		Description: "The device code has expired and the device authorization session has concluded",
+		Code:        http.StatusBadRequest,
+	}
+)
+
+// ErrDeviceCodeNotFound indicates a user code does not match a pending device authorization
+var ErrDeviceCodeNotFound = errors.New("OAuth device code not found")
+
+// deviceCodeSignature generates the stored signature for a device code
+// This means device codes cannot be recovered from the database
+func deviceCodeSignature(deviceCode string) string {
+	hash := sha256.Sum256([]byte(deviceCode))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// generateUserCode generates a user code for entry at the verification endpoint
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeCharset)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeCharset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normaliseUserCode strips formatting from user entered codes
+func normaliseUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	userCode = strings.Replace(userCode, "-", "", -1)
+	return strings.Replace(userCode, " ", "", -1)
+}
+
+// formatUserCode formats a user code for display (ie. BCDF-GHJK)
+func formatUserCode(userCode string) string {
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// DeviceAuthorization is the device authorization response returned to clients
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// CreateDeviceAuthorization authenticates a client and issues a device and user code
+// Confidential clients must provide a secret, public clients are identified by client ID only
+func (oc *Controller) CreateDeviceAuthorization(clientID, clientSecret string, scopes []string) (*DeviceAuthorization, error) {
+	c, err := oc.store.GetClientByID(clientID)
+	if err != nil {
+		log.Printf("OAuthController.CreateDeviceAuthorization error fetching client: %s", err)
+		return nil, errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+	if c == nil {
+		return nil, errors.WithStack(fosite.ErrInvalidClient)
+	}
+	client := c.(Client)
+
+	if !client.IsPublic() {
+		if err := bcrypt.CompareHashAndPassword([]byte(client.GetSecret()), []byte(clientSecret)); err != nil {
+			return nil, errors.WithStack(fosite.ErrInvalidClient)
+		}
+	}
+
+	if !arrayContains(client.GetGrantTypes(), DeviceCodeGrantType) {
+		return nil, errors.Wrap(fosite.ErrUnauthorizedClient, "The client is not allowed to use the device code grant")
+	}
+
+	for _, s := range scopes {
+		if !fosite.HierarchicScopeStrategy(client.GetScopes(), s) {
+			return nil, errors.Wrapf(fosite.ErrInvalidScope, "The client is not allowed to request scope %s", s)
+		}
+	}
+
+	deviceCode, err := generateSecret(OAuthSecretBytes)
+	if err != nil {
+		log.Printf("OAuthController.CreateDeviceAuthorization error generating device code: %s", err)
+		return nil, errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		log.Printf("OAuthController.CreateDeviceAuthorization error generating user code: %s", err)
+		return nil, errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+
+	now := time.Now()
+	_, err = oc.store.AddDeviceCodeSession(client.GetID(), deviceCodeSignature(deviceCode), userCode, uuid.NewV4().String(),
+		now, now.Add(oc.config.DeviceExpiry), scopes)
+	if err != nil {
+		log.Printf("OAuthController.CreateDeviceAuthorization error saving device code: %s", err)
+		return nil, errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+
+	verificationURI := strings.TrimSuffix(oc.config.Issuer, "/") + oc.config.DeviceVerificationRedirect
+
+	v := url.Values{}
+	v.Set("user_code", formatUserCode(userCode))
+
+	return &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + v.Encode(),
+		ExpiresIn:               int64(oc.config.DeviceExpiry / time.Second),
+		Interval:                int64(oc.config.DeviceInterval / time.Second),
+	}, nil
+}
+
+// fetchPendingDevice fetches a device code session awaiting user approval
+func (oc *Controller) fetchPendingDevice(userCode string) (DeviceCodeSession, error) {
+	d, err := oc.store.GetDeviceCodeSessionByUserCode(normaliseUserCode(userCode))
+	if err != nil {
+		log.Printf("OAuthController.fetchPendingDevice error fetching device code: %s", err)
+		return nil, ErrInternal
+	}
+	if d == nil {
+		return nil, ErrDeviceCodeNotFound
+	}
+	device := d.(DeviceCodeSession)
+
+	if device.IsApproved() || device.IsDenied() || time.Now().After(device.GetExpiresAt()) {
+		return nil, ErrDeviceCodeNotFound
+	}
+
+	return device, nil
+}
+
+// DeviceRequest is a pending device authorization to be accepted by the user
+type DeviceRequest struct {
+	UserCode string   `json:"user_code"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"requested_scopes"`
+}
+
+// GetDeviceRequest fetches the pending device authorization for a user code
+func (oc *Controller) GetDeviceRequest(userCode string) (*DeviceRequest, error) {
+	device, err := oc.fetchPendingDevice(userCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceRequest{
+		UserCode: formatUserCode(device.GetUserCode()),
+		Name:     device.GetClient().(Client).GetName(),
+		Scopes:   device.GetRequestedScopes(),
+	}, nil
+}
+
+// ConfirmDeviceRequest approves or denies a pending device authorization on behalf of a user
+// Granted scopes are limited to those requested by the device
+func (oc *Controller) ConfirmDeviceRequest(userID, userCode string, accept bool, grantedScopes []string) error {
+	device, err := oc.fetchPendingDevice(userCode)
+	if err != nil {
+		return err
+	}
+
+	if !accept {
+		if err := oc.store.DenyDeviceCodeSession(device.GetUserCode()); err != nil {
+			log.Printf("OAuthController.ConfirmDeviceRequest error denying device code: %s", err)
+			return ErrInternal
+		}
+		return nil
+	}
+
+	granted := make([]string, 0)
+	for _, s := range grantedScopes {
+		if fosite.HierarchicScopeStrategy(device.GetRequestedScopes(), s) {
+			granted = append(granted, s)
+		}
+	}
+
+	if _, err := oc.store.ApproveDeviceCodeSession(device.GetUserCode(), userID, granted); err != nil {
+		log.Printf("OAuthController.ConfirmDeviceRequest error approving device code: %s", err)
+		return ErrInternal
+	}
+
+	log.Printf("OAuthController.ConfirmDeviceRequest user %s approved device authorization for client %s",
+		userID, device.GetClient().(Client).GetID())
+
+	return nil
+}
+
+// DeviceCodeGrantHandler is a fosite token endpoint handler for device code polling
+// Pending requests return authorization_pending (or slow_down if polled too frequently) errors,
+// and approved requests are exchanged once for an access token (and refresh token with the offline scope)
+type DeviceCodeGrantHandler struct {
+	*oauth2.HandleHelper
+	RefreshTokenStrategy oauth2.RefreshTokenStrategy
+	RefreshTokenStorage  oauth2.RefreshTokenStorage
+	Store                Storer
+	Interval             time.Duration
+}
+
+// DeviceCodeGrantFactory creates a device code grant handler for use with compose.Compose
+func DeviceCodeGrantFactory(store Storer, interval time.Duration) compose.Factory {
+	return func(config *compose.Config, storage interface{}, strategy interface{}) interface{} {
+		return &DeviceCodeGrantHandler{
+			HandleHelper: &oauth2.HandleHelper{
+				AccessTokenStrategy: strategy.(oauth2.AccessTokenStrategy),
+				AccessTokenStorage:  storage.(oauth2.AccessTokenStorage),
+				AccessTokenLifespan: config.GetAccessTokenLifespan(),
+			},
+			RefreshTokenStrategy: strategy.(oauth2.RefreshTokenStrategy),
+			RefreshTokenStorage:  storage.(oauth2.RefreshTokenStorage),
+			Store:                store,
+			Interval:             interval,
+		}
+	}
+}
+
+// HandleTokenEndpointRequest validates a device code token request
+func (h *DeviceCodeGrantHandler) HandleTokenEndpointRequest(ctx context.Context, request fosite.AccessRequester) error {
+	if !request.GetGrantTypes().Exact(DeviceCodeGrantType) {
+		return errors.WithStack(fosite.ErrUnknownRequest)
+	}
+
+	client := request.GetClient()
+	if !client.GetGrantTypes().Has(DeviceCodeGrantType) {
+		return errors.Wrap(fosite.ErrInvalidGrant, "The client is not allowed to use the device code grant")
+	}
+
+	signature := deviceCodeSignature(request.GetRequestForm().Get("device_code"))
+	d, err := h.Store.GetDeviceCodeSession(signature)
+	if err != nil {
+		return errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+	if d == nil {
+		return errors.Wrap(fosite.ErrInvalidGrant, "The device code is invalid")
+	}
+	device := d.(DeviceCodeSession)
+
+	if device.GetClient().(Client).GetID() != client.GetID() {
+		return errors.Wrap(fosite.ErrInvalidGrant, "The device code was not issued to this client")
+	}
+
+	now := time.Now()
+
+	if now.After(device.GetExpiresAt()) {
+		h.Store.RemoveDeviceCodeSession(signature)
+		return errors.WithStack(ErrExpiredToken)
+	}
+
+	if device.IsDenied() {
+		h.Store.RemoveDeviceCodeSession(signature)
+		return errors.WithStack(fosite.ErrAccessDenied)
+	}
+
+	if !device.IsApproved() {
+		lastPolled := device.GetLastPolled()
+		if err := h.Store.UpdateDeviceCodeSessionPolled(signature, now); err != nil {
+			return errors.Wrap(fosite.ErrServerError, err.Error())
+		}
+		if now.Sub(lastPolled) < h.Interval {
+			return errors.WithStack(ErrSlowDown)
+		}
+		return errors.WithStack(ErrAuthorizationPending)
+	}
+
+	// Device codes may only be exchanged once, concurrent exchanges are rejected if the code has been removed
+	removed, err := h.Store.RemoveApprovedDeviceCodeSession(signature)
+	if err != nil {
+		return errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+	if !removed {
+		return errors.Wrap(fosite.ErrInvalidGrant, "The device code has already been exchanged")
+	}
+
+	// Bind the approving user and granted scopes to the request
+	userSession := device.GetSession().(UserSession)
+	if wrap, ok := request.GetSession().(*SessionWrap); ok {
+		if session, ok := wrap.UserSession.(*Session); ok {
+			session.UserID = userSession.GetUserID()
+			session.Username = userSession.GetUsername()
+			session.Subject = userSession.GetUserID()
+		}
+	}
+
+	request.SetRequestedScopes(fosite.Arguments(device.GetRequestedScopes()))
+	for _, s := range device.GetGrantedScopes() {
+		request.GrantScope(s)
+	}
+
+	return nil
+}
+
+// PopulateTokenEndpointResponse issues tokens for an approved device code
+func (h *DeviceCodeGrantHandler) PopulateTokenEndpointResponse(ctx context.Context, request fosite.AccessRequester, response fosite.AccessResponder) error {
+	if !request.GetGrantTypes().Exact(DeviceCodeGrantType) {
+		return errors.WithStack(fosite.ErrUnknownRequest)
+	}
+
+	if err := h.IssueAccessToken(ctx, request, response); err != nil {
+		return err
+	}
+
+	if !request.GetGrantedScopes().Has("offline") {
+		return nil
+	}
+
+	refresh, signature, err := h.RefreshTokenStrategy.GenerateRefreshToken(ctx, request)
+	if err != nil {
+		return errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+	if err := h.RefreshTokenStorage.CreateRefreshTokenSession(ctx, signature, request); err != nil {
+		return errors.Wrap(fosite.ErrServerError, err.Error())
+	}
+	response.SetExtra("refresh_token", refresh)
+
+	return nil
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/openid"
 )
 
 // FositeAdaptor adapts a generic interface for osin compliance
@@ -59,8 +61,11 @@ func (oa *FositeAdaptor) CreateAuthorizeCodeSession(ctx context.Context, code st
 	requestedScopes := []string(request.GetRequestedScopes())
 	grantedScopes := []string(request.GetGrantedScopes())
 
+	// PKCE parameters are persisted with the code for verification by the PKCE handler on exchange
+	form := request.GetRequestForm()
+
 	_, err = oa.Storer.AddAuthorizeCodeSession(session.GetUserID(), client.GetID(), code, request.GetID(), request.GetRequestedAt(),
-		session.GetAuthorizeExpiry(), requestedScopes, grantedScopes)
+		session.GetAuthorizeExpiry(), requestedScopes, grantedScopes, form.Get("code_challenge"), form.Get("code_challenge_method"))
 
 	return err
 }
@@ -70,6 +75,9 @@ func (oa *FositeAdaptor) GetAuthorizeCodeSession(ctx context.Context, code strin
 	if err != nil {
 		return nil, err
 	}
+	if a == nil {
+		return nil, fosite.ErrNotFound
+	}
 
 	return NewAuthorizeCodeWrap(a).(fosite.Requester), nil
 }
@@ -94,6 +102,29 @@ func (oa *FositeAdaptor) DeleteAuthorizeCodeSession(ctx context.Context, code st
 	return oa.Storer.RemoveAuthorizeCodeSession(code)
 }
 
+// PKCE request storage
+// PKCE challenges are stored with the authorization code session they are bound to
+
+func (oa *FositeAdaptor) CreatePKCERequestSession(ctx context.Context, signature string, request fosite.Requester) error {
+	a, err := oa.Storer.GetAuthorizeCodeSession(signature)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return fosite.ErrNotFound
+	}
+	return nil
+}
+
+func (oa *FositeAdaptor) GetPKCERequestSession(ctx context.Context, signature string, session fosite.Session) (fosite.Requester, error) {
+	return oa.GetAuthorizeCodeSession(ctx, signature, session)
+}
+
+func (oa *FositeAdaptor) DeletePKCERequestSession(ctx context.Context, signature string) error {
+	// Removed with the authorization code session
+	return nil
+}
+
 // Access code storage (used by all implementations)
 
 func (oa *FositeAdaptor) CreateAccessTokenSession(c context.Context, signature string, request fosite.Requester) (err error) {
@@ -114,19 +145,16 @@ func (oa *FositeAdaptor) GetAccessTokenSession(ctx context.Context, signature st
 	if err != nil {
 		return nil, err
 	}
+	if a == nil {
+		return nil, fosite.ErrNotFound
+	}
 
 	return NewAccessTokenWrap(a).(fosite.Requester), nil
 }
 
+// RevokeAccessToken revokes all access tokens issued for a request ID
 func (oa *FositeAdaptor) RevokeAccessToken(ctx context.Context, requestID string) error {
-	token, err := oa.Storer.GetAccessTokenSessionByRequestID(requestID)
-	if err != nil {
-		return err
-	}
-
-	t := token.(AccessTokenSession)
-
-	return oa.DeleteAccessTokenSession(ctx, t.GetSignature())
+	return oa.Storer.RemoveAccessTokenSessionsByRequestID(requestID)
 }
 
 func (oa *FositeAdaptor) DeleteAccessTokenSession(ctx context.Context, signature string) (err error) {
@@ -153,6 +181,9 @@ func (oa *FositeAdaptor) GetRefreshTokenSession(ctx context.Context, signature s
 	if err != nil {
 		return nil, err
 	}
+	if a == nil {
+		return nil, fosite.ErrNotFound
+	}
 
 	return NewRefreshTokenWrap(a).(fosite.Requester), nil
 }
@@ -170,17 +201,84 @@ func (oa *FositeAdaptor) PersistRefreshTokenGrantSession(ctx context.Context, or
 	return fosite.ErrAccessDenied
 }
 
+// RevokeRefreshToken revokes all refresh tokens issued for a request ID
 func (oa *FositeAdaptor) RevokeRefreshToken(ctx context.Context, requestID string) error {
-	token, err := oa.Storer.GetRefreshTokenSessionByRequestID(requestID)
+	return oa.Storer.RemoveRefreshTokenSessionsByRequestID(requestID)
+}
+
+func (oa *FositeAdaptor) DeleteRefreshTokenSession(ctx context.Context, signature string) (err error) {
+	return oa.Storer.RemoveRefreshToken(signature)
+}
+
+// OpenID Connect session storage
+
+func (oa *FositeAdaptor) CreateOpenIDConnectSession(ctx context.Context, authorizeCode string, request fosite.Requester) error {
+	client := request.GetClient()
+	session := request.GetSession().(*SessionWrap)
+
+	login, ok := session.UserSession.(IDTokenSession)
+	if !ok {
+		return fmt.Errorf("Unsupported OpenID Connect session type: %T", session.UserSession)
+	}
+
+	requestedScopes := []string(request.GetRequestedScopes())
+	grantedScopes := []string(request.GetGrantedScopes())
+
+	_, err := oa.Storer.AddOpenIDSession(session.GetUserID(), client.GetID(), authorizeCode, request.GetID(), request.GetRequestedAt(),
+		session.GetAuthorizeExpiry(), login.GetAuthTime(), login.GetNonce(), login.GetAuthMethods(), requestedScopes, grantedScopes)
+
+	return err
+}
+
+// GetOpenIDConnectSession fetches the OpenID Connect session for an authorization code
+// Sessions are removed when fetched, as the authorization codes they are bound to may only be used once
+func (oa *FositeAdaptor) GetOpenIDConnectSession(ctx context.Context, authorizeCode string, requester fosite.Requester) (fosite.Requester, error) {
+	o, err := oa.Storer.GetOpenIDSession(authorizeCode)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if o == nil {
+		return nil, openid.ErrNoSessionFound
 	}
+	openIDSession := o.(OpenIDSession)
 
-	t := token.(RefreshTokenSession)
+	if err := oa.Storer.RemoveOpenIDSession(authorizeCode); err != nil {
+		return nil, err
+	}
+	if time.Now().After(openIDSession.GetExpiresAt()) {
+		return nil, openid.ErrNoSessionFound
+	}
 
-	return oa.DeleteRefreshTokenSession(ctx, t.GetSignature())
+	session := NewSession(openIDSession.GetUserID(), "")
+	session.Subject = openIDSession.GetUserID()
+	session.Nonce = openIDSession.GetNonce()
+	session.AuthTime = openIDSession.GetAuthTime()
+	session.AuthMethods = openIDSession.GetAuthMethods()
+	wrap := NewSessionWrap(session).(*SessionWrap)
+
+	// Share claims with the token request session, as fosite populates these prior to issuing the ID token
+	if w, ok := requester.GetSession().(*SessionWrap); ok {
+		claims := w.IDTokenClaims()
+		claims.Subject = session.Subject
+		claims.Nonce = session.Nonce
+		claims.AuthTime = session.AuthTime
+		wrap.claims = claims
+	}
+
+	request := fosite.NewRequest()
+	request.SetID(openIDSession.GetRequestID())
+	request.RequestedAt = openIDSession.GetRequestedAt()
+	request.Client = NewClientWrapper(openIDSession.GetClient())
+	request.SetRequestedScopes(fosite.Arguments(openIDSession.GetRequestedScopes()))
+	for _, scope := range openIDSession.GetGrantedScopes() {
+		request.GrantScope(scope)
+	}
+	request.Form.Set("nonce", session.Nonce)
+	request.SetSession(wrap)
+
+	return request, nil
 }
 
-func (oa *FositeAdaptor) DeleteRefreshTokenSession(ctx context.Context, signature string) (err error) {
-	return oa.Storer.RemoveRefreshToken(signature)
+func (oa *FositeAdaptor) DeleteOpenIDConnectSession(ctx context.Context, authorizeCode string) error {
+	return oa.Storer.RemoveOpenIDSession(authorizeCode)
 }
@@ -2,6 +2,7 @@ package oauth
 
 import (
 	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
 	"net/url"
 	"time"
 )
@@ -34,13 +35,17 @@ func (c ClientWrapper) GetScopes() fosite.Arguments {
 }
 
 // SessionWrap overrides the Session interface with Fosite specific types
+// This also implements the fosite OpenID Connect Session interface, with ID token claims
+// populated by fosite (ie. token hashes) held for the lifetime of the wrapper
 type SessionWrap struct {
 	UserSession
+	claims  *jwt.IDTokenClaims
+	headers *jwt.Headers
 }
 
 // NewSessionWrap creates a session wrapper around a session object to support the methods required by fosite
 func NewSessionWrap(s interface{}) fosite.Session {
-	return &SessionWrap{s.(UserSession)}
+	return &SessionWrap{UserSession: s.(UserSession)}
 }
 
 // SetExpiresAt sets the expiry date of a session instance
@@ -73,15 +78,36 @@ func (session *SessionWrap) GetExpiresAt(key fosite.TokenType) time.Time {
 }
 
 func (s *SessionWrap) GetUsername() string {
-	return s.GetUsername()
+	return s.UserSession.GetUsername()
 }
 
 func (s *SessionWrap) GetSubject() string {
-	return s.GetSubject()
+	return s.UserSession.GetSubject()
 }
 
 func (s *SessionWrap) Clone() fosite.Session {
-	return s.UserSession.Clone().(fosite.Session)
+	return NewSessionWrap(s.UserSession.Clone())
+}
+
+// IDTokenClaims fetches the ID token claims for the session
+// Claims are created from the underlying session on first use
+func (s *SessionWrap) IDTokenClaims() *jwt.IDTokenClaims {
+	if s.claims == nil {
+		s.claims = &jwt.IDTokenClaims{Subject: s.UserSession.GetSubject()}
+		if session, ok := s.UserSession.(IDTokenSession); ok {
+			s.claims.Nonce = session.GetNonce()
+			s.claims.AuthTime = session.GetAuthTime()
+		}
+	}
+	return s.claims
+}
+
+// IDTokenHeaders fetches the ID token headers for the session
+func (s *SessionWrap) IDTokenHeaders() *jwt.Headers {
+	if s.headers == nil {
+		s.headers = &jwt.Headers{}
+	}
+	return s.headers
 }
 
 type AuthorizeCodeWrap struct {
@@ -93,7 +119,7 @@ func NewAuthorizeCodeWrap(i interface{}) fosite.Requester {
 }
 
 func (s *AuthorizeCodeWrap) GetID() string {
-	return s.GetID()
+	return s.GetRequestID()
 }
 
 func (s *AuthorizeCodeWrap) SetID(id string) {
@@ -109,8 +135,14 @@ func (s *AuthorizeCodeWrap) GetGrantedScopes() fosite.Arguments {
 	return fosite.Arguments(s.AuthorizeCodeSession.GetGrantedScopes())
 }
 
+// GetRequestForm fetches the persisted authorization request parameters (the PKCE challenge and method)
 func (s *AuthorizeCodeWrap) GetRequestForm() url.Values {
-	return url.Values{}
+	form := url.Values{}
+	if challenge := s.AuthorizeCodeSession.GetChallenge(); challenge != "" {
+		form.Set("code_challenge", challenge)
+		form.Set("code_challenge_method", s.AuthorizeCodeSession.GetChallengeMethod())
+	}
+	return form
 }
 
 func (s *AuthorizeCodeWrap) GetRequestedScopes() fosite.Arguments {
@@ -142,7 +174,7 @@ func NewAccessTokenWrap(i interface{}) interface{} {
 }
 
 func (s *AccessTokenWrap) GetID() string {
-	return s.GetID()
+	return s.GetRequestID()
 }
 
 func (s *AccessTokenWrap) GetClient() fosite.Client {
@@ -187,7 +219,7 @@ func NewRefreshTokenWrap(i interface{}) interface{} {
 }
 
 func (s *RefreshTokenWrap) GetID() string {
-	return s.GetID()
+	return s.GetRequestID()
 }
 
 func (s *RefreshTokenWrap) GetClient() fosite.Client {
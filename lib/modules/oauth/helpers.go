@@ -4,6 +4,9 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"net"
+	"net/url"
+	"strings"
 )
 
 func generateSecret(len int) (string, error) {
@@ -27,3 +30,26 @@ func arrayContains(arr []string, line string) bool {
 	}
 	return false
 }
+
+// isNativeRedirect checks whether a redirect URI is suitable for a native application (RFC 8252)
+// This allows loopback http redirects and private-use (reverse domain name) URI schemes
+func isNativeRedirect(redirect string) bool {
+	u, err := url.Parse(redirect)
+	if err != nil || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	case "https", "":
+		return false
+	}
+
+	return strings.Contains(u.Scheme, ".")
+}
@@ -9,7 +9,6 @@
 package oauth
 
 import (
-	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -17,10 +16,12 @@ import (
 
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/compose"
+	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/events"
 )
 
 const (
@@ -33,28 +34,43 @@ const (
 // This is a safe error return for the OAuth API to wrap underlying errors
 var ErrInternal = errors.New("OAuth internal error")
 
+// PKCEMethodS256 is the only supported PKCE challenge method
+const PKCEMethodS256 = "S256"
+
 // Controller OAuth module controller
 type Controller struct {
-	OAuth2 fosite.OAuth2Provider
-	store  Storer
-	config config.OAuthConfig
+	OAuth2   fosite.OAuth2Provider
+	store    Storer
+	config   config.OAuthConfig
+	idTokens *IDTokenStrategy
+	emitter  events.Emitter
 }
 
 // NewController Creates a new OAuth2 controller instance
-func NewController(store Storer, config config.OAuthConfig) *Controller {
+func NewController(store Storer, config config.OAuthConfig, emitter events.Emitter) (*Controller, error) {
+
+	// Load OpenID Connect signing key
+	key, err := LoadSigningKey(config.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	idTokens, err := NewIDTokenStrategy(config.Issuer, config.IDExpiry, key)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create configuration
 	var oauthConfig = &compose.Config{
 		AccessTokenLifespan:   time.Hour * 1,
 		AuthorizeCodeLifespan: time.Hour * 1,
-		IDTokenLifespan:       time.Hour * 1,
+		IDTokenLifespan:       config.IDExpiry,
 		HashCost:              clientSecretHashRounds,
 	}
 
 	// Create OAuth2 and OpenID Strategies
 	var strat = compose.CommonStrategy{
-		CoreStrategy: compose.NewOAuth2HMACStrategy(oauthConfig, []byte(config.TokenSecret)),
-		//OpenIDConnectTokenStrategy: compose.NewOpenIDConnectStrategy(cfg.Key),
+		CoreStrategy:               compose.NewOAuth2HMACStrategy(oauthConfig, []byte(config.TokenSecret)),
+		OpenIDConnectTokenStrategy: idTokens,
 	}
 
 	wrappedStore := NewAdaptor(store)
@@ -67,24 +83,28 @@ func NewController(store Storer, config config.OAuthConfig) *Controller {
 
 		compose.OAuth2AuthorizeExplicitFactory,
 		compose.OAuth2AuthorizeImplicitFactory,
+		compose.OAuth2PKCEFactory,
 		compose.OAuth2ClientCredentialsGrantFactory,
 		compose.OAuth2RefreshTokenGrantFactory,
+		DeviceCodeGrantFactory(store, config.DeviceInterval),
 
 		compose.OAuth2TokenRevocationFactory,
 		compose.OAuth2TokenIntrospectionFactory,
 
-		//compose.OpenIDConnectExplicitFactory,
-		//compose.OpenIDConnectImplicitFactory,
-		//compose.OpenIDConnectHybridFactory,
+		compose.OpenIDConnectExplicitFactory,
+		compose.OpenIDConnectImplicitFactory,
+		compose.OpenIDConnectHybridFactory,
 	)
 
 	c := Controller{
-		OAuth2: oauth2,
-		store:  store,
-		config: config,
+		OAuth2:   oauth2,
+		store:    store,
+		config:   config,
+		idTokens: idTokens,
+		emitter:  emitter,
 	}
 
-	return &c
+	return &c, nil
 }
 
 // CreateClient Creates an OAuth Client Credential grant based client for a given user
@@ -131,6 +151,11 @@ func (oc *Controller) CreateClient(userID, clientName string, scopes, redirects,
 
 	// Check grant / response types are valid
 	for _, g := range grantTypes {
+		if public && g == "client_credentials" {
+			log.Printf("OAuthController.CreateClient blocked due to client credentials grant for public client")
+			return nil, fmt.Errorf("Invalid grant type: %s (not available to public clients)", g)
+		}
+
 		if user.IsAdmin() {
 			if !arrayContains(oc.config.AllowedGrants.Admin, g) {
 				log.Printf("OAuthController.CreateClient blocked due to invalid admin grants")
@@ -163,6 +188,7 @@ func (oc *Controller) CreateClient(userID, clientName string, scopes, redirects,
 		Scopes:       client.GetScopes(),
 		GrantTypes:   client.GetGrantTypes(),
 		RedirectURIs: client.GetRedirectURIs(),
+		Public:       client.IsPublic(),
 		Secret:       clientSecret,
 	}
 
@@ -202,6 +228,7 @@ type ClientResp struct {
 	GrantTypes    []string  `json:"grant_types"`
 	ResponseTypes []string  `json:"response_types"`
 	RedirectURIs  []string  `json:"redirect_uris"`
+	Public        bool      `json:"public"`
 	Secret        string    `json:"secret"`
 }
 
@@ -227,6 +254,7 @@ func (oc *Controller) GetClients(userID string) ([]ClientResp, error) {
 			GrantTypes:    client.GetGrantTypes(),
 			ResponseTypes: client.GetResponseTypes(),
 			RedirectURIs:  client.GetRedirectURIs(),
+			Public:        client.IsPublic(),
 		}
 
 		clientResps = append(clientResps, clean)
@@ -286,6 +314,8 @@ func (oc *Controller) GetAccessTokenInfo(tokenString string) (*AccessTokenInfo,
 type GrantInfo struct {
 	ID          string    `json:"id"`
 	Type        string    `json:"type"`
+	ClientID    string    `json:"client_id"`
+	ClientName  string    `json:"client_name"`
 	Scopes      []string  `json:"scopes"`
 	RequestedAt time.Time `json:"requested_at"`
 	ExpiresAt   time.Time `json:"expires_at"`
@@ -297,9 +327,13 @@ type UserSessions struct {
 	AccessCodes        []GrantInfo `json:"access_codes"`
 }
 
-func sessionBaseToGrantInfo(s SessionBase) GrantInfo {
+func sessionBaseToGrantInfo(s SessionBase, grantType string) GrantInfo {
+	client := s.GetClient().(Client)
 	grant := GrantInfo{
 		ID:          s.GetRequestID(),
+		Type:        grantType,
+		ClientID:    client.GetID(),
+		ClientName:  client.GetName(),
 		Scopes:      s.GetRequestedScopes(),
 		RequestedAt: s.GetRequestedAt(),
 		ExpiresAt:   s.GetExpiresAt(),
@@ -327,7 +361,7 @@ func (oc *Controller) GetUserSessions(userID string) (*UserSessions, error) {
 		return nil, ErrInternal
 	}
 	for _, tokenSession := range authorizationCodes {
-		grants.AuthorizationCodes = append(grants.AuthorizationCodes, sessionBaseToGrantInfo(tokenSession.(SessionBase)))
+		grants.AuthorizationCodes = append(grants.AuthorizationCodes, sessionBaseToGrantInfo(tokenSession.(SessionBase), GrantTypeAuthorizationCode))
 	}
 
 	// Fetch refresh token grants
@@ -337,7 +371,7 @@ func (oc *Controller) GetUserSessions(userID string) (*UserSessions, error) {
 		return nil, ErrInternal
 	}
 	for _, tokenSession := range refreshTokens {
-		grants.RefreshTokens = append(grants.RefreshTokens, sessionBaseToGrantInfo(tokenSession.(SessionBase)))
+		grants.RefreshTokens = append(grants.RefreshTokens, sessionBaseToGrantInfo(tokenSession.(SessionBase), GrantTypeRefreshToken))
 	}
 
 	// Fetch access code grants
@@ -347,12 +381,33 @@ func (oc *Controller) GetUserSessions(userID string) (*UserSessions, error) {
 		return nil, ErrInternal
 	}
 	for _, tokenSession := range accessCodes {
-		grants.AccessCodes = append(grants.AccessCodes, sessionBaseToGrantInfo(tokenSession.(SessionBase)))
+		grants.AccessCodes = append(grants.AccessCodes, sessionBaseToGrantInfo(tokenSession.(SessionBase), GrantTypeAccessToken))
 	}
 
 	return &grants, nil
 }
 
+// checkPKCE checks the PKCE parameters of an authorization request
+// Public clients cannot authenticate when exchanging codes, so must provide a PKCE challenge for code
+// responses. Only S256 challenges are accepted, with verification performed by the fosite PKCE handler.
+func checkPKCE(ar fosite.AuthorizeRequester) error {
+	challenge := ar.GetRequestForm().Get("code_challenge")
+	method := ar.GetRequestForm().Get("code_challenge_method")
+
+	if challenge == "" {
+		if ar.GetClient().IsPublic() && ar.GetResponseTypes().Has("code") {
+			return errors.Wrap(fosite.ErrInvalidRequest, "PKCE code_challenge is required for public clients")
+		}
+		return nil
+	}
+
+	if method != PKCEMethodS256 {
+		return errors.Wrap(fosite.ErrInvalidRequest, "PKCE code_challenge_method must be S256")
+	}
+
+	return nil
+}
+
 func (oc *Controller) newOauthSession(userID, subject string) Session {
 	now := time.Now()
 	return Session{
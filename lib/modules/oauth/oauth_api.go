@@ -63,23 +63,48 @@ func (oc *Controller) BindAPI(base *web.Router) *web.Router {
 	// Bind paths to endpoint
 	router.Get("/clients", (*APICtx).ClientsGet)
 	router.Get("/options", (*APICtx).OptionsGet)
-	router.Post("/clients", (*APICtx).ClientsPost)
 
 	router.Get("/auth", (*APICtx).AuthorizeRequestGet)
 	router.Get("/pending", (*APICtx).AuthorizePendingGet)
 	router.Post("/auth", (*APICtx).AuthorizeConfirmPost)
 
 	router.Post("/token", (*APICtx).TokenPost)
+	router.Post("/device", (*APICtx).DevicePost)
+	router.Get("/device/verify", (*APICtx).DeviceVerifyGet)
+	router.Post("/device/verify", (*APICtx).DeviceVerifyPost)
 	router.Get("/introspect", (*APICtx).IntrospectPost)
+	router.Post("/revoke", (*APICtx).RevokePost)
 
 	router.Get("/info", (*APICtx).AccessTokenInfoGet)
 
 	router.Get("/sessions", (*APICtx).SessionsInfoGet)
+	router.Post("/sessions/revoke", (*APICtx).SessionRevokePost)
+	router.Post("/clients/revoke", (*APICtx).ClientRevokePost)
+
+	router.Get("/userinfo", (*APICtx).UserInfoGet)
+	router.Post("/userinfo", (*APICtx).UserInfoGet)
+
+	// Bind paths requiring reauthorization
+	sudoRouter := router.Subrouter(APICtx{}, "")
+	sudoRouter.Middleware((*APICtx).RequireSudoMiddleware)
+	sudoRouter.Post("/clients", (*APICtx).ClientsPost)
+
+	// Bind OpenID Connect discovery paths
+	wellKnown := base.Subrouter(APICtx{}, "/.well-known")
+	wellKnown.Middleware(BindOauthContext(oc))
+	wellKnown.Get("/openid-configuration", (*APICtx).DiscoveryGet)
+	wellKnown.Get("/jwks.json", (*APICtx).KeySetGet)
 
 	// Return router for external use
 	return router
 }
 
+// BindFlows registers the flow actions started by the OAuth module with the global context
+// Consent flows hold authorization requests until the user has logged in and accepted or rejected the request
+func (oc *Controller) BindFlows(ctx *appcontext.AuthPlzGlobalCtx) {
+	ctx.RegisterFlowAction(appcontext.FlowActionOAuthConsent, appcontext.FlowAction{})
+}
+
 // ClientsGet Lists clients bound owned by a user account
 func (c *APICtx) ClientsGet(rw web.ResponseWriter, req *web.Request) {
 	// Check user is logged in
@@ -126,6 +151,7 @@ type ClientReq struct {
 	Redirects []string `json:"redirects"`
 	Grants    []string `json:"grant_types"`
 	Responses []string `json:"response_types"`
+	Public    bool     `json:"public"`
 }
 
 var clientNameExp = regexp.MustCompile(`([a-zA-Z0-9\. ]+)`)
@@ -155,8 +181,9 @@ func (c *APICtx) ClientsPost(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Validate request URLs
+	// Public (native) clients may also use private-use URI scheme redirects
 	for _, url := range clientReq.Redirects {
-		if !govalidator.IsURL(url) {
+		if !govalidator.IsURL(url) && !(clientReq.Public && isNativeRedirect(url)) {
 			c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthInvalidRedirect)
 			return
 		}
@@ -165,7 +192,7 @@ func (c *APICtx) ClientsPost(rw web.ResponseWriter, req *web.Request) {
 	// TODO: Validate response types
 
 	// Create client instance
-	client, err := c.oc.CreateClient(c.GetUserID(), clientReq.Name, clientReq.Scopes, clientReq.Redirects, clientReq.Grants, clientReq.Responses, true)
+	client, err := c.oc.CreateClient(c.GetUserID(), clientReq.Name, clientReq.Scopes, clientReq.Redirects, clientReq.Grants, clientReq.Responses, clientReq.Public)
 	if err != nil {
 		log.Printf("oauth.ClientsPost error creating client: %s", err)
 		c.WriteInternalError(rw)
@@ -186,14 +213,30 @@ func (c *APICtx) AuthorizeRequestGet(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
+	// Check PKCE parameters (required for public clients)
+	if err := checkPKCE(ar); err != nil {
+		log.Printf("Oauth AuthorizeResponseGet PKCE error: %s", err)
+		c.oc.OAuth2.WriteAuthorizeError(rw, ar, err)
+		return
+	}
+
 	// Note that checks occur at the AuthorizeConfirmPost stage
 
 	// TODO: Check if app is already authorized and redirect if so (and appropriate)
 
-	// Cache authorization request
-	session := c.GetSession()
-	session.Values["oauth"] = ar
-	session.Save(req.Request, rw)
+	// Start consent flow for the authorization request, requiring login first if the user is not logged in
+	if c.GetUserID() == "" {
+		err = c.StartFlowWithData(rw, req, "", appcontext.FlowActionOAuthConsent, ar,
+			appcontext.FlowStepCredentials, appcontext.FlowStepConsent)
+	} else {
+		err = c.StartFlowWithData(rw, req, c.GetUserID(), appcontext.FlowActionOAuthConsent, ar,
+			appcontext.FlowStepConsent)
+	}
+	if err != nil {
+		log.Printf("Oauth AuthorizeResponseGet error starting consent flow: %s", err)
+		c.WriteInternalError(rw)
+		return
+	}
 
 	// Check user is logged in
 	if c.GetUserID() == "" {
@@ -222,12 +265,12 @@ func (c *APICtx) AuthorizePendingGet(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
-	// Fetch OAuth Authorization Request from session
-	if c.GetSession().Values["oauth"] == nil {
+	// Fetch OAuth Authorization Request from consent flow
+	ar, ok := c.getConsentRequest(rw, req)
+	if !ok {
 		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthNoAuthorizePending)
 		return
 	}
-	ar := c.GetSession().Values["oauth"].(fosite.AuthorizeRequest)
 
 	// Client is an interface so cannot be parsed to or from json
 	ar.Client = nil
@@ -261,13 +304,12 @@ func (c *APICtx) AuthorizeConfirmPost(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
-	// Fetch authorization request from session
-	ar := c.GetSession().Values["oauth"]
-	if ar == nil {
+	// Fetch authorization request from consent flow
+	authorizeRequest, ok := c.getConsentRequest(rw, req)
+	if !ok {
 		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthNoAuthorizePending)
 		return
 	}
-	authorizeRequest := ar.(fosite.AuthorizeRequest)
 
 	authorizeConfirm := AuthorizeConfirm{}
 	defer req.Body.Close()
@@ -283,14 +325,16 @@ func (c *APICtx) AuthorizeConfirmPost(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	if !authorizeConfirm.Accept {
-		session := c.GetSession()
-		session.Values["oauth"] = nil
-		session.Save(req.Request, rw)
+		c.CancelFlow(rw, req, appcontext.FlowActionOAuthConsent)
 		return
 	}
 
 	// Create OAuth Session
-	oauthSession := c.oc.newOauthSession(c.GetUserID(), "")
+	// OpenID Connect claims are taken from the authorization request and the user's current login
+	oauthSession := c.oc.newOauthSession(c.GetUserID(), c.GetUserID())
+	oauthSession.Nonce = authorizeRequest.GetRequestForm().Get("nonce")
+	oauthSession.AuthTime = c.GetLoginTime()
+	oauthSession.AuthMethods = c.GetLoginMethods()
 
 	log.Printf("AuthConfirm: %+v", authorizeConfirm)
 
@@ -304,18 +348,48 @@ func (c *APICtx) AuthorizeConfirmPost(rw web.ResponseWriter, req *web.Request) {
 	authorizeRequest.HandledResponseTypes = validResponses
 	log.Printf("AuthRequest: %+v", authorizeRequest)
 
-	// Create response
-	response, err := c.oc.OAuth2.NewAuthorizeResponse(c.fositeContext, &authorizeRequest, NewSessionWrap(&oauthSession))
+	// Create response, completing the consent flow
+	var response fosite.AuthorizeResponder
+	err := c.CompleteFlowStep(rw, req, c.GetUserID(), appcontext.FlowActionOAuthConsent, appcontext.FlowStepConsent, func() error {
+		var err error
+		response, err = c.oc.OAuth2.NewAuthorizeResponse(c.fositeContext, authorizeRequest, NewSessionWrap(&oauthSession))
+		return err
+	})
 	if err != nil {
 		log.Printf("OauthAPI.AuthorizeConfirmPost error: %s", errors.Cause(err))
-		c.oc.OAuth2.WriteAuthorizeError(rw, &authorizeRequest, err)
+		c.oc.OAuth2.WriteAuthorizeError(rw, authorizeRequest, err)
 		return
 	}
 
 	log.Printf("AuthResponse: %+v", response)
 
 	// Write output
-	c.oc.OAuth2.WriteAuthorizeResponse(rw, &authorizeRequest, response)
+	c.oc.OAuth2.WriteAuthorizeResponse(rw, authorizeRequest, response)
+}
+
+// getConsentRequest fetches the authorization request awaiting consent from the logged in user
+// Consent flows started before login are bound to the user at the credentials step
+func (c *APICtx) getConsentRequest(rw web.ResponseWriter, req *web.Request) (*fosite.AuthorizeRequest, bool) {
+	flow := c.GetActionFlow(rw, req, appcontext.FlowActionOAuthConsent)
+	if flow != nil && flow.Next() == appcontext.FlowStepCredentials {
+		err := c.CompleteFlowStep(rw, req, c.GetUserID(), appcontext.FlowActionOAuthConsent, appcontext.FlowStepCredentials, nil)
+		if err != nil {
+			log.Printf("OauthAPI.getConsentRequest error binding consent flow: %s", err)
+			return nil, false
+		}
+		flow = c.GetActionFlow(rw, req, appcontext.FlowActionOAuthConsent)
+	}
+
+	if flow == nil || flow.UserID != c.GetUserID() || flow.Next() != appcontext.FlowStepConsent {
+		return nil, false
+	}
+
+	authorizeRequest, ok := flow.Data.(fosite.AuthorizeRequest)
+	if !ok {
+		return nil, false
+	}
+
+	return &authorizeRequest, true
 }
 
 // IntrospectPost Token Introspection endpoint
@@ -333,6 +407,20 @@ func (c *APICtx) IntrospectPost(rw web.ResponseWriter, req *web.Request) {
 	c.oc.OAuth2.WriteIntrospectionResponse(rw, response)
 }
 
+// RevokePost Token Revocation endpoint (RFC 7009)
+// Clients may revoke their own access and refresh tokens, revoking a refresh token also revokes
+// access tokens issued from the same grant
+func (c *APICtx) RevokePost(rw web.ResponseWriter, req *web.Request) {
+	ctx := fosite.NewContext()
+
+	err := c.oc.OAuth2.NewRevocationRequest(ctx, req.Request)
+	if err != nil {
+		log.Printf("OauthAPI.RevokePost NewRevocationRequest error: %s", err)
+	}
+
+	c.oc.OAuth2.WriteRevocationResponse(rw, err)
+}
+
 // AccessTokenInfoGet Access Token Information endpoint
 func (c *APICtx) AccessTokenInfoGet(rw web.ResponseWriter, req *web.Request) {
 
@@ -358,6 +446,45 @@ func (c *APICtx) AccessTokenInfoGet(rw web.ResponseWriter, req *web.Request) {
 	c.WriteJSON(rw, token)
 }
 
+// UserInfoGet OpenID Connect UserInfo endpoint
+func (c *APICtx) UserInfoGet(rw web.ResponseWriter, req *web.Request) {
+
+	tokenString := fosite.AccessTokenFromRequest(req.Request)
+	split := strings.Split(tokenString, ".")
+	if len(split) != 2 {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.OAuthMissingAccessToken)
+		return
+	}
+
+	info, err := c.oc.GetUserInfo(split[1])
+	if err == ErrInvalidToken {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.WriteAPIResultWithCode(rw, http.StatusUnauthorized, api.OAuthNoTokenFound)
+		return
+	} else if err == ErrInsufficientScope {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		c.WriteAPIResultWithCode(rw, http.StatusForbidden, api.Unauthorized)
+		return
+	} else if err != nil {
+		log.Printf("OauthAPI.UserInfoGet GetUserInfo error: %s", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteJSON(rw, info)
+}
+
+// DiscoveryGet OpenID Connect discovery endpoint
+func (c *APICtx) DiscoveryGet(rw web.ResponseWriter, req *web.Request) {
+	c.WriteJSON(rw, c.oc.GetDiscovery())
+}
+
+// KeySetGet OpenID Connect signing key set endpoint
+func (c *APICtx) KeySetGet(rw web.ResponseWriter, req *web.Request) {
+	c.WriteJSON(rw, c.oc.GetKeySet())
+}
+
 // TokenPost Uses an authorization to fetch an access token
 func (c *APICtx) TokenPost(rw web.ResponseWriter, req *web.Request) {
 	ctx := fosite.NewContext()
@@ -389,10 +516,13 @@ func (c *APICtx) TokenPost(rw web.ResponseWriter, req *web.Request) {
 	c.oc.UpdateClient(client.Client)
 
 	// Grant requested scopes
+	// Device code grants are limited to the scopes approved by the user
 	// TODO: limit by client..?
 	// I think client_credentials should be limited to introspection only (no app level permissions)
-	for _, scope := range ar.GetRequestedScopes() {
-		ar.GrantScope(scope)
+	if !ar.GetGrantTypes().Exact(DeviceCodeGrantType) {
+		for _, scope := range ar.GetRequestedScopes() {
+			ar.GrantScope(scope)
+		}
 	}
 
 	// Build response
@@ -407,6 +537,94 @@ func (c *APICtx) TokenPost(rw web.ResponseWriter, req *web.Request) {
 	c.oc.OAuth2.WriteAccessResponse(rw, ar, response)
 }
 
+// DevicePost Device Authorization endpoint (RFC 8628)
+// Issues device and user codes to a client, with the user code to be entered at the verification endpoint
+func (c *APICtx) DevicePost(rw web.ResponseWriter, req *web.Request) {
+	if err := req.ParseForm(); err != nil {
+		c.oc.OAuth2.WriteAccessError(rw, nil, errors.Wrap(fosite.ErrInvalidRequest, err.Error()))
+		return
+	}
+
+	// Clients may authenticate with basic auth or form parameters
+	clientID, clientSecret, ok := req.BasicAuth()
+	if !ok {
+		clientID = req.PostForm.Get("client_id")
+		clientSecret = req.PostForm.Get("client_secret")
+	}
+
+	scopes := strings.Fields(req.PostForm.Get("scope"))
+
+	device, err := c.oc.CreateDeviceAuthorization(clientID, clientSecret, scopes)
+	if err != nil {
+		log.Printf("OauthAPI.DevicePost CreateDeviceAuthorization error: %s", err)
+		c.oc.OAuth2.WriteAccessError(rw, nil, err)
+		return
+	}
+
+	rw.Header().Set("Cache-Control", "no-store")
+	c.WriteJSON(rw, device)
+}
+
+// DeviceVerifyGet Fetch the pending device authorization for a user code
+func (c *APICtx) DeviceVerifyGet(rw web.ResponseWriter, req *web.Request) {
+	// Check user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	device, err := c.oc.GetDeviceRequest(req.URL.Query().Get("user_code"))
+	if err == ErrDeviceCodeNotFound {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthNoDevicePending)
+		return
+	} else if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteJSON(rw, device)
+}
+
+// DeviceConfirm is the confirmation for a given device authorization
+type DeviceConfirm struct {
+	UserCode      string   `json:"user_code"`
+	Accept        bool     `json:"accept"`
+	GrantedScopes []string `json:"granted_scopes"`
+}
+
+// DeviceVerifyPost Approve or deny a pending device authorization
+func (c *APICtx) DeviceVerifyPost(rw web.ResponseWriter, req *web.Request) {
+	// Check user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	deviceConfirm := DeviceConfirm{}
+	defer req.Body.Close()
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&deviceConfirm); err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.DecodingFailed)
+		return
+	}
+
+	if deviceConfirm.Accept && len(deviceConfirm.GrantedScopes) == 0 {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthNoGrantedScopes)
+		return
+	}
+
+	err := c.oc.ConfirmDeviceRequest(c.GetUserID(), deviceConfirm.UserCode, deviceConfirm.Accept, deviceConfirm.GrantedScopes)
+	if err == ErrDeviceCodeNotFound {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthNoDevicePending)
+		return
+	} else if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.OK)
+}
+
 // SessionsInfoGet Lists authorized sessions for a user
 func (c *APICtx) SessionsInfoGet(rw web.ResponseWriter, req *web.Request) {
 	// Check user is logged in
@@ -424,3 +642,43 @@ func (c *APICtx) SessionsInfoGet(rw web.ResponseWriter, req *web.Request) {
 
 	c.WriteJSON(rw, sessions)
 }
+
+// SessionRevokePost revokes a single OAuth grant listed in the user's sessions
+func (c *APICtx) SessionRevokePost(rw web.ResponseWriter, req *web.Request) {
+	// Check user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := c.oc.RevokeGrant(c.GetUserID(), req.FormValue("type"), req.FormValue("id"), c.GetMeta())
+	if err == ErrGrantNotFound {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthNoTokenFound)
+		return
+	} else if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.OAuthGrantRevoked)
+}
+
+// ClientRevokePost revokes all OAuth grants issued to a client on behalf of the user
+func (c *APICtx) ClientRevokePost(rw web.ResponseWriter, req *web.Request) {
+	// Check user is logged in
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := c.oc.RevokeClient(c.GetUserID(), req.FormValue("client_id"), c.GetMeta())
+	if err == ErrGrantNotFound {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.OAuthNoTokenFound)
+		return
+	} else if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.OAuthClientRevoked)
+}
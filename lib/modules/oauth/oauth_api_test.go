@@ -11,12 +11,16 @@
 package oauth
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	jwtgo "github.com/dgrijalva/jwt-go"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 
@@ -24,8 +28,11 @@ import (
 	"github.com/ory/fosite"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
 	"github.com/authplz/authplz-core/lib/config"
 	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/events"
 	"github.com/authplz/authplz-core/lib/modules/core"
 	"github.com/authplz/authplz-core/lib/modules/user"
 	"github.com/authplz/authplz-core/lib/test"
@@ -88,15 +95,22 @@ func TestOauthAPI(t *testing.T) {
 
 	config := config.DefaultOAuthConfig()
 
-	userModule := user.NewController(ts.DataStore, ts.EventEmitter)
+	userModule := user.NewController(ts.DataStore, ts.Config.Lockout, ts.EventEmitter)
 
 	coreModule := core.NewController(ts.TokenControl, userModule, &test.MockEventEmitter{})
 	coreModule.BindModule("user", userModule)
+	coreModule.BindFlows(ts.Ctx, time.Minute)
 	coreModule.BindAPI(ts.Router)
 	userModule.BindAPI(ts.Router)
 
 	// Create and bind oauth server instance
-	oauthModule := NewController(ts.DataStore, config)
+	mockEventEmitter := test.MockEventEmitter{}
+	oauthModule, err := NewController(ts.DataStore, config, &mockEventEmitter)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	oauthModule.BindFlows(ts.Ctx)
 	oauthModule.BindAPI(ts.Router)
 
 	ts.Run()
@@ -137,10 +151,10 @@ func TestOauthAPI(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
-	scopes := []string{"public.read", "public.write", "private.read", "private.write", "offline", "introspect"}
+	scopes := []string{"openid", "email", "public.read", "public.write", "private.read", "private.write", "offline", "introspect"}
 	redirects := []string{redirect}
 	grants := []string{"authorization_code", "implicit", "client_credentials", "refresh_token"}
-	responses := []string{"token", "code"}
+	responses := []string{"token", "code", "id_token"}
 
 	t.Run("OAuthAPI create client", func(t *testing.T) {
 		cr := ClientReq{
@@ -151,6 +165,15 @@ func TestOauthAPI(t *testing.T) {
 			Responses: responses,
 		}
 
+		// Client creation requires reauthorization
+		_, err := client.PostJSON("/oauth/clients", http.StatusForbidden, &cr)
+		assert.Nil(t, err)
+
+		v := url.Values{}
+		v.Set("password", test.FakePass)
+		_, err = client.PostForm("/sudo", http.StatusOK, v)
+		assert.Nil(t, err)
+
 		resp, err := client.PostJSON("/oauth/clients", 200, &cr)
 		assert.Nil(t, err)
 		err = test.ParseJson(resp, &oauthClient)
@@ -232,6 +255,54 @@ func TestOauthAPI(t *testing.T) {
 
 	})
 
+	t.Run("OAuthAPI consent flows wait for login", func(t *testing.T) {
+		loginClient := test.NewClient("http://" + ts.Address() + "/api")
+
+		v := url.Values{}
+		v.Set("response_type", "token")
+		v.Set("client_id", oauthClient.ClientID)
+		v.Set("redirect_uri", oauthClient.RedirectURIs[0])
+		v.Set("scope", "public.read")
+		v.Set("state", "kdsjfhaw4i7ryhasdkjfh")
+
+		// Authorization requests before login start a consent flow requiring credentials
+		_, err := loginClient.GetWithParams("/oauth/auth", http.StatusUnauthorized, v)
+		assert.Nil(t, err)
+
+		var status appcontext.FlowStatus
+		err = loginClient.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, appcontext.FlowActionOAuthConsent, status.Action)
+		assert.EqualValues(t, appcontext.FlowStepCredentials, status.Step)
+
+		lv := url.Values{}
+		lv.Set("email", test.FakeEmail)
+		lv.Set("password", test.FakePass)
+		_, err = loginClient.PostForm("/login", http.StatusOK, lv)
+		assert.Nil(t, err)
+
+		// The pending request is available for consent once logged in
+		resp, err := loginClient.Get("/oauth/pending", http.StatusOK)
+		assert.Nil(t, err)
+
+		authReq := AuthorizationRequest{}
+		err = test.ParseJson(resp, &authReq)
+		assert.Nil(t, err)
+		assert.EqualValues(t, v.Get("state"), authReq.State)
+
+		err = loginClient.GetJSON("/flow", http.StatusOK, &status)
+		assert.Nil(t, err)
+		assert.EqualValues(t, appcontext.FlowStepConsent, status.Step)
+
+		// Rejecting the request completes the consent flow
+		ac := AuthorizeConfirm{false, v.Get("state"), []string{"public.read"}}
+		_, err = loginClient.PostJSON("/oauth/auth", http.StatusOK, &ac)
+		assert.Nil(t, err)
+
+		_, err = loginClient.Get("/oauth/pending", http.StatusBadRequest)
+		assert.Nil(t, err)
+	})
+
 	t.Run("OAuthAPI Authorization Code grant", func(t *testing.T) {
 		v := url.Values{}
 		v.Set("response_type", "code")
@@ -303,6 +374,166 @@ func TestOauthAPI(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("OAuthAPI OpenID Connect Authorization Code flow", func(t *testing.T) {
+		v := url.Values{}
+		v.Set("response_type", "code")
+		v.Set("client_id", oauthClient.ClientID)
+		v.Set("redirect_uri", oauthClient.RedirectURIs[0])
+		v.Set("scope", "openid email")
+		v.Set("state", "bsf3rjengkrasfdasbtjrc")
+		v.Set("nonce", "ljkxnvoiwuhrtaosidfj")
+
+		_, err := client.GetWithParams("/oauth/auth", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		ac := AuthorizeConfirm{true, v.Get("state"), []string{"openid", "email"}}
+		resp, err := client.PostJSON("/oauth/auth", 302, &ac)
+		assert.Nil(t, err)
+
+		tokenValues, err := url.ParseQuery(resp.Header.Get("Location"))
+		assert.Nil(t, err)
+
+		codeString := tokenValues.Get(oauthClient.RedirectURIs[0] + "?code")
+		if codeString == "" {
+			t.Errorf("No authorization code received")
+			t.FailNow()
+		}
+
+		config := &oauth2.Config{
+			ClientID:     oauthClient.ClientID,
+			ClientSecret: oauthClient.Secret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "http://" + ts.Address() + "/api/oauth/auth",
+				TokenURL: "http://" + ts.Address() + "/api/oauth/token",
+			},
+			RedirectURL: oauthClient.RedirectURIs[0],
+		}
+
+		accessToken, err := config.Exchange(oauth2.NoContext, codeString)
+		if err != nil {
+			t.Errorf("Error swapping code for token: %s", err)
+			t.FailNow()
+		}
+
+		// Validate ID token against the published key
+		idToken, ok := accessToken.Extra("id_token").(string)
+		if !ok || idToken == "" {
+			t.Errorf("No ID token received")
+			t.FailNow()
+		}
+
+		claims := jwtgo.MapClaims{}
+		_, err = jwtgo.ParseWithClaims(idToken, claims, func(token *jwtgo.Token) (interface{}, error) {
+			return oauthModule.idTokens.key.Public(), nil
+		})
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, user.GetExtID(), claims["sub"])
+		assert.EqualValues(t, oauthClient.ClientID, claims["aud"])
+		assert.EqualValues(t, v.Get("nonce"), claims["nonce"])
+		assert.EqualValues(t, []interface{}{"pwd"}, claims["amr"])
+		assert.NotNil(t, claims["auth_time"])
+		assert.NotNil(t, claims["at_hash"])
+
+		// Fetch user info using the access token
+		userInfo := UserInfo{}
+		resp, err = config.Client(oauth2.NoContext, accessToken).Get("http://" + ts.Address() + "/api/oauth/userinfo")
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseJson(resp, &userInfo))
+		assert.EqualValues(t, user.GetExtID(), userInfo.Subject)
+		assert.EqualValues(t, test.FakeEmail, userInfo.Email)
+		assert.EqualValues(t, "", userInfo.PreferredUsername)
+
+		// Fetch discovery document and key set
+		discovery := Discovery{}
+		resp, err = http.Get("http://" + ts.Address() + "/.well-known/openid-configuration")
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseJson(resp, &discovery))
+		assert.Contains(t, discovery.ResponseTypesSupported, "code id_token")
+
+		keys := JSONWebKeySet{}
+		resp, err = http.Get("http://" + ts.Address() + "/.well-known/jwks.json")
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseJson(resp, &keys))
+		assert.Len(t, keys.Keys, 1)
+	})
+
+	t.Run("OAuthAPI public clients require PKCE", func(t *testing.T) {
+		nativeRedirect := "com.example.app:/callback"
+
+		cr := ClientReq{
+			Name:      "test-native-client",
+			Scopes:    []string{"public.read"},
+			Redirects: []string{nativeRedirect, "http://127.0.0.1:9003/callback"},
+			Grants:    []string{"authorization_code"},
+			Responses: []string{"code"},
+			Public:    true,
+		}
+
+		nativeClient := ClientResp{}
+		resp, err := client.PostJSON("/oauth/clients", http.StatusOK, &cr)
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseJson(resp, &nativeClient))
+		assert.True(t, nativeClient.Public)
+
+		verifier := "ajsdkfhaksjdhf7834yriuwaehfrkuqw3y4r8qwhefjsdbf"
+		hash := sha256.Sum256([]byte(verifier))
+		challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+
+		v := url.Values{}
+		v.Set("response_type", "code")
+		v.Set("client_id", nativeClient.ClientID)
+		v.Set("redirect_uri", nativeRedirect)
+		v.Set("scope", "public.read")
+		v.Set("state", "csf3rjengkrasfdasbtjrd")
+
+		// Requests without a challenge, or with a plain challenge, are rejected
+		resp, err = client.GetWithParams("/oauth/auth", http.StatusFound, v)
+		assert.Nil(t, err)
+		assert.Contains(t, resp.Header.Get("Location"), "error=invalid_request")
+
+		v.Set("code_challenge", verifier)
+		v.Set("code_challenge_method", "plain")
+		resp, err = client.GetWithParams("/oauth/auth", http.StatusFound, v)
+		assert.Nil(t, err)
+		assert.Contains(t, resp.Header.Get("Location"), "error=invalid_request")
+
+		v.Set("code_challenge", challenge)
+		v.Set("code_challenge_method", "S256")
+		_, err = client.GetWithParams("/oauth/auth", http.StatusOK, v)
+		assert.Nil(t, err)
+
+		ac := AuthorizeConfirm{true, v.Get("state"), []string{"public.read"}}
+		resp, err = client.PostJSON("/oauth/auth", 302, &ac)
+		assert.Nil(t, err)
+
+		tokenValues, err := url.ParseQuery(resp.Header.Get("Location"))
+		assert.Nil(t, err)
+
+		codeString := tokenValues.Get(nativeRedirect + "?code")
+		if codeString == "" {
+			t.Errorf("No authorization code received")
+			t.FailNow()
+		}
+
+		config := &oauth2.Config{
+			ClientID: nativeClient.ClientID,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "http://" + ts.Address() + "/api/oauth/auth",
+				TokenURL: "http://" + ts.Address() + "/api/oauth/token",
+			},
+			RedirectURL: nativeRedirect,
+		}
+
+		// Exchange fails without the verifier
+		_, err = config.Exchange(oauth2.NoContext, codeString)
+		assert.NotNil(t, err)
+
+		// Exchange succeeds with the verifier
+		_, err = config.Exchange(oauth2.NoContext, codeString, oauth2.SetAuthURLParam("code_verifier", verifier))
+		assert.Nil(t, err)
+	})
+
 	//
 	t.Run("OAuthAPI Client Credentials grant", func(t *testing.T) {
 		v := url.Values{}
@@ -386,6 +617,163 @@ func TestOauthAPI(t *testing.T) {
 
 	})
 
+	var deviceClient ClientResp
+	var deviceToken string
+
+	t.Run("OAuthAPI Device Authorization grant", func(t *testing.T) {
+		cr := ClientReq{
+			Name:      "test-device-client",
+			Scopes:    []string{"public.read", "private.read"},
+			Grants:    []string{DeviceCodeGrantType},
+			Responses: []string{},
+			Public:    true,
+		}
+
+		resp, err := client.PostJSON("/oauth/clients", http.StatusOK, &cr)
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseJson(resp, &deviceClient))
+
+		// Request device and user codes
+		v := url.Values{}
+		v.Set("client_id", deviceClient.ClientID)
+		v.Set("scope", "public.read private.read")
+
+		device := DeviceAuthorization{}
+		resp, err = client.PostForm("/oauth/device", http.StatusOK, v)
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseJson(resp, &device))
+		assert.NotEmpty(t, device.DeviceCode)
+		assert.Len(t, device.UserCode, userCodeLength+1)
+
+		// Poll helper, returns the token or error response
+		poll := func(deviceCode string) (int, map[string]interface{}) {
+			v := url.Values{}
+			v.Set("grant_type", DeviceCodeGrantType)
+			v.Set("device_code", deviceCode)
+
+			req, _ := http.NewRequest("POST", "http://"+ts.Address()+"/api/oauth/token", strings.NewReader(v.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(deviceClient.ClientID, "")
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+
+			body := make(map[string]interface{})
+			assert.Nil(t, test.ParseJson(resp, &body))
+			return resp.StatusCode, body
+		}
+
+		// Pending requests must wait for the user, and back off when polled too frequently
+		_, body := poll(device.DeviceCode)
+		assert.EqualValues(t, "authorization_pending", body["error"])
+		_, body = poll(device.DeviceCode)
+		assert.EqualValues(t, "slow_down", body["error"])
+
+		// Users fetch and approve pending device requests by user code
+		deviceReq := DeviceRequest{}
+		err = client.GetJSONWithParams("/oauth/device/verify", http.StatusOK, url.Values{"user_code": {device.UserCode}}, &deviceReq)
+		assert.Nil(t, err)
+		assert.EqualValues(t, "test-device-client", deviceReq.Name)
+		assert.EqualValues(t, []string{"public.read", "private.read"}, deviceReq.Scopes)
+
+		dc := DeviceConfirm{strings.ToLower(device.UserCode), true, []string{"public.read"}}
+		_, err = client.PostJSON("/oauth/device/verify", http.StatusOK, &dc)
+		assert.Nil(t, err)
+
+		// Approved requests are no longer pending
+		err = client.GetAPIResponse("/oauth/device/verify?user_code="+device.UserCode, http.StatusBadRequest, api.OAuthNoDevicePending)
+		assert.Nil(t, err)
+
+		// Device codes are exchanged once for tokens with the approved scopes
+		status, body := poll(device.DeviceCode)
+		assert.EqualValues(t, http.StatusOK, status)
+		assert.NotEmpty(t, body["access_token"])
+		assert.EqualValues(t, "public.read", body["scope"])
+		deviceToken, _ = body["access_token"].(string)
+
+		_, body = poll(device.DeviceCode)
+		assert.EqualValues(t, "invalid_grant", body["error"])
+
+		// Denied requests return access_denied
+		resp, err = client.PostForm("/oauth/device", http.StatusOK, v)
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseJson(resp, &device))
+
+		dc = DeviceConfirm{device.UserCode, false, []string{}}
+		_, err = client.PostJSON("/oauth/device/verify", http.StatusOK, &dc)
+		assert.Nil(t, err)
+
+		_, body = poll(device.DeviceCode)
+		assert.EqualValues(t, "access_denied", body["error"])
+	})
+
+	t.Run("OAuthAPI clients can revoke tokens", func(t *testing.T) {
+		v := url.Values{}
+		v.Set("token", deviceToken)
+
+		req, _ := http.NewRequest("POST", "http://"+ts.Address()+"/api/oauth/revoke", strings.NewReader(v.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(deviceClient.ClientID, "")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+		// Revoked tokens are no longer found
+		req, _ = http.NewRequest("GET", "http://"+ts.Address()+"/api/oauth/info", nil)
+		req.Header.Set("Authorization", "Bearer "+deviceToken)
+
+		resp, err = http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.EqualValues(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Nil(t, test.ParseAndCheckAPIResponse(resp, api.OAuthNoTokenFound))
+	})
+
+	t.Run("OAuthAPI users can revoke grants", func(t *testing.T) {
+		sessions := UserSessions{}
+		err := client.GetJSON("/oauth/sessions", http.StatusOK, &sessions)
+		assert.Nil(t, err)
+		if len(sessions.AccessCodes) == 0 {
+			t.Errorf("No access token grants found")
+			t.FailNow()
+		}
+
+		grant := sessions.AccessCodes[0]
+		assert.EqualValues(t, GrantTypeAccessToken, grant.Type)
+		assert.NotEmpty(t, grant.ClientID)
+
+		v := url.Values{}
+		v.Set("type", grant.Type)
+		v.Set("id", grant.ID)
+
+		resp, err := client.PostForm("/oauth/sessions/revoke", http.StatusOK, v)
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseAndCheckAPIResponse(resp, api.OAuthGrantRevoked))
+		assert.EqualValues(t, events.OAuthClientDeauthorized, mockEventEmitter.Event.GetType())
+		assert.EqualValues(t, grant.ID, mockEventEmitter.Event.GetData()["GrantID"])
+
+		// Grants cannot be revoked twice
+		resp, err = client.PostForm("/oauth/sessions/revoke", http.StatusBadRequest, v)
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseAndCheckAPIResponse(resp, api.OAuthNoTokenFound))
+
+		// Revoking a client removes all grants issued to it
+		v = url.Values{}
+		v.Set("client_id", oauthClient.ClientID)
+
+		resp, err = client.PostForm("/oauth/clients/revoke", http.StatusOK, v)
+		assert.Nil(t, err)
+		assert.Nil(t, test.ParseAndCheckAPIResponse(resp, api.OAuthClientRevoked))
+		assert.EqualValues(t, oauthClient.ClientID, mockEventEmitter.Event.GetData()["ClientID"])
+
+		sessions = UserSessions{}
+		err = client.GetJSON("/oauth/sessions", http.StatusOK, &sessions)
+		assert.Nil(t, err)
+		for _, g := range append(append(sessions.AccessCodes, sessions.RefreshTokens...), sessions.AuthorizationCodes...) {
+			assert.NotEqual(t, oauthClient.ClientID, g.ClientID)
+		}
+	})
+
 	t.Run("OAuthAPI rejects invalid scopes", func(t *testing.T) {
 		config := &clientcredentials.Config{
 			ClientID:     oauthClient.ClientID,
@@ -15,6 +15,9 @@ import (
 // User OAuth user interface
 type User interface {
 	GetExtID() string
+	GetEmail() string
+	GetUsername() string
+	IsActivated() bool
 	IsAdmin() bool
 }
 
@@ -61,6 +64,19 @@ type SessionBase interface {
 type AuthorizeCodeSession interface {
 	SessionBase
 	GetCode() string
+	GetChallenge() string
+	GetChallengeMethod() string
+}
+
+// DeviceCodeSession is an OAuth Device Authorization Grant Session
+// This is pending until approved or denied by a user
+type DeviceCodeSession interface {
+	SessionBase
+	GetDeviceCode() string
+	GetUserCode() string
+	IsApproved() bool
+	IsDenied() bool
+	GetLastPolled() time.Time
 }
 
 // RefreshTokenSession is an OAuth Refresh Token Session
@@ -75,6 +91,31 @@ type AccessTokenSession interface {
 	GetSignature() string
 }
 
+// OpenIDSession is an OpenID Connect session bound to an authorization code
+type OpenIDSession interface {
+	GetClient() interface{}
+	GetRequestID() string
+	GetUserID() string
+
+	GetRequestedAt() time.Time
+	GetExpiresAt() time.Time
+
+	GetRequestedScopes() []string
+	GetGrantedScopes() []string
+
+	GetNonce() string
+	GetAuthTime() time.Time
+	GetAuthMethods() []string
+}
+
+// IDTokenSession is the login information used to issue OpenID Connect ID tokens
+type IDTokenSession interface {
+	GetSubject() string
+	GetNonce() string
+	GetAuthTime() time.Time
+	GetAuthMethods() []string
+}
+
 // UserSession is user data associated with an OAuth session
 type UserSession interface {
 	GetUserID() string
@@ -106,16 +147,28 @@ type Storer interface {
 	GetClientsByUserID(userID string) ([]interface{}, error)
 	UpdateClient(client interface{}) (interface{}, error)
 	RemoveClientByID(clientID string) error
+	RemoveClientSessionsByUserID(userID, clientID string) error
 
 	// OAuth User Session Storage
 
 	// Authorization code storage
-	AddAuthorizeCodeSession(userID, clientID, code, requestID string, requestedAt, expiresAt time.Time, scopes, grantedScopes []string) (interface{}, error)
+	AddAuthorizeCodeSession(userID, clientID, code, requestID string, requestedAt, expiresAt time.Time, scopes, grantedScopes []string,
+		challenge, challengeMethod string) (interface{}, error)
 	GetAuthorizeCodeSession(code string) (interface{}, error)
 	GetAuthorizeCodeSessionByRequestID(requestID string) (interface{}, error)
 	GetAuthorizeCodeSessionsByUserID(userID string) ([]interface{}, error)
 	RemoveAuthorizeCodeSession(code string) error
 
+	// Device code storage
+	AddDeviceCodeSession(clientID, deviceCode, userCode, requestID string, requestedAt, expiresAt time.Time, scopes []string) (interface{}, error)
+	GetDeviceCodeSession(deviceCode string) (interface{}, error)
+	GetDeviceCodeSessionByUserCode(userCode string) (interface{}, error)
+	ApproveDeviceCodeSession(userCode, userID string, grantedScopes []string) (interface{}, error)
+	DenyDeviceCodeSession(userCode string) error
+	UpdateDeviceCodeSessionPolled(deviceCode string, polled time.Time) error
+	RemoveDeviceCodeSession(deviceCode string) error
+	RemoveApprovedDeviceCodeSession(deviceCode string) (bool, error)
+
 	// Access Token storage
 	AddAccessTokenSession(userID, clientID, signature, requestID string, requestedAt, expiresAt time.Time,
 		scopes, grantedScopes []string) (interface{}, error)
@@ -124,6 +177,7 @@ type Storer interface {
 	GetAccessTokenSessionByRequestID(requestID string) (interface{}, error)
 	GetAccessTokenSessionsByUserID(userID string) ([]interface{}, error)
 	RemoveAccessTokenSession(token string) error
+	RemoveAccessTokenSessionsByRequestID(requestID string) error
 
 	// Refresh token storage
 	AddRefreshTokenSession(userID, clientID, signature, requestID string, requestedAt, expiresAt time.Time, scopes, grantedScopes []string) (interface{}, error)
@@ -131,4 +185,11 @@ type Storer interface {
 	GetRefreshTokenSessionByRequestID(requestID string) (interface{}, error)
 	GetRefreshTokenSessionsByUserID(userID string) ([]interface{}, error)
 	RemoveRefreshToken(signature string) error
+	RemoveRefreshTokenSessionsByRequestID(requestID string) error
+
+	// OpenID Connect session storage
+	AddOpenIDSession(userID, clientID, code, requestID string, requestedAt, expiresAt, authTime time.Time,
+		nonce string, authMethods, requestedScopes, grantedScopes []string) (interface{}, error)
+	GetOpenIDSession(code string) (interface{}, error)
+	RemoveOpenIDSession(code string) error
 }
@@ -21,7 +21,7 @@ func NoTestOauth(t *testing.T) {
 
 	config := config.DefaultOAuthConfig()
 
-	oauthModule := NewController(ts.DataStore, config)
+	oauthModule, err := NewController(ts.DataStore, config, &test.MockEventEmitter{})
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
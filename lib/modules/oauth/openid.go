@@ -0,0 +1,371 @@
+/*
+ * OAuth Module OpenID Connect Support
+ * This provides ID token signing, key loading and discovery documents for OpenID Connect
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/ory/fosite"
+)
+
+const (
+	// Size of generated RSA signing keys
+	signingKeyBits = 2048
+)
+
+// ErrInvalidToken indicates an access token is invalid or expired
+var ErrInvalidToken = errors.New("OAuth invalid or expired access token")
+
+// ErrInsufficientScope indicates an access token has not been granted a required scope
+var ErrInsufficientScope = errors.New("OAuth access token scope insufficient")
+
+// IDTokenStrategy signs OpenID Connect ID tokens
+// This implements the fosite OpenIDConnectTokenStrategy interface, with login information
+// taken from the IDTokenSession held by the request session
+type IDTokenStrategy struct {
+	issuer string
+	expiry time.Duration
+	key    crypto.Signer
+	method jwtgo.SigningMethod
+	keyID  string
+}
+
+// NewIDTokenStrategy creates an ID token strategy using the provided signing key
+// RSA keys are used with RS256, and ECDSA P-256 keys with ES256
+func NewIDTokenStrategy(issuer string, expiry time.Duration, key crypto.Signer) (*IDTokenStrategy, error) {
+	var method jwtgo.SigningMethod
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		method = jwtgo.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		// Token hashes (at_hash, c_hash) are calculated by fosite using SHA-256
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("OpenID signing key curve %s unsupported (P-256 required)", k.Curve.Params().Name)
+		}
+		method = jwtgo.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("OpenID signing key type %T unsupported", key)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(der)
+
+	return &IDTokenStrategy{
+		issuer: issuer,
+		expiry: expiry,
+		key:    key,
+		method: method,
+		keyID:  base64.RawURLEncoding.EncodeToString(hash[:16]),
+	}, nil
+}
+
+// GenerateIDToken generates a signed ID token for the provided request
+func (s *IDTokenStrategy) GenerateIDToken(ctx context.Context, requester fosite.Requester) (string, error) {
+	wrap, ok := requester.GetSession().(*SessionWrap)
+	if !ok {
+		return "", fmt.Errorf("Unsupported OpenID Connect session type: %T", requester.GetSession())
+	}
+	session, ok := wrap.UserSession.(IDTokenSession)
+	if !ok {
+		return "", fmt.Errorf("Unsupported OpenID Connect session type: %T", wrap.UserSession)
+	}
+	if session.GetSubject() == "" {
+		return "", errors.New("ID token subject required")
+	}
+
+	now := time.Now()
+
+	claims := jwtgo.MapClaims{
+		"iss": s.issuer,
+		"sub": session.GetSubject(),
+		"aud": requester.GetClient().GetID(),
+		"iat": now.Unix(),
+		"exp": now.Add(s.expiry).Unix(),
+	}
+
+	if authTime := session.GetAuthTime(); !authTime.IsZero() {
+		claims["auth_time"] = authTime.Unix()
+	}
+	if nonce := session.GetNonce(); nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if methods := session.GetAuthMethods(); len(methods) > 0 {
+		claims["amr"] = methods
+	}
+
+	// Token hashes are populated by fosite when ID tokens are issued alongside codes or access tokens
+	hashes := wrap.IDTokenClaims()
+	if hashes.AccessTokenHash != "" {
+		claims["at_hash"] = hashes.AccessTokenHash
+	}
+	if hashes.CodeHash != "" {
+		claims["c_hash"] = hashes.CodeHash
+	}
+
+	token := jwtgo.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.keyID
+
+	return token.SignedString(s.key)
+}
+
+// Algorithm fetches the JWS algorithm used to sign ID tokens
+func (s *IDTokenStrategy) Algorithm() string {
+	return s.method.Alg()
+}
+
+// JSONWebKey is a public JSON Web Key (RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// ECDSA public key parameters
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a JSON Web Key Set (RFC 7517)
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeySet fetches the public key set used to validate ID tokens
+func (s *IDTokenStrategy) KeySet() *JSONWebKeySet {
+	key := JSONWebKey{
+		KeyID:     s.keyID,
+		Use:       "sig",
+		Algorithm: s.method.Alg(),
+	}
+
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		key.KeyType = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.KeyType = "EC"
+		key.Curve = pub.Curve.Params().Name
+		key.X = base64.RawURLEncoding.EncodeToString(padBytes(pub.X.Bytes(), size))
+		key.Y = base64.RawURLEncoding.EncodeToString(padBytes(pub.Y.Bytes(), size))
+	}
+
+	return &JSONWebKeySet{Keys: []JSONWebKey{key}}
+}
+
+// padBytes left pads a big endian value to the provided length
+func padBytes(data []byte, length int) []byte {
+	if len(data) >= length {
+		return data
+	}
+	padded := make([]byte, length)
+	copy(padded[length-len(data):], data)
+	return padded
+}
+
+// LoadSigningKey loads a PEM encoded RSA or ECDSA private key from the provided file
+// If no file is provided an RSA key is generated, so ID tokens will not validate following a restart
+func LoadSigningKey(file string) (crypto.Signer, error) {
+	if file == "" {
+		log.Printf("OAuth: no OpenID signing key configured, generating ephemeral key")
+		return rsa.GenerateKey(rand.Reader, signingKeyBits)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in signing key file %s", file)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case *ecdsa.PrivateKey:
+			return k, nil
+		}
+		return nil, fmt.Errorf("Unsupported signing key type %T in %s", key, file)
+	}
+
+	return nil, fmt.Errorf("Unsupported PEM block type %s in signing key file %s", block.Type, file)
+}
+
+// Discovery is an OpenID Connect provider configuration document
+type Discovery struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	UserInfoEndpoint                 string   `json:"userinfo_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IntrospectionEndpoint            string   `json:"introspection_endpoint"`
+	RevocationEndpoint               string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint      string   `json:"device_authorization_endpoint"`
+	ScopesSupported                  []string `json:"scopes_supported"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	ResponseModesSupported           []string `json:"response_modes_supported"`
+	GrantTypesSupported              []string `json:"grant_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethods         []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+// Response type combinations supported by the OAuth and OpenID Connect handlers
+var responseTypeCombinations = []string{"code", "token", "id_token", "id_token token", "code id_token", "code token", "code id_token token"}
+
+// GetDiscovery builds the OpenID Connect discovery document
+func (oc *Controller) GetDiscovery() *Discovery {
+	issuer := strings.TrimSuffix(oc.config.Issuer, "/")
+
+	// Only advertise combinations of enabled response types
+	responseTypes := make([]string, 0)
+	for _, combination := range responseTypeCombinations {
+		supported := true
+		for _, t := range strings.Split(combination, " ") {
+			if !arrayContains(oc.config.AllowedResponses, t) {
+				supported = false
+			}
+		}
+		if supported {
+			responseTypes = append(responseTypes, combination)
+		}
+	}
+
+	// Scopes and grants are the union of those available to admins and users
+	scopes := append([]string{}, oc.config.AllowedScopes.User...)
+	for _, s := range oc.config.AllowedScopes.Admin {
+		if !arrayContains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	grants := append([]string{}, oc.config.AllowedGrants.User...)
+	for _, g := range oc.config.AllowedGrants.Admin {
+		if !arrayContains(grants, g) {
+			grants = append(grants, g)
+		}
+	}
+
+	return &Discovery{
+		Issuer:                           issuer,
+		AuthorizationEndpoint:            issuer + "/api/oauth/auth",
+		TokenEndpoint:                    issuer + "/api/oauth/token",
+		UserInfoEndpoint:                 issuer + "/api/oauth/userinfo",
+		JWKSURI:                          issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:            issuer + "/api/oauth/introspect",
+		RevocationEndpoint:               issuer + "/api/oauth/revoke",
+		DeviceAuthorizationEndpoint:      issuer + "/api/oauth/device",
+		ScopesSupported:                  scopes,
+		ResponseTypesSupported:           responseTypes,
+		ResponseModesSupported:           []string{"query", "fragment"},
+		GrantTypesSupported:              grants,
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{oc.idTokens.Algorithm()},
+		TokenEndpointAuthMethods:         []string{"client_secret_basic", "client_secret_post"},
+		ClaimsSupported:                  []string{"iss", "sub", "aud", "iat", "exp", "auth_time", "nonce", "amr", "at_hash", "c_hash", "email", "email_verified", "preferred_username"},
+	}
+}
+
+// GetKeySet fetches the public key set used to validate ID tokens
+func (oc *Controller) GetKeySet() *JSONWebKeySet {
+	return oc.idTokens.KeySet()
+}
+
+// UserInfo is an OpenID Connect UserInfo response
+// Email and profile claims are only included when the associated scopes have been granted
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// GetUserInfo fetches user information for the provided access token signature
+// The access token must be valid and have been granted the openid scope
+func (oc *Controller) GetUserInfo(signature string) (*UserInfo, error) {
+	a, err := oc.store.GetAccessTokenSession(signature)
+	if err != nil {
+		log.Printf("OAuthController.GetUserInfo error fetching token session: %s", err)
+		return nil, ErrInternal
+	}
+	if a == nil {
+		return nil, ErrInvalidToken
+	}
+	access := a.(AccessTokenSession)
+
+	if time.Now().After(access.GetExpiresAt()) {
+		return nil, ErrInvalidToken
+	}
+
+	scopes := fosite.Arguments(access.GetGrantedScopes())
+	if !scopes.Has("openid") {
+		return nil, ErrInsufficientScope
+	}
+
+	u, err := oc.store.GetUserByExtID(access.GetUserID())
+	if err != nil {
+		log.Printf("OAuthController.GetUserInfo error fetching user: %s", err)
+		return nil, ErrInternal
+	}
+	if u == nil {
+		return nil, ErrInvalidToken
+	}
+	user := u.(User)
+
+	info := UserInfo{
+		Subject: user.GetExtID(),
+	}
+	if scopes.Has("email") {
+		verified := user.IsActivated()
+		info.Email = user.GetEmail()
+		info.EmailVerified = &verified
+	}
+	if scopes.Has("profile") {
+		info.PreferredUsername = user.GetUsername()
+	}
+
+	return &info, nil
+}
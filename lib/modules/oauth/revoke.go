@@ -0,0 +1,119 @@
+/*
+ * OAuth Module Revocation
+ * Allows users to revoke grants issued to OAuth clients on their behalf
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package oauth
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+// Grant types listed in UserSessions, used to select grants for revocation
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeAccessToken       = "access_token"
+	GrantTypeRefreshToken      = "refresh_token"
+)
+
+// ErrGrantNotFound indicates a grant does not exist or is not owned by the requesting user
+var ErrGrantNotFound = errors.New("OAuth grant not found")
+
+// RevokeGrant revokes a single grant listed in a user's sessions
+// Access token grants revoke all access tokens issued for the request, refresh token grants
+// revoke the refresh token chain and associated access tokens, and authorization code grants
+// revoke the code prior to exchange
+func (oc *Controller) RevokeGrant(userID, grantType, requestID string, meta map[string]string) error {
+	if requestID == "" {
+		return ErrGrantNotFound
+	}
+
+	var s interface{}
+	var err error
+
+	switch grantType {
+	case GrantTypeAuthorizationCode:
+		s, err = oc.store.GetAuthorizeCodeSessionByRequestID(requestID)
+	case GrantTypeAccessToken:
+		s, err = oc.store.GetAccessTokenSessionByRequestID(requestID)
+	case GrantTypeRefreshToken:
+		s, err = oc.store.GetRefreshTokenSessionByRequestID(requestID)
+	default:
+		return ErrGrantNotFound
+	}
+	if err != nil {
+		log.Printf("OAuthController.RevokeGrant error fetching %s grant %s: %s", grantType, requestID, err)
+		return ErrInternal
+	}
+	if s == nil {
+		return ErrGrantNotFound
+	}
+
+	session := s.(SessionBase)
+	if session.GetUserID() != userID {
+		return ErrGrantNotFound
+	}
+
+	switch grantType {
+	case GrantTypeAuthorizationCode:
+		code := s.(AuthorizeCodeSession).GetCode()
+		err = oc.store.RemoveAuthorizeCodeSession(code)
+		if err == nil {
+			err = oc.store.RemoveOpenIDSession(code)
+		}
+	case GrantTypeAccessToken:
+		err = oc.store.RemoveAccessTokenSessionsByRequestID(requestID)
+	case GrantTypeRefreshToken:
+		err = oc.store.RemoveRefreshTokenSessionsByRequestID(requestID)
+		if err == nil {
+			err = oc.store.RemoveAccessTokenSessionsByRequestID(requestID)
+		}
+	}
+	if err != nil {
+		log.Printf("OAuthController.RevokeGrant error revoking %s grant %s: %s", grantType, requestID, err)
+		return ErrInternal
+	}
+
+	data := events.NewDataWithMeta(meta)
+	data["ClientID"] = session.GetClient().(Client).GetID()
+	data["GrantType"] = grantType
+	data["GrantID"] = requestID
+	oc.emitter.SendEvent(events.NewEvent(userID, events.OAuthClientDeauthorized, data))
+
+	log.Printf("OAuthController.RevokeGrant revoked %s grant %s for user %s", grantType, requestID, userID)
+
+	return nil
+}
+
+// RevokeClient revokes all grants issued to a client on behalf of a user
+func (oc *Controller) RevokeClient(userID, clientID string, meta map[string]string) error {
+	c, err := oc.store.GetClientByID(clientID)
+	if err != nil {
+		log.Printf("OAuthController.RevokeClient error fetching client %s: %s", clientID, err)
+		return ErrInternal
+	}
+	if c == nil {
+		return ErrGrantNotFound
+	}
+
+	err = oc.store.RemoveClientSessionsByUserID(userID, clientID)
+	if err != nil {
+		log.Printf("OAuthController.RevokeClient error revoking client %s for user %s: %s", clientID, userID, err)
+		return ErrInternal
+	}
+
+	data := events.NewDataWithMeta(meta)
+	data["ClientID"] = clientID
+	oc.emitter.SendEvent(events.NewEvent(userID, events.OAuthClientDeauthorized, data))
+
+	log.Printf("OAuthController.RevokeClient revoked client %s for user %s", clientID, userID)
+
+	return nil
+}
@@ -24,6 +24,9 @@ type Session struct {
 	RefreshExpiry   time.Time
 	AuthorizeExpiry time.Time
 	IDExpiry        time.Time
+	Nonce           string
+	AuthTime        time.Time
+	AuthMethods     []string
 }
 
 // NewSession creates a new default session instance for a given user
@@ -49,6 +52,9 @@ func (s *Session) SetAuthorizeExpiry(t time.Time) { s.AuthorizeExpiry = t }
 func (s *Session) GetAuthorizeExpiry() time.Time  { return s.AuthorizeExpiry }
 func (s *Session) SetIDExpiry(t time.Time)        { s.IDExpiry = t }
 func (s *Session) GetIDExpiry() time.Time         { return s.IDExpiry }
+func (s *Session) GetNonce() string               { return s.Nonce }
+func (s *Session) GetAuthTime() time.Time         { return s.AuthTime }
+func (s *Session) GetAuthMethods() []string       { return s.AuthMethods }
 
 func (s *Session) Clone() interface{} {
 	clone := Session{}
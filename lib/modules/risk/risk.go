@@ -0,0 +1,278 @@
+/*
+ * Login Risk Module Controller
+ * This defines the login risk module controller
+ * The login risk module scores logins using a set of rules defined in the configuration file, and
+ * maps the score to an action (allowing, requiring a second factor, email confirmation, or blocking the login).
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package risk
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+// Signals that may be evaluated by risk rules
+const (
+	SignalNewDevice    = "new-device"
+	SignalFailedLogins = "failed-logins"
+	SignalIPList       = "ip-list"
+	SignalTimeOfDay    = "time-of-day"
+	SignalAccountAge   = "account-age"
+)
+
+// signal evaluates a risk signal for a user login
+type signal func(user User, meta map[string]string) (bool, error)
+
+// rule is a compiled risk rule
+type rule struct {
+	name  string
+	score int
+	match signal
+}
+
+// Controller Login risk controller instance
+type Controller struct {
+	rules      []rule
+	thresholds config.RiskThresholdConfig
+	store      Storer
+	devices    DeviceChecker
+	emitter    events.Emitter
+}
+
+// NewController creates a new login risk controller, compiling the provided policy
+// Devices may be nil if no new-device rules are configured
+func NewController(c config.RiskConfig, store Storer, devices DeviceChecker, emitter events.Emitter) (*Controller, error) {
+	rc := Controller{
+		rules:      make([]rule, 0),
+		thresholds: c.Thresholds,
+		store:      store,
+		devices:    devices,
+		emitter:    emitter,
+	}
+
+	// Load IP lists
+	lists := make(map[string][]*net.IPNet)
+	for name, lc := range c.IPLists {
+		networks, err := loadIPList(lc)
+		if err != nil {
+			return nil, fmt.Errorf("risk: error loading ip list %s (%s)", name, err)
+		}
+		lists[name] = networks
+	}
+
+	// Compile rules
+	for _, rcfg := range c.Rules {
+		match, err := rc.compileRule(rcfg, lists)
+		if err != nil {
+			return nil, fmt.Errorf("risk: invalid rule %s (%s)", rcfg.Name, err)
+		}
+		rc.rules = append(rc.rules, rule{name: rcfg.Name, score: rcfg.Score, match: match})
+	}
+
+	return &rc, nil
+}
+
+// compileRule builds the signal function for a rule configuration
+func (rc *Controller) compileRule(c config.RiskRuleConfig, lists map[string][]*net.IPNet) (signal, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("rule name required")
+	}
+
+	switch c.Signal {
+	case SignalNewDevice:
+		if rc.devices == nil {
+			return nil, fmt.Errorf("no device checker available")
+		}
+		return func(user User, meta map[string]string) (bool, error) {
+			return rc.devices.IsNewDevice(user.GetExtID(), meta)
+		}, nil
+
+	case SignalFailedLogins:
+		if c.Count == 0 || c.Window == 0 {
+			return nil, fmt.Errorf("count and window required")
+		}
+		return func(user User, meta map[string]string) (bool, error) {
+			count, err := rc.store.CountAuditEvents(user.GetExtID(), events.LoginFailure, time.Now().Add(-c.Window))
+			return count >= c.Count, err
+		}, nil
+
+	case SignalIPList:
+		networks, ok := lists[c.List]
+		if !ok {
+			return nil, fmt.Errorf("unknown ip list '%s'", c.List)
+		}
+		return func(user User, meta map[string]string) (bool, error) {
+			ip := net.ParseIP(meta["remote-address"])
+			if ip == nil {
+				return false, nil
+			}
+			for _, n := range networks {
+				if n.Contains(ip) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}, nil
+
+	case SignalTimeOfDay:
+		if c.StartHour < 0 || c.StartHour > 23 || c.EndHour < 0 || c.EndHour > 23 {
+			return nil, fmt.Errorf("start and end hours must be between 0 and 23")
+		}
+		location := time.UTC
+		if c.Timezone != "" {
+			l, err := time.LoadLocation(c.Timezone)
+			if err != nil {
+				return nil, err
+			}
+			location = l
+		}
+		return func(user User, meta map[string]string) (bool, error) {
+			return inHours(time.Now().In(location).Hour(), c.StartHour, c.EndHour), nil
+		}, nil
+
+	case SignalAccountAge:
+		if c.MaxAge == 0 {
+			return nil, fmt.Errorf("max-age required")
+		}
+		return func(user User, meta map[string]string) (bool, error) {
+			return time.Since(user.GetCreatedAt()) < c.MaxAge, nil
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown signal '%s'", c.Signal)
+	}
+}
+
+// Evaluate scores a login, returning the total score and the names of matching rules
+func (rc *Controller) Evaluate(user User, meta map[string]string) (int, []string, error) {
+	score := 0
+	matched := make([]string, 0)
+
+	for _, r := range rc.rules {
+		ok, err := r.match(user, meta)
+		if err != nil {
+			log.Printf("RiskModule.Evaluate error evaluating rule %s for user %s (%s)", r.name, user.GetExtID(), err)
+			return 0, nil, err
+		}
+		if ok {
+			score += r.score
+			matched = append(matched, r.name)
+		}
+	}
+
+	return score, matched, nil
+}
+
+// Action maps a risk score to the most severe action with a threshold met by the score
+func (rc *Controller) Action(score int) api.RiskAction {
+	action := api.RiskActionAllow
+
+	thresholds := map[api.RiskAction]int{
+		api.RiskActionSecondFactor:      rc.thresholds.SecondFactor,
+		api.RiskActionEmailConfirmation: rc.thresholds.EmailConfirmation,
+		api.RiskActionBlock:             rc.thresholds.Block,
+	}
+
+	for a, threshold := range thresholds {
+		if threshold > 0 && score >= threshold && a.Exceeds(action) {
+			action = a
+		}
+	}
+
+	return action
+}
+
+// CheckLoginRisk evaluates the risk policy for a login
+// Each decision is emitted as a LoginRiskDecision event (and thus recorded in the audit log) with
+// the score and matching rules.
+func (rc *Controller) CheckLoginRisk(u interface{}, meta map[string]string) (api.RiskAction, string, error) {
+	user, ok := u.(User)
+	if !ok {
+		return api.RiskActionAllow, "", nil
+	}
+
+	score, matched, err := rc.Evaluate(user, meta)
+	if err != nil {
+		return api.RiskActionAllow, "", err
+	}
+
+	action := rc.Action(score)
+	reason := fmt.Sprintf("score %d (%s)", score, strings.Join(matched, ", "))
+
+	log.Printf("RiskModule.CheckLoginRisk user %s: %s %s", user.GetExtID(), action, reason)
+
+	data := events.NewDataWithMeta(meta)
+	data["Score"] = strconv.Itoa(score)
+	data["Action"] = string(action)
+	data["Rules"] = strings.Join(matched, ",")
+	rc.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.LoginRiskDecision, data))
+
+	return action, reason, nil
+}
+
+// inHours checks whether an hour is within a [start, end) range, wrapping at midnight
+func inHours(hour, start, end int) bool {
+	if start <= end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// loadIPList parses the networks in an IP list configuration
+// Single addresses are treated as host networks
+func loadIPList(c config.IPListConfig) ([]*net.IPNet, error) {
+	entries := append([]string{}, c.Networks...)
+
+	if c.File != "" {
+		f, err := os.Open(c.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			if ip := net.ParseIP(e); ip != nil && ip.To4() != nil {
+				e += "/32"
+			} else {
+				e += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
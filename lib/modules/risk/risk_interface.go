@@ -0,0 +1,32 @@
+/*
+ * Login Risk Module interfaces
+ * This defines the interfaces required to use the login risk module
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package risk
+
+import (
+	"time"
+)
+
+// User interface type
+// Login hook user objects must implement this interface
+type User interface {
+	GetExtID() string
+	GetCreatedAt() time.Time
+}
+
+// DeviceChecker interface for checking whether a login is from a new device
+type DeviceChecker interface {
+	IsNewDevice(userid string, meta map[string]string) (bool, error)
+}
+
+// Storer Audit event store interface
+// This must be implemented by a storage module to provide failed login counts to the module
+type Storer interface {
+	// Count the audit events of a given type for a user since the provided time
+	CountAuditEvents(userid, eventType string, since time.Time) (uint, error)
+}
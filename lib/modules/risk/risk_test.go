@@ -0,0 +1,179 @@
+/*
+ * Login Risk Module tests
+ * This defines login risk module tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package risk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/events"
+	"github.com/authplz/authplz-core/lib/test"
+)
+
+// MockDeviceChecker mock device checker for testing
+type MockDeviceChecker struct {
+	NewDevice bool
+}
+
+func (mdc *MockDeviceChecker) IsNewDevice(userid string, meta map[string]string) (bool, error) {
+	return mdc.NewDevice, nil
+}
+
+func TestRiskModule(t *testing.T) {
+	var fakeEmail = "test@abc.com"
+	var fakePass = "abcDEF123@abcDEF123@"
+	var fakeName = "user.sdfsfdF"
+
+	c, _ := config.DefaultConfig()
+
+	// Attempt database connection
+	dataStore, err := datastore.NewDataStore(c.Database)
+	if err != nil {
+		t.Error("Error opening database")
+		t.FailNow()
+	}
+
+	// Force synchronization
+	dataStore.ForceSync()
+
+	// Create user for tests
+	u, err := dataStore.AddUser(fakeEmail, fakeName, fakePass)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user := u.(*datastore.User)
+
+	mockEventEmitter := test.MockEventEmitter{}
+	mockDevices := MockDeviceChecker{}
+
+	policy := config.RiskConfig{
+		Enabled: true,
+		IPLists: map[string]config.IPListConfig{
+			"blocked": {Networks: []string{"192.0.2.0/24", "198.51.100.7"}},
+		},
+		Rules: []config.RiskRuleConfig{
+			{Name: "new-device", Signal: SignalNewDevice, Score: 30},
+			{Name: "failed-logins", Signal: SignalFailedLogins, Score: 40, Count: 3, Window: time.Hour},
+			{Name: "blocked-ip", Signal: SignalIPList, Score: 100, List: "blocked"},
+			{Name: "new-account", Signal: SignalAccountAge, Score: 10, MaxAge: time.Hour},
+		},
+		Thresholds: config.RiskThresholdConfig{
+			SecondFactor:      30,
+			EmailConfirmation: 60,
+			Block:             100,
+		},
+	}
+
+	riskModule, err := NewController(policy, dataStore, &mockDevices, &mockEventEmitter)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	meta := map[string]string{"remote-address": "10.0.0.1", "user-agent": "test-agent"}
+
+	t.Run("Invalid policies are rejected", func(t *testing.T) {
+		invalid := []config.RiskRuleConfig{
+			{Name: "unknown", Signal: "not-a-signal"},
+			{Name: "unknown-list", Signal: SignalIPList, List: "not-a-list"},
+			{Name: "no-window", Signal: SignalFailedLogins, Count: 3},
+			{Name: "bad-hours", Signal: SignalTimeOfDay, StartHour: 25},
+		}
+		for _, r := range invalid {
+			_, err := NewController(config.RiskConfig{Rules: []config.RiskRuleConfig{r}}, dataStore, &mockDevices, &mockEventEmitter)
+			if err == nil {
+				t.Errorf("Expected error for invalid rule %s", r.Name)
+			}
+		}
+
+		_, err := NewController(config.RiskConfig{IPLists: map[string]config.IPListConfig{"bad": {Networks: []string{"not-an-ip"}}}},
+			dataStore, &mockDevices, &mockEventEmitter)
+		if err == nil {
+			t.Errorf("Expected error for invalid ip list")
+		}
+	})
+
+	t.Run("Logins below thresholds are allowed", func(t *testing.T) {
+		action, _, err := riskModule.CheckLoginRisk(user, meta)
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionAllow {
+			t.Errorf("Expected login to be allowed, received %s", action)
+		}
+	})
+
+	t.Run("Decisions are emitted with scores and rules", func(t *testing.T) {
+		mockDevices.NewDevice = true
+		defer func() { mockDevices.NewDevice = false }()
+
+		action, _, err := riskModule.CheckLoginRisk(user, meta)
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionSecondFactor {
+			t.Errorf("Expected second factor for new device, received %s", action)
+		}
+
+		if mockEventEmitter.Event == nil || mockEventEmitter.Event.GetType() != events.LoginRiskDecision {
+			t.Errorf("Expected login risk decision event")
+			t.FailNow()
+		}
+		data := mockEventEmitter.Event.GetData()
+		if data["Score"] != "40" || data["Rules"] != "new-device,new-account" || data["user-agent"] != "test-agent" {
+			t.Errorf("Unexpected decision event data %+v", data)
+		}
+	})
+
+	t.Run("Failed login velocity requires email confirmation", func(t *testing.T) {
+		mockDevices.NewDevice = true
+		defer func() { mockDevices.NewDevice = false }()
+
+		for i := 0; i < 3; i++ {
+			_, err := dataStore.AddAuditEvent(user.GetExtID(), "", events.LoginFailure, time.Now(), nil)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+		}
+
+		action, _, err := riskModule.CheckLoginRisk(user, meta)
+		if err != nil {
+			t.Error(err)
+		}
+		if action != api.RiskActionEmailConfirmation {
+			t.Errorf("Expected email confirmation, received %s", action)
+		}
+	})
+
+	t.Run("Listed addresses are blocked", func(t *testing.T) {
+		for _, addr := range []string{"192.0.2.44", "198.51.100.7"} {
+			action, _, err := riskModule.CheckLoginRisk(user, map[string]string{"remote-address": addr})
+			if err != nil {
+				t.Error(err)
+			}
+			if action != api.RiskActionBlock {
+				t.Errorf("Expected block for listed address %s, received %s", addr, action)
+			}
+		}
+	})
+
+	t.Run("Time of day ranges wrap at midnight", func(t *testing.T) {
+		if !inHours(23, 22, 6) || !inHours(2, 22, 6) || inHours(12, 22, 6) {
+			t.Errorf("Unexpected wrapped time of day match")
+		}
+		if !inHours(9, 9, 17) || inHours(17, 9, 17) {
+			t.Errorf("Unexpected time of day match")
+		}
+	})
+}
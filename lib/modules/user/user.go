@@ -16,6 +16,8 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+	"github.com/authplz/authplz-core/lib/config"
 	"github.com/authplz/authplz-core/lib/events"
 )
 
@@ -35,15 +37,24 @@ type Controller struct {
 	passwordLen int
 	hashRounds  int
 	zxcvbnScore int
+	lockout     config.LockoutConfig
 }
 
 // NewController Create a new user controller
-func NewController(userStore Storer, emitter events.Emitter) *Controller {
-	return &Controller{userStore, emitter, MinPasswordLength, HashRounds, MinZxcvbnScore}
+func NewController(userStore Storer, lockout config.LockoutConfig, emitter events.Emitter) *Controller {
+	return &Controller{userStore, emitter, MinPasswordLength, HashRounds, MinZxcvbnScore, lockout}
+}
+
+// transaction runs the provided function with a store and emitter scoped to a single transaction where
+// supported by the emitter, so that account changes are committed with the events they cause
+func (userModule *Controller) transaction(fn func(store Storer, emitter events.Emitter) error) error {
+	return events.Transaction(userModule.emitter, userModule.userStore, func(store interface{}, emitter events.Emitter) error {
+		return fn(store.(Storer), emitter)
+	})
 }
 
 // Create a new user account
-func (userModule *Controller) Create(email, username, pass string) (user User, err error) {
+func (userModule *Controller) Create(email, username, pass string, meta map[string]string) (user User, err error) {
 
 	// Generate password hash
 	hash, hashErr := bcrypt.GenerateFromPassword([]byte(pass), userModule.hashRounds)
@@ -88,27 +99,32 @@ func (userModule *Controller) Create(email, username, pass string) (user User, e
 		return nil, ErrorDuplicateAccount
 	}
 
-	// Add user to database (disabled)
-	u, err = userModule.userStore.AddUser(email, username, string(hash))
+	// Add user to database (disabled) and emit user creation event
+	err = userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		u, err := store.AddUser(email, username, string(hash))
+		if err != nil {
+			return err
+		}
+		user = u.(User)
+
+		data := events.NewDataWithMeta(meta)
+		emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountCreated, data))
+
+		return nil
+	})
 	if err != nil {
 		// Userstore error, wrap
 		log.Println(err)
 		return nil, ErrorCreatingUser
 	}
 
-	user = u.(User)
-
-	// Emit user creation event
-	data := make(map[string]string)
-	userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountCreated, data))
-
 	log.Printf("UserModule.Create: User %s created\r\n", user.GetExtID())
 
 	return user, nil
 }
 
 // Activate activates the provided user account
-func (userModule *Controller) Activate(email string) (user User, err error) {
+func (userModule *Controller) Activate(email string, meta map[string]string) (user User, err error) {
 
 	// Fetch user account
 	u, err := userModule.userStore.GetUserByEmail(email)
@@ -122,26 +138,33 @@ func (userModule *Controller) Activate(email string) (user User, err error) {
 
 	user.SetActivated(true)
 
-	u, err = userModule.userStore.UpdateUser(user)
+	// Update user and emit user activation event
+	err = userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		u, err := store.UpdateUser(user)
+		if err != nil {
+			return err
+		}
+		user = u.(User)
+
+		data := events.NewDataWithMeta(meta)
+		emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountActivated, data))
+
+		return nil
+	})
 	if err != nil {
 		// Userstore error, wrap
 		fmt.Println(err)
 		return nil, errLogin
 	}
 
-	user = u.(User)
-
-	// Emit user activation event
-	data := make(map[string]string)
-	userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountActivated, data))
-
 	log.Printf("UserModule.Activate: User %s account activated\r\n", user.GetExtID())
 
 	return user, nil
 }
 
-// Unlock unlocks the provided user account
-func (userModule *Controller) Lock(email string) (user User, err error) {
+// Lock locks the provided user account
+// Manual locks do not expire, and must be removed with an unlock token or by an administrator
+func (userModule *Controller) Lock(email string, meta map[string]string) (user User, err error) {
 
 	// Fetch user account
 	u, err := userModule.userStore.GetUserByEmail(email)
@@ -150,31 +173,41 @@ func (userModule *Controller) Lock(email string) (user User, err error) {
 		log.Println(err)
 		return nil, errLogin
 	}
+	if u == nil {
+		return nil, ErrorUserNotFound
+	}
 
 	user = u.(User)
 
 	user.SetLocked(true)
+	user.SetLockedUntil(time.Time{})
+
+	// Update user and emit user lock event
+	err = userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		u, err := store.UpdateUser(user)
+		if err != nil {
+			return err
+		}
+		user = u.(User)
 
-	u, err = userModule.userStore.UpdateUser(user)
+		data := events.NewDataWithMeta(meta)
+		emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountLocked, data))
+
+		return nil
+	})
 	if err != nil {
 		// Userstore error, wrap
 		log.Println(err)
 		return nil, errLogin
 	}
 
-	user = u.(User)
-
-	// Emit user unlock event
-	data := make(map[string]string)
-	userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountLocked, data))
-
-	log.Printf("UserModule.Unlock: User %s account locked\r\n", user.GetExtID())
+	log.Printf("UserModule.Lock: User %s account locked\r\n", user.GetExtID())
 
 	return user, nil
 }
 
 // Unlock unlocks the provided user account
-func (userModule *Controller) Unlock(email string) (user User, err error) {
+func (userModule *Controller) Unlock(email string, meta map[string]string) (user User, err error) {
 
 	// Fetch user account
 	u, err := userModule.userStore.GetUserByEmail(email)
@@ -183,31 +216,149 @@ func (userModule *Controller) Unlock(email string) (user User, err error) {
 		log.Println(err)
 		return nil, errLogin
 	}
+	if u == nil {
+		return nil, ErrorUserNotFound
+	}
 
 	user = u.(User)
 
+	// Unlocking proves account ownership, so the lockout back-off is also reset
 	user.SetLocked(false)
+	user.SetLockedUntil(time.Time{})
+	user.SetLockoutCount(0)
+	user.ClearLoginRetries()
+
+	// Update user and emit user unlock event
+	err = userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		u, err := store.UpdateUser(user)
+		if err != nil {
+			return err
+		}
+		user = u.(User)
+
+		data := events.NewDataWithMeta(meta)
+		emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountUnlocked, data))
 
-	u, err = userModule.userStore.UpdateUser(user)
+		return nil
+	})
 	if err != nil {
 		// Userstore error, wrap
 		log.Println(err)
 		return nil, errLogin
 	}
 
-	user = u.(User)
-
-	// Emit user unlock event
-	data := make(map[string]string)
-	userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountUnlocked, data))
-
 	log.Printf("UserModule.Unlock: User %s account unlocked\r\n", user.GetExtID())
 
 	return user, nil
 }
 
+// AdminLock locks a user account on behalf of an administrator and revokes any active sessions
+func (userModule *Controller) AdminLock(adminID, email string, meta map[string]string) error {
+	err := userModule.checkAdmin(adminID)
+	if err != nil {
+		return err
+	}
+
+	user, err := userModule.Lock(email, meta)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("UserModule.AdminLock: User %s account locked by admin %s\r\n", user.GetExtID(), adminID)
+
+	return userModule.RevokeSessions(user.GetExtID(), "", meta)
+}
+
+// AdminUnlock unlocks a user account on behalf of an administrator
+// This overrides both manual and time based lockouts
+func (userModule *Controller) AdminUnlock(adminID, email string, meta map[string]string) error {
+	err := userModule.checkAdmin(adminID)
+	if err != nil {
+		return err
+	}
+
+	user, err := userModule.Unlock(email, meta)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("UserModule.AdminUnlock: User %s account unlocked by admin %s\r\n", user.GetExtID(), adminID)
+
+	return nil
+}
+
+// checkAdmin checks the provided user is an administrator
+func (userModule *Controller) checkAdmin(adminID string) error {
+	a, err := userModule.userStore.GetUserByExtID(adminID)
+	if err != nil {
+		log.Println(err)
+		return ErrorFindingUser
+	}
+	if a == nil || !a.(User).IsAdmin() {
+		return ErrorUnauthorized
+	}
+	return nil
+}
+
+// isLockActive checks whether a user account is currently locked
+// Time based locks are no longer active once their expiry has passed
+func isLockActive(user User) bool {
+	if !user.IsLocked() {
+		return false
+	}
+	until := user.GetLockedUntil()
+	return until.IsZero() || time.Now().Before(until)
+}
+
+// lockoutDuration calculates the lockout duration for the provided number of previous lockouts
+// Durations double with each lockout up to the configured maximum
+func (userModule *Controller) lockoutDuration(count uint) time.Duration {
+	duration := userModule.lockout.BaseDuration
+	for i := uint(0); i < count && duration < userModule.lockout.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > userModule.lockout.MaxDuration {
+		duration = userModule.lockout.MaxDuration
+	}
+	return duration
+}
+
+// handleLoginFailure updates login retries for a user, applying a time based lock once the threshold is reached
+// Failures while a lock is active are not counted, so repeated attempts cannot extend an existing lock
+func (userModule *Controller) handleLoginFailure(user User, meta map[string]string, emitter events.Emitter) {
+	if isLockActive(user) {
+		return
+	}
+
+	retries := user.GetLoginRetries() + 1
+	user.SetLoginRetries(retries)
+	if retries < userModule.lockout.Threshold {
+		return
+	}
+
+	// Reset the back-off if the account has not been locked out recently
+	count := user.GetLockoutCount()
+	last := user.GetLockedUntil()
+	if !last.IsZero() && time.Since(last) > userModule.lockout.ResetAfter {
+		count = 0
+	}
+
+	until := time.Now().Add(userModule.lockoutDuration(count))
+
+	log.Printf("UserModule.Login: Locking user %s until %s", user.GetExtID(), until)
+
+	user.SetLocked(true)
+	user.SetLockedUntil(until)
+	user.SetLockoutCount(count + 1)
+	user.ClearLoginRetries()
+
+	data := events.NewDataWithMeta(meta)
+	data["LockedUntil"] = until.Format(time.RFC3339)
+	emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountLocked, data))
+}
+
 // Login checks user credentials and returns a login state and the associated user object (if found)
-func (userModule *Controller) Login(email string, pass string) (bool, interface{}, error) {
+func (userModule *Controller) Login(email string, pass string, meta map[string]string) (bool, interface{}, error) {
 
 	// Fetch user account
 	u, err := userModule.userStore.GetUserByEmail(email)
@@ -229,19 +380,14 @@ func (userModule *Controller) Login(email string, pass string) (bool, interface{
 	if hashErr != nil {
 		if u != nil {
 			user := u.(User)
-			retries := user.GetLoginRetries()
 
 			// Handle account lock after N retries
-			user.SetLoginRetries(retries + 1)
-			if (retries > 5) && (user.IsLocked() == false) {
-				log.Printf("UserModule.Login: Locking user %s", user.GetExtID())
-				user.SetLocked(true)
+			err = userModule.transaction(func(store Storer, emitter events.Emitter) error {
+				userModule.handleLoginFailure(user, meta, emitter)
 
-				data := make(map[string]string)
-				userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountLocked, data))
-			}
-
-			u, err = userModule.userStore.UpdateUser(user)
+				_, err := store.UpdateUser(user)
+				return err
+			})
 			if err != nil {
 				// Userstore error, wrap
 				log.Println(err)
@@ -307,7 +453,7 @@ func (userModule *Controller) GetUser(userid string) (interface{}, error) {
 		Username:  user.GetUsername(),
 		Activated: user.IsActivated(),
 		Enabled:   user.IsEnabled(),
-		Locked:    user.IsLocked(),
+		Locked:    isLockActive(user),
 		LastLogin: user.GetLastLogin(),
 		CreatedAt: user.GetCreatedAt(),
 	}
@@ -338,7 +484,7 @@ func (userModule *Controller) GetUserByEmail(email string) (interface{}, error)
 		Username:  user.GetUsername(),
 		Activated: user.IsActivated(),
 		Enabled:   user.IsEnabled(),
-		Locked:    user.IsLocked(),
+		Locked:    isLockActive(user),
 		LastLogin: user.GetLastLogin(),
 		CreatedAt: user.GetCreatedAt(),
 	}
@@ -346,7 +492,61 @@ func (userModule *Controller) GetUserByEmail(email string) (interface{}, error)
 	return &resp, nil
 }
 
-func (userModule *Controller) handleSetPassword(user User, password string) error {
+// GetLoginUser fetches a user instance by email for passwordless logins
+// This returns the underlying user object as required by login hooks
+func (userModule *Controller) GetLoginUser(email string) (interface{}, error) {
+	u, err := userModule.userStore.GetUserByEmail(email)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrorUserNotFound
+	}
+
+	if u == nil {
+		log.Printf("UserModule.GetLoginUser: user not found %s", email)
+		return nil, ErrorUserNotFound
+	}
+
+	return u.(User), nil
+}
+
+// GetLoginUserByExtID fetches a user instance by external ID for completion of second factor logins
+// This returns the underlying user object as required by login hooks
+func (userModule *Controller) GetLoginUserByExtID(userid string) (interface{}, error) {
+	u, err := userModule.userStore.GetUserByExtID(userid)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrorUserNotFound
+	}
+
+	if u == nil {
+		log.Printf("UserModule.GetLoginUserByExtID: user not found %s", userid)
+		return nil, ErrorUserNotFound
+	}
+
+	return u.(User), nil
+}
+
+// CheckPassword checks the password for a user by external ID without the side effects of a login
+// This is used to recheck the credentials of logged in users, failed checks are not counted towards account
+// lockout and must be passed to login failure hooks by the caller
+func (userModule *Controller) CheckPassword(userid, password string) (bool, error) {
+	u, err := userModule.userStore.GetUserByExtID(userid)
+	if err != nil {
+		log.Println(err)
+		return false, ErrorUserNotFound
+	}
+
+	if u == nil {
+		log.Printf("UserModule.CheckPassword: user not found %s", userid)
+		return false, ErrorUserNotFound
+	}
+
+	hashErr := bcrypt.CompareHashAndPassword([]byte(u.(User).GetPassword()), []byte(password))
+
+	return hashErr == nil, nil
+}
+
+func (userModule *Controller) handleSetPassword(user User, password string, meta map[string]string) error {
 
 	// TODO: check password requirements here.
 	// Not URL, Not in most common list, does not contain username or servicename
@@ -363,19 +563,25 @@ func (userModule *Controller) handleSetPassword(user User, password string) erro
 		return ErrorPasswordEntropyTooLow
 	}
 
-	// Update user object
+	// Update user object and emit password update event
 	user.SetPassword(string(hash))
-	_, err = userModule.userStore.UpdateUser(user)
+	err = userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		_, err := store.UpdateUser(user)
+		if err != nil {
+			return err
+		}
+
+		data := events.NewDataWithMeta(meta)
+		emitter.SendEvent(events.NewEvent(user.GetExtID(), events.PasswordUpdate, data))
+
+		return nil
+	})
 	if err != nil {
 		// Userstore error, wrap
 		log.Println(err)
 		return ErrorUpdatingUser
 	}
 
-	// Emit password update event
-	data := make(map[string]string)
-	userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.PasswordUpdate, data))
-
 	// Log update
 	log.Printf("UserModule.handleSetPassword: User %s password updated\r\n", user.GetExtID())
 
@@ -383,7 +589,7 @@ func (userModule *Controller) handleSetPassword(user User, password string) erro
 }
 
 // SetPassword sets a user password without checking the existing one
-func (userModule *Controller) SetPassword(userid, password string) (User, error) {
+func (userModule *Controller) SetPassword(userid, password string, meta map[string]string) (User, error) {
 	// Fetch user
 	u, err := userModule.userStore.GetUserByExtID(userid)
 	if err != nil {
@@ -400,7 +606,7 @@ func (userModule *Controller) SetPassword(userid, password string) (User, error)
 	user := u.(User)
 
 	// Call password setting method
-	err = userModule.handleSetPassword(user, password)
+	err = userModule.handleSetPassword(user, password, meta)
 	if err != nil {
 		return user, err
 	}
@@ -410,7 +616,7 @@ func (userModule *Controller) SetPassword(userid, password string) (User, error)
 
 // UpdatePassword updates a user password
 // This checks the original password prior to updating and fails on password errors
-func (userModule *Controller) UpdatePassword(userid string, old string, new string) (User, error) {
+func (userModule *Controller) UpdatePassword(userid string, old string, new string, meta map[string]string) (User, error) {
 
 	// Fetch user
 	u, err := userModule.userStore.GetUserByExtID(userid)
@@ -434,14 +640,131 @@ func (userModule *Controller) UpdatePassword(userid string, old string, new stri
 	}
 
 	// Call password setting method
-	err = userModule.handleSetPassword(user, new)
+	err = userModule.handleSetPassword(user, new, meta)
 
 	return user, err
 }
 
+// Delete removes a user account and all associated credentials
+func (userModule *Controller) Delete(userid string, meta map[string]string) error {
+	// Fetch user
+	u, err := userModule.userStore.GetUserByExtID(userid)
+	if err != nil {
+		// Userstore error, wrap
+		log.Println(err)
+		return ErrorUserNotFound
+	}
+
+	if u == nil {
+		log.Println("UserModule.Delete error, user not found")
+		return ErrorUserNotFound
+	}
+
+	// Remove user and emit account deletion event
+	err = userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		err := store.RemoveUser(u)
+		if err != nil {
+			return err
+		}
+
+		data := events.NewDataWithMeta(meta)
+		emitter.SendEvent(events.NewEvent(userid, events.AccountDeleted, data))
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("UserModule.Delete error removing user %s (%s)", userid, err)
+		return ErrorRemovingUser
+	}
+
+	log.Printf("UserModule.Delete: User %s account deleted\r\n", userid)
+
+	return nil
+}
+
+// SessionResp sanitised web session object
+type SessionResp struct {
+	ID         uint      `json:"id"`
+	DeviceID   string    `json:"device_id"`
+	RemoteAddr string    `json:"remote_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeen   time.Time `json:"last_seen"`
+	Current    bool      `json:"current"`
+}
+
+// GetSessions fetches the web sessions for a user
+// current is the session ID of the requesting session, which is flagged in the response
+func (userModule *Controller) GetSessions(userid, current string) ([]SessionResp, error) {
+	sessions, err := userModule.userStore.GetWebSessionsByUserID(userid)
+	if err != nil {
+		log.Printf("UserModule.GetSessions error fetching sessions for user %s (%s)", userid, err)
+		return nil, err
+	}
+
+	resp := make([]SessionResp, len(sessions))
+	for i, s := range sessions {
+		session := s.(WebSession)
+		resp[i] = SessionResp{
+			ID:         session.GetID(),
+			DeviceID:   session.GetDeviceID(),
+			RemoteAddr: session.GetRemoteAddr(),
+			UserAgent:  session.GetUserAgent(),
+			CreatedAt:  session.GetCreatedAt(),
+			LastSeen:   session.GetLastSeen(),
+			Current:    current != "" && session.GetSessionID() == current,
+		}
+	}
+
+	return resp, nil
+}
+
+// RevokeSession revokes a single web session for a user
+func (userModule *Controller) RevokeSession(userid string, id uint, meta map[string]string) error {
+	err := userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		err := store.RemoveWebSessionByID(userid, id)
+		if err != nil {
+			return err
+		}
+
+		data := events.NewDataWithMeta(meta)
+		data["Session"] = fmt.Sprintf("%d", id)
+		emitter.SendEvent(events.NewEvent(userid, events.SessionRevoked, data))
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("UserModule.RevokeSession error revoking session for user %s (%s)", userid, err)
+		return err
+	}
+
+	return nil
+}
+
+// RevokeSessions revokes all web sessions for a user other than the session ID provided
+// An empty except string revokes all sessions
+func (userModule *Controller) RevokeSessions(userid, except string, meta map[string]string) error {
+	err := userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		err := store.RemoveWebSessionsByUserID(userid, except)
+		if err != nil {
+			return err
+		}
+
+		emitter.SendEvent(events.NewEvent(userid, events.SessionsRevoked, events.NewDataWithMeta(meta)))
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("UserModule.RevokeSessions error revoking sessions for user %s (%s)", userid, err)
+		return err
+	}
+
+	return nil
+}
+
 // HandleToken provides a generic method to handle an action token
 // This executes the specified api.TokenAction on the provided user
-func (userModule *Controller) HandleToken(userid string, action api.TokenAction) (err error) {
+func (userModule *Controller) HandleToken(userid string, action api.TokenAction, meta map[string]string) (err error) {
 
 	u, err := userModule.userStore.GetUserByExtID(userid)
 	if err != nil {
@@ -460,14 +783,23 @@ func (userModule *Controller) HandleToken(userid string, action api.TokenAction)
 	switch action {
 	case api.TokenActionUnlock:
 		log.Printf("UserModule.HandleToken: Unlocking user\n")
-		userModule.Unlock(user.GetEmail())
+		userModule.Unlock(user.GetEmail(), meta)
 		return nil
 
 	case api.TokenActionActivate:
 		log.Printf("UserModule.HandleToken: Activating user\n")
-		userModule.Activate(user.GetEmail())
+		userModule.Activate(user.GetEmail(), meta)
 		return nil
 
+	case api.TokenActionLock:
+		log.Printf("UserModule.HandleToken: Locking user\n")
+		_, err = userModule.Lock(user.GetEmail(), meta)
+		if err != nil {
+			return err
+		}
+		// Locked accounts must not retain existing sessions
+		return userModule.RevokeSessions(userid, "", meta)
+
 	default:
 		log.Printf("UserModule.HandleToken: Invalid token action\n")
 		return api.TokenError
@@ -475,26 +807,54 @@ func (userModule *Controller) HandleToken(userid string, action api.TokenAction)
 }
 
 // PreLogin checks for the user module
-func (userModule *Controller) PreLogin(u interface{}) (bool, error) {
+func (userModule *Controller) PreLogin(u interface{}, meta map[string]string) (bool, error) {
 	user := u.(User)
 
 	if user.IsEnabled() == false {
 		//TODO: handle disabled error
-		userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountNotEnabled, events.NewData()))
+		userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountNotEnabled, events.NewDataWithMeta(meta)))
 		log.Printf("UserModule.PreLogin: User %s login failed, account disabled\r\n", user.GetExtID())
 		return false, nil
 	}
 
 	if user.IsActivated() == false {
 		//TODO: handle un-activated error
-		userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountNotActivated, events.NewData()))
+		userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountNotActivated, events.NewDataWithMeta(meta)))
 		log.Printf("UserModule.PreLogin: User %s login failed, account deactivated\r\n", user.GetExtID())
 		return false, nil
 	}
 
+	if user.IsLocked() == true && !isLockActive(user) {
+		// Time based lock has expired, unlock the account
+		user.SetLocked(false)
+		err := userModule.transaction(func(store Storer, emitter events.Emitter) error {
+			_, err := store.UpdateUser(user)
+			if err != nil {
+				return err
+			}
+
+			emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountLockExpired, events.NewDataWithMeta(meta)))
+
+			return nil
+		})
+		if err != nil {
+			log.Printf("UserModule.PreLogin: error updating user %s (%s)\r\n", user.GetExtID(), err)
+			return false, err
+		}
+
+		log.Printf("UserModule.PreLogin: User %s account lock expired\r\n", user.GetExtID())
+	}
+
+	// Time based lockouts do not apply to logins from devices trusted by the user, so repeated failures from
+	// other sources cannot keep the user locked out. Locks applied by the user or an administrator still apply.
+	if isLockActive(user) && !user.GetLockedUntil().IsZero() && meta[appcontext.TrustedDeviceMeta] == "true" {
+		log.Printf("UserModule.PreLogin: User %s bypassed lockout from trusted device\r\n", user.GetExtID())
+		return true, nil
+	}
+
 	if user.IsLocked() == true {
 		//TODO: handle locked error
-		userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountNotUnlocked, events.NewData()))
+		userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.AccountNotUnlocked, events.NewDataWithMeta(meta)))
 		log.Printf("UserModule.PreLogin: User %s login failed, account locked\r\n", user.GetExtID())
 		return false, nil
 	}
@@ -503,28 +863,41 @@ func (userModule *Controller) PreLogin(u interface{}) (bool, error) {
 }
 
 // PostLoginSuccess runs success actions for the user module
-func (userModule *Controller) PostLoginSuccess(u interface{}) error {
+func (userModule *Controller) PostLoginSuccess(u interface{}, meta map[string]string) error {
 	user := u.(User)
 
-	// Update user object
+	// Update user object, successful logins reset retries and the lockout back-off
 	user.SetLastLogin(time.Now())
-	_, err := userModule.userStore.UpdateUser(user)
+	user.ClearLoginRetries()
+	user.SetLockoutCount(0)
+	err := userModule.transaction(func(store Storer, emitter events.Emitter) error {
+		_, err := store.UpdateUser(user)
+		if err != nil {
+			return err
+		}
+
+		data := events.NewDataWithMeta(meta)
+		emitter.SendEvent(events.NewEvent(user.GetExtID(), events.LoginSuccess, data))
+
+		return nil
+	})
 	if err != nil {
 		log.Printf("UserModule.PostLogin: error %s\r\n", err)
 		return err
 	}
 
-	data := make(map[string]string)
-	userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.LoginSuccess, data))
-
 	return nil
 }
 
 // PostLoginFailure runs Failure actions for the user module
-func (userModule *Controller) PostLoginFailure(u interface{}) error {
-	user := u.(User)
+// This is called with a nil user for failed logins to unknown accounts
+func (userModule *Controller) PostLoginFailure(u interface{}, meta map[string]string) error {
+	user, ok := u.(User)
+	if !ok {
+		return nil
+	}
 
-	data := make(map[string]string)
+	data := events.NewDataWithMeta(meta)
 	userModule.emitter.SendEvent(events.NewEvent(user.GetExtID(), events.LoginFailure, data))
 
 	return nil
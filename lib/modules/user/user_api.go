@@ -11,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -49,8 +50,18 @@ func (userModule *Controller) BindAPI(router *web.Router) {
 	userRouter.Get("/status", (*apiCtx).Status)
 	userRouter.Post("/create", (*apiCtx).Create)
 	userRouter.Get("/account", (*apiCtx).AccountGet)
-	userRouter.Post("/account", (*apiCtx).AccountPost)
 	userRouter.Post("/reset", (*apiCtx).ResetPost)
+	userRouter.Get("/sessions", (*apiCtx).SessionsGet)
+	userRouter.Post("/sessions/revoke", (*apiCtx).SessionRevokePost)
+	userRouter.Post("/sessions/revoke-all", (*apiCtx).SessionsRevokeAllPost)
+	userRouter.Post("/admin/lock", (*apiCtx).AdminLockPost)
+	userRouter.Post("/admin/unlock", (*apiCtx).AdminUnlockPost)
+
+	// Bind endpoints requiring reauthorization
+	sudoRouter := userRouter.Subrouter(apiCtx{}, "")
+	sudoRouter.Middleware((*apiCtx).RequireSudoMiddleware)
+	sudoRouter.Post("/account", (*apiCtx).AccountPost)
+	sudoRouter.Post("/account/delete", (*apiCtx).AccountDelete)
 }
 
 // Get user login status
@@ -87,7 +98,7 @@ func (c *apiCtx) Create(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
-	u, e := c.um.Create(email, username, password)
+	u, e := c.um.Create(email, username, password, c.GetMeta())
 	if e != nil {
 		log.Printf("User.Create: user creation failed with %s", e)
 		if e == ErrorDuplicateAccount {
@@ -151,16 +162,42 @@ func (c *apiCtx) AccountPost(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Update password
-	_, err := c.um.UpdatePassword(c.GetUserID(), oldPass, newPass)
+	_, err := c.um.UpdatePassword(c.GetUserID(), oldPass, newPass, c.GetMeta())
 	if err != nil {
 		log.Print(err)
 		c.WriteInternalError(rw)
 		return
 	}
 
+	// Revoke all other sessions
+	err = c.um.RevokeSessions(c.GetUserID(), c.GetSession().ID, c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
 	c.WriteAPIResult(rw, api.PasswordUpdated)
 }
 
+// AccountDelete removes the logged in user account and ends the user session
+func (c *apiCtx) AccountDelete(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := c.um.Delete(c.GetUserID(), c.GetMeta())
+	if err != nil {
+		log.Printf("UserAPI.AccountDelete error deleting user (%s)", err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.LogoutUser(rw, req)
+
+	c.WriteAPIResult(rw, api.AccountDeleted)
+}
+
 // ResetPost handles password reset posts
 func (c *apiCtx) ResetPost(rw web.ResponseWriter, req *web.Request) {
 	if c.GetUserID() != "" {
@@ -186,7 +223,7 @@ func (c *apiCtx) ResetPost(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Update password
-	_, err := c.um.SetPassword(userid, password)
+	_, err := c.um.SetPassword(userid, password, c.GetMeta())
 	if err != nil {
 		if err == ErrorPasswordTooShort || err == ErrorPasswordEntropyTooLow {
 			c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.PasswordComplexityTooLow)
@@ -198,6 +235,110 @@ func (c *apiCtx) ResetPost(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
+	// Revoke all existing sessions
+	err = c.um.RevokeSessions(userid, "", c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
 	// Write OK response
 	c.WriteAPIResult(rw, api.PasswordUpdated)
 }
+
+// SessionsGet lists the web sessions for the logged in user
+func (c *apiCtx) SessionsGet(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	sessions, err := c.um.GetSessions(c.GetUserID(), c.GetSession().ID)
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteJSON(rw, sessions)
+}
+
+// SessionRevokePost revokes a single web session for the logged in user
+func (c *apiCtx) SessionRevokePost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	id, err := strconv.ParseUint(req.FormValue("id"), 10, 64)
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	err = c.um.RevokeSession(c.GetUserID(), uint(id), c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.SessionRevoked)
+}
+
+// SessionsRevokeAllPost revokes all web sessions for the logged in user, including the current session
+func (c *apiCtx) SessionsRevokeAllPost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := c.um.RevokeSessions(c.GetUserID(), "", c.GetMeta())
+	if err != nil {
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.LogoutUser(rw, req)
+
+	c.WriteAPIResult(rw, api.SessionsRevoked)
+}
+
+// AdminLockPost locks the specified user account (admin only)
+func (c *apiCtx) AdminLockPost(rw web.ResponseWriter, req *web.Request) {
+	c.handleAdminLock(rw, req, c.um.AdminLock, api.AccountLocked)
+}
+
+// AdminUnlockPost unlocks the specified user account, overriding any lockout (admin only)
+func (c *apiCtx) AdminUnlockPost(rw web.ResponseWriter, req *web.Request) {
+	c.handleAdminLock(rw, req, c.um.AdminUnlock, api.UnlockSuccessful)
+}
+
+// handleAdminLock applies an administrative lock action to the account specified by email
+func (c *apiCtx) handleAdminLock(rw web.ResponseWriter, req *web.Request,
+	action func(adminID, email string, meta map[string]string) error, result string) {
+
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	email := strings.ToLower(req.FormValue("email"))
+	if !govalidator.IsEmail(email) {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.InvalidEmail)
+		return
+	}
+
+	err := action(c.GetUserID(), email, c.GetMeta())
+	if err == ErrorUnauthorized {
+		c.WriteUnauthorized(rw)
+		return
+	} else if err == ErrorUserNotFound {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	} else if err != nil {
+		log.Printf("UserAPI.handleAdminLock: error updating user %s (%s)", email, err)
+		c.WriteInternalError(rw)
+		return
+	}
+
+	c.WriteAPIResult(rw, result)
+}
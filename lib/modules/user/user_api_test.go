@@ -40,7 +40,7 @@ func TestUserApi(t *testing.T) {
 	// Create controllers
 	sessionStore := sessions.NewCookieStore([]byte("abcDEF123"))
 	mockEventEmitter := test.MockEventEmitter{}
-	userModule := NewController(dataStore, &mockEventEmitter)
+	userModule := NewController(dataStore, c.Lockout, &mockEventEmitter)
 
 	ac := appcontext.AuthPlzGlobalCtx{
 		SessionStore: sessionStore,
@@ -15,4 +15,6 @@ var (
 	ErrorUpdatingUser          = errors.New("User Controller: error updating user")
 	ErrorAddingToken           = errors.New("User Controller: error adding token")
 	ErrorUpdatingToken         = errors.New("User Controller: error updating token")
+	ErrorRemovingUser          = errors.New("User Controller: error removing user")
+	ErrorUnauthorized          = errors.New("User Controller: admin privileges required")
 )
@@ -30,6 +30,7 @@ type User interface {
 
 	GetLoginRetries() uint
 	SetLoginRetries(retries uint)
+	ClearLoginRetries()
 
 	GetLastLogin() time.Time
 	SetLastLogin(t time.Time)
@@ -37,10 +38,25 @@ type User interface {
 
 	IsLocked() bool
 	SetLocked(locked bool)
+	GetLockedUntil() time.Time
+	SetLockedUntil(t time.Time)
+	GetLockoutCount() uint
+	SetLockoutCount(count uint)
 
 	IsAdmin() bool
 }
 
+// WebSession Defines the web session object interfaces required by this module
+type WebSession interface {
+	GetID() uint
+	GetSessionID() string
+	GetDeviceID() string
+	GetRemoteAddr() string
+	GetUserAgent() string
+	GetCreatedAt() time.Time
+	GetLastSeen() time.Time
+}
+
 // Storer Defines the required store interfaces for the user module
 // Returned interfaces must satisfy the User interface requirements
 type Storer interface {
@@ -49,6 +65,11 @@ type Storer interface {
 	GetUserByEmail(email string) (interface{}, error)
 	GetUserByUsername(username string) (interface{}, error)
 	UpdateUser(user interface{}) (interface{}, error)
+	RemoveUser(user interface{}) error
+
+	GetWebSessionsByUserID(userid string) ([]interface{}, error)
+	RemoveWebSessionByID(userid string, id uint) error
+	RemoveWebSessionsByUserID(userid, except string) error
 }
 
 /*
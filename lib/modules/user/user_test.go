@@ -9,9 +9,11 @@ package user
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/authplz/authplz-core/lib/appcontext"
 	"github.com/authplz/authplz-core/lib/controllers/datastore"
 	"github.com/authplz/authplz-core/lib/events"
 	"github.com/authplz/authplz-core/lib/test"
@@ -34,10 +36,10 @@ func TestUserController(t *testing.T) {
 	mockEventEmitter := test.MockEventEmitter{}
 
 	// Create controllers
-	uc := NewController(dataStore, &mockEventEmitter)
+	uc := NewController(dataStore, c.Lockout, &mockEventEmitter)
 
 	t.Run("Create user", func(t *testing.T) {
-		u, err := uc.Create(test.FakeEmail, test.FakeName, fakePass)
+		u, err := uc.Create(test.FakeEmail, test.FakeName, fakePass, nil)
 		assert.Nil(t, err)
 		if u == nil {
 			t.Error("User creation failed")
@@ -46,7 +48,7 @@ func TestUserController(t *testing.T) {
 
 	t.Run("PreLogin blocks inactivate accounts", func(t *testing.T) {
 		u1, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
-		res, err := uc.PreLogin(u1)
+		res, err := uc.PreLogin(u1, nil)
 		assert.Nil(t, err)
 		if res {
 			t.Error("User login succeeded (and shouldn't have)")
@@ -56,7 +58,7 @@ func TestUserController(t *testing.T) {
 	})
 
 	t.Run("Activate user", func(t *testing.T) {
-		u, err := uc.Activate(test.FakeEmail)
+		u, err := uc.Activate(test.FakeEmail, nil)
 		assert.Nil(t, err)
 		if u == nil {
 			t.Error("No login result")
@@ -67,13 +69,13 @@ func TestUserController(t *testing.T) {
 	t.Run("Login user", func(t *testing.T) {
 		u1, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
 
-		res, _, err := uc.Login(test.FakeEmail, fakePass)
+		res, _, err := uc.Login(test.FakeEmail, fakePass, nil)
 		assert.Nil(t, err)
 		if !res {
 			t.Error("User login failed")
 		}
 
-		res, err = uc.PreLogin(u1)
+		res, err = uc.PreLogin(u1, nil)
 		assert.Nil(t, err)
 		if !res {
 			t.Error("User login failed (and shouldn't have)")
@@ -85,7 +87,7 @@ func TestUserController(t *testing.T) {
 		u1, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
 		assert.Nil(t, err)
 
-		err := uc.PostLoginSuccess(u1)
+		err := uc.PostLoginSuccess(u1, nil)
 		assert.Nil(t, err)
 
 		u2, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
@@ -100,7 +102,7 @@ func TestUserController(t *testing.T) {
 	})
 
 	t.Run("Login rejects logins with invalid passwords", func(t *testing.T) {
-		res, _, err := uc.Login(test.FakeEmail, "Wrong password")
+		res, _, err := uc.Login(test.FakeEmail, "Wrong password", nil)
 		if err != nil {
 			t.Error(err)
 		}
@@ -110,7 +112,7 @@ func TestUserController(t *testing.T) {
 	})
 
 	t.Run("Login rejects logins with unknown user", func(t *testing.T) {
-		res, _, err := uc.Login("not@email.com", fakePass)
+		res, _, err := uc.Login("not@email.com", fakePass, nil)
 		assert.Nil(t, err)
 		if res {
 			t.Error("User login succeeded with unknown email")
@@ -123,7 +125,7 @@ func TestUserController(t *testing.T) {
 		u.(User).SetEnabled(false)
 		uc.userStore.UpdateUser(u)
 
-		res, err := uc.PreLogin(u)
+		res, err := uc.PreLogin(u, nil)
 		assert.Nil(t, err)
 		assert.EqualValues(t, false, res, "User account was not disabled")
 
@@ -141,7 +143,7 @@ func TestUserController(t *testing.T) {
 		}
 
 		for i := 0; i < 6; i++ {
-			uc.Login(test.FakeEmail, "Wrong password")
+			uc.Login(test.FakeEmail, "Wrong password", nil)
 		}
 
 		u, _ = uc.userStore.GetUserByEmail(test.FakeEmail)
@@ -154,7 +156,7 @@ func TestUserController(t *testing.T) {
 	t.Run("PreLogin blocks locked accounts", func(t *testing.T) {
 		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
 
-		res, err := uc.PreLogin(u)
+		res, err := uc.PreLogin(u, nil)
 		assert.Nil(t, err)
 		assert.EqualValues(t, false, res, "User account was not locked")
 		assert.EqualValues(t, events.AccountNotUnlocked, mockEventEmitter.Event.Type)
@@ -163,7 +165,7 @@ func TestUserController(t *testing.T) {
 	t.Run("Unlock unlocks accounts", func(t *testing.T) {
 		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
 
-		_, err = uc.Unlock(test.FakeEmail)
+		_, err = uc.Unlock(test.FakeEmail, nil)
 		assert.Nil(t, err)
 
 		u, _ = uc.userStore.GetUserByEmail(test.FakeEmail)
@@ -173,6 +175,126 @@ func TestUserController(t *testing.T) {
 		assert.EqualValues(t, events.AccountUnlocked, mockEventEmitter.Event.Type)
 	})
 
+	t.Run("Failed logins lock accounts for the base lockout duration", func(t *testing.T) {
+		for i := uint(0); i < c.Lockout.Threshold; i++ {
+			uc.Login(test.FakeEmail, "Wrong password", nil)
+		}
+
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+		user := u.(User)
+		assert.True(t, user.IsLocked(), "Account not locked")
+		assert.WithinDuration(t, time.Now().Add(c.Lockout.BaseDuration), user.GetLockedUntil(), time.Minute)
+		assert.EqualValues(t, 1, user.GetLockoutCount())
+		assert.EqualValues(t, events.AccountLocked, mockEventEmitter.Event.Type)
+	})
+
+	t.Run("Failed logins do not extend active locks", func(t *testing.T) {
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+		until := u.(User).GetLockedUntil()
+
+		for i := uint(0); i < c.Lockout.Threshold; i++ {
+			uc.Login(test.FakeEmail, "Wrong password", nil)
+		}
+
+		u, _ = uc.userStore.GetUserByEmail(test.FakeEmail)
+		assert.EqualValues(t, 1, u.(User).GetLockoutCount())
+		assert.WithinDuration(t, until, u.(User).GetLockedUntil(), time.Second)
+	})
+
+	t.Run("PreLogin unlocks accounts once locks expire", func(t *testing.T) {
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+		u.(User).SetLockedUntil(time.Now().Add(-time.Second))
+		uc.userStore.UpdateUser(u)
+
+		u, _ = uc.userStore.GetUserByEmail(test.FakeEmail)
+		res, err := uc.PreLogin(u, nil)
+		assert.Nil(t, err)
+		assert.EqualValues(t, true, res, "Expired lock blocked login")
+		assert.EqualValues(t, events.AccountLockExpired, mockEventEmitter.Event.Type)
+
+		u, _ = uc.userStore.GetUserByEmail(test.FakeEmail)
+		assert.False(t, u.(User).IsLocked(), "Account is still locked")
+	})
+
+	t.Run("Repeated lockouts back off exponentially", func(t *testing.T) {
+		for i := uint(0); i < c.Lockout.Threshold; i++ {
+			uc.Login(test.FakeEmail, "Wrong password", nil)
+		}
+
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+		user := u.(User)
+		assert.True(t, user.IsLocked(), "Account not locked")
+		assert.WithinDuration(t, time.Now().Add(2*c.Lockout.BaseDuration), user.GetLockedUntil(), time.Minute)
+		assert.EqualValues(t, 2, user.GetLockoutCount())
+	})
+
+	t.Run("Lockout durations are capped", func(t *testing.T) {
+		assert.EqualValues(t, c.Lockout.MaxDuration, uc.lockoutDuration(64))
+	})
+
+	t.Run("Trusted devices bypass time based lockouts", func(t *testing.T) {
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+		assert.True(t, isLockActive(u.(User)), "Account not locked")
+
+		res, err := uc.PreLogin(u, nil)
+		assert.Nil(t, err)
+		assert.False(t, res, "Locked account allowed login from untrusted device")
+
+		res, err = uc.PreLogin(u, map[string]string{appcontext.TrustedDeviceMeta: "true"})
+		assert.Nil(t, err)
+		assert.True(t, res, "Trusted device blocked by time based lockout")
+	})
+
+	t.Run("Admins can override lockouts", func(t *testing.T) {
+		a, err := uc.Create("admin@abc.com", "admin.user", fakePass, nil)
+		assert.Nil(t, err)
+		if a == nil {
+			t.FailNow()
+		}
+
+		// Non-admins cannot unlock accounts
+		err = uc.AdminUnlock(a.GetExtID(), test.FakeEmail, nil)
+		assert.EqualValues(t, ErrorUnauthorized, err)
+
+		admin := a.(*datastore.User)
+		admin.SetAdmin(true)
+		uc.userStore.UpdateUser(admin)
+
+		err = uc.AdminUnlock(admin.GetExtID(), test.FakeEmail, nil)
+		assert.Nil(t, err)
+
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+		assert.False(t, u.(User).IsLocked(), "Account is still locked")
+		assert.EqualValues(t, 0, u.(User).GetLockoutCount())
+		assert.EqualValues(t, events.AccountUnlocked, mockEventEmitter.Event.Type)
+
+		// Admin locks do not expire
+		err = uc.AdminLock(admin.GetExtID(), test.FakeEmail, nil)
+		assert.Nil(t, err)
+
+		u, _ = uc.userStore.GetUserByEmail(test.FakeEmail)
+		assert.True(t, u.(User).IsLocked(), "Account not locked")
+		assert.True(t, u.(User).GetLockedUntil().IsZero(), "Admin lock expires")
+
+		res, _ := uc.PreLogin(u, map[string]string{appcontext.TrustedDeviceMeta: "true"})
+		assert.False(t, res, "Trusted device bypassed admin lock")
+
+		uc.AdminUnlock(admin.GetExtID(), test.FakeEmail, nil)
+	})
+
+	t.Run("Successful logins reset login retries", func(t *testing.T) {
+		uc.Login(test.FakeEmail, "Wrong password", nil)
+
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+		assert.EqualValues(t, 1, u.(User).GetLoginRetries())
+
+		err := uc.PostLoginSuccess(u, nil)
+		assert.Nil(t, err)
+
+		u, _ = uc.userStore.GetUserByEmail(test.FakeEmail)
+		assert.EqualValues(t, 0, u.(User).GetLoginRetries())
+	})
+
 	t.Run("Get user", func(t *testing.T) {
 		u, err := uc.userStore.GetUserByEmail(test.FakeEmail)
 		assert.Nil(t, err)
@@ -188,10 +310,10 @@ func TestUserController(t *testing.T) {
 
 		newPass := test.NewPass
 
-		_, err := uc.UpdatePassword(u.(User).GetExtID(), fakePass, newPass)
+		_, err := uc.UpdatePassword(u.(User).GetExtID(), fakePass, newPass, nil)
 		assert.Nil(t, err)
 
-		res, _, err := uc.Login(test.FakeEmail, newPass)
+		res, _, err := uc.Login(test.FakeEmail, newPass, nil)
 		assert.Nil(t, err)
 		assert.EqualValues(t, true, res, "User account login failed")
 
@@ -206,7 +328,7 @@ func TestUserController(t *testing.T) {
 
 		newPass := "Test new password &$#%"
 
-		_, err := uc.UpdatePassword(u1.(User).GetExtID(), fakePass, newPass)
+		_, err := uc.UpdatePassword(u1.(User).GetExtID(), fakePass, newPass, nil)
 		assert.Nil(t, err)
 
 		u2, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
@@ -224,26 +346,45 @@ func TestUserController(t *testing.T) {
 
 		newPass := "Test new password"
 
-		_, err := uc.UpdatePassword(u.(User).GetExtID(), "wrongPass", newPass)
+		_, err := uc.UpdatePassword(u.(User).GetExtID(), "wrongPass", newPass, nil)
 		assert.NotNil(t, err)
 	})
 
 	t.Run("PostLoginSuccess causes login success event", func(t *testing.T) {
 		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
 
-		err := uc.PostLoginSuccess(u)
+		err := uc.PostLoginSuccess(u, nil)
 		assert.Nil(t, err)
 		assert.EqualValues(t, events.LoginSuccess, mockEventEmitter.Event.Type)
 	})
 
+	t.Run("Events carry request metadata", func(t *testing.T) {
+		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
+
+		meta := map[string]string{"remote-address": "10.1.2.3", "user-agent": "test-agent", "request-id": "abc123"}
+		err := uc.PostLoginSuccess(u, meta)
+		assert.Nil(t, err)
+		assert.EqualValues(t, "10.1.2.3", mockEventEmitter.Event.Data["remote-address"])
+		assert.EqualValues(t, "test-agent", mockEventEmitter.Event.Data["user-agent"])
+		assert.EqualValues(t, "abc123", mockEventEmitter.Event.Data["request-id"])
+	})
+
 	t.Run("PostLoginFailure causes login failure event", func(t *testing.T) {
 		u, _ := uc.userStore.GetUserByEmail(test.FakeEmail)
 
-		err := uc.PostLoginFailure(u)
+		err := uc.PostLoginFailure(u, nil)
 		assert.Nil(t, err)
 		assert.EqualValues(t, events.LoginFailure, mockEventEmitter.Event.Type)
 	})
 
+	t.Run("PostLoginFailure ignores unknown accounts", func(t *testing.T) {
+		mockEventEmitter.Event = nil
+
+		err := uc.PostLoginFailure(nil, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, mockEventEmitter.Event)
+	})
+
 	// Tear down user controller
 
 }
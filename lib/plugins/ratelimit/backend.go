@@ -0,0 +1,150 @@
+/*
+ * Rate limiting plugin backends
+ * This defines the in-memory and database backends for rate limit state
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Interval between removals of full (unused) buckets
+const pruneInterval = time.Minute
+
+// take consumes a token from a bucket with the provided state
+// Buckets hold up to limit tokens and refill at limit tokens per window, new (zero) buckets are full.
+// This returns the remaining tokens, the time at which the bucket will be full, whether a token was
+// available and if not the time until one will be.
+func take(tokens float64, updated, now time.Time, limit uint, window time.Duration) (float64, time.Time, bool, time.Duration) {
+	capacity := float64(limit)
+	rate := capacity / window.Seconds()
+
+	if updated.IsZero() {
+		tokens = capacity
+	} else {
+		tokens = math.Min(capacity, tokens+now.Sub(updated).Seconds()*rate)
+	}
+
+	ok := false
+	retryAfter := time.Duration(0)
+	if tokens >= 1 {
+		tokens--
+		ok = true
+	} else {
+		retryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	fullAt := now.Add(time.Duration((capacity - tokens) / rate * float64(time.Second)))
+
+	return tokens, fullAt, ok, retryAfter
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	fullAt  time.Time
+}
+
+// MemoryBackend in-memory rate limit backend
+// Limits are not shared between instances
+type MemoryBackend struct {
+	mutex     sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastPrune time.Time
+}
+
+// NewMemoryBackend creates a new in-memory rate limit backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets:   make(map[string]*memoryBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Take consumes a request from the bucket for a key
+func (mb *MemoryBackend) Take(key string, limit uint, window time.Duration) (bool, time.Duration, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	now := time.Now()
+	mb.prune(now)
+
+	b, ok := mb.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		mb.buckets[key] = b
+	}
+
+	tokens, fullAt, allowed, retryAfter := take(b.tokens, b.updated, now, limit, window)
+	b.tokens, b.updated, b.fullAt = tokens, now, fullAt
+
+	return allowed, retryAfter, nil
+}
+
+// prune removes full buckets, as these are equivalent to new buckets
+func (mb *MemoryBackend) prune(now time.Time) {
+	if now.Sub(mb.lastPrune) < pruneInterval {
+		return
+	}
+	for k, b := range mb.buckets {
+		if b.fullAt.Before(now) {
+			delete(mb.buckets, k)
+		}
+	}
+	mb.lastPrune = now
+}
+
+// DatabaseBackend database rate limit backend
+// Limits are shared between all instances using the database
+type DatabaseBackend struct {
+	store     BucketStorer
+	mutex     sync.Mutex
+	lastPrune time.Time
+}
+
+// NewDatabaseBackend creates a new database rate limit backend
+func NewDatabaseBackend(store BucketStorer) *DatabaseBackend {
+	return &DatabaseBackend{
+		store:     store,
+		lastPrune: time.Now(),
+	}
+}
+
+// Take consumes a request from the bucket for a key
+func (db *DatabaseBackend) Take(key string, limit uint, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now()
+	db.prune(now)
+
+	allowed, retryAfter := false, time.Duration(0)
+
+	err := db.store.UpdateRateLimitBucket(key, func(tokens float64, updated time.Time) (float64, time.Time, time.Time) {
+		var fullAt time.Time
+		tokens, fullAt, allowed, retryAfter = take(tokens, updated, now, limit, window)
+		return tokens, now, fullAt
+	})
+
+	return allowed, retryAfter, err
+}
+
+// prune removes full buckets, as these are equivalent to new buckets
+func (db *DatabaseBackend) prune(now time.Time) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if now.Sub(db.lastPrune) < pruneInterval {
+		return
+	}
+
+	err := db.store.RemoveRateLimitBuckets(now)
+	if err != nil {
+		log.Printf("RateLimit.DatabaseBackend error removing full buckets (%s)", err)
+	}
+	db.lastPrune = now
+}
@@ -1,11 +1,238 @@
 /*
- * Rate limiting plugin (WIP)
+ * Rate limiting plugin
+ * This limits requests to API endpoints by source IP and account using token buckets, and limits login
+ * attempts for each account and source address via PreLogin and PostLoginFailure hooks. Rate limited requests receive a 429
+ * response with a Retry-After header.
  *
  * AuthPlz Project (https://github.com/authplz/authplz-core)
- * Copyright 2017 Ryan Kurte
+ * Copyright 2018 Ryan Kurte
  */
 
 package ratelimit
 
-type RateLimitStorageInterface interface {
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+	"github.com/authplz/authplz-core/lib/config"
+)
+
+// Keys that requests may be limited by
+const (
+	KeyIP      = "ip"
+	KeyAccount = "account"
+)
+
+// Backends for rate limit state
+const (
+	BackendMemory   = "memory"
+	BackendDatabase = "database"
+)
+
+// policy is a compiled rate limit policy
+type policy struct {
+	name    string
+	paths   map[string]bool
+	methods map[string]bool
+	key     string
+	limit   uint
+	window  time.Duration
+}
+
+// matches checks whether a policy applies to a request
+func (p *policy) matches(method, path string) bool {
+	if !p.paths[path] {
+		return false
+	}
+	return len(p.methods) == 0 || p.methods[method]
+}
+
+// Controller Rate limiting plugin instance
+type Controller struct {
+	backend  Backend
+	policies []*policy
+	login    *policy
+}
+
+// NewController creates a new rate limiting plugin with the provided backend
+func NewController(c config.RateLimitConfig, backend Backend) (*Controller, error) {
+	rl := Controller{
+		backend:  backend,
+		policies: make([]*policy, 0),
+	}
+
+	for _, pc := range c.Policies {
+		p, err := compilePolicy(pc)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.paths) == 0 {
+			return nil, fmt.Errorf("ratelimit: policy %s requires paths", pc.Name)
+		}
+		rl.policies = append(rl.policies, p)
+	}
+
+	if c.Login.Limit != 0 {
+		p, err := compilePolicy(c.Login)
+		if err != nil {
+			return nil, err
+		}
+		if p.key != KeyAccount {
+			return nil, fmt.Errorf("ratelimit: login policy must be keyed by account")
+		}
+		rl.login = p
+	}
+
+	return &rl, nil
+}
+
+// compilePolicy validates a policy configuration
+func compilePolicy(c config.RateLimitPolicyConfig) (*policy, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("ratelimit: policy name required")
+	}
+	if c.Key != KeyIP && c.Key != KeyAccount {
+		return nil, fmt.Errorf("ratelimit: policy %s has invalid key '%s'", c.Name, c.Key)
+	}
+	if c.Limit == 0 || c.Window == 0 {
+		return nil, fmt.Errorf("ratelimit: policy %s requires limit and window", c.Name)
+	}
+
+	p := policy{
+		name:    c.Name,
+		paths:   make(map[string]bool),
+		methods: make(map[string]bool),
+		key:     c.Key,
+		limit:   c.Limit,
+		window:  c.Window,
+	}
+	for _, path := range c.Paths {
+		p.paths[normalisePath(path)] = true
+	}
+	for _, m := range c.Methods {
+		p.methods[strings.ToUpper(m)] = true
+	}
+
+	return &p, nil
+}
+
+// take consumes a request for a policy and key
+// Backend errors are logged and the request allowed, so the backend failing does not prevent logins
+func (rl *Controller) take(p *policy, key string) (bool, time.Duration) {
+	ok, retryAfter, err := rl.backend.Take(p.name+":"+key, p.limit, p.window)
+	if err != nil {
+		log.Printf("RateLimit: backend error for policy %s (%s)", p.name, err)
+		return true, 0
+	}
+	if !ok {
+		log.Printf("RateLimit: policy %s limit exceeded for %s", p.name, key)
+	}
+	return ok, retryAfter
+}
+
+// Middleware creates router middleware applying rate limit policies to matching requests
+// This must be bound following the GetIPMiddleware so the remote address is available
+func (rl *Controller) Middleware() appcontext.MiddlewareFunc {
+	return func(c *appcontext.AuthPlzCtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		path := normalisePath(req.URL.Path)
+
+		for _, p := range rl.policies {
+			if !p.matches(req.Method, path) {
+				continue
+			}
+
+			key := requestKey(c, rw, req, p.key)
+			if key == "" {
+				continue
+			}
+
+			ok, retryAfter := rl.take(p, path+":"+key)
+			if !ok {
+				c.WriteRateLimited(rw, retryAfter)
+				return
+			}
+		}
+
+		next(rw, req)
+	}
+}
+
+// PreLogin limits login attempts for each account
+// PreLogin is only called once credentials have been accepted, so failed attempts are counted by
+// PostLoginFailure and exhaust the bucket for subsequent logins. Attempts are limited for each account and
+// source address, so failures from other sources cannot prevent the user logging in, and logins from devices
+// trusted by the user are not limited. Rate limited logins return an api.RateLimitError
+func (rl *Controller) PreLogin(u interface{}, meta map[string]string) (bool, error) {
+	if rl.login == nil {
+		return true, nil
+	}
+
+	user, ok := u.(User)
+	if !ok {
+		return true, nil
+	}
+
+	if meta[appcontext.TrustedDeviceMeta] == "true" {
+		return true, nil
+	}
+
+	ok, retryAfter := rl.take(rl.login, loginKey(user, meta))
+	if !ok {
+		return false, &api.RateLimitError{RetryAfter: retryAfter}
+	}
+
+	return true, nil
+}
+
+// PostLoginFailure counts failed login attempts against the login limit for the account and source address
+// Failures for unknown accounts are not counted
+func (rl *Controller) PostLoginFailure(u interface{}, meta map[string]string) error {
+	if rl.login == nil {
+		return nil
+	}
+
+	user, ok := u.(User)
+	if !ok {
+		return nil
+	}
+
+	rl.take(rl.login, loginKey(user, meta))
+
+	return nil
+}
+
+// loginKey fetches the login limit key for an account and the source address of a login
+func loginKey(user User, meta map[string]string) string {
+	return user.GetExtID() + ":" + meta["remote-address"]
+}
+
+// requestKey fetches the key for a request
+// Account keys use the logged in user or the user of an in progress login flow, and requests
+// without an associated account are not limited by account policies
+func requestKey(c *appcontext.AuthPlzCtx, rw web.ResponseWriter, req *web.Request, key string) string {
+	switch key {
+	case KeyIP:
+		return c.GetMeta()["remote-address"]
+	case KeyAccount:
+		if userID := c.GetUserID(); userID != "" {
+			return userID
+		}
+		if flow := c.GetFlow(rw, req); flow != nil {
+			return flow.UserID
+		}
+	}
+	return ""
+}
+
+func normalisePath(path string) string {
+	if len(path) > 1 {
+		return strings.TrimSuffix(path, "/")
+	}
+	return path
 }
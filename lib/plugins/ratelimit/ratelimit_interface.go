@@ -0,0 +1,35 @@
+/*
+ * Rate limiting plugin interfaces
+ * This defines the interfaces required to use the rate limiting plugin
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package ratelimit
+
+import (
+	"time"
+)
+
+// Backend rate limit state storage interface
+// Take consumes a request from the bucket for a key, returning whether the request is allowed
+// and if not, the time until a request will be allowed
+type Backend interface {
+	Take(key string, limit uint, window time.Duration) (bool, time.Duration, error)
+}
+
+// BucketStorer rate limit bucket store interface
+// This must be implemented by a storage module to provide shared state for the database backend
+type BucketStorer interface {
+	// Atomically update the bucket for a key, update returns the new tokens, update time and time the bucket is full
+	UpdateRateLimitBucket(key string, update func(tokens float64, updated time.Time) (float64, time.Time, time.Time)) error
+	// Remove buckets that are full as of the provided time
+	RemoveRateLimitBuckets(before time.Time) error
+}
+
+// User interface type
+// Login hook user objects must implement this interface
+type User interface {
+	GetExtID() string
+}
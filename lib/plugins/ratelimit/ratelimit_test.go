@@ -0,0 +1,218 @@
+/*
+ * Rate limiting plugin tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+)
+
+func TestRateLimit(t *testing.T) {
+
+	t.Run("Buckets allow bursts up to the limit", func(t *testing.T) {
+		now := time.Now()
+		tokens, updated := 0.0, time.Time{}
+
+		for i := 0; i < 5; i++ {
+			var ok bool
+			tokens, _, ok, _ = take(tokens, updated, now, 5, time.Minute)
+			updated = now
+			if !ok {
+				t.Errorf("Request %d rejected within limit", i)
+			}
+		}
+
+		_, _, ok, retryAfter := take(tokens, updated, now, 5, time.Minute)
+		if ok {
+			t.Errorf("Request allowed over limit")
+		}
+		if retryAfter != 12*time.Second {
+			t.Errorf("Unexpected retry after %s", retryAfter)
+		}
+	})
+
+	t.Run("Buckets refill over the window", func(t *testing.T) {
+		now := time.Now()
+
+		_, _, ok, _ := take(0, now, now.Add(11*time.Second), 5, time.Minute)
+		if ok {
+			t.Errorf("Request allowed prior to refill")
+		}
+
+		_, fullAt, ok, _ := take(0, now, now.Add(12*time.Second), 5, time.Minute)
+		if !ok {
+			t.Errorf("Request rejected following refill")
+		}
+		// The refilled token is consumed, so the bucket is full a window after the request
+		if !fullAt.Equal(now.Add(12*time.Second + time.Minute)) {
+			t.Errorf("Unexpected full time %s", fullAt.Sub(now))
+		}
+	})
+
+	t.Run("Memory backend limits keys independently", func(t *testing.T) {
+		mb := NewMemoryBackend()
+
+		for i := 0; i < 3; i++ {
+			mb.Take("a", 3, time.Hour)
+		}
+
+		ok, retryAfter, err := mb.Take("a", 3, time.Hour)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok || retryAfter == 0 {
+			t.Errorf("Expected request to be limited with retry time")
+		}
+
+		ok, _, _ = mb.Take("b", 3, time.Hour)
+		if !ok {
+			t.Errorf("Unrelated key limited")
+		}
+	})
+
+	t.Run("Invalid policies are rejected", func(t *testing.T) {
+		invalid := []config.RateLimitPolicyConfig{
+			{Name: "", Paths: []string{"/api/login"}, Key: KeyIP, Limit: 1, Window: time.Minute},
+			{Name: "no-paths", Key: KeyIP, Limit: 1, Window: time.Minute},
+			{Name: "bad-key", Paths: []string{"/api/login"}, Key: "cookie", Limit: 1, Window: time.Minute},
+			{Name: "no-limit", Paths: []string{"/api/login"}, Key: KeyIP, Window: time.Minute},
+		}
+		for _, p := range invalid {
+			_, err := NewController(config.RateLimitConfig{Policies: []config.RateLimitPolicyConfig{p}}, NewMemoryBackend())
+			if err == nil {
+				t.Errorf("Expected error for invalid policy %s", p.Name)
+			}
+		}
+	})
+
+	t.Run("Default policies are valid", func(t *testing.T) {
+		rl, err := NewController(config.DefaultRateLimitConfig(), NewMemoryBackend())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		for _, path := range []string{"/api/login", "/api/create", "/api/recovery", "/api/totp/authenticate/"} {
+			matched := false
+			for _, p := range rl.policies {
+				if p.matches("POST", normalisePath(path)) {
+					matched = true
+				}
+			}
+			if !matched {
+				t.Errorf("No default policy for %s", path)
+			}
+		}
+	})
+
+	t.Run("PreLogin limits logins by account", func(t *testing.T) {
+		c := config.RateLimitConfig{
+			Login: config.RateLimitPolicyConfig{Name: "login-account", Key: KeyAccount, Limit: 2, Window: time.Hour},
+		}
+		rl, err := NewController(c, NewMemoryBackend())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		u := &datastore.User{ExtID: "fake-id"}
+		for i := 0; i < 2; i++ {
+			ok, err := rl.PreLogin(u, nil)
+			if !ok || err != nil {
+				t.Errorf("Login %d rejected within limit", i)
+			}
+		}
+
+		ok, err := rl.PreLogin(u, nil)
+		if ok {
+			t.Errorf("Login allowed over limit")
+		}
+		if rle, isRLE := err.(*api.RateLimitError); !isRLE || rle.RetryAfter <= 0 {
+			t.Errorf("Expected rate limit error, received %v", err)
+		}
+
+		ok, _ = rl.PreLogin(&datastore.User{ExtID: "other-id"}, nil)
+		if !ok {
+			t.Errorf("Unrelated account limited")
+		}
+	})
+
+	t.Run("Failed logins count against the account limit", func(t *testing.T) {
+		c := config.RateLimitConfig{
+			Login: config.RateLimitPolicyConfig{Name: "login-account", Key: KeyAccount, Limit: 2, Window: time.Hour},
+		}
+		rl, err := NewController(c, NewMemoryBackend())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		u := &datastore.User{ExtID: "fake-id"}
+		for i := 0; i < 2; i++ {
+			err := rl.PostLoginFailure(u, nil)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		// Failures for unknown accounts are ignored
+		err = rl.PostLoginFailure(nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		ok, err := rl.PreLogin(u, nil)
+		if ok {
+			t.Errorf("Login allowed following failed attempts over limit")
+		}
+		if _, isRLE := err.(*api.RateLimitError); !isRLE {
+			t.Errorf("Expected rate limit error, received %v", err)
+		}
+	})
+
+	t.Run("Failed logins from other sources do not limit the account owner", func(t *testing.T) {
+		c := config.RateLimitConfig{
+			Login: config.RateLimitPolicyConfig{Name: "login-account", Key: KeyAccount, Limit: 2, Window: time.Hour},
+		}
+		rl, err := NewController(c, NewMemoryBackend())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		u := &datastore.User{ExtID: "fake-id"}
+		attacker := map[string]string{"remote-address": "10.1.2.3"}
+		owner := map[string]string{"remote-address": "10.4.5.6"}
+
+		for i := 0; i < 10; i++ {
+			rl.PostLoginFailure(u, attacker)
+		}
+
+		ok, _ := rl.PreLogin(u, attacker)
+		if ok {
+			t.Errorf("Login allowed from failing source over limit")
+		}
+
+		ok, err = rl.PreLogin(u, owner)
+		if !ok || err != nil {
+			t.Errorf("Owner login rejected due to failures from another source")
+		}
+
+		// Trusted devices are not limited, even when sharing the failing source address
+		trusted := map[string]string{"remote-address": "10.1.2.3", appcontext.TrustedDeviceMeta: "true"}
+		ok, err = rl.PreLogin(u, trusted)
+		if !ok || err != nil {
+			t.Errorf("Trusted device login rejected due to failures from another source")
+		}
+	})
+}
@@ -0,0 +1,302 @@
+/*
+ * Credential stuffing detection plugin
+ * This tracks failed logins by source IP and subnet across all accounts, applying progressive delays
+ * and temporary blocks to sources that exceed the configured thresholds. Detections are emitted as
+ * system events for admins. Account lockout is not affected by the detector. Blocks are shared between
+ * instances by handling detection events broadcast by the event outbox.
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package stuffing
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/appcontext"
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+const (
+	// Network prefix lengths used to group addresses into subnets
+	ipv4SubnetBits = 24
+	ipv6SubnetBits = 48
+
+	// Interval between removals of inactive sources
+	pruneInterval = time.Minute
+
+	// Actions recorded in detection events
+	actionDelay = "delay"
+	actionBlock = "block"
+)
+
+// Event interface for events consumed by the plugin
+type Event interface {
+	GetType() string
+	GetData() map[string]string
+}
+
+// source failed login state for an address or subnet
+type source struct {
+	failures     []time.Time
+	blockedUntil time.Time
+	delayed      bool
+}
+
+// Controller Credential stuffing detector instance
+type Controller struct {
+	config    config.StuffingConfig
+	paths     map[string]bool
+	emitter   events.Emitter
+	mutex     sync.Mutex
+	addresses map[string]*source
+	subnets   map[string]*source
+	lastPrune time.Time
+}
+
+// NewController creates a new credential stuffing detector
+func NewController(c config.StuffingConfig, emitter events.Emitter) (*Controller, error) {
+	if c.Window == 0 || c.BlockDuration == 0 {
+		return nil, fmt.Errorf("stuffing: window and block-duration required")
+	}
+	if c.DelayThreshold != 0 && c.BaseDelay == 0 {
+		return nil, fmt.Errorf("stuffing: base-delay required")
+	}
+
+	paths := make(map[string]bool)
+	for _, p := range c.Paths {
+		paths[p] = true
+	}
+
+	return &Controller{
+		config:    c,
+		paths:     paths,
+		emitter:   emitter,
+		addresses: make(map[string]*source),
+		subnets:   make(map[string]*source),
+		lastPrune: time.Now(),
+	}, nil
+}
+
+// Check determines whether login attempts are currently allowed from an address
+// If not, this returns the time until the next attempt is allowed
+func (sc *Controller) Check(address string) (bool, time.Duration) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return true, 0
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	now := time.Now()
+
+	if s, ok := sc.subnets[subnet(ip)]; ok && now.Before(s.blockedUntil) {
+		return false, s.blockedUntil.Sub(now)
+	}
+
+	s, ok := sc.addresses[ip.String()]
+	if !ok {
+		return true, 0
+	}
+	if now.Before(s.blockedUntil) {
+		return false, s.blockedUntil.Sub(now)
+	}
+
+	// Apply progressive delays following the last failure
+	count := s.count(now, sc.config.Window)
+	if sc.config.DelayThreshold == 0 || count < sc.config.DelayThreshold {
+		return true, 0
+	}
+	next := s.failures[len(s.failures)-1].Add(sc.delay(count))
+	if now.Before(next) {
+		return false, next.Sub(now)
+	}
+
+	return true, 0
+}
+
+// delay calculates the delay required following the provided number of failures
+func (sc *Controller) delay(count uint) time.Duration {
+	delay := sc.config.BaseDelay
+	for i := sc.config.DelayThreshold; i < count; i++ {
+		delay *= 2
+		if sc.config.MaxDelay != 0 && delay >= sc.config.MaxDelay {
+			return sc.config.MaxDelay
+		}
+	}
+	return delay
+}
+
+// PostLoginFailure records failed logins by source address and subnet
+// This is called for all failed logins, including those to unknown accounts
+func (sc *Controller) PostLoginFailure(u interface{}, meta map[string]string) error {
+	ip := net.ParseIP(meta["remote-address"])
+	if ip == nil {
+		return nil
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	now := time.Now()
+	sc.prune(now)
+
+	address := ip.String()
+	a := sc.record(sc.addresses, address, now)
+	count := a.count(now, sc.config.Window)
+
+	if sc.config.BlockThreshold != 0 && count >= sc.config.BlockThreshold && now.After(a.blockedUntil) {
+		a.blockedUntil = now.Add(sc.config.BlockDuration)
+		sc.detected(meta, address, actionBlock, count, a.blockedUntil)
+	} else if sc.config.DelayThreshold != 0 && count >= sc.config.DelayThreshold && !a.delayed {
+		a.delayed = true
+		sc.detected(meta, address, actionDelay, count, now.Add(sc.delay(count)))
+	}
+
+	network := subnet(ip)
+	n := sc.record(sc.subnets, network, now)
+	count = n.count(now, sc.config.Window)
+
+	if sc.config.SubnetBlockThreshold != 0 && count >= sc.config.SubnetBlockThreshold && now.After(n.blockedUntil) {
+		n.blockedUntil = now.Add(sc.config.BlockDuration)
+		sc.detected(meta, network, actionBlock, count, n.blockedUntil)
+	}
+
+	return nil
+}
+
+// HandleEvent applies blocks from detection events, allowing blocks detected by other instances to be shared
+// Delays and failure counts are not shared between instances.
+func (sc *Controller) HandleEvent(e interface{}) error {
+	event, ok := e.(Event)
+	if !ok {
+		return fmt.Errorf("stuffing: unsupported event type %T", e)
+	}
+
+	data := event.GetData()
+	if event.GetType() != events.CredentialStuffingDetected || data["Action"] != actionBlock {
+		return nil
+	}
+
+	until, err := time.Parse(time.RFC3339, data["Until"])
+	if err != nil {
+		return err
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if !until.After(time.Now()) {
+		return nil
+	}
+
+	sources := sc.addresses
+	if strings.Contains(data["Source"], "/") {
+		sources = sc.subnets
+	}
+
+	s, ok := sources[data["Source"]]
+	if !ok {
+		s = &source{failures: make([]time.Time, 0)}
+		sources[data["Source"]] = s
+	}
+	if until.After(s.blockedUntil) {
+		s.blockedUntil = until
+	}
+
+	return nil
+}
+
+// record adds a failure to the source for a key
+func (sc *Controller) record(sources map[string]*source, key string, now time.Time) *source {
+	s, ok := sources[key]
+	if !ok {
+		s = &source{failures: make([]time.Time, 0)}
+		sources[key] = s
+	}
+	s.failures = append(s.failures, now)
+	return s
+}
+
+// detected logs and emits a system event for a source exceeding a threshold
+func (sc *Controller) detected(meta map[string]string, key, action string, count uint, until time.Time) {
+	log.Printf("Stuffing: %d failed logins from %s, applying %s until %s", count, key, action, until.Format(time.RFC3339))
+
+	data := events.NewDataWithMeta(meta)
+	data["Source"] = key
+	data["Action"] = action
+	data["Failures"] = strconv.FormatUint(uint64(count), 10)
+	data["Until"] = until.Format(time.RFC3339)
+	sc.emitter.SendEvent(events.NewEvent("", events.CredentialStuffingDetected, data))
+}
+
+// count removes failures outside the window and returns the remaining failure count
+func (s *source) count(now time.Time, window time.Duration) uint {
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(s.failures) && s.failures[i].Before(cutoff) {
+		i++
+	}
+	s.failures = s.failures[i:]
+
+	// Delay notifications are re-armed once failures expire
+	if len(s.failures) == 0 {
+		s.delayed = false
+	}
+
+	return uint(len(s.failures))
+}
+
+// prune removes sources with no failures in the window and no active block
+func (sc *Controller) prune(now time.Time) {
+	if now.Sub(sc.lastPrune) < pruneInterval {
+		return
+	}
+	for _, sources := range []map[string]*source{sc.addresses, sc.subnets} {
+		for k, s := range sources {
+			if s.count(now, sc.config.Window) == 0 && now.After(s.blockedUntil) {
+				delete(sources, k)
+			}
+		}
+	}
+	sc.lastPrune = now
+}
+
+// Middleware creates router middleware rejecting login attempts from delayed or blocked sources
+// This must be bound following the GetIPMiddleware so the remote address is available
+func (sc *Controller) Middleware() appcontext.MiddlewareFunc {
+	return func(c *appcontext.AuthPlzCtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		if req.Method != "POST" || !sc.paths[strings.TrimSuffix(req.URL.Path, "/")] {
+			next(rw, req)
+			return
+		}
+
+		ok, retryAfter := sc.Check(c.GetMeta()["remote-address"])
+		if !ok {
+			log.Printf("Stuffing: rejected login attempt from %s", c.GetMeta()["remote-address"])
+			c.WriteRateLimited(rw, retryAfter)
+			return
+		}
+
+		next(rw, req)
+	}
+}
+
+// subnet fetches the subnet containing an address
+func subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(ipv4SubnetBits, 32)), Mask: net.CIDRMask(ipv4SubnetBits, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6SubnetBits, 128)), Mask: net.CIDRMask(ipv6SubnetBits, 128)}).String()
+}
@@ -0,0 +1,157 @@
+/*
+ * Credential stuffing detection plugin tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package stuffing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/events"
+	"github.com/authplz/authplz-core/lib/test"
+)
+
+func TestStuffingDetector(t *testing.T) {
+	c := config.StuffingConfig{
+		Enabled:              true,
+		Paths:                []string{"/api/login"},
+		Window:               time.Hour,
+		DelayThreshold:       3,
+		BaseDelay:            time.Minute,
+		MaxDelay:             10 * time.Minute,
+		BlockThreshold:       6,
+		SubnetBlockThreshold: 10,
+		BlockDuration:        time.Hour,
+	}
+
+	mockEventEmitter := test.MockEventEmitter{}
+
+	sc, err := NewController(c, &mockEventEmitter)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	fail := func(address string, n int) {
+		for i := 0; i < n; i++ {
+			sc.PostLoginFailure(nil, map[string]string{"remote-address": address})
+		}
+	}
+
+	t.Run("Failures below thresholds are allowed", func(t *testing.T) {
+		fail("10.0.0.1", 2)
+
+		ok, _ := sc.Check("10.0.0.1")
+		if !ok {
+			t.Errorf("Address limited below threshold")
+		}
+		if mockEventEmitter.Event != nil {
+			t.Errorf("Unexpected detection event")
+		}
+	})
+
+	t.Run("Progressive delays are applied", func(t *testing.T) {
+		fail("10.0.0.1", 1)
+
+		ok, retryAfter := sc.Check("10.0.0.1")
+		if ok || retryAfter <= 0 || retryAfter > time.Minute {
+			t.Errorf("Expected delay of up to 1 minute, received %s", retryAfter)
+		}
+
+		fail("10.0.0.1", 1)
+		_, retryAfter = sc.Check("10.0.0.1")
+		if retryAfter <= time.Minute {
+			t.Errorf("Expected delay to increase, received %s", retryAfter)
+		}
+
+		if sc.delay(20) != c.MaxDelay {
+			t.Errorf("Expected delay to be limited to max delay")
+		}
+	})
+
+	t.Run("Detections emit system events", func(t *testing.T) {
+		e := mockEventEmitter.Event
+		if e == nil || e.GetType() != events.CredentialStuffingDetected {
+			t.Errorf("Expected detection event")
+			t.FailNow()
+		}
+		if e.GetUserExtID() != "" || e.GetData()["Source"] != "10.0.0.1" || e.GetData()["Action"] != actionDelay {
+			t.Errorf("Unexpected detection event %+v", e)
+		}
+	})
+
+	t.Run("Addresses are blocked over threshold", func(t *testing.T) {
+		fail("10.0.0.1", 2)
+
+		ok, retryAfter := sc.Check("10.0.0.1")
+		if ok || retryAfter <= 50*time.Minute {
+			t.Errorf("Expected block, received retry after %s", retryAfter)
+		}
+		if mockEventEmitter.Event.GetData()["Action"] != actionBlock {
+			t.Errorf("Expected block detection event")
+		}
+
+		ok, _ = sc.Check("10.0.1.1")
+		if !ok {
+			t.Errorf("Unrelated address limited")
+		}
+	})
+
+	t.Run("Subnets are blocked over threshold", func(t *testing.T) {
+		for i := 10; i < 14; i++ {
+			fail(fmt.Sprintf("10.0.0.%d", i), 1)
+		}
+
+		ok, _ := sc.Check("10.0.0.200")
+		if ok {
+			t.Errorf("Expected subnet block")
+		}
+		if mockEventEmitter.Event.GetData()["Source"] != "10.0.0.0/24" {
+			t.Errorf("Expected subnet detection event")
+		}
+	})
+
+	t.Run("Blocks from other instances are applied", func(t *testing.T) {
+		for _, source := range []string{"10.0.5.1", "10.0.6.0/24"} {
+			data := events.NewData()
+			data["Source"] = source
+			data["Action"] = actionBlock
+			data["Until"] = time.Now().Add(time.Hour).Format(time.RFC3339)
+
+			err := sc.HandleEvent(events.NewEvent("", events.CredentialStuffingDetected, data))
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		ok, _ := sc.Check("10.0.5.1")
+		if ok {
+			t.Errorf("Expected address block")
+		}
+		ok, _ = sc.Check("10.0.6.10")
+		if ok {
+			t.Errorf("Expected subnet block")
+		}
+		ok, _ = sc.Check("10.0.5.2")
+		if !ok {
+			t.Errorf("Unrelated address limited")
+		}
+	})
+
+	t.Run("Invalid addresses are ignored", func(t *testing.T) {
+		err := sc.PostLoginFailure(nil, map[string]string{})
+		if err != nil {
+			t.Error(err)
+		}
+		ok, _ := sc.Check("")
+		if !ok {
+			t.Errorf("Empty address limited")
+		}
+	})
+}
@@ -0,0 +1,531 @@
+/*
+ * Webhooks plugin
+ * This delivers events to external services as signed JSON requests. Deliveries are persisted,
+ * retried with exponential back-off on failure, and marked as dead once attempts are exhausted.
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+const (
+	// StatusPending deliveries are awaiting a (re)attempt
+	StatusPending = "pending"
+	// StatusDelivered deliveries have been accepted by the endpoint
+	StatusDelivered = "delivered"
+	// StatusDead deliveries have exhausted their attempts and will not be retried unless replayed
+	StatusDead = "dead"
+
+	// EventWildcard subscribes a webhook to all events
+	EventWildcard = "*"
+
+	// Delivery request headers
+	EventHeader     = "X-AuthPlz-Event"
+	DeliveryHeader  = "X-AuthPlz-Delivery"
+	TimestampHeader = "X-AuthPlz-Timestamp"
+	SignatureHeader = "X-AuthPlz-Signature"
+
+	// Length of generated webhook secrets
+	secretLength = 32
+	// Deliveries claimed per processing run, attempted sequentially within the claim lease
+	deliveryBatch = 20
+	// Deliveries returned when listing a webhook delivery log
+	deliveryListLimit = 100
+	// Maximum endpoint response read before closing the connection
+	responseLimit = 4096
+)
+
+// Webhook plugin errors
+var (
+	ErrUnauthorized     = errors.New("webhooks: admin privileges required")
+	ErrInvalidURL       = errors.New("webhooks: invalid endpoint URL")
+	ErrNoEventTypes     = errors.New("webhooks: at least one event type required")
+	ErrWebhookNotFound  = errors.New("webhooks: webhook not found")
+	ErrDeliveryNotFound = errors.New("webhooks: delivery not found")
+)
+
+// Controller Webhooks instance
+type Controller struct {
+	config config.WebhooksConfig
+	store  Storer
+	client *http.Client
+	mutex  sync.Mutex
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// NewController creates a new webhooks plugin
+func NewController(c config.WebhooksConfig, store Storer) (*Controller, error) {
+	if c.MaxAttempts == 0 {
+		return nil, fmt.Errorf("webhooks: max-attempts required")
+	}
+	if c.BaseDelay == 0 || c.RetryInterval == 0 || c.Timeout == 0 {
+		return nil, fmt.Errorf("webhooks: base-delay, retry-interval and timeout required")
+	}
+	if c.Lease < deliveryBatch*c.Timeout {
+		return nil, fmt.Errorf("webhooks: lease must exceed the time to attempt a batch of deliveries (%s)", deliveryBatch*c.Timeout)
+	}
+
+	return &Controller{
+		config: c,
+		store:  store,
+		client: &http.Client{Timeout: c.Timeout},
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// HandleEvent handles async events for go-async
+// Deliveries are persisted for each matching webhook, and attempted by the delivery worker.
+// Errors persisting deliveries are returned so that the event is redelivered by the outbox.
+func (wc *Controller) HandleEvent(event interface{}) error {
+	e, ok := event.(Event)
+	if !ok {
+		return nil
+	}
+
+	webhooks, err := wc.store.GetWebhooks()
+	if err != nil {
+		log.Printf("Webhooks.HandleEvent: error fetching webhooks (%s)", err)
+		return err
+	}
+
+	payload := ""
+	queued := 0
+	for _, w := range webhooks {
+		webhook := w.(Webhook)
+		if !webhook.IsEnabled() || !matches(webhook.GetEventTypes(), e.GetType()) {
+			continue
+		}
+
+		if payload == "" {
+			payload, err = buildPayload(e)
+			if err != nil {
+				log.Printf("Webhooks.HandleEvent: error encoding event (%s)", err)
+				return err
+			}
+		}
+
+		// Events redelivered by the outbox return the existing delivery, so are only delivered once per webhook
+		_, err = wc.store.AddWebhookDelivery(webhook.GetID(), e.GetID(), e.GetType(), payload, StatusPending, time.Now())
+		if err != nil {
+			log.Printf("Webhooks.HandleEvent: error adding delivery for webhook %d (%s)", webhook.GetID(), err)
+			return err
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		wc.trigger()
+	}
+
+	return nil
+}
+
+// Start starts the delivery worker, which attempts new deliveries and pending retries
+func (wc *Controller) Start() {
+	wc.wg.Add(1)
+	go func() {
+		defer wc.wg.Done()
+
+		ticker := time.NewTicker(wc.config.RetryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-wc.done:
+				return
+			case <-wc.notify:
+			case <-ticker.C:
+			}
+			wc.ProcessDeliveries()
+		}
+	}()
+}
+
+// Stop stops the delivery worker
+func (wc *Controller) Stop() {
+	wc.once.Do(func() { close(wc.done) })
+	wc.wg.Wait()
+}
+
+// trigger wakes the delivery worker without blocking
+func (wc *Controller) trigger() {
+	select {
+	case wc.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ProcessDeliveries claims and attempts pending deliveries that are due
+// Claims are leased so that instances sharing the database do not attempt the same delivery
+func (wc *Controller) ProcessDeliveries() error {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+
+	deliveries, err := wc.store.ClaimWebhookDeliveries(StatusPending, time.Now(), wc.config.Lease, deliveryBatch)
+	if err != nil {
+		log.Printf("Webhooks.ProcessDeliveries: error claiming deliveries (%s)", err)
+		return err
+	}
+
+	for _, d := range deliveries {
+		wc.deliver(d.(Delivery))
+	}
+
+	return nil
+}
+
+// deliver attempts a single delivery, updating the delivery state with the outcome
+func (wc *Controller) deliver(delivery Delivery) {
+	now := time.Now()
+	attempts := delivery.GetAttempts() + 1
+
+	delivery.SetAttempts(attempts)
+	delivery.SetLastAttempt(now)
+
+	w, err := wc.store.GetWebhook(delivery.GetWebhookID())
+	if err != nil {
+		log.Printf("Webhooks.deliver: error fetching webhook %d (%s)", delivery.GetWebhookID(), err)
+		return
+	}
+
+	if w == nil || !w.(Webhook).IsEnabled() {
+		// Deliveries to removed or disabled webhooks cannot succeed
+		delivery.SetStatus(StatusDead)
+		delivery.SetLastError("webhook removed or disabled")
+	} else {
+		code, err := wc.send(w.(Webhook), delivery)
+		delivery.SetResponseCode(code)
+
+		if err == nil {
+			delivery.SetStatus(StatusDelivered)
+			delivery.SetLastError("")
+		} else if attempts >= wc.config.MaxAttempts {
+			log.Printf("Webhooks.deliver: delivery %d failed after %d attempts (%s)", delivery.GetID(), attempts, err)
+			delivery.SetStatus(StatusDead)
+			delivery.SetLastError(err.Error())
+		} else {
+			delivery.SetNextAttempt(now.Add(wc.backoff(attempts)))
+			delivery.SetLastError(err.Error())
+		}
+	}
+
+	_, err = wc.store.UpdateWebhookDelivery(delivery)
+	if err != nil {
+		log.Printf("Webhooks.deliver: error updating delivery %d (%s)", delivery.GetID(), err)
+	}
+}
+
+// send posts a delivery payload to a webhook endpoint, returning the response status code
+func (wc *Controller) send(webhook Webhook, delivery Delivery) (int, error) {
+	payload := delivery.GetPayload()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.GetURL(), strings.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.GetEventType())
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.GetID()), 10))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(webhook.GetSecret(), timestamp, payload))
+
+	resp, err := wc.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, responseLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff calculates the delay before the next attempt following the provided number of attempts
+// Delays double with each attempt up to the configured maximum
+func (wc *Controller) backoff(attempts uint) time.Duration {
+	delay := wc.config.BaseDelay
+	for i := uint(1); i < attempts && (wc.config.MaxDelay == 0 || delay < wc.config.MaxDelay); i++ {
+		delay *= 2
+	}
+	if wc.config.MaxDelay != 0 && delay > wc.config.MaxDelay {
+		delay = wc.config.MaxDelay
+	}
+	return delay
+}
+
+// Sign generates the hex encoded HMAC-SHA256 signature of a delivery
+// Signatures cover the delivery timestamp and payload, separated by a '.', to allow receivers to reject replayed requests
+func Sign(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// buildPayload encodes an event for delivery
+func buildPayload(e Event) (string, error) {
+	event := events.AuthPlzEvent{
+		ID:        e.GetID(),
+		UserExtID: e.GetUserExtID(),
+		Time:      e.GetTime(),
+		Type:      e.GetType(),
+		Data:      e.GetData(),
+	}
+
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// matches checks whether an event type matches a webhook event filter
+func matches(eventTypes []string, eventType string) bool {
+	for _, t := range eventTypes {
+		if t == EventWildcard || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookResp sanitised webhook object
+// The signing secret is only included when a webhook is created
+type WebhookResp struct {
+	ID         uint      `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Enabled    bool      `json:"enabled"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DeliveryResp sanitised webhook delivery object
+type DeliveryResp struct {
+	ID           uint      `json:"id"`
+	WebhookID    uint      `json:"webhook_id"`
+	EventID      string    `json:"event_id"`
+	EventType    string    `json:"event_type"`
+	Status       string    `json:"status"`
+	Attempts     uint      `json:"attempts"`
+	ResponseCode int       `json:"response_code"`
+	LastError    string    `json:"last_error"`
+	NextAttempt  time.Time `json:"next_attempt"`
+	LastAttempt  time.Time `json:"last_attempt"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func buildWebhookResp(webhook Webhook) WebhookResp {
+	return WebhookResp{
+		ID:         webhook.GetID(),
+		URL:        webhook.GetURL(),
+		EventTypes: webhook.GetEventTypes(),
+		Enabled:    webhook.IsEnabled(),
+		CreatedAt:  webhook.GetCreatedAt(),
+	}
+}
+
+func buildDeliveryResp(delivery Delivery) DeliveryResp {
+	return DeliveryResp{
+		ID:           delivery.GetID(),
+		WebhookID:    delivery.GetWebhookID(),
+		EventID:      delivery.GetEventID(),
+		EventType:    delivery.GetEventType(),
+		Status:       delivery.GetStatus(),
+		Attempts:     delivery.GetAttempts(),
+		ResponseCode: delivery.GetResponseCode(),
+		LastError:    delivery.GetLastError(),
+		NextAttempt:  delivery.GetNextAttempt(),
+		LastAttempt:  delivery.GetLastAttempt(),
+		CreatedAt:    delivery.GetCreatedAt(),
+	}
+}
+
+// checkAdmin checks the provided user is an administrator
+func (wc *Controller) checkAdmin(adminID string) error {
+	a, err := wc.store.GetUserByExtID(adminID)
+	if err != nil {
+		return err
+	}
+	if a == nil || !a.(User).IsAdmin() {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// AddWebhook registers a webhook endpoint subscribed to the provided event types
+// This returns the webhook including the generated signing secret, which is not available subsequently
+func (wc *Controller) AddWebhook(adminID, endpoint string, eventTypes []string) (*WebhookResp, error) {
+	err := wc.checkAdmin(adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	filtered := make([]string, 0, len(eventTypes))
+	for _, t := range eventTypes {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			filtered = append(filtered, t)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, ErrNoEventTypes
+	}
+
+	secret, err := config.GenerateSecret(secretLength)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := wc.store.AddWebhook(endpoint, secret, filtered)
+	if err != nil {
+		log.Printf("Webhooks.AddWebhook: error adding webhook (%s)", err)
+		return nil, err
+	}
+
+	resp := buildWebhookResp(w.(Webhook))
+	resp.Secret = secret
+
+	log.Printf("Webhooks.AddWebhook: webhook %d added by admin %s", resp.ID, adminID)
+
+	return &resp, nil
+}
+
+// ListWebhooks lists registered webhooks
+func (wc *Controller) ListWebhooks(adminID string) ([]WebhookResp, error) {
+	err := wc.checkAdmin(adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := wc.store.GetWebhooks()
+	if err != nil {
+		log.Printf("Webhooks.ListWebhooks: error fetching webhooks (%s)", err)
+		return nil, err
+	}
+
+	resp := make([]WebhookResp, len(webhooks))
+	for i, w := range webhooks {
+		resp[i] = buildWebhookResp(w.(Webhook))
+	}
+
+	return resp, nil
+}
+
+// RemoveWebhook removes a webhook and its delivery log
+func (wc *Controller) RemoveWebhook(adminID string, id uint) error {
+	err := wc.checkAdmin(adminID)
+	if err != nil {
+		return err
+	}
+
+	w, err := wc.store.GetWebhook(id)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		return ErrWebhookNotFound
+	}
+
+	err = wc.store.RemoveWebhook(id)
+	if err != nil {
+		log.Printf("Webhooks.RemoveWebhook: error removing webhook %d (%s)", id, err)
+		return err
+	}
+
+	log.Printf("Webhooks.RemoveWebhook: webhook %d removed by admin %s", id, adminID)
+
+	return nil
+}
+
+// ListDeliveries lists the most recent deliveries for a webhook
+func (wc *Controller) ListDeliveries(adminID string, webhookID uint) ([]DeliveryResp, error) {
+	err := wc.checkAdmin(adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := wc.store.GetWebhookDeliveries(webhookID, deliveryListLimit)
+	if err != nil {
+		log.Printf("Webhooks.ListDeliveries: error fetching deliveries (%s)", err)
+		return nil, err
+	}
+
+	resp := make([]DeliveryResp, len(deliveries))
+	for i, d := range deliveries {
+		resp[i] = buildDeliveryResp(d.(Delivery))
+	}
+
+	return resp, nil
+}
+
+// ReplayDelivery resets a delivery to be attempted again with a full set of retries
+// This allows dead (or previously delivered) events to be resent once an endpoint is fixed
+func (wc *Controller) ReplayDelivery(adminID string, id uint) error {
+	err := wc.checkAdmin(adminID)
+	if err != nil {
+		return err
+	}
+
+	d, err := wc.store.GetWebhookDelivery(id)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return ErrDeliveryNotFound
+	}
+
+	delivery := d.(Delivery)
+	delivery.SetStatus(StatusPending)
+	delivery.SetAttempts(0)
+	delivery.SetNextAttempt(time.Now())
+	delivery.SetLastError("")
+
+	_, err = wc.store.UpdateWebhookDelivery(delivery)
+	if err != nil {
+		log.Printf("Webhooks.ReplayDelivery: error updating delivery %d (%s)", id, err)
+		return err
+	}
+
+	log.Printf("Webhooks.ReplayDelivery: delivery %d replayed by admin %s", id, adminID)
+
+	wc.trigger()
+
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+ * Webhooks plugin API
+ * This defines the admin API endpoints exposed by the webhooks plugin
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package webhooks
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/authplz/authplz-core/lib/api"
+	"github.com/authplz/authplz-core/lib/appcontext"
+)
+
+// APICtx API context instance
+type APICtx struct {
+	// Base context required by router
+	*appcontext.AuthPlzCtx
+	// Webhooks plugin instance
+	wc *Controller
+}
+
+// BindWebhooksContext Helper middleware to bind plugin to API context
+func BindWebhooksContext(wc *Controller) func(ctx *APICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	return func(ctx *APICtx, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		ctx.wc = wc
+		next(rw, req)
+	}
+}
+
+// BindAPI binds the webhooks API to a provided router
+func (wc *Controller) BindAPI(router *web.Router) {
+	webhooksRouter := router.Subrouter(APICtx{}, "/api/webhooks")
+
+	// Attach plugin context
+	webhooksRouter.Middleware(BindWebhooksContext(wc))
+
+	// Bind endpoints
+	webhooksRouter.Get("/", (*APICtx).WebhooksGet)
+	webhooksRouter.Post("/", (*APICtx).WebhooksPost)
+	webhooksRouter.Post("/remove", (*APICtx).WebhookRemovePost)
+	webhooksRouter.Get("/deliveries", (*APICtx).DeliveriesGet)
+	webhooksRouter.Post("/deliveries/replay", (*APICtx).DeliveryReplayPost)
+}
+
+// writeError writes an API response for webhook plugin errors
+func (c *APICtx) writeError(rw web.ResponseWriter, err error) {
+	switch err {
+	case ErrUnauthorized:
+		c.WriteUnauthorized(rw)
+	case ErrInvalidURL, ErrNoEventTypes:
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+	case ErrWebhookNotFound, ErrDeliveryNotFound:
+		c.WriteAPIResultWithCode(rw, http.StatusNotFound, api.IncorrectArguments)
+	default:
+		log.Printf("WebhooksAPI: error (%s)", err)
+		c.WriteInternalError(rw)
+	}
+}
+
+// parseID parses a record ID from the named request field
+func parseID(req *web.Request, name string) (uint, bool) {
+	id, err := strconv.ParseUint(req.FormValue(name), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// WebhooksGet lists registered webhooks (admin only)
+func (c *APICtx) WebhooksGet(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	webhooks, err := c.wc.ListWebhooks(c.GetUserID())
+	if err != nil {
+		c.writeError(rw, err)
+		return
+	}
+
+	c.WriteJSON(rw, webhooks)
+}
+
+// WebhooksPost registers a webhook endpoint (admin only)
+// Event types are provided as repeated or comma separated `events` fields, with `*` matching all events
+func (c *APICtx) WebhooksPost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	err := req.ParseForm()
+	if err != nil {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.FormParsingError)
+		return
+	}
+
+	eventTypes := make([]string, 0)
+	for _, v := range req.Form["events"] {
+		eventTypes = append(eventTypes, strings.Split(v, ",")...)
+	}
+
+	webhook, err := c.wc.AddWebhook(c.GetUserID(), req.FormValue("url"), eventTypes)
+	if err != nil {
+		c.writeError(rw, err)
+		return
+	}
+
+	c.WriteJSON(rw, webhook)
+}
+
+// WebhookRemovePost removes a webhook (admin only)
+func (c *APICtx) WebhookRemovePost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	id, ok := parseID(req, "id")
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	err := c.wc.RemoveWebhook(c.GetUserID(), id)
+	if err != nil {
+		c.writeError(rw, err)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.OK)
+}
+
+// DeliveriesGet lists the recent deliveries for a webhook (admin only)
+func (c *APICtx) DeliveriesGet(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	id, ok := parseID(req, "webhook")
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	deliveries, err := c.wc.ListDeliveries(c.GetUserID(), id)
+	if err != nil {
+		c.writeError(rw, err)
+		return
+	}
+
+	c.WriteJSON(rw, deliveries)
+}
+
+// DeliveryReplayPost replays a webhook delivery (admin only)
+func (c *APICtx) DeliveryReplayPost(rw web.ResponseWriter, req *web.Request) {
+	if c.GetUserID() == "" {
+		c.WriteUnauthorized(rw)
+		return
+	}
+
+	id, ok := parseID(req, "id")
+	if !ok {
+		c.WriteAPIResultWithCode(rw, http.StatusBadRequest, api.IncorrectArguments)
+		return
+	}
+
+	err := c.wc.ReplayDelivery(c.GetUserID(), id)
+	if err != nil {
+		c.writeError(rw, err)
+		return
+	}
+
+	c.WriteAPIResult(rw, api.OK)
+}
@@ -0,0 +1,89 @@
+/*
+ * Webhooks plugin interfaces
+ * This defines the interfaces required to use the webhooks plugin
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package webhooks
+
+import (
+	"time"
+)
+
+// Webhook webhook instance interface
+// Storer webhook objects must implement this interface
+type Webhook interface {
+	GetID() uint
+	GetURL() string
+	GetSecret() string
+	GetEventTypes() []string
+	IsEnabled() bool
+	GetCreatedAt() time.Time
+}
+
+// Delivery webhook delivery instance interface
+// Storer delivery objects must implement this interface
+type Delivery interface {
+	GetID() uint
+	GetWebhookID() uint
+	GetEventID() string
+	GetEventType() string
+	GetPayload() string
+	GetStatus() string
+	SetStatus(status string)
+	GetAttempts() uint
+	SetAttempts(attempts uint)
+	GetNextAttempt() time.Time
+	SetNextAttempt(t time.Time)
+	GetLastAttempt() time.Time
+	SetLastAttempt(t time.Time)
+	GetResponseCode() int
+	SetResponseCode(code int)
+	GetLastError() string
+	SetLastError(err string)
+	GetCreatedAt() time.Time
+}
+
+// User interface type
+// Storer user objects must implement this interface
+type User interface {
+	IsAdmin() bool
+}
+
+// Event interface for events consumed by the plugin
+type Event interface {
+	GetID() string
+	GetUserExtID() string
+	GetType() string
+	GetTime() time.Time
+	GetData() map[string]string
+}
+
+// Storer Webhook and delivery store interface
+// This must be implemented by a storage module to provide persistence to the plugin
+type Storer interface {
+	// Fetch a user by external ID for admin checks
+	GetUserByExtID(userid string) (interface{}, error)
+
+	// Add a webhook subscribed to the provided event types
+	AddWebhook(url, secret string, eventTypes []string) (interface{}, error)
+	// Fetch a webhook by ID
+	GetWebhook(id uint) (interface{}, error)
+	// Fetch all webhooks
+	GetWebhooks() ([]interface{}, error)
+	// Remove a webhook and its delivery log
+	RemoveWebhook(id uint) error
+
+	// Add a delivery of an event to a webhook, returning the existing delivery if the event has been added
+	AddWebhookDelivery(webhookID uint, eventID, eventType, payload, status string, nextAttempt time.Time) (interface{}, error)
+	// Fetch a delivery by ID
+	GetWebhookDelivery(id uint) (interface{}, error)
+	// Fetch the most recent deliveries for a webhook
+	GetWebhookDeliveries(webhookID uint, limit uint) ([]interface{}, error)
+	// Claim deliveries with the provided status due for an attempt, leasing them to this instance
+	ClaimWebhookDeliveries(status string, now time.Time, lease time.Duration, limit uint) ([]interface{}, error)
+	// Update a provided delivery
+	UpdateWebhookDelivery(delivery interface{}) (interface{}, error)
+}
@@ -0,0 +1,392 @@
+/*
+ * Webhooks plugin tests
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2018 Ryan Kurte
+ */
+
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/authplz/authplz-core/lib/config"
+	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/events"
+)
+
+// mockUser user for admin checks
+type mockUser struct {
+	admin bool
+}
+
+func (u *mockUser) IsAdmin() bool { return u.admin }
+
+// mockStore in memory webhook store
+type mockStore struct {
+	users      map[string]*mockUser
+	webhooks   map[uint]*datastore.Webhook
+	deliveries map[uint]*datastore.WebhookDelivery
+	nextID     uint
+	err        error
+}
+
+func newMockStore() *mockStore {
+	return &mockStore{
+		users: map[string]*mockUser{
+			"admin": &mockUser{true},
+			"user":  &mockUser{false},
+		},
+		webhooks:   make(map[uint]*datastore.Webhook),
+		deliveries: make(map[uint]*datastore.WebhookDelivery),
+	}
+}
+
+func (s *mockStore) GetUserByExtID(userid string) (interface{}, error) {
+	u, ok := s.users[userid]
+	if !ok {
+		return nil, nil
+	}
+	return u, nil
+}
+
+func (s *mockStore) AddWebhook(url, secret string, eventTypes []string) (interface{}, error) {
+	s.nextID++
+	w := &datastore.Webhook{URL: url, Secret: secret, EventTypes: strings.Join(eventTypes, ","), Enabled: true}
+	w.ID = s.nextID
+	s.webhooks[w.ID] = w
+	return w, nil
+}
+
+func (s *mockStore) GetWebhook(id uint) (interface{}, error) {
+	w, ok := s.webhooks[id]
+	if !ok {
+		return nil, nil
+	}
+	return w, nil
+}
+
+func (s *mockStore) GetWebhooks() ([]interface{}, error) {
+	webhooks := make([]interface{}, 0)
+	for _, w := range s.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+func (s *mockStore) RemoveWebhook(id uint) error {
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *mockStore) AddWebhookDelivery(webhookID uint, eventID, eventType, payload, status string, nextAttempt time.Time) (interface{}, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID && d.EventID == eventID {
+			return d, nil
+		}
+	}
+
+	s.nextID++
+	d := &datastore.WebhookDelivery{WebhookID: webhookID, EventID: eventID, EventType: eventType, Payload: payload, Status: status, NextAttempt: nextAttempt}
+	d.ID = s.nextID
+	s.deliveries[d.ID] = d
+	return d, nil
+}
+
+func (s *mockStore) GetWebhookDelivery(id uint) (interface{}, error) {
+	d, ok := s.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	return d, nil
+}
+
+func (s *mockStore) GetWebhookDeliveries(webhookID uint, limit uint) ([]interface{}, error) {
+	deliveries := make([]interface{}, 0)
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *mockStore) ClaimWebhookDeliveries(status string, now time.Time, lease time.Duration, limit uint) ([]interface{}, error) {
+	due := make([]*datastore.WebhookDelivery, 0)
+	for _, d := range s.deliveries {
+		if d.Status == status && !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+
+	deliveries := make([]interface{}, len(due))
+	for i := range due {
+		due[i].NextAttempt = now.Add(lease)
+		deliveries[i] = due[i]
+	}
+	return deliveries, nil
+}
+
+func (s *mockStore) UpdateWebhookDelivery(delivery interface{}) (interface{}, error) {
+	return delivery, nil
+}
+
+// receiver records requests to a test endpoint
+type receiver struct {
+	mutex    sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *receiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+	rw.WriteHeader(r.status)
+}
+
+func TestWebhooks(t *testing.T) {
+	store := newMockStore()
+
+	c := config.DefaultWebhooksConfig()
+	c.MaxAttempts = 3
+	c.Timeout = time.Second
+
+	short := c
+	short.Lease = c.Timeout
+	_, err := NewController(short, store)
+	if err == nil {
+		t.Errorf("Expected leases shorter than a delivery batch to be rejected")
+	}
+
+	wc, err := NewController(c, store)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	recv := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	var webhook *WebhookResp
+
+	t.Run("Non-admins cannot manage webhooks", func(t *testing.T) {
+		_, err := wc.AddWebhook("user", server.URL, []string{events.LoginSuccess})
+		if err != ErrUnauthorized {
+			t.Errorf("Expected unauthorized error, received %v", err)
+		}
+		_, err = wc.ListWebhooks("user")
+		if err != ErrUnauthorized {
+			t.Errorf("Expected unauthorized error, received %v", err)
+		}
+	})
+
+	t.Run("Webhooks require valid URLs and event types", func(t *testing.T) {
+		_, err := wc.AddWebhook("admin", "ftp://example.com", []string{events.LoginSuccess})
+		if err != ErrInvalidURL {
+			t.Errorf("Expected invalid URL error, received %v", err)
+		}
+		_, err = wc.AddWebhook("admin", server.URL, []string{" "})
+		if err != ErrNoEventTypes {
+			t.Errorf("Expected no event types error, received %v", err)
+		}
+	})
+
+	t.Run("Admins can register webhooks", func(t *testing.T) {
+		webhook, err = wc.AddWebhook("admin", server.URL, []string{events.LoginSuccess, events.AccountLocked})
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if webhook.Secret == "" {
+			t.Errorf("Webhook secret not returned on creation")
+		}
+
+		webhooks, err := wc.ListWebhooks("admin")
+		if err != nil {
+			t.Error(err)
+		}
+		if len(webhooks) != 1 || webhooks[0].Secret != "" {
+			t.Errorf("Unexpected webhook listing %+v", webhooks)
+		}
+	})
+
+	t.Run("Matching events are delivered with signatures", func(t *testing.T) {
+		event := events.NewEvent("fake-user", events.LoginSuccess, map[string]string{"remote-address": "10.1.2.3"})
+		err := wc.HandleEvent(event)
+		if err != nil {
+			t.Error(err)
+		}
+		wc.ProcessDeliveries()
+
+		if len(recv.requests) != 1 {
+			t.Errorf("Expected 1 delivery, received %d", len(recv.requests))
+			t.FailNow()
+		}
+
+		req, body := recv.requests[0], recv.bodies[0]
+		if req.Header.Get(EventHeader) != events.LoginSuccess {
+			t.Errorf("Unexpected event header %s", req.Header.Get(EventHeader))
+		}
+
+		expected := "sha256=" + Sign(webhook.Secret, req.Header.Get(TimestampHeader), body)
+		if req.Header.Get(SignatureHeader) != expected {
+			t.Errorf("Invalid delivery signature")
+		}
+
+		var received events.AuthPlzEvent
+		err = json.Unmarshal([]byte(body), &received)
+		if err != nil {
+			t.Error(err)
+		}
+		if received.ID != event.ID || received.Type != events.LoginSuccess || received.UserExtID != "fake-user" || received.Data["remote-address"] != "10.1.2.3" {
+			t.Errorf("Unexpected delivered event %+v", received)
+		}
+
+		deliveries, _ := wc.ListDeliveries("admin", webhook.ID)
+		if len(deliveries) != 1 || deliveries[0].Status != StatusDelivered {
+			t.Errorf("Delivery not recorded as delivered %+v", deliveries)
+		}
+	})
+
+	t.Run("Unmatched events are not delivered", func(t *testing.T) {
+		wc.HandleEvent(events.NewEvent("fake-user", events.LoginFailure, events.NewData()))
+		wc.ProcessDeliveries()
+
+		if len(recv.requests) != 1 {
+			t.Errorf("Unexpected delivery of unmatched event")
+		}
+	})
+
+	var failed DeliveryResp
+
+	t.Run("Failed deliveries are retried with back-off", func(t *testing.T) {
+		recv.status = http.StatusInternalServerError
+
+		wc.HandleEvent(events.NewEvent("fake-user", events.AccountLocked, events.NewData()))
+		wc.ProcessDeliveries()
+
+		deliveries, _ := wc.ListDeliveries("admin", webhook.ID)
+		for _, d := range deliveries {
+			if d.EventType == events.AccountLocked {
+				failed = d
+			}
+		}
+
+		if failed.Status != StatusPending || failed.Attempts != 1 || failed.ResponseCode != http.StatusInternalServerError {
+			t.Errorf("Unexpected failed delivery state %+v", failed)
+		}
+		if delay := failed.NextAttempt.Sub(failed.LastAttempt); delay != c.BaseDelay {
+			t.Errorf("Unexpected retry delay %s", delay)
+		}
+
+		// Retries are not attempted before they are due
+		wc.ProcessDeliveries()
+		if len(recv.requests) != 2 {
+			t.Errorf("Retry attempted before due")
+		}
+	})
+
+	t.Run("Deliveries are marked dead once attempts are exhausted", func(t *testing.T) {
+		for i := 1; i < int(c.MaxAttempts); i++ {
+			store.deliveries[failed.ID].NextAttempt = time.Now().Add(-time.Second)
+			wc.ProcessDeliveries()
+		}
+
+		d := store.deliveries[failed.ID]
+		if d.Status != StatusDead || d.Attempts != c.MaxAttempts {
+			t.Errorf("Unexpected dead delivery state %+v", d)
+		}
+
+		store.deliveries[failed.ID].NextAttempt = time.Now().Add(-time.Second)
+		wc.ProcessDeliveries()
+		if store.deliveries[failed.ID].Attempts != c.MaxAttempts {
+			t.Errorf("Dead delivery retried")
+		}
+	})
+
+	t.Run("Dead deliveries can be replayed", func(t *testing.T) {
+		recv.status = http.StatusOK
+
+		err := wc.ReplayDelivery("user", failed.ID)
+		if err != ErrUnauthorized {
+			t.Errorf("Expected unauthorized error, received %v", err)
+		}
+
+		err = wc.ReplayDelivery("admin", failed.ID)
+		if err != nil {
+			t.Error(err)
+		}
+		wc.ProcessDeliveries()
+
+		d := store.deliveries[failed.ID]
+		if d.Status != StatusDelivered || d.Attempts != 1 {
+			t.Errorf("Unexpected replayed delivery state %+v", d)
+		}
+	})
+
+	t.Run("Redelivered events are only delivered once", func(t *testing.T) {
+		sent := len(recv.requests)
+
+		event := events.NewEvent("fake-user", events.LoginSuccess, events.NewData())
+		wc.HandleEvent(event)
+		wc.HandleEvent(event)
+		wc.ProcessDeliveries()
+
+		if len(recv.requests) != sent+1 {
+			t.Errorf("Expected 1 delivery, received %d", len(recv.requests)-sent)
+		}
+	})
+
+	t.Run("Errors persisting deliveries are returned", func(t *testing.T) {
+		store.err = errors.New("fake store error")
+		defer func() { store.err = nil }()
+
+		err := wc.HandleEvent(events.NewEvent("fake-user", events.LoginSuccess, events.NewData()))
+		if err == nil {
+			t.Errorf("Expected delivery persistence error")
+		}
+	})
+
+	t.Run("Retry delays are capped", func(t *testing.T) {
+		if wc.backoff(1) != c.BaseDelay {
+			t.Errorf("Unexpected initial delay %s", wc.backoff(1))
+		}
+		if wc.backoff(64) != c.MaxDelay {
+			t.Errorf("Unexpected capped delay %s", wc.backoff(64))
+		}
+	})
+
+	t.Run("Deliveries to removed webhooks are marked dead", func(t *testing.T) {
+		wc.HandleEvent(events.NewEvent("fake-user", events.LoginSuccess, events.NewData()))
+		err := wc.RemoveWebhook("admin", webhook.ID)
+		if err != nil {
+			t.Error(err)
+		}
+		wc.ProcessDeliveries()
+
+		for _, d := range store.deliveries {
+			if d.Status == StatusPending {
+				t.Errorf("Delivery to removed webhook still pending")
+			}
+		}
+	})
+}
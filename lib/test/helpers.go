@@ -15,11 +15,11 @@ import (
 
 	"github.com/gocraft/web"
 	"github.com/gorilla/context"
-	"github.com/gorilla/sessions"
 
 	"github.com/authplz/authplz-core/lib/appcontext"
 	"github.com/authplz/authplz-core/lib/config"
 	"github.com/authplz/authplz-core/lib/controllers/datastore"
+	"github.com/authplz/authplz-core/lib/controllers/sessionstore"
 	"github.com/authplz/authplz-core/lib/controllers/token"
 )
 
@@ -36,6 +36,7 @@ type TestServer struct {
 	TokenControl *token.TokenController
 	EventEmitter *MockEventEmitter
 	Config       *config.AuthPlzConfig
+	Ctx          *appcontext.AuthPlzGlobalCtx
 }
 
 func NewTestServer() (*TestServer, error) {
@@ -47,10 +48,8 @@ func NewTestServer() (*TestServer, error) {
 	}
 	ds.ForceSync()
 
-	sessionStore := sessions.NewCookieStore([]byte("abcDEF123"))
-	ac := appcontext.AuthPlzGlobalCtx{
-		SessionStore: sessionStore,
-	}
+	sessionStore := sessionstore.NewStore(ds, []byte("abcDEF123"))
+	ac := appcontext.NewGlobalCtx(sessionStore)
 
 	tokenControl := token.NewTokenController("localhost", "abcDEF123", ds)
 
@@ -61,7 +60,7 @@ func NewTestServer() (*TestServer, error) {
 		Middleware(appcontext.BindContext(&ac)).
 		Middleware((*appcontext.AuthPlzCtx).SessionMiddleware)
 
-	return &TestServer{router, ds, tokenControl, &mockEventEmitter, c}, nil
+	return &TestServer{router, ds, tokenControl, &mockEventEmitter, c, &ac}, nil
 }
 
 func (ts *TestServer) Address() string {
@@ -91,7 +90,13 @@ func NewConfig() *config.AuthPlzConfig {
 	c.TemplateDir = "../../templates"
 	c.Mailer.Driver = "logger"
 	c.Mailer.Options = map[string]string{"mode": "silent"}
+	c.SMS.Driver = "logger"
+	c.SMS.Options = map[string]string{"mode": "silent"}
 	c.DisableWebSecurity = true
 
+	// Tests make repeated requests from a single address
+	c.RateLimit.Enabled = false
+	c.Stuffing.Enabled = false
+
 	return c
 }
@@ -0,0 +1,21 @@
+/*
+ * Mock 2fa completion handler for test use
+ *
+ * AuthPlz Project (https://github.com/authplz/authplz-core)
+ * Copyright 2017 Ryan Kurte
+ */
+
+package test
+
+type MockCompletedHandler struct {
+	UserID string
+	Action string
+	Factor string
+}
+
+func (m *MockCompletedHandler) SecondFactorCompleted(userid, action, factor string, meta map[string]string) error {
+	m.UserID = userid
+	m.Action = action
+	m.Factor = factor
+	return nil
+}